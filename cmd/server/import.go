@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/PipeOpsHQ/pipehook/internal/config"
+	"github.com/PipeOpsHQ/pipehook/internal/handler"
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+// runImport implements `pipehook import`, which loads an NDJSON export into
+// the configured database. It can run next to a live server.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv("CONFIG_PATH"), "path to a YAML configuration file")
+	owner := flags.String("owner", "", "username of the local account that should own the imported endpoints")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: pipehook import [-config path.yaml] [-owner username] FILE.ndjson")
+		fmt.Fprintln(flags.Output(), "Use - as the file to read from standard input.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected one file to import")
+	}
+
+	cfg, err := config.Load(*configPath, os.LookupEnv)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.DatabasePath), 0750); err != nil {
+		return err
+	}
+	s, err := store.NewSQLiteStore(cfg.DatabasePath)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var ownerID string
+	if *owner != "" {
+		user, _, err := s.GetUserByUsername(ctx, *owner)
+		if err != nil {
+			return fmt.Errorf("find owner %q: %w", *owner, err)
+		}
+		ownerID = user.ID
+	}
+
+	var input io.Reader = os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	h := handler.NewHandler(s)
+	h.ApplyRuntimeConfig(runtimeConfig(cfg))
+	result, importErr := h.ImportNDJSON(ctx, bufio.NewReaderSize(input, 1<<20), ownerID)
+	for _, endpoint := range result.Endpoints {
+		if endpoint.ID != endpoint.SourceID {
+			fmt.Printf("%s -> %s: %d requests (source ID was taken)\n", endpoint.SourceID, endpoint.ID, endpoint.Requests)
+		} else {
+			fmt.Printf("%s: %d requests\n", endpoint.ID, endpoint.Requests)
+		}
+		if len(endpoint.ReplacedSecrets) > 0 {
+			fmt.Printf("%s: enter the sender authentication secrets again, they were not exported: %s\n",
+				endpoint.ID, strings.Join(endpoint.ReplacedSecrets, ", "))
+		}
+	}
+	return importErr
+}
@@ -2,116 +2,206 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
-	"fmt"
-	"log"
+	"flag"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"strconv"
+	"reflect"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/PipeOpsHQ/pipehook/internal/config"
 	"github.com/PipeOpsHQ/pipehook/internal/handler"
+	"github.com/PipeOpsHQ/pipehook/internal/logging"
+	"github.com/PipeOpsHQ/pipehook/internal/metrics"
+	"github.com/PipeOpsHQ/pipehook/internal/notify"
+	"github.com/PipeOpsHQ/pipehook/internal/oidc"
 	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/PipeOpsHQ/pipehook/internal/tracing"
 	"github.com/PipeOpsHQ/pipehook/ui"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
 
-// parseSize parses a size string like "50MB", "100KB", "1GB" into bytes
-func parseSize(s string) (int64, error) {
-	s = strings.TrimSpace(s)
-	s = strings.ToUpper(s)
-
-	var multiplier int64 = 1
-	if strings.HasSuffix(s, "KB") {
-		multiplier = 1024
-		s = strings.TrimSuffix(s, "KB")
-	} else if strings.HasSuffix(s, "MB") {
-		multiplier = 1024 * 1024
-		s = strings.TrimSuffix(s, "MB")
-	} else if strings.HasSuffix(s, "GB") {
-		multiplier = 1024 * 1024 * 1024
-		s = strings.TrimSuffix(s, "GB")
-	} else if strings.HasSuffix(s, "B") {
-		s = strings.TrimSuffix(s, "B")
-	}
-
-	val, err := strconv.ParseInt(s, 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid size format: %v", err)
+func runtimeConfig(cfg *config.Config) handler.RuntimeConfig {
+	proxies := make([]netip.Prefix, 0, len(cfg.TrustedProxies))
+	for _, proxy := range cfg.TrustedProxies {
+		// Validate has checked that every entry parses.
+		prefix, _ := config.ParsePrefix(proxy)
+		proxies = append(proxies, prefix)
+	}
+	return handler.RuntimeConfig{
+		APIKey:              cfg.APIKey,
+		APIKeyRateLimit:     handler.RateLimit(cfg.RateLimits.APIKey),
+		ClientIPRateLimit:   handler.RateLimit(cfg.RateLimits.ClientIP),
+		CaptureIPRateLimit:  handler.RateLimit(cfg.RateLimits.CaptureIP),
+		MaxWebhookBodyBytes: int64(cfg.MaxWebhookBodySize),
+		AllowPrivateForward: cfg.AllowPrivateForwarding,
+		AllowSignup:         cfg.AllowSignup,
+		ForwardTimeout:      time.Duration(cfg.Timeouts.Forward),
+		ReplayTimeout:       time.Duration(cfg.Timeouts.Replay),
+		MetricsToken:        cfg.Metrics.Token,
+		MetricsEndpoints:    cfg.Metrics.Endpoints,
+		MinFreeDiskBytes:    int64(cfg.MinFreeDisk),
+		SMTP:                notify.SMTP(cfg.SMTP),
+		TrustedProxies:      proxies,
+		PublicURL:           strings.TrimRight(cfg.PublicURL, "/"),
 	}
+}
 
-	return val * multiplier, nil
+// watchReloads re-reads the configuration on SIGHUP and applies the settings
+// that are safe to change without restarting the listener or database.
+func watchReloads(ctx context.Context, configPath string, h *handler.Handler, logLevel *slog.LevelVar, current *config.Config) {
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	defer signal.Stop(reloads)
+	for {
+		select {
+		case <-reloads:
+			next, err := config.Load(configPath, os.LookupEnv)
+			if err != nil {
+				slog.Error("configuration reload rejected, keeping previous settings", "error", err)
+				continue
+			}
+			if next.Port != current.Port || next.DatabasePath != current.DatabasePath || next.TLS != current.TLS ||
+				next.Admin != current.Admin || !reflect.DeepEqual(next.OIDC, current.OIDC) || next.Timeouts.Read != current.Timeouts.Read ||
+				next.Timeouts.Write != current.Timeouts.Write || next.Timeouts.Idle != current.Timeouts.Idle ||
+				next.CleanupInterval != current.CleanupInterval || !reflect.DeepEqual(next.Tracing, current.Tracing) ||
+				next.Log.Format != current.Log.Format {
+				slog.Warn("configuration reload: listener, TLS, database, admin, single sign-on, server timeout, cleanup, tracing and log format changes require a restart")
+			}
+			h.ApplyRuntimeConfig(runtimeConfig(next))
+			logLevel.Set(next.Log.SlogLevel())
+			current = next
+			slog.Info("configuration reloaded", "max_body_bytes", int64(next.MaxWebhookBodySize),
+				"api_key_requests_per_minute", next.RateLimits.APIKey.RequestsPerMinute, "api_key_burst", next.RateLimits.APIKey.Burst,
+				"allow_private_forwarding", next.AllowPrivateForwarding, "log_level", next.Log.SlogLevel().String())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func main() {
-	dbPath := os.Getenv("DATABASE_PATH")
-	if dbPath == "" {
-		dbPath = "webhook.db"
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		if err := runImport(os.Args[2:]); err != nil {
+			fatal("import failed", "error", err)
+		}
+		return
 	}
+	configPath := flag.String("config", os.Getenv("CONFIG_PATH"), "path to a YAML configuration file")
+	flag.Parse()
 
-	dbDir := filepath.Dir(dbPath)
+	cfg, err := config.Load(*configPath, os.LookupEnv)
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.Log.SlogLevel())
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, logLevel)
+	if err != nil {
+		fatal("invalid log configuration", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	dbDir := filepath.Dir(cfg.DatabasePath)
 	if err := os.MkdirAll(dbDir, 0750); err != nil {
-		log.Fatalf("create database directory %s: %v", dbDir, err)
+		fatal("failed to create database directory", "path", dbDir, "error", err)
 	}
 
-	s, err := store.NewSQLiteStore(dbPath)
+	s, err := store.NewSQLiteStore(cfg.DatabasePath)
 	if err != nil {
-		log.Fatal(err)
+		fatal("failed to open database", "path", cfg.DatabasePath, "error", err)
 	}
 	defer s.Close()
 
 	h := handler.NewHandler(s)
-
-	maxWebhookBodySize := int64(2 * 1024 * 1024) // 2MB default
-	if maxBodySizeStr := os.Getenv("MAX_WEBHOOK_BODY_SIZE"); maxBodySizeStr != "" {
-		parsedSize, parseErr := parseSize(maxBodySizeStr)
-		if parseErr != nil || parsedSize <= 0 {
-			log.Printf("Invalid MAX_WEBHOOK_BODY_SIZE=%q, using default 2MB", maxBodySizeStr)
-		} else {
-			maxWebhookBodySize = parsedSize
+	h.ApplyRuntimeConfig(runtimeConfig(cfg))
+	slog.Info("webhook body size limit configured", "max_body_bytes", int64(cfg.MaxWebhookBodySize))
+	h.Metrics.NewGaugeFunc("pipehook_database_size_bytes", "Size of the SQLite database file.", nil, func() []metrics.Sample {
+		size, err := s.DatabaseSize(context.Background())
+		if err != nil {
+			slog.Error("failed to read database size", "error", err)
+			return nil
 		}
-	}
-	h.MaxWebhookBodyBytes = maxWebhookBodySize
-	log.Printf("Webhook max body size configured to %d bytes", maxWebhookBodySize)
-
-	// Get admin credentials from environment variables
-	adminUsername := os.Getenv("ADMIN_USERNAME")
-	adminPassword := os.Getenv("ADMIN_PASSWORD")
+		return []metrics.Sample{{Value: float64(size)}}
+	})
 
 	// Set admin credentials in handler so it can check authentication
+	adminUsername := cfg.Admin.Username
+	adminPassword := cfg.Admin.Password
 	h.AdminUsername = adminUsername
 	h.AdminPassword = adminPassword
-	h.APIKey = strings.TrimSpace(os.Getenv("API_KEY"))
-	allowPrivateForward, _ := strconv.ParseBool(os.Getenv("ALLOW_PRIVATE_FORWARDING"))
-	h.SetAllowPrivateForwarding(allowPrivateForward)
 
+	if cfg.OIDC.Enabled() {
+		h.OIDC = oidc.NewProvider(oidc.Config{
+			IssuerURL:      cfg.OIDC.Issuer,
+			ClientID:       cfg.OIDC.ClientID,
+			ClientSecret:   cfg.OIDC.ClientSecret,
+			RedirectURL:    cfg.OIDC.RedirectURL,
+			Scopes:         cfg.OIDC.Scopes,
+			AllowedDomains: cfg.OIDC.AllowedEmailDomains,
+			AllowedGroups:  cfg.OIDC.AllowedGroups,
+			GroupsClaim:    cfg.OIDC.GroupsClaim,
+			AdminClaim:     cfg.OIDC.AdminClaim,
+			AdminValues:    cfg.OIDC.AdminValues,
+		}, nil)
+		slog.Info("single sign-on enabled", "issuer", cfg.OIDC.Issuer)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeouts.Shutdown))
+		defer cancel()
+		if err := h.Shutdown(ctx); err != nil {
+			slog.Error("failed to send queued notifications", "error", err)
+		}
+	}()
+	if cfg.Tracing.Enabled() {
+		h.Tracer = tracing.New(tracing.Config{
+			Endpoint: cfg.Tracing.Endpoint, ServiceName: cfg.Tracing.ServiceName, Headers: cfg.Tracing.Headers,
+		})
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := h.Tracer.Shutdown(ctx); err != nil {
+				slog.Error("failed to export remaining spans", "error", err)
+			}
+		}()
+		slog.Info("tracing enabled", "endpoint", cfg.Tracing.Endpoint)
+	}
+	h.TraceURL = cfg.Tracing.TraceURL
+	h.WriteTimeout = time.Duration(cfg.Timeouts.Write)
 	if adminUsername != "" && adminPassword != "" {
-		log.Printf("Admin authentication enabled for /admin endpoint")
+		slog.Info("admin authentication enabled for /admin")
+	} else if cfg.OIDC.Enabled() {
+		slog.Info("admin routes are only available through single sign-on; configure ADMIN_USERNAME and ADMIN_PASSWORD for headless access")
 	} else {
-		log.Printf("WARNING: Admin routes are unavailable until ADMIN_USERNAME and ADMIN_PASSWORD are configured.")
+		slog.Warn("admin routes are unavailable until ADMIN_USERNAME and ADMIN_PASSWORD are configured")
 	}
-	if h.APIKey == "" {
-		log.Printf("API routes are unavailable until API_KEY is configured.")
+	if cfg.APIKey == "" {
+		slog.Info("API_KEY is not configured; the API accepts only keys created from the admin page")
 	}
 
 	r := chi.NewRouter()
+	r.Use(logging.RequestID)
+	// Captures write their own line with the endpoint and outcome, and probes
+	// would flood the log.
+	r.Use(logging.Requests(logger, func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/h/") || r.URL.Path == "/healthz" || r.URL.Path == "/readyz"
+	}))
 	r.Use(middleware.Recoverer)
-
-	// Logger middleware - skip for webhook routes to preserve body
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !strings.HasPrefix(r.URL.Path, "/h/") {
-				middleware.Logger(next).ServeHTTP(w, r)
-			} else {
-				next.ServeHTTP(w, r)
-			}
-		})
-	})
+	r.Use(h.SessionMiddleware)
 
 	// Versioned assets are immutable; unversioned assets must revalidate after deploys.
 	staticFiles := http.FileServer(http.FS(ui.FS))
@@ -123,41 +213,112 @@ func main() {
 		}
 		staticFiles.ServeHTTP(w, request)
 	}))
+	r.Get("/metrics", h.ServeMetrics)
+	r.Get("/healthz", h.Healthz)
+	r.Get("/readyz", h.Readyz)
 	r.Get("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/static/pipehook.svg", http.StatusMovedPermanently)
 	})
 
 	// UI
 	r.Get("/", h.Home)
-	r.Post("/new", h.CreateEndpoint)
+	r.With(h.ClientIPRateLimit).Post("/new", h.CreateEndpoint)
+	r.Get("/login", h.LoginPage)
+	r.With(h.ClientIPRateLimit).Post("/login", h.Login)
+	r.With(h.ClientIPRateLimit).Post("/signup", h.Signup)
+	r.Post("/logout", h.Logout)
+	r.Get("/auth/oidc/login", h.OIDCLogin)
+	r.With(h.ClientIPRateLimit).Get("/auth/oidc/callback", h.OIDCCallback)
+	r.Post("/account/claim", h.ClaimBrowserEndpoints)
+	r.Post("/workspaces", h.CreateWorkspace)
+	r.Get("/workspaces/{workspaceID}", h.WorkspacePage)
+	r.Post("/workspaces/{workspaceID}/members", h.SetWorkspaceMember)
+	r.Delete("/workspaces/{workspaceID}/members/{userID}", h.RemoveWorkspaceMember)
 	r.Get("/r/{requestID}", h.RequestDetail)
+	r.Get("/r/{requestID}/body", h.RequestBody)
+	r.Get("/r/{requestID}/snippet", h.RequestSnippet)
 	r.Post("/r/{requestID}/replay", h.ReplayRequest)
 	r.Delete("/r/{requestID}", h.DeleteRequest)
 	r.Delete("/endpoint/{endpointID}", h.DeleteEndpoint)
 	r.Post("/endpoint/{endpointID}/settings", h.UpdateEndpointSettings)
+	r.Post("/endpoint/{endpointID}/workspace", h.MoveEndpointWorkspace)
+	r.Post("/endpoint/{endpointID}/shares", h.CreateShareLink)
+	r.Delete("/endpoint/{endpointID}/shares/{shareID}", h.RevokeShareLink)
+	r.Post("/endpoint/{endpointID}/sequences", h.SaveResponseSequence)
+	r.Post("/endpoint/{endpointID}/sequences/reset", h.ResetResponseSequence)
+	r.Delete("/endpoint/{endpointID}/sequences", h.DeleteResponseSequence)
+	r.Post("/endpoint/{endpointID}/notifications", h.CreateNotification)
+	r.Delete("/endpoint/{endpointID}/notifications/{channelID}", h.DeleteNotification)
+	r.Post("/endpoint/{endpointID}/assertions", h.SaveAssertion)
+	r.Delete("/endpoint/{endpointID}/assertions", h.DeleteAssertion)
+	r.With(h.ClientIPRateLimit).Get("/s/{token}", h.SharedView)
 	r.Get("/endpoint/{endpointID}/export.json", h.ExportRequestsJSON)
 	r.Get("/endpoint/{endpointID}/export.csv", h.ExportRequestsCSV)
+	r.Get("/endpoint/{endpointID}/export.har", h.ExportRequestsHAR)
+	r.Get("/endpoint/{endpointID}/export.ndjson", h.ExportEndpointNDJSON)
+	r.Get("/endpoint/{endpointID}/shapes", h.EndpointShapes)
+	r.Get("/endpoint/{endpointID}/shapes/schema.json", h.DownloadShapeSchema)
+	r.Get("/endpoint/{endpointID}/shapes/types.go", h.DownloadShapeGo)
+	r.Post("/endpoint/{endpointID}/import.har", h.ImportRequestsHAR)
 	r.Get("/ws/{endpointID}", h.WebSocket)
 	r.Get("/{endpointID}/more", h.LoadMoreRequests)
+	r.Get("/{endpointID}/diff", h.DiffRequests)
 	r.Get("/{endpointID}", h.Dashboard)
 
-	// Admin routes (protected with basic auth if credentials are set)
+	// Admin routes (single sign-on admins, or Basic auth when credentials are set)
 	r.Group(func(r chi.Router) {
-		r.Use(handler.BasicAuthMiddleware(adminUsername, adminPassword))
+		r.Use(h.AdminAuthMiddleware)
 		r.Get("/admin", h.AdminPage)
+		r.Get("/admin/audit.ndjson", h.AdminExportAuditEvents)
+		r.Get("/admin/diagnostics", h.AdminDiagnostics)
 		r.Delete("/admin/endpoint/{endpointID}", h.AdminDeleteEndpoint)
+		r.Post("/admin/api-keys", h.AdminCreateAPIKey)
+		r.Delete("/admin/api-keys/{keyID}", h.AdminRevokeAPIKey)
 	})
 
 	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(h.APIMetrics)
 		r.Use(h.APIAuthMiddleware)
-		r.Get("/endpoints", h.APIListEndpoints)
-		r.Post("/endpoints", h.APICreateEndpoint)
-		r.Get("/endpoints/{endpointID}", h.APIGetEndpoint)
-		r.Put("/endpoints/{endpointID}", h.APIUpdateEndpoint)
-		r.Delete("/endpoints/{endpointID}", h.APIDeleteEndpoint)
-		r.Get("/endpoints/{endpointID}/requests", h.APIListRequests)
-		r.Get("/requests/{requestID}", h.APIGetRequest)
-		r.Delete("/requests/{requestID}", h.APIDeleteRequest)
+		read := h.RequireAPIScope(store.APIScopeRead)
+		write := h.RequireAPIScope(store.APIScopeWrite)
+		remove := h.RequireAPIScope(store.APIScopeDelete)
+		r.With(read).Get("/endpoints", h.APIListEndpoints)
+		r.With(write).Post("/endpoints", h.APICreateEndpoint)
+		r.With(read).Get("/endpoints/{endpointID}", h.APIGetEndpoint)
+		r.With(write).Put("/endpoints/{endpointID}", h.APIUpdateEndpoint)
+		r.With(remove).Delete("/endpoints/{endpointID}", h.APIDeleteEndpoint)
+		r.With(read).Get("/endpoints/{endpointID}/requests", h.APIListRequests)
+		r.With(read).Get("/endpoints/{endpointID}/diff", h.APIDiffRequests)
+		r.With(read).Get("/endpoints/{endpointID}/shapes", h.APIEndpointShapes)
+		r.With(read).Get("/endpoints/{endpointID}/shapes/schema", h.APIShapeSchema)
+		r.With(read).Get("/endpoints/{endpointID}/shapes/go", h.APIShapeGo)
+		r.With(read).Get("/endpoints/{endpointID}/har", h.APIExportHAR)
+		r.With(write).Post("/endpoints/{endpointID}/har", h.APIImportHAR)
+		r.With(write).Get("/endpoints/{endpointID}/ndjson", h.APIExportEndpointNDJSON)
+		r.With(write).Post("/import", h.APIImportNDJSON)
+		r.With(read).Get("/endpoints/{endpointID}/sequences", h.APIListSequences)
+		r.With(write).Put("/endpoints/{endpointID}/sequences", h.APISetSequence)
+		r.With(write).Post("/endpoints/{endpointID}/sequences/reset", h.APIResetSequence)
+		r.With(write).Delete("/endpoints/{endpointID}/sequences", h.APIDeleteSequence)
+		r.With(read).Get("/endpoints/{endpointID}/notifications", h.APIListNotifications)
+		r.With(write).Post("/endpoints/{endpointID}/notifications", h.APICreateNotification)
+		r.With(write).Delete("/endpoints/{endpointID}/notifications/{channelID}", h.APIDeleteNotification)
+		r.With(write).Get("/endpoints/{endpointID}/assertions", h.APIListAssertions)
+		r.With(write).Put("/endpoints/{endpointID}/assertions", h.APISetAssertion)
+		r.With(write).Delete("/endpoints/{endpointID}/assertions", h.APIDeleteAssertion)
+		r.With(read).Get("/requests/{requestID}", h.APIGetRequest)
+		r.With(read).Get("/requests/{requestID}/body", h.APIRequestBody)
+		r.With(read).Get("/requests/{requestID}/snippet", h.APIRequestSnippet)
+		r.With(remove).Delete("/requests/{requestID}", h.APIDeleteRequest)
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.RequireAPIScope(store.APIScopeAdmin))
+			r.Get("/keys", h.APIListKeys)
+			r.Post("/keys", h.APICreateKey)
+			r.Delete("/keys/{keyID}", h.APIRevokeKey)
+			r.Get("/audit", h.APIListAuditEvents)
+			r.Get("/export", h.APIExportNDJSON)
+		})
 	})
 
 	// Webhook receiver - accept ALL HTTP methods (GET, POST, PUT, PATCH, DELETE, etc.)
@@ -175,45 +336,71 @@ func main() {
 	shutdownCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	go watchReloads(shutdownCtx, *configPath, h, logLevel, cfg)
+
 	go func() {
-		ticker := time.NewTicker(1 * time.Hour)
+		ticker := time.NewTicker(time.Duration(cfg.CleanupInterval))
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				if err := s.Cleanup(shutdownCtx); err != nil && shutdownCtx.Err() == nil {
-					log.Printf("cleanup error: %v", err)
+					slog.Error("cleanup failed", "error", err)
 				}
+				h.ForgetDeletedEndpointMetrics(shutdownCtx)
 			case <-shutdownCtx.Done():
 				return
 			}
 		}
 	}()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
+	// Captures dropped by rate limits are counted in memory and stored in
+	// batches, so a flood does not turn into a write per request.
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := h.FlushThrottledCounts(shutdownCtx); err != nil && shutdownCtx.Err() == nil {
+					slog.Error("failed to store throttled capture counts", "error", err)
+				}
+			case <-shutdownCtx.Done():
+				return
+			}
+		}
+	}()
 
+	port := cfg.Port
 	srv := &http.Server{
 		Addr:           ":" + port,
 		Handler:        r,
 		MaxHeaderBytes: 1 << 20, // 1MB max header size
-		ReadTimeout:    30 * time.Second,
-		WriteTimeout:   45 * time.Second,
-		IdleTimeout:    120 * time.Second,
+		ReadTimeout:    time.Duration(cfg.Timeouts.Read),
+		WriteTimeout:   time.Duration(cfg.Timeouts.Write),
+		IdleTimeout:    time.Duration(cfg.Timeouts.Idle),
+		ErrorLog:       slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
 	}
 	go func() {
 		<-shutdownCtx.Done()
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeouts.Shutdown))
 		defer cancel()
 		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("server shutdown error: %v", err)
+			slog.Error("server shutdown failed", "error", err)
 		}
 	}()
 
-	log.Printf("Starting server on :%s", port)
-	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatal(err)
+	if cfg.TLS.Enabled() {
+		// Endpoints decide for themselves whether a client certificate is
+		// needed, so the handshake only asks for one.
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, ClientAuth: tls.RequestClientCert}
+		slog.Info("starting HTTPS server", "port", port)
+		err = srv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	} else {
+		slog.Info("starting server", "port", port)
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatal("server failed", "error", err)
 	}
 }
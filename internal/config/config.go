@@ -0,0 +1,534 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/logging"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultPort               = "8080"
+	DefaultDatabasePath       = "webhook.db"
+	DefaultMaxWebhookBodySize = 2 * 1024 * 1024
+	DefaultCleanupInterval    = time.Hour
+	DefaultMetricsEndpoints   = 100
+	DefaultMinFreeDisk        = 100 * 1024 * 1024
+)
+
+// Config is the complete server configuration. Values come from an optional
+// YAML file and are then overridden by environment variables.
+type Config struct {
+	Port                   string     `yaml:"port"`
+	DatabasePath           string     `yaml:"database_path"`
+	TLS                    TLS        `yaml:"tls"`
+	MaxWebhookBodySize     ByteSize   `yaml:"max_webhook_body_size"`
+	Admin                  Admin      `yaml:"admin"`
+	OIDC                   OIDC       `yaml:"oidc"`
+	APIKey                 string     `yaml:"api_key"`
+	AllowPrivateForwarding bool       `yaml:"allow_private_forwarding"`
+	AllowSignup            bool       `yaml:"allow_signup"`
+	TrustedProxies         []string   `yaml:"trusted_proxies"`
+	PublicURL              string     `yaml:"public_url"`
+	RateLimits             RateLimits `yaml:"rate_limits"`
+	Timeouts               Timeouts   `yaml:"timeouts"`
+	CleanupInterval        Duration   `yaml:"cleanup_interval"`
+	Metrics                Metrics    `yaml:"metrics"`
+	Tracing                Tracing    `yaml:"tracing"`
+	// MinFreeDisk is the free space below which /readyz fails. Zero turns
+	// the check off.
+	MinFreeDisk ByteSize `yaml:"min_free_disk"`
+	Log         Log      `yaml:"log"`
+	SMTP        SMTP     `yaml:"smtp"`
+}
+
+// TLS makes the server terminate HTTPS itself. Client certificates are
+// requested but not required, so endpoints can pin sender certificates.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+func (t TLS) Enabled() bool {
+	return t.CertFile != ""
+}
+
+type Admin struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// OIDC configures single sign-on. It is enabled when Issuer is set; Basic
+// auth admin credentials keep working alongside it.
+type OIDC struct {
+	Issuer              string   `yaml:"issuer"`
+	ClientID            string   `yaml:"client_id"`
+	ClientSecret        string   `yaml:"client_secret"`
+	RedirectURL         string   `yaml:"redirect_url"`
+	Scopes              []string `yaml:"scopes"`
+	AllowedEmailDomains []string `yaml:"allowed_email_domains"`
+	AllowedGroups       []string `yaml:"allowed_groups"`
+	GroupsClaim         string   `yaml:"groups_claim"`
+	AdminClaim          string   `yaml:"admin_claim"`
+	AdminValues         []string `yaml:"admin_values"`
+}
+
+func (o OIDC) Enabled() bool {
+	return o.Issuer != ""
+}
+
+// Tracing exports OpenTelemetry spans over OTLP/HTTP when Endpoint is set.
+// TraceURL, when set, links each captured request to a tracing backend;
+// {trace_id} is replaced with the request's trace ID.
+type Tracing struct {
+	Endpoint    string            `yaml:"endpoint"`
+	ServiceName string            `yaml:"service_name"`
+	Headers     map[string]string `yaml:"headers"`
+	TraceURL    string            `yaml:"trace_url"`
+}
+
+func (t Tracing) Enabled() bool {
+	return t.Endpoint != ""
+}
+
+// RateLimits configures the token buckets used for the API. Each API key gets
+// its own bucket; unauthenticated callers share one bucket per client IP.
+// CaptureIP is a separate per-IP bucket for webhook captures on /h/.
+type RateLimits struct {
+	APIKey    RateLimit `yaml:"api_key"`
+	ClientIP  RateLimit `yaml:"client_ip"`
+	CaptureIP RateLimit `yaml:"capture_ip"`
+}
+
+type RateLimit struct {
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+	Burst             int `yaml:"burst"`
+}
+
+// Metrics configures /metrics. Token, when set, must be sent as a bearer
+// token. Endpoints caps how many endpoints get their own label; captures for
+// the rest are counted under "other".
+type Metrics struct {
+	Token     string `yaml:"token"`
+	Endpoints int    `yaml:"endpoints"`
+}
+
+// Log selects the log format, text or json, and the lowest level written:
+// debug, info, warn or error.
+type Log struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// SlogLevel returns Level as a slog level. Validate has checked that it
+// parses.
+func (l Log) SlogLevel() slog.Level {
+	level, _ := logging.ParseLevel(l.Level)
+	return level
+}
+
+// SMTP is the mail server email notification channels send through. Email
+// channels can only be added while Host is set.
+type SMTP struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+func (s SMTP) Enabled() bool {
+	return s.Host != ""
+}
+
+type Timeouts struct {
+	Read     Duration `yaml:"read"`
+	Write    Duration `yaml:"write"`
+	Idle     Duration `yaml:"idle"`
+	Shutdown Duration `yaml:"shutdown"`
+	Forward  Duration `yaml:"forward"`
+	Replay   Duration `yaml:"replay"`
+}
+
+// Default returns the configuration used when neither a file nor environment
+// variables provide a value.
+func Default() *Config {
+	return &Config{
+		Port:               DefaultPort,
+		DatabasePath:       DefaultDatabasePath,
+		MaxWebhookBodySize: DefaultMaxWebhookBodySize,
+		AllowSignup:        true,
+		OIDC: OIDC{
+			Scopes:      []string{"email", "profile"},
+			GroupsClaim: "groups",
+		},
+		RateLimits: RateLimits{
+			APIKey:    RateLimit{RequestsPerMinute: 300, Burst: 60},
+			ClientIP:  RateLimit{RequestsPerMinute: 60, Burst: 20},
+			CaptureIP: RateLimit{RequestsPerMinute: 6000, Burst: 200},
+		},
+		Timeouts: Timeouts{
+			Read:     Duration(30 * time.Second),
+			Write:    Duration(45 * time.Second),
+			Idle:     Duration(120 * time.Second),
+			Shutdown: Duration(10 * time.Second),
+			Forward:  Duration(10 * time.Second),
+			Replay:   Duration(10 * time.Second),
+		},
+		CleanupInterval: Duration(DefaultCleanupInterval),
+		Metrics:         Metrics{Endpoints: DefaultMetricsEndpoints},
+		Tracing:         Tracing{ServiceName: "pipehook"},
+		MinFreeDisk:     DefaultMinFreeDisk,
+		Log:             Log{Format: logging.FormatText, Level: "info"},
+		SMTP:            SMTP{Port: 587},
+	}
+}
+
+// Load reads the YAML file at path (if path is not empty), applies
+// environment overrides from lookupEnv and validates the result.
+func Load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// An empty file decodes to io.EOF and simply means "use the defaults".
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parse config file %s: %w", path, err)
+		}
+	}
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	var errs []error
+	// Empty variables are treated as unset, so that a blank entry in a
+	// container spec does not replace a default or a file value.
+	str := func(name string, target *string) {
+		if value, ok := lookupEnv(name); ok && strings.TrimSpace(value) != "" {
+			*target = strings.TrimSpace(value)
+		}
+	}
+	parse := func(name string, apply func(string) error) {
+		value, ok := lookupEnv(name)
+		if !ok || strings.TrimSpace(value) == "" {
+			return
+		}
+		if err := apply(strings.TrimSpace(value)); err != nil {
+			errs = append(errs, fmt.Errorf("%s=%q: %w", name, value, err))
+		}
+	}
+	duration := func(name string, target *Duration) {
+		parse(name, func(value string) error {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			*target = Duration(parsed)
+			return nil
+		})
+	}
+
+	str("PORT", &c.Port)
+	str("DATABASE_PATH", &c.DatabasePath)
+	str("TLS_CERT_FILE", &c.TLS.CertFile)
+	str("TLS_KEY_FILE", &c.TLS.KeyFile)
+	parse("MAX_WEBHOOK_BODY_SIZE", func(value string) error {
+		size, err := ParseSize(value)
+		c.MaxWebhookBodySize = ByteSize(size)
+		return err
+	})
+	if value, ok := lookupEnv("ADMIN_USERNAME"); ok {
+		c.Admin.Username = value
+	}
+	if value, ok := lookupEnv("ADMIN_PASSWORD"); ok {
+		c.Admin.Password = value
+	}
+	str("API_KEY", &c.APIKey)
+	list := func(name string, target *[]string) {
+		if value, ok := lookupEnv(name); ok {
+			*target = splitList(value)
+		}
+	}
+	str("OIDC_ISSUER", &c.OIDC.Issuer)
+	str("OIDC_CLIENT_ID", &c.OIDC.ClientID)
+	str("OIDC_CLIENT_SECRET", &c.OIDC.ClientSecret)
+	str("OIDC_REDIRECT_URL", &c.OIDC.RedirectURL)
+	list("OIDC_SCOPES", &c.OIDC.Scopes)
+	list("OIDC_ALLOWED_EMAIL_DOMAINS", &c.OIDC.AllowedEmailDomains)
+	list("OIDC_ALLOWED_GROUPS", &c.OIDC.AllowedGroups)
+	str("OIDC_GROUPS_CLAIM", &c.OIDC.GroupsClaim)
+	str("OIDC_ADMIN_CLAIM", &c.OIDC.AdminClaim)
+	list("OIDC_ADMIN_VALUES", &c.OIDC.AdminValues)
+	parse("ALLOW_PRIVATE_FORWARDING", func(value string) error {
+		allow, err := strconv.ParseBool(value)
+		c.AllowPrivateForwarding = allow
+		return err
+	})
+	list("TRUSTED_PROXIES", &c.TrustedProxies)
+	str("PUBLIC_URL", &c.PublicURL)
+	parse("ALLOW_SIGNUP", func(value string) error {
+		allow, err := strconv.ParseBool(value)
+		c.AllowSignup = allow
+		return err
+	})
+	integer := func(name string, target *int) {
+		parse(name, func(value string) error {
+			parsed, err := strconv.Atoi(value)
+			*target = parsed
+			return err
+		})
+	}
+	integer("API_RATE_LIMIT_PER_MINUTE", &c.RateLimits.APIKey.RequestsPerMinute)
+	integer("API_RATE_LIMIT_BURST", &c.RateLimits.APIKey.Burst)
+	integer("IP_RATE_LIMIT_PER_MINUTE", &c.RateLimits.ClientIP.RequestsPerMinute)
+	integer("IP_RATE_LIMIT_BURST", &c.RateLimits.ClientIP.Burst)
+	integer("CAPTURE_RATE_LIMIT_PER_MINUTE", &c.RateLimits.CaptureIP.RequestsPerMinute)
+	integer("CAPTURE_RATE_LIMIT_BURST", &c.RateLimits.CaptureIP.Burst)
+	duration("READ_TIMEOUT", &c.Timeouts.Read)
+	duration("WRITE_TIMEOUT", &c.Timeouts.Write)
+	duration("IDLE_TIMEOUT", &c.Timeouts.Idle)
+	duration("SHUTDOWN_TIMEOUT", &c.Timeouts.Shutdown)
+	duration("FORWARD_TIMEOUT", &c.Timeouts.Forward)
+	duration("REPLAY_TIMEOUT", &c.Timeouts.Replay)
+	duration("CLEANUP_INTERVAL", &c.CleanupInterval)
+	str("METRICS_TOKEN", &c.Metrics.Token)
+	integer("METRICS_ENDPOINTS", &c.Metrics.Endpoints)
+	str("OTEL_EXPORTER_OTLP_ENDPOINT", &c.Tracing.Endpoint)
+	str("OTEL_SERVICE_NAME", &c.Tracing.ServiceName)
+	parse("OTEL_EXPORTER_OTLP_HEADERS", func(value string) error {
+		headers := make(map[string]string)
+		for _, pair := range splitList(value) {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				return errors.New("headers must be written as key=value pairs")
+			}
+			decoded, err := url.QueryUnescape(strings.TrimSpace(value))
+			if err != nil {
+				return err
+			}
+			headers[strings.TrimSpace(key)] = decoded
+		}
+		c.Tracing.Headers = headers
+		return nil
+	})
+	str("TRACE_URL", &c.Tracing.TraceURL)
+	parse("MIN_FREE_DISK", func(value string) error {
+		size, err := ParseSize(value)
+		c.MinFreeDisk = ByteSize(size)
+		return err
+	})
+	str("LOG_FORMAT", &c.Log.Format)
+	str("LOG_LEVEL", &c.Log.Level)
+	str("SMTP_HOST", &c.SMTP.Host)
+	integer("SMTP_PORT", &c.SMTP.Port)
+	str("SMTP_USERNAME", &c.SMTP.Username)
+	if value, ok := lookupEnv("SMTP_PASSWORD"); ok {
+		c.SMTP.Password = value
+	}
+	str("SMTP_FROM", &c.SMTP.From)
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid setting at once so operators can fix a
+// configuration in a single pass.
+func (c *Config) Validate() error {
+	var errs []error
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port %q must be a number between 1 and 65535", c.Port))
+	}
+	if strings.TrimSpace(c.DatabasePath) == "" {
+		errs = append(errs, errors.New("database_path must not be empty"))
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file must be configured together"))
+	}
+	if c.MaxWebhookBodySize <= 0 {
+		errs = append(errs, errors.New("max_webhook_body_size must be greater than zero"))
+	}
+	if (c.Admin.Username == "") != (c.Admin.Password == "") {
+		errs = append(errs, errors.New("admin username and password must be configured together"))
+	}
+	if c.OIDC.Enabled() {
+		if issuer, err := url.Parse(c.OIDC.Issuer); err != nil || (issuer.Scheme != "https" && issuer.Scheme != "http") || issuer.Host == "" {
+			errs = append(errs, fmt.Errorf("oidc.issuer %q must be an http(s) URL", c.OIDC.Issuer))
+		}
+		if c.OIDC.ClientID == "" {
+			errs = append(errs, errors.New("oidc.client_id is required when oidc.issuer is set"))
+		}
+		if redirect, err := url.Parse(c.OIDC.RedirectURL); err != nil || !redirect.IsAbs() || redirect.Path != "/auth/oidc/callback" {
+			errs = append(errs, errors.New("oidc.redirect_url must be the absolute URL of /auth/oidc/callback"))
+		}
+		if c.OIDC.GroupsClaim == "" {
+			errs = append(errs, errors.New("oidc.groups_claim must not be empty"))
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, err := ParsePrefix(proxy); err != nil {
+			errs = append(errs, fmt.Errorf("trusted_proxies: %q must be an IP address or CIDR range", proxy))
+		}
+	}
+	errs = append(errs, c.RateLimits.APIKey.validate("rate_limits.api_key"), c.RateLimits.ClientIP.validate("rate_limits.client_ip"),
+		c.RateLimits.CaptureIP.validate("rate_limits.capture_ip"))
+	timeouts := []struct {
+		name  string
+		value Duration
+	}{
+		{"timeouts.read", c.Timeouts.Read}, {"timeouts.write", c.Timeouts.Write}, {"timeouts.idle", c.Timeouts.Idle},
+		{"timeouts.shutdown", c.Timeouts.Shutdown}, {"timeouts.forward", c.Timeouts.Forward}, {"timeouts.replay", c.Timeouts.Replay},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be a positive duration", timeout.name))
+		}
+	}
+	if time.Duration(c.CleanupInterval) < time.Minute {
+		errs = append(errs, errors.New("cleanup_interval must be at least 1m"))
+	}
+	if c.Metrics.Endpoints < 0 || c.Metrics.Endpoints > 10000 {
+		errs = append(errs, errors.New("metrics.endpoints must be between 0 and 10000"))
+	}
+	if c.MinFreeDisk < 0 {
+		errs = append(errs, errors.New("min_free_disk must not be negative"))
+	}
+	if c.Log.Format != logging.FormatText && c.Log.Format != logging.FormatJSON {
+		errs = append(errs, fmt.Errorf("log.format %q must be text or json", c.Log.Format))
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level %q must be debug, info, warn or error", c.Log.Level))
+	}
+	if c.SMTP.Enabled() {
+		if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+			errs = append(errs, fmt.Errorf("smtp.port %d must be between 1 and 65535", c.SMTP.Port))
+		}
+		if _, err := mail.ParseAddress(c.SMTP.From); err != nil {
+			errs = append(errs, fmt.Errorf("smtp.from %q must be an email address", c.SMTP.From))
+		}
+	}
+	if c.PublicURL != "" {
+		if public, err := url.Parse(c.PublicURL); err != nil || (public.Scheme != "https" && public.Scheme != "http") || public.Host == "" {
+			errs = append(errs, fmt.Errorf("public_url %q must be an http(s) URL", c.PublicURL))
+		}
+	}
+	if c.Tracing.Enabled() {
+		if endpoint, err := url.Parse(c.Tracing.Endpoint); err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+			errs = append(errs, fmt.Errorf("tracing.endpoint %q must be an http(s) URL", c.Tracing.Endpoint))
+		}
+		if strings.TrimSpace(c.Tracing.ServiceName) == "" {
+			errs = append(errs, errors.New("tracing.service_name must not be empty"))
+		}
+	}
+	if c.Tracing.TraceURL != "" {
+		if link, err := url.Parse(c.Tracing.TraceURL); err != nil || (link.Scheme != "https" && link.Scheme != "http") ||
+			!strings.Contains(c.Tracing.TraceURL, "{trace_id}") {
+			errs = append(errs, errors.New("tracing.trace_url must be an http(s) URL containing {trace_id}"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l RateLimit) validate(name string) error {
+	if l.RequestsPerMinute < 1 || l.Burst < 1 {
+		return fmt.Errorf("%s.requests_per_minute and %s.burst must be at least 1", name, name)
+	}
+	return nil
+}
+
+// splitList parses a comma-separated environment value, dropping blanks.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ByteSize is a number of bytes that can be written as "512KB", "2MB" or a
+// plain integer in YAML.
+type ByteSize int64
+
+func (s *ByteSize) UnmarshalYAML(node *yaml.Node) error {
+	size, err := ParseSize(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*s = ByteSize(size)
+	return nil
+}
+
+// Duration accepts Go duration strings such as "30s" or "1h" in YAML.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ParsePrefix parses a CIDR range or a bare IP address, which stands for
+// itself alone.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// ParseSize parses a size string like "50MB", "100KB", "1GB" into bytes
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.ToUpper(s)
+
+	var multiplier int64 = 1
+	if strings.HasSuffix(s, "KB") {
+		multiplier = 1024
+		s = strings.TrimSuffix(s, "KB")
+	} else if strings.HasSuffix(s, "MB") {
+		multiplier = 1024 * 1024
+		s = strings.TrimSuffix(s, "MB")
+	} else if strings.HasSuffix(s, "GB") {
+		multiplier = 1024 * 1024 * 1024
+		s = strings.TrimSuffix(s, "GB")
+	} else if strings.HasSuffix(s, "B") {
+		s = strings.TrimSuffix(s, "B")
+	}
+
+	val, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size format: %v", err)
+	}
+
+	return val * multiplier, nil
+}
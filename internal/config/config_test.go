@@ -0,0 +1,148 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envMap(values map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+}
+
+func TestLoadAppliesFileThenEnvironment(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipehook.yaml")
+	err := os.WriteFile(path, []byte(`
+port: "9090"
+database_path: /data/hooks.db
+max_webhook_body_size: 512KB
+api_key: from-file
+rate_limits:
+  api_key:
+    requests_per_minute: 60
+    burst: 10
+  capture_ip:
+    burst: 500
+timeouts:
+  forward: 3s
+cleanup_interval: 15m
+oidc:
+  issuer: https://id.example.com
+  client_id: pipehook
+  redirect_url: https://hooks.example.com/auth/oidc/callback
+  allowed_email_domains: [example.com]
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path, envMap(map[string]string{
+		"API_KEY": "from-env", "ALLOW_PRIVATE_FORWARDING": "true", "ALLOW_SIGNUP": "false", "MIN_FREE_DISK": "1GB",
+		"LOG_FORMAT": "json", "LOG_LEVEL": "DEBUG", "SMTP_HOST": "smtp.example.com", "SMTP_FROM": "Pipehook <hooks@example.com>",
+		"OIDC_ADMIN_CLAIM": "groups", "OIDC_ADMIN_VALUES": "ops, platform,", "METRICS_TOKEN": "scrape",
+		"OTEL_EXPORTER_OTLP_ENDPOINT": "http://collector:4318", "OTEL_EXPORTER_OTLP_HEADERS": "authorization=Bearer%20abc, x-team=hooks",
+		"TRUSTED_PROXIES": "10.0.0.0/8, 192.0.2.1", "PUBLIC_URL": "https://hooks.example.com/", "PORT": "", "DATABASE_PATH": " ", "OTEL_SERVICE_NAME": "",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9090" || cfg.DatabasePath != "/data/hooks.db" || cfg.MaxWebhookBodySize != 512*1024 {
+		t.Fatalf("file values were not applied: %+v", cfg)
+	}
+	if cfg.APIKey != "from-env" || !cfg.AllowPrivateForwarding || cfg.AllowSignup || cfg.RateLimits.APIKey.RequestsPerMinute != 60 || cfg.RateLimits.ClientIP.Burst != 20 ||
+		cfg.RateLimits.CaptureIP.Burst != 500 || cfg.RateLimits.CaptureIP.RequestsPerMinute != 6000 {
+		t.Fatalf("environment overrides were not applied: %+v", cfg)
+	}
+	if time.Duration(cfg.Timeouts.Forward) != 3*time.Second || time.Duration(cfg.Timeouts.Read) != 30*time.Second ||
+		time.Duration(cfg.CleanupInterval) != 15*time.Minute {
+		t.Fatalf("unexpected durations: %+v", cfg.Timeouts)
+	}
+	if !cfg.OIDC.Enabled() || cfg.OIDC.AllowedEmailDomains[0] != "example.com" || cfg.OIDC.GroupsClaim != "groups" ||
+		cfg.OIDC.AdminClaim != "groups" || strings.Join(cfg.OIDC.AdminValues, "|") != "ops|platform" {
+		t.Fatalf("unexpected single sign-on settings: %+v", cfg.OIDC)
+	}
+	if strings.Join(cfg.TrustedProxies, "|") != "10.0.0.0/8|192.0.2.1" {
+		t.Fatalf("unexpected trusted proxies: %v", cfg.TrustedProxies)
+	}
+	if cfg.PublicURL != "https://hooks.example.com/" {
+		t.Fatalf("unexpected public URL: %q", cfg.PublicURL)
+	}
+	if prefix, err := ParsePrefix("192.0.2.1"); err != nil || prefix.String() != "192.0.2.1/32" {
+		t.Fatalf("expected a bare address to stand for itself: %v %v", prefix, err)
+	}
+	if cfg.MinFreeDisk != 1024*1024*1024 {
+		t.Fatalf("unexpected minimum free disk: %d", cfg.MinFreeDisk)
+	}
+	if cfg.Log.Format != "json" || cfg.Log.SlogLevel() != slog.LevelDebug {
+		t.Fatalf("unexpected log settings: %+v", cfg.Log)
+	}
+	if !cfg.SMTP.Enabled() || cfg.SMTP.Port != 587 || cfg.SMTP.From != "Pipehook <hooks@example.com>" {
+		t.Fatalf("unexpected SMTP settings: %+v", cfg.SMTP)
+	}
+	if cfg.Metrics.Token != "scrape" || cfg.Metrics.Endpoints != DefaultMetricsEndpoints {
+		t.Fatalf("unexpected metrics settings: %+v", cfg.Metrics)
+	}
+	if !cfg.Tracing.Enabled() || cfg.Tracing.ServiceName != "pipehook" || cfg.Tracing.Headers["authorization"] != "Bearer abc" ||
+		cfg.Tracing.Headers["x-team"] != "hooks" {
+		t.Fatalf("unexpected tracing settings: %+v", cfg.Tracing)
+	}
+}
+
+func TestLoadRejectsInvalidValues(t *testing.T) {
+	_, err := Load("", envMap(map[string]string{
+		"MAX_WEBHOOK_BODY_SIZE": "lots", "ALLOW_PRIVATE_FORWARDING": "maybe",
+	}))
+	if err == nil || !strings.Contains(err.Error(), "MAX_WEBHOOK_BODY_SIZE") || !strings.Contains(err.Error(), "ALLOW_PRIVATE_FORWARDING") {
+		t.Fatalf("expected both environment errors, got %v", err)
+	}
+
+	_, err = Load("", envMap(map[string]string{"PORT": "0", "ADMIN_USERNAME": "admin", "CLEANUP_INTERVAL": "1s", "TLS_CERT_FILE": "cert.pem"}))
+	if err == nil || !strings.Contains(err.Error(), "port") || !strings.Contains(err.Error(), "admin") || !strings.Contains(err.Error(), "cleanup_interval") ||
+		!strings.Contains(err.Error(), "tls.key_file") {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+
+	_, err = Load("", envMap(map[string]string{"OIDC_ISSUER": "id.example.com", "OIDC_REDIRECT_URL": "https://hooks.example.com/login"}))
+	if err == nil || !strings.Contains(err.Error(), "oidc.issuer") || !strings.Contains(err.Error(), "oidc.client_id") || !strings.Contains(err.Error(), "oidc.redirect_url") {
+		t.Fatalf("expected single sign-on validation errors, got %v", err)
+	}
+
+	_, err = Load("", envMap(map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "collector:4318", "TRACE_URL": "https://tempo.example.com/trace"}))
+	if err == nil || !strings.Contains(err.Error(), "tracing.endpoint") || !strings.Contains(err.Error(), "tracing.trace_url") {
+		t.Fatalf("expected tracing validation errors, got %v", err)
+	}
+
+	_, err = Load("", envMap(map[string]string{"TRUSTED_PROXIES": "10.0.0.0/8, proxy.internal"}))
+	if err == nil || !strings.Contains(err.Error(), `trusted_proxies: "proxy.internal"`) {
+		t.Fatalf("expected a trusted proxy validation error, got %v", err)
+	}
+
+	_, err = Load("", envMap(map[string]string{"PUBLIC_URL": "hooks.example.com"}))
+	if err == nil || !strings.Contains(err.Error(), `public_url "hooks.example.com"`) {
+		t.Fatalf("expected a public URL validation error, got %v", err)
+	}
+
+	_, err = Load("", envMap(map[string]string{"LOG_FORMAT": "xml", "LOG_LEVEL": "loud"}))
+	if err == nil || !strings.Contains(err.Error(), "log.format") || !strings.Contains(err.Error(), "log.level") {
+		t.Fatalf("expected log validation errors, got %v", err)
+	}
+
+	_, err = Load("", envMap(map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "99999"}))
+	if err == nil || !strings.Contains(err.Error(), "smtp.port") || !strings.Contains(err.Error(), "smtp.from") {
+		t.Fatalf("expected SMTP validation errors, got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "typo.yaml")
+	if err := os.WriteFile(path, []byte("api_keys: nope\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path, envMap(nil)); err == nil || !strings.Contains(err.Error(), "api_keys") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookieName = "pipehook_session"
+	sessionTTL        = 30 * 24 * time.Hour
+	minPasswordLength = 8
+	// bcrypt ignores everything after 72 bytes, so longer passwords are refused
+	// rather than silently truncated.
+	maxPasswordLength = 72
+)
+
+const sessionContextKey contextKey = "session"
+
+var (
+	usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,64}$`)
+
+	// dummyPasswordHash keeps failed logins for unknown usernames as slow as
+	// those for known ones.
+	dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("pipehook-dummy-password"), bcrypt.DefaultCost)
+)
+
+type loginPageData struct {
+	BaseTemplateData
+	Mode        string
+	Username    string
+	Error       string
+	AllowSignup bool
+	SSO         bool
+}
+
+// SessionMiddleware resolves the session cookie, if any, and stores the
+// session and its user in the request context.
+func (h *Handler) SessionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName)
+		if err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		session, err := h.Store.GetSession(r.Context(), hashToken(cookie.Value))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey, session)))
+	})
+}
+
+func currentSession(r *http.Request) *store.Session {
+	session, _ := r.Context().Value(sessionContextKey).(*store.Session)
+	return session
+}
+
+func currentUser(r *http.Request) *store.User {
+	if session := currentSession(r); session != nil {
+		return session.User
+	}
+	return nil
+}
+
+func (h *Handler) baseTemplateData(r *http.Request) BaseTemplateData {
+	return BaseTemplateData{IsAdmin: h.IsAdminAuthenticated(r), User: currentUser(r)}
+}
+
+func (h *Handler) LoginPage(w http.ResponseWriter, r *http.Request) {
+	if currentUser(r) != nil {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	mode := "login"
+	if r.URL.Query().Get("mode") == "signup" && h.runtimeConfig().AllowSignup {
+		mode = "signup"
+	}
+	h.renderLogin(w, r, http.StatusOK, loginPageData{Mode: mode})
+}
+
+func (h *Handler) renderLogin(w http.ResponseWriter, r *http.Request, status int, data loginPageData) {
+	data.BaseTemplateData = h.baseTemplateData(r)
+	data.AllowSignup = h.runtimeConfig().AllowSignup
+	data.SSO = h.OIDC != nil
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := loginTemplate.ExecuteTemplate(w, "layout", data); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+	}
+}
+
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	username, password := r.FormValue("username"), r.FormValue("password")
+	user, passwordHash, err := h.Store.GetUserByUsername(r.Context(), username)
+	if err != nil {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		h.renderLogin(w, r, http.StatusUnauthorized, loginPageData{Mode: "login", Username: username, Error: "invalid username or password"})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) != nil {
+		h.renderLogin(w, r, http.StatusUnauthorized, loginPageData{Mode: "login", Username: username, Error: "invalid username or password"})
+		return
+	}
+	h.startSession(w, r, user, false, "/")
+}
+
+func (h *Handler) Signup(w http.ResponseWriter, r *http.Request) {
+	if !h.runtimeConfig().AllowSignup {
+		http.Error(w, "sign-up is disabled", http.StatusForbidden)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	username, password := r.FormValue("username"), r.FormValue("password")
+	user, err := h.createUser(r.Context(), username, password)
+	if err != nil {
+		h.renderLogin(w, r, http.StatusBadRequest, loginPageData{Mode: "signup", Username: username, Error: err.Error()})
+		return
+	}
+	h.startSession(w, r, user, false, "/")
+}
+
+func (h *Handler) createUser(ctx context.Context, username, password string) (*store.User, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, errors.New("username must be 3-64 letters, digits, dots, dashes or underscores")
+	}
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return nil, errors.New("password must be between 8 and 72 bytes")
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user := &store.User{ID: uuid.NewString(), Username: username}
+	if err := h.Store.CreateUser(ctx, user, string(passwordHash)); err != nil {
+		if errors.Is(err, store.ErrUsernameTaken) {
+			return nil, errors.New("that username is already taken")
+		}
+		slog.ErrorContext(ctx, "failed to create user", "error", err)
+		return nil, errors.New("failed to create the account")
+	}
+	return user, nil
+}
+
+// startSession signs the user in and redirects to next. admin is only ever
+// true for sign-ins through the identity provider.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *store.User, admin bool, next string) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		http.Error(w, "failed to start session", http.StatusInternalServerError)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	expiresAt := time.Now().Add(sessionTTL)
+	session := &store.Session{TokenHash: hashToken(token), UserID: user.ID, Admin: admin, ExpiresAt: expiresAt}
+	if err := h.Store.CreateSession(r.Context(), session); err != nil {
+		slog.ErrorContext(r.Context(), "failed to create session", "user_id", user.ID, "error", err)
+		http.Error(w, "failed to start session", http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   h.requestScheme(r) == "https",
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if err := h.Store.DeleteSession(r.Context(), hashToken(cookie.Value)); err != nil {
+			slog.ErrorContext(r.Context(), "failed to delete session", "error", err)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ClaimBrowserEndpoints moves the endpoints owned by the current browser
+// cookie to the signed-in account. Afterwards the cookie alone no longer
+// grants access to them.
+func (h *Handler) ClaimBrowserEndpoints(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	claimed, err := h.Store.ClaimEndpoints(r.Context(), browserIDFromRequest(r), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to claim endpoints", "user_id", user.ID, "error", err)
+		http.Error(w, "failed to claim endpoints", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "claimed browser endpoints", "user_id", user.ID, "endpoints", claimed)
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// listOwnEndpoints returns the endpoints shown in the UI: the account's
+// endpoints when signed in, otherwise those created by this browser. Signed-in
+// users also get the browser's unclaimed endpoints so they can claim them.
+func (h *Handler) listOwnEndpoints(r *http.Request, browserID string, limit int) (owned, unclaimed []*store.Endpoint, err error) {
+	unclaimed, err = h.Store.ListEndpoints(r.Context(), browserID, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	user := currentUser(r)
+	if user == nil {
+		return unclaimed, nil, nil
+	}
+	owned, err = h.Store.ListUserEndpoints(r.Context(), user.ID, limit)
+	return owned, unclaimed, err
+}
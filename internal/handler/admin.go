@@ -1,8 +1,9 @@
 package handler
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/PipeOpsHQ/pipehook/internal/store"
 	"github.com/go-chi/chi/v5"
@@ -11,23 +12,52 @@ import (
 func (h *Handler) AdminPage(w http.ResponseWriter, r *http.Request) {
 	stats, err := h.Store.GetAdminStats(r.Context())
 	if err != nil {
-		log.Printf("failed to get admin stats: %v", err)
+		slog.ErrorContext(r.Context(), "failed to get admin stats", "error", err)
 		http.Error(w, "failed to load admin statistics", http.StatusInternalServerError)
 		return
 	}
 
+	apiKeys, err := h.Store.ListAPIKeys(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list API keys", "error", err)
+		apiKeys = []*store.APIKey{}
+	}
+
+	auditFilter := auditFilterFromQuery(r)
+	auditEvents, err := h.Store.ListAuditEvents(r.Context(), auditFilter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list audit events", "error", err)
+		auditEvents = []*store.AuditEvent{}
+	}
+
 	data := struct {
 		BaseTemplateData
-		Stats *store.AdminStats
+		Stats         *store.AdminStats
+		RateLimits    RateLimitStats
+		APIKeys       []*store.APIKey
+		APIScopes     []string
+		AuditEvents   []*store.AuditEvent
+		AuditFilter   store.AuditFilter
+		AuditActions  []string
+		AuditExport   string
+		AuditOlderURL string
+		Now           time.Time
 	}{
-		BaseTemplateData: BaseTemplateData{
-			IsAdmin: h.IsAdminAuthenticated(r),
-		},
-		Stats: stats,
+		BaseTemplateData: h.baseTemplateData(r),
+		Stats:            stats,
+		RateLimits:       h.RateLimitStats(),
+		APIKeys:          apiKeys,
+		APIScopes:        store.APIScopes,
+		AuditEvents:      auditEvents,
+		AuditFilter:      auditFilter,
+		AuditActions:     auditActions,
+		AuditExport:      auditExportURL(r),
+		AuditOlderURL:    auditOlderURL(r, auditEvents, auditFilter.Limit),
+		Now:              time.Now(),
 	}
 
 	if err := adminTemplate.ExecuteTemplate(w, "layout", data); err != nil {
-		log.Printf("template execution error: %v", err)
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
 		http.Error(w, "failed to render page", http.StatusInternalServerError)
 		return
 	}
@@ -40,7 +70,8 @@ func (h *Handler) AdminDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err := h.Store.GetEndpoint(r.Context(), endpointID); err != nil {
+	endpoint, err := h.Store.GetEndpoint(r.Context(), endpointID)
+	if err != nil {
 		http.Error(w, "endpoint not found", http.StatusNotFound)
 		return
 	}
@@ -50,6 +81,8 @@ func (h *Handler) AdminDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to delete endpoint", http.StatusInternalServerError)
 		return
 	}
+	h.forgetEndpointMetrics(endpointID)
+	h.audit(r, auditEndpointDelete, "endpoint", endpointID, map[string]string{"alias": endpoint.Alias})
 
 	w.Header().Set("HX-Trigger", "endpointDeleted")
 	w.WriteHeader(http.StatusOK)
@@ -13,15 +13,50 @@ import (
 )
 
 type apiEndpointInput struct {
-	Alias              string `json:"alias"`
-	TTL                string `json:"ttl"`
-	DefaultStatus      int    `json:"default_status"`
-	DefaultBody        string `json:"default_body"`
-	DefaultContentType string `json:"default_content_type"`
-	ResponseDelayMS    int    `json:"response_delay_ms"`
-	EnableCORS         bool   `json:"enable_cors"`
-	ForwardURL         string `json:"forward_url"`
-	RequestLimit       int    `json:"request_limit"`
+	Alias              string           `json:"alias"`
+	TTL                string           `json:"ttl"`
+	DefaultStatus      int              `json:"default_status"`
+	DefaultBody        string           `json:"default_body"`
+	DefaultContentType string           `json:"default_content_type"`
+	ResponseDelayMS    int              `json:"response_delay_ms"`
+	EnableCORS         bool             `json:"enable_cors"`
+	ForwardURL         string           `json:"forward_url"`
+	RequestLimit       int              `json:"request_limit"`
+	InboundAuth        inboundAuthInput `json:"inbound_auth"`
+	Throttle           store.Throttle   `json:"throttle"`
+	Chaos              store.Chaos      `json:"chaos"`
+}
+
+// inboundAuthInput takes the secrets that store.InboundAuth never encodes.
+// A blank secret keeps the stored one unless its *_set field is false, so
+// an endpoint read from the API can be sent back unchanged.
+type inboundAuthInput struct {
+	BasicUsername    string   `json:"basic_username"`
+	BasicPassword    string   `json:"basic_password"`
+	BasicPasswordSet *bool    `json:"basic_password_set"`
+	BearerToken      string   `json:"bearer_token"`
+	BearerTokenSet   *bool    `json:"bearer_token_set"`
+	HeaderName       string   `json:"header_name"`
+	HeaderValue      string   `json:"header_value"`
+	HeaderValueSet   *bool    `json:"header_value_set"`
+	AllowedCIDRs     []string `json:"allowed_cidrs"`
+	ClientCertSHA256 []string `json:"client_cert_sha256"`
+	StoreRejected    bool     `json:"store_rejected"`
+}
+
+func (input inboundAuthInput) auth() store.InboundAuth {
+	return store.InboundAuth{
+		BasicUsername: input.BasicUsername, BasicPassword: input.BasicPassword, BearerToken: input.BearerToken,
+		HeaderName: input.HeaderName, HeaderValue: input.HeaderValue, AllowedCIDRs: input.AllowedCIDRs,
+		ClientCertSHA256: input.ClientCertSHA256, StoreRejected: input.StoreRejected,
+	}
+}
+
+func (input inboundAuthInput) cleared() inboundSecrets {
+	unset := func(set *bool) bool { return set != nil && !*set }
+	return inboundSecrets{
+		basicPassword: unset(input.BasicPasswordSet), bearerToken: unset(input.BearerTokenSet), headerValue: unset(input.HeaderValueSet),
+	}
 }
 
 type apiRequestSummary struct {
@@ -86,11 +121,60 @@ func settingsFromAPI(input apiEndpointInput) store.EndpointSettings {
 	settings.ResponseDelayMS = input.ResponseDelayMS
 	settings.EnableCORS = input.EnableCORS
 	settings.ForwardURL = strings.TrimSpace(input.ForwardURL)
+	settings.InboundAuth = normalizeInboundAuth(input.InboundAuth.auth())
+	settings.Throttle = normalizeThrottle(input.Throttle)
+	settings.Chaos = normalizeChaos(input.Chaos)
 	return settings
 }
 
+// apiEndpoint loads an endpoint for the API and checks it with authorize.
+func (h *Handler) apiEndpoint(w http.ResponseWriter, r *http.Request, id string, perm permission) (*store.Endpoint, bool) {
+	endpoint, err := h.Store.GetEndpoint(r.Context(), id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "endpoint not found"})
+		return nil, false
+	}
+	if !h.authorize(r, endpoint, perm) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "API key is not allowed to access this endpoint"})
+		return nil, false
+	}
+	return endpoint, true
+}
+
+func (h *Handler) apiRequest(w http.ResponseWriter, r *http.Request, perm permission) (*store.Request, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "requestID"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request ID"})
+		return nil, false
+	}
+	request, err := h.Store.GetRequest(r.Context(), id)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "request not found"})
+		return nil, false
+	}
+	if _, ok := h.apiEndpoint(w, r, request.EndpointID, perm); !ok {
+		return nil, false
+	}
+	return request, true
+}
+
 func (h *Handler) APIListEndpoints(w http.ResponseWriter, r *http.Request) {
 	limit, offset := apiPagination(r)
+	if key := apiKeyFromContext(r.Context()); key != nil && len(key.EndpointIDs) > 0 {
+		endpoints := make([]*store.Endpoint, 0, len(key.EndpointIDs))
+		for _, id := range key.EndpointIDs {
+			if endpoint, err := h.Store.GetEndpoint(r.Context(), id); err == nil {
+				endpoints = append(endpoints, endpoint)
+			}
+		}
+		if offset >= len(endpoints) {
+			endpoints = endpoints[:0]
+		} else {
+			endpoints = endpoints[offset:min(len(endpoints), offset+limit)]
+		}
+		writeJSON(w, http.StatusOK, endpoints)
+		return
+	}
 	endpoints, err := h.Store.ListAllEndpoints(r.Context(), limit, offset)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list endpoints"})
@@ -100,6 +184,10 @@ func (h *Handler) APIListEndpoints(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) APICreateEndpoint(w http.ResponseWriter, r *http.Request) {
+	if key := apiKeyFromContext(r.Context()); key != nil && len(key.EndpointIDs) > 0 {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "endpoint-restricted API keys cannot create endpoints"})
+		return
+	}
 	var input apiEndpointInput
 	if !decodeJSON(w, r, &input) {
 		return
@@ -124,9 +212,8 @@ func (h *Handler) APICreateEndpoint(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) APIGetEndpoint(w http.ResponseWriter, r *http.Request) {
-	endpoint, err := h.Store.GetEndpoint(r.Context(), chi.URLParam(r, "endpointID"))
-	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "endpoint not found"})
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
 		return
 	}
 	writeJSON(w, http.StatusOK, endpoint)
@@ -134,8 +221,8 @@ func (h *Handler) APIGetEndpoint(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) APIUpdateEndpoint(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "endpointID")
-	if _, err := h.Store.GetEndpoint(r.Context(), id); err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "endpoint not found"})
+	before, ok := h.apiEndpoint(w, r, id, permEdit)
+	if !ok {
 		return
 	}
 	var input apiEndpointInput
@@ -143,6 +230,7 @@ func (h *Handler) APIUpdateEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	settings := settingsFromAPI(input)
+	settings.InboundAuth = keepInboundSecrets(settings.InboundAuth, before.InboundAuth, input.InboundAuth.cleared())
 	if err := validateEndpointSettings(settings); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
@@ -152,14 +240,17 @@ func (h *Handler) APIUpdateEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	_ = h.Store.TrimRequests(r.Context(), id, settings.RequestLimit)
-	endpoint, _ := h.Store.GetEndpoint(r.Context(), id)
+	endpoint, err := h.Store.GetEndpoint(r.Context(), id)
+	if err == nil {
+		h.audit(r, auditEndpointUpdate, "endpoint", id, endpointSettingsChanges(before, endpoint))
+	}
 	writeJSON(w, http.StatusOK, endpoint)
 }
 
 func (h *Handler) APIDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "endpointID")
-	if _, err := h.Store.GetEndpoint(r.Context(), id); err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "endpoint not found"})
+	endpoint, ok := h.apiEndpoint(w, r, id, permManage)
+	if !ok {
 		return
 	}
 	h.closeEndpointConnections(id)
@@ -167,13 +258,14 @@ func (h *Handler) APIDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete endpoint"})
 		return
 	}
+	h.forgetEndpointMetrics(id)
+	h.audit(r, auditEndpointDelete, "endpoint", id, map[string]string{"alias": endpoint.Alias})
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (h *Handler) APIListRequests(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "endpointID")
-	if _, err := h.Store.GetEndpoint(r.Context(), id); err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "endpoint not found"})
+	if _, ok := h.apiEndpoint(w, r, id, permView); !ok {
 		return
 	}
 	limit, offset := apiPagination(r)
@@ -196,33 +288,23 @@ func (h *Handler) APIListRequests(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) APIGetRequest(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "requestID"), 10, 64)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request ID"})
-		return
-	}
-	request, err := h.Store.GetRequest(r.Context(), id)
-	if err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "request not found"})
+	request, ok := h.apiRequest(w, r, permView)
+	if !ok {
 		return
 	}
 	writeJSON(w, http.StatusOK, exportRequest(request))
 }
 
 func (h *Handler) APIDeleteRequest(w http.ResponseWriter, r *http.Request) {
-	id, err := strconv.ParseInt(chi.URLParam(r, "requestID"), 10, 64)
-	if err != nil {
-		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request ID"})
-		return
-	}
-	if _, err := h.Store.GetRequest(r.Context(), id); err != nil {
-		writeJSON(w, http.StatusNotFound, map[string]string{"error": "request not found"})
+	request, ok := h.apiRequest(w, r, permEdit)
+	if !ok {
 		return
 	}
-	if err := h.Store.DeleteRequest(r.Context(), id); err != nil {
+	if err := h.Store.DeleteRequest(r.Context(), request.ID); err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to delete request"})
 		return
 	}
+	h.audit(r, auditRequestDelete, "request", strconv.FormatInt(request.ID, 10), map[string]string{"endpoint_id": request.EndpointID})
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const apiKeyTokenPrefix = "phk_"
+
+type apiKeyInput struct {
+	Name        string     `json:"name"`
+	Scopes      []string   `json:"scopes"`
+	EndpointIDs []string   `json:"endpoint_ids"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+type createdAPIKey struct {
+	*store.APIKey
+	Token string `json:"token"`
+}
+
+// hashToken returns the SHA-256 of a bearer secret such as an API key or a
+// session token. Only hashes are stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAPIKeyToken() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return apiKeyTokenPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// createAPIKey validates input, stores the hashed key and returns the
+// plaintext token, which is only ever shown to the caller once.
+func (h *Handler) createAPIKey(ctx context.Context, input apiKeyInput) (*createdAPIKey, error) {
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len(input.Name) > 120 {
+		return nil, errors.New("name is required and must not exceed 120 characters")
+	}
+	if len(input.Scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	scopes := make([]string, 0, len(input.Scopes))
+	for _, scope := range input.Scopes {
+		scope = strings.TrimSpace(scope)
+		if !slices.Contains(store.APIScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	endpointIDs := make([]string, 0, len(input.EndpointIDs))
+	for _, id := range input.EndpointIDs {
+		id = strings.TrimSpace(id)
+		if id == "" || slices.Contains(endpointIDs, id) {
+			continue
+		}
+		if _, err := h.Store.GetEndpoint(ctx, id); err != nil {
+			return nil, fmt.Errorf("endpoint %q does not exist", id)
+		}
+		endpointIDs = append(endpointIDs, id)
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, errors.New("expiry must be in the future")
+	}
+
+	token, err := generateAPIKeyToken()
+	if err != nil {
+		return nil, err
+	}
+	key := &store.APIKey{
+		ID: uuid.NewString(), Name: input.Name, Prefix: token[:len(apiKeyTokenPrefix)+8], Scopes: scopes,
+		EndpointIDs: endpointIDs, ExpiresAt: input.ExpiresAt,
+	}
+	if err := h.Store.CreateAPIKey(ctx, key, hashToken(token)); err != nil {
+		return nil, err
+	}
+	return &createdAPIKey{APIKey: key, Token: token}, nil
+}
+
+func (h *Handler) auditAPIKeyCreated(r *http.Request, key *store.APIKey) {
+	h.audit(r, auditAPIKeyCreate, "api_key", key.ID, map[string]any{
+		"name": key.Name, "scopes": key.Scopes, "endpoint_ids": key.EndpointIDs, "expires_at": key.ExpiresAt,
+	})
+}
+
+func (h *Handler) APIListKeys(w http.ResponseWriter, r *http.Request) {
+	if rejectRestrictedAPIKey(w, r, "manage API keys") {
+		return
+	}
+	keys, err := h.Store.ListAPIKeys(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list API keys"})
+		return
+	}
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (h *Handler) APICreateKey(w http.ResponseWriter, r *http.Request) {
+	if rejectRestrictedAPIKey(w, r, "manage API keys") {
+		return
+	}
+	var input apiKeyInput
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	created, err := h.createAPIKey(r.Context(), input)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.auditAPIKeyCreated(r, created.APIKey)
+	writeJSON(w, http.StatusCreated, created)
+}
+
+func (h *Handler) APIRevokeKey(w http.ResponseWriter, r *http.Request) {
+	if rejectRestrictedAPIKey(w, r, "manage API keys") {
+		return
+	}
+	if err := h.Store.RevokeAPIKey(r.Context(), chi.URLParam(r, "keyID")); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "API key not found or already revoked"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to revoke API key"})
+		return
+	}
+	h.audit(r, auditAPIKeyRevoke, "api_key", chi.URLParam(r, "keyID"), nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) AdminCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 64*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	input := apiKeyInput{Name: r.FormValue("name"), Scopes: r.Form["scopes"]}
+	for _, id := range strings.FieldsFunc(r.FormValue("endpoint_ids"), func(c rune) bool { return c == ',' || c == ' ' || c == '\n' }) {
+		input.EndpointIDs = append(input.EndpointIDs, id)
+	}
+	if days := strings.TrimSpace(r.FormValue("expires_in_days")); days != "" {
+		parsed, err := strconv.Atoi(days)
+		if err != nil || parsed < 1 || parsed > 3650 {
+			http.Error(w, "expiry must be between 1 and 3650 days", http.StatusBadRequest)
+			return
+		}
+		expiresAt := time.Now().Add(time.Duration(parsed) * 24 * time.Hour)
+		input.ExpiresAt = &expiresAt
+	}
+	created, err := h.createAPIKey(r.Context(), input)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.auditAPIKeyCreated(r, created.APIKey)
+	if err := adminTemplate.ExecuteTemplate(w, "api-key-created", created); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+	}
+}
+
+func (h *Handler) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := h.Store.RevokeAPIKey(r.Context(), chi.URLParam(r, "keyID")); err != nil {
+		http.Error(w, "API key not found or already revoked", http.StatusNotFound)
+		return
+	}
+	h.audit(r, auditAPIKeyRevoke, "api_key", chi.URLParam(r, "keyID"), nil)
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
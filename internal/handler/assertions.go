@@ -0,0 +1,424 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PipeOpsHQ/pipehook/internal/jsonschema"
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxAssertionMethods    = 10
+	maxAssertionHeaders    = 20
+	maxAssertionSchemaSize = 64 * 1024
+	maxCachedSchemas       = 10000
+)
+
+// schemaCache keeps compiled assertion schemas by endpoint and path, so a
+// capture does not recompile a schema of up to 64KB. An entry only counts
+// while the stored schema is unchanged, which covers replaced and imported
+// assertions without explicit invalidation.
+type schemaCache struct {
+	mu      sync.Mutex
+	schemas map[string]cachedSchema
+}
+
+type cachedSchema struct {
+	raw    string
+	schema *jsonschema.Schema
+}
+
+func newSchemaCache() *schemaCache {
+	return &schemaCache{schemas: make(map[string]cachedSchema)}
+}
+
+func (c *schemaCache) put(assertion *store.Assertion, schema *jsonschema.Schema) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.schemas) >= maxCachedSchemas {
+		clear(c.schemas)
+	}
+	c.schemas[assertion.EndpointID+"\x00"+assertion.Path] = cachedSchema{raw: string(assertion.Schema), schema: schema}
+}
+
+func (c *schemaCache) remove(endpointID, path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.schemas, endpointID+"\x00"+path)
+}
+
+// compiled returns the compiled schema of assertion, compiling and caching
+// it on a miss.
+func (c *schemaCache) compiled(assertion *store.Assertion) (*jsonschema.Schema, error) {
+	c.mu.Lock()
+	cached, ok := c.schemas[assertion.EndpointID+"\x00"+assertion.Path]
+	c.mu.Unlock()
+	if ok && cached.raw == string(assertion.Schema) {
+		return cached.schema, nil
+	}
+	schema, err := jsonschema.Compile(assertion.Schema)
+	if err != nil {
+		return nil, err
+	}
+	c.put(assertion, schema)
+	return schema, nil
+}
+
+type assertionInput struct {
+	Path         string            `json:"path"`
+	Methods      []string          `json:"methods"`
+	Headers      map[string]string `json:"headers"`
+	Schema       json.RawMessage   `json:"schema"`
+	RejectStatus int               `json:"reject_status"`
+}
+
+func normalizeAssertion(input assertionInput) assertionInput {
+	input.Path = normalizeCapturePath(input.Path)
+	methods := make([]string, 0, len(input.Methods))
+	for _, method := range input.Methods {
+		if method = strings.ToUpper(strings.TrimSpace(method)); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	input.Methods = methods
+	headers := make(map[string]string, len(input.Headers))
+	for name, value := range input.Headers {
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	input.Headers = headers
+	if trimmed := bytes.TrimSpace(input.Schema); len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		input.Schema = nil
+	}
+	return input
+}
+
+// validateAssertion checks input and returns its compiled schema, if any.
+func validateAssertion(input assertionInput) (*jsonschema.Schema, error) {
+	if len(input.Path) > maxSequencePathSize || strings.ContainsAny(input.Path, "?#") {
+		return nil, fmt.Errorf("path must be a URL path of at most %d characters", maxSequencePathSize)
+	}
+	if len(input.Methods) > maxAssertionMethods {
+		return nil, fmt.Errorf("at most %d methods are allowed", maxAssertionMethods)
+	}
+	for _, method := range input.Methods {
+		if !validHeaderName(method) {
+			return nil, fmt.Errorf("%q is not a valid method", method)
+		}
+	}
+	if len(input.Headers) > maxAssertionHeaders {
+		return nil, fmt.Errorf("at most %d headers are allowed", maxAssertionHeaders)
+	}
+	for name, value := range input.Headers {
+		if !validHeaderName(name) {
+			return nil, fmt.Errorf("%q is not a valid header name", name)
+		}
+		if len(value) > 1024 {
+			return nil, fmt.Errorf("header %s: value must not exceed 1024 characters", name)
+		}
+	}
+	if len(input.Schema) > maxAssertionSchemaSize {
+		return nil, errors.New("schema must not exceed 64KB")
+	}
+	var schema *jsonschema.Schema
+	if input.Schema != nil {
+		var err error
+		if schema, err = jsonschema.Compile(input.Schema); err != nil {
+			return nil, fmt.Errorf("schema: %w", err)
+		}
+	}
+	if input.RejectStatus != 0 && (input.RejectStatus < 400 || input.RejectStatus > 599) {
+		return nil, errors.New("reject_status must be 0 or between 400 and 599")
+	}
+	if len(input.Methods) == 0 && len(input.Headers) == 0 && input.Schema == nil {
+		return nil, errors.New("an assertion needs methods, headers or a schema to check")
+	}
+	return schema, nil
+}
+
+// parseAssertionHeaders reads one "Name: value" expectation per line. A name
+// on its own only requires the header to be present.
+func parseAssertionHeaders(text string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for number, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(line, ":")
+		if name = strings.TrimSpace(name); name == "" {
+			return nil, fmt.Errorf("line %d: %q does not start with a header name", number+1, line)
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// assertionView adds the editable text forms of an assertion for the
+// settings page.
+type assertionView struct {
+	*store.Assertion
+	MethodsText string
+	HeadersText string
+	SchemaText  string
+}
+
+func (h *Handler) assertionViews(r *http.Request, endpointID string) []assertionView {
+	assertions, err := h.Store.ListAssertions(r.Context(), endpointID)
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to list assertions", "endpoint_id", endpointID, "error", err)
+		return nil
+	}
+	views := make([]assertionView, len(assertions))
+	for i, assertion := range assertions {
+		names := make([]string, 0, len(assertion.Headers))
+		for name := range assertion.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		lines := make([]string, len(names))
+		for j, name := range names {
+			lines[j] = strings.TrimSpace(name + ": " + assertion.Headers[name])
+		}
+		views[i] = assertionView{Assertion: assertion, MethodsText: strings.Join(assertion.Methods, " "), HeadersText: strings.Join(lines, "\n")}
+		var schema bytes.Buffer
+		if json.Indent(&schema, assertion.Schema, "", "  ") == nil {
+			views[i].SchemaText = schema.String()
+		}
+	}
+	return views
+}
+
+// matchAssertion returns the assertion that applies to r, if any. A store
+// failure is logged and treated as no assertion so captures keep working.
+func (h *Handler) matchAssertion(r *http.Request, endpoint *store.Endpoint) *store.Assertion {
+	assertion, err := h.Store.MatchAssertion(r.Context(), endpoint.ID, capturePath(r, endpoint.ID))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to look up assertion", "endpoint_id", endpoint.ID, "error", err)
+		return nil
+	}
+	return assertion
+}
+
+// checkAssertion compares a capture with what assertion expects. The body is
+// decoded per its Content-Encoding before the schema sees it.
+func (h *Handler) checkAssertion(assertion *store.Assertion, captured *store.Request, header http.Header, maxBodyBytes int) *store.Validation {
+	var violations []string
+	if len(assertion.Methods) > 0 && !slices.Contains(assertion.Methods, captured.Method) {
+		violations = append(violations, fmt.Sprintf("method: must be one of %s, got %s", strings.Join(assertion.Methods, ", "), captured.Method))
+	}
+	names := make([]string, 0, len(assertion.Headers))
+	for name := range assertion.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values, present := header[http.CanonicalHeaderKey(name)]
+		want := assertion.Headers[name]
+		switch {
+		case !present:
+			violations = append(violations, fmt.Sprintf("header %s: missing", name))
+		case want != "" && !slices.Contains(values, want):
+			violations = append(violations, fmt.Sprintf("header %s: must be %q, got %q", name, want, strings.Join(values, ", ")))
+		}
+	}
+	if assertion.Schema != nil {
+		schema, err := h.schemas.compiled(assertion)
+		violations = append(violations, checkSchema(schema, err, captured, header.Get("Content-Encoding"), maxBodyBytes)...)
+	}
+	return &store.Validation{Passed: len(violations) == 0, Path: assertion.Path, Violations: violations}
+}
+
+func checkSchema(schema *jsonschema.Schema, err error, captured *store.Request, contentEncoding string, maxBodyBytes int) []string {
+	if err != nil {
+		// Schemas are checked when saved, so this only happens to a stored
+		// schema that a newer build reads differently.
+		return []string{"schema: " + err.Error()}
+	}
+	if captured.BodyTruncated {
+		return []string{"body: truncated at the capture size limit, so it cannot be validated"}
+	}
+	body, truncated, err := decodeBodyForDisplay(captured.Body, contentEncoding, maxBodyBytes)
+	if err != nil {
+		return []string{"body: cannot be decoded: " + err.Error()}
+	}
+	if truncated {
+		return []string{"body: decodes to more than the capture size limit, so it cannot be validated"}
+	}
+	violations, err := schema.Validate(body)
+	if err != nil {
+		return []string{"body: is not valid JSON"}
+	}
+	return violations
+}
+
+// rejectsInvalid reports whether captured failed assertion and should be
+// answered with its rejection status. A chaos error status still wins.
+func rejectsInvalid(assertion *store.Assertion, captured *store.Request) bool {
+	if assertion == nil || assertion.RejectStatus == 0 || captured.Validation == nil || captured.Validation.Passed {
+		return false
+	}
+	return captured.Chaos == nil || captured.Chaos.Status == 0
+}
+
+// validationRejection is the response body for a capture refused by its
+// assertion.
+func validationRejection(validation *store.Validation) []byte {
+	body, _ := json.Marshal(map[string]any{"error": "payload failed validation", "violations": validation.Violations})
+	return body
+}
+
+// saveAssertion validates and stores an assertion. The returned status tells
+// the caller whether an error was the input's fault.
+func (h *Handler) saveAssertion(r *http.Request, endpointID string, input assertionInput) (*store.Assertion, int, error) {
+	input = normalizeAssertion(input)
+	schema, err := validateAssertion(input)
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	assertion := &store.Assertion{
+		EndpointID: endpointID, Path: input.Path, Methods: input.Methods, Headers: input.Headers,
+		Schema: input.Schema, RejectStatus: input.RejectStatus,
+	}
+	if err := h.Store.SetAssertion(r.Context(), assertion); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save assertion", "endpoint_id", endpointID, "error", err)
+		return nil, http.StatusInternalServerError, errors.New("failed to save assertion")
+	}
+	if schema != nil {
+		h.schemas.put(assertion, schema)
+	}
+	// Header values can be shared secrets, so only their names are audited.
+	h.audit(r, auditAssertionSet, "endpoint", endpointID, map[string]any{
+		"path": assertion.Path, "methods": assertion.Methods, "headers": len(assertion.Headers),
+		"schema": assertion.Schema != nil, "reject_status": assertion.RejectStatus,
+	})
+	return assertion, http.StatusOK, nil
+}
+
+func (h *Handler) deleteAssertion(r *http.Request, endpointID string) (int, error) {
+	path := normalizeCapturePath(r.URL.Query().Get("path"))
+	if err := h.Store.DeleteAssertion(r.Context(), endpointID, path); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return http.StatusNotFound, errors.New("assertion not found")
+		}
+		slog.ErrorContext(r.Context(), "failed to delete assertion", "endpoint_id", endpointID, "error", err)
+		return http.StatusInternalServerError, errors.New("failed to delete assertion")
+	}
+	h.schemas.remove(endpointID, path)
+	h.audit(r, auditAssertionDelete, "endpoint", endpointID, map[string]string{"path": path})
+	return http.StatusNoContent, nil
+}
+
+// APIListAssertions returns the endpoint's assertions. Header expectations
+// can hold secrets, so listing needs edit access.
+func (h *Handler) APIListAssertions(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	assertions, err := h.Store.ListAssertions(r.Context(), endpoint.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list assertions"})
+		return
+	}
+	writeJSON(w, http.StatusOK, assertions)
+}
+
+// APISetAssertion creates or replaces the assertion for a path.
+func (h *Handler) APISetAssertion(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	var input assertionInput
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	assertion, status, err := h.saveAssertion(r, endpoint.ID, input)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, status, assertion)
+}
+
+// APIDeleteAssertion removes the assertion for ?path=.
+func (h *Handler) APIDeleteAssertion(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	if status, err := h.deleteAssertion(r, endpoint.ID); err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SaveAssertion handles the settings form, which lists methods separated by
+// spaces or commas and one header expectation per line.
+func (h *Handler) SaveAssertion(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 128*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	headers, err := parseAssertionHeaders(r.FormValue("headers"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input := assertionInput{
+		Path:    r.FormValue("path"),
+		Methods: strings.FieldsFunc(r.FormValue("methods"), func(c rune) bool { return c == ',' || c == ' ' }),
+		Headers: headers,
+		Schema:  json.RawMessage(strings.TrimSpace(r.FormValue("schema"))),
+	}
+	if len(input.Schema) > 0 && !json.Valid(input.Schema) {
+		http.Error(w, "schema must be valid JSON", http.StatusBadRequest)
+		return
+	}
+	if status := strings.TrimSpace(r.FormValue("reject_status")); status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			http.Error(w, "reject_status must be a number", http.StatusBadRequest)
+			return
+		}
+		input.RejectStatus = code
+	}
+	if _, status, err := h.saveAssertion(r, endpoint.ID, input); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("HX-Redirect", "/"+endpoint.ID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteAssertion removes the assertion for ?path= from the settings page.
+func (h *Handler) DeleteAssertion(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	if status, err := h.deleteAssertion(r, endpoint.ID); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
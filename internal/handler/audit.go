@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+const (
+	auditEndpointDelete   = "endpoint.delete"
+	auditEndpointUpdate   = "endpoint.update"
+	auditEndpointMove     = "endpoint.move"
+	auditRequestDelete    = "request.delete"
+	auditRequestReplay    = "request.replay"
+	auditRequestImport    = "request.import"
+	auditAPIKeyCreate     = "api_key.create"
+	auditAPIKeyRevoke     = "api_key.revoke"
+	auditAPIKeyUse        = "api_key.use"
+	auditShareCreate      = "share.create"
+	auditShareRevoke      = "share.revoke"
+	auditWorkspaceMember  = "workspace.member_set"
+	auditWorkspaceRemoved = "workspace.member_remove"
+	auditSequenceSet      = "sequence.set"
+	auditSequenceReset    = "sequence.reset"
+	auditSequenceDelete   = "sequence.delete"
+
+	auditNotificationCreate = "notification.create"
+	auditNotificationDelete = "notification.delete"
+	auditAssertionSet       = "assertion.set"
+	auditAssertionDelete    = "assertion.delete"
+
+	auditPageSize = 100
+)
+
+// auditActions lists every recorded action for the admin page filter.
+var auditActions = []string{
+	auditEndpointDelete, auditEndpointUpdate, auditEndpointMove, auditRequestDelete, auditRequestReplay, auditRequestImport,
+	auditAPIKeyCreate, auditAPIKeyRevoke, auditAPIKeyUse, auditShareCreate, auditShareRevoke,
+	auditWorkspaceMember, auditWorkspaceRemoved, auditSequenceSet, auditSequenceReset, auditSequenceDelete,
+	auditNotificationCreate, auditNotificationDelete, auditAssertionSet, auditAssertionDelete,
+}
+
+// auditChange is one changed field in an audit event's details.
+type auditChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// audit records an action taken by the current request's actor. Failing to
+// record never fails the action itself; it is logged instead.
+func (h *Handler) audit(r *http.Request, action, targetType, targetID string, details any) {
+	event := &store.AuditEvent{
+		Action: action, TargetType: targetType, TargetID: targetID, RemoteAddr: h.clientIP(r),
+	}
+	switch key, user, session := apiKeyFromContext(r.Context()), currentUser(r), currentSession(r); {
+	case key != nil:
+		event.ActorType, event.ActorID, event.Actor = store.AuditActorAPIKey, key.ID, key.Name
+	case user != nil && session.Admin && h.OIDC != nil:
+		event.ActorType, event.ActorID, event.Actor = store.AuditActorAdmin, user.ID, user.Username
+	case h.IsAdminAuthenticated(r):
+		event.ActorType, event.Actor = store.AuditActorAdmin, "admin"
+	case user != nil:
+		event.ActorType, event.ActorID, event.Actor = store.AuditActorUser, user.ID, user.Username
+	default:
+		browserID := browserIDFromRequest(r)
+		event.ActorType, event.ActorID, event.Actor = store.AuditActorBrowser, browserID, browserID
+	}
+	if details != nil {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to encode audit details", "action", action, "error", err)
+		} else {
+			event.Details = encoded
+		}
+	}
+	if err := h.Store.RecordAuditEvent(r.Context(), event); err != nil {
+		slog.ErrorContext(r.Context(), "failed to record audit event", "action", action, "target_type", targetType, "target_id", targetID, "error", err)
+	}
+}
+
+// endpointSettingsChanges returns the settings that differ between two
+// versions of an endpoint, keyed by their JSON names. Saving settings always
+// reapplies the TTL, so expires_at is normally among them.
+func endpointSettingsChanges(before, after *store.Endpoint) map[string]auditChange {
+	changes := make(map[string]auditChange)
+	if !before.ExpiresAt.Equal(after.ExpiresAt) {
+		changes["expires_at"] = auditChange{From: before.ExpiresAt, To: after.ExpiresAt}
+	}
+	compare := func(name string, from, to any) {
+		if from != to {
+			changes[name] = auditChange{From: from, To: to}
+		}
+	}
+	compare("alias", before.Alias, after.Alias)
+	compare("default_status", before.DefaultStatus, after.DefaultStatus)
+	compare("default_body", before.DefaultBody, after.DefaultBody)
+	compare("default_content_type", before.DefaultContentType, after.DefaultContentType)
+	compare("response_delay_ms", before.ResponseDelayMS, after.ResponseDelayMS)
+	compare("enable_cors", before.EnableCORS, after.EnableCORS)
+	compare("forward_url", before.ForwardURL, after.ForwardURL)
+	compare("request_limit", before.RequestLimit, after.RequestLimit)
+	compare("throttle", before.Throttle, after.Throttle)
+	if !reflect.DeepEqual(before.Chaos, after.Chaos) {
+		changes["chaos"] = auditChange{From: before.Chaos, To: after.Chaos}
+	}
+	if !reflect.DeepEqual(before.InboundAuth, after.InboundAuth) {
+		changes["inbound_auth"] = auditChange{From: inboundAuthSummary(before.InboundAuth), To: inboundAuthSummary(after.InboundAuth)}
+	}
+	if before.InboundAuth.BasicPassword != after.InboundAuth.BasicPassword || before.InboundAuth.BearerToken != after.InboundAuth.BearerToken ||
+		before.InboundAuth.HeaderValue != after.InboundAuth.HeaderValue {
+		changes["inbound_auth_secret"] = auditChange{From: "[redacted]", To: "[redacted]"}
+	}
+	return changes
+}
+
+// inboundAuthSummary describes sender requirements without their secrets.
+func inboundAuthSummary(auth store.InboundAuth) map[string]any {
+	return map[string]any{
+		"basic_username": auth.BasicUsername, "bearer_token": auth.BearerToken != "", "header_name": auth.HeaderName,
+		"allowed_cidrs": auth.AllowedCIDRs, "client_cert_sha256": auth.ClientCertSHA256, "store_rejected": auth.StoreRejected,
+	}
+}
+
+// auditFilterFromQuery reads actor_type, actor, action, target, since,
+// until (RFC 3339), before_id and limit.
+func auditFilterFromQuery(r *http.Request) store.AuditFilter {
+	query := r.URL.Query()
+	filter := store.AuditFilter{
+		ActorType: strings.TrimSpace(query.Get("actor_type")),
+		Actor:     strings.TrimSpace(query.Get("actor")),
+		Action:    strings.TrimSpace(query.Get("action")),
+		TargetID:  strings.TrimSpace(query.Get("target")),
+		Limit:     auditPageSize,
+	}
+	if since, err := time.Parse(time.RFC3339, query.Get("since")); err == nil {
+		filter.Since = since
+	}
+	if until, err := time.Parse(time.RFC3339, query.Get("until")); err == nil {
+		filter.Until = until
+	}
+	if beforeID, err := strconv.ParseInt(query.Get("before_id"), 10, 64); err == nil && beforeID > 0 {
+		filter.BeforeID = beforeID
+	}
+	if limit, err := strconv.Atoi(query.Get("limit")); err == nil && limit > 0 && limit <= 1000 {
+		filter.Limit = limit
+	}
+	return filter
+}
+
+// APIListAuditEvents returns one page of matching events as JSON, or with
+// format=ndjson streams every matching event, one per line, for export.
+func (h *Handler) APIListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter := auditFilterFromQuery(r)
+	if r.URL.Query().Get("format") == "ndjson" {
+		h.exportAuditEvents(w, r, filter)
+		return
+	}
+	events, err := h.Store.ListAuditEvents(r.Context(), filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list audit events"})
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+// AdminExportAuditEvents downloads the events matching the admin page filter.
+func (h *Handler) AdminExportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	h.exportAuditEvents(w, r, auditFilterFromQuery(r))
+}
+
+func (h *Handler) exportAuditEvents(w http.ResponseWriter, r *http.Request, filter store.AuditFilter) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="pipehook-audit.ndjson"`)
+	encoder := json.NewEncoder(w)
+	filter.Limit = auditPageSize
+	for {
+		events, err := h.Store.ListAuditEvents(r.Context(), filter)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to export audit events", "error", err)
+			return
+		}
+		for _, event := range events {
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+		}
+		if len(events) < filter.Limit || r.Context().Err() != nil {
+			return
+		}
+		filter.BeforeID = events[len(events)-1].ID
+	}
+}
+
+// auditOlderURL links to the page after events, keeping the other filters.
+func auditOlderURL(r *http.Request, events []*store.AuditEvent, limit int) string {
+	if len(events) < limit {
+		return ""
+	}
+	query := r.URL.Query()
+	query.Set("before_id", strconv.FormatInt(events[len(events)-1].ID, 10))
+	return "/admin?" + query.Encode() + "#audit"
+}
+
+// auditExportURL downloads the admin page's current filter, without paging.
+func auditExportURL(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("before_id")
+	query.Del("limit")
+	if len(query) == 0 {
+		return "/admin/audit.ndjson"
+	}
+	return "/admin/audit.ndjson?" + query.Encode()
+}
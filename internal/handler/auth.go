@@ -1,19 +1,26 @@
 package handler
 
 import (
+	"context"
 	"crypto/subtle"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/PipeOpsHQ/pipehook/internal/store"
 )
 
-// IsAdminAuthenticated checks if the current request has valid admin credentials
-// Returns true if admin credentials are configured and the request has valid credentials
-// Returns false if admin credentials are not configured or request has invalid/missing credentials
+// IsAdminAuthenticated reports whether the request belongs to a session the
+// identity provider granted admin access, or carries valid Basic auth admin
+// credentials. Without either, or with nothing configured, it returns false.
 func (h *Handler) IsAdminAuthenticated(r *http.Request) bool {
-	// If no credentials are configured, admin is not authenticated
+	if session := currentSession(r); h.OIDC != nil && session != nil && session.Admin {
+		return true
+	}
+
+	// If no credentials are configured, Basic auth cannot succeed
 	if h.AdminUsername == "" || h.AdminPassword == "" {
 		return false
 	}
@@ -70,78 +77,115 @@ func BasicAuthMiddleware(username, password string) func(http.Handler) http.Hand
 	}
 }
 
-func (h *Handler) APIAuthMiddleware(next http.Handler) http.Handler {
+// AdminAuthMiddleware protects the admin routes. Administrators signed in
+// through single sign-on pass straight through. Browsers without a session are
+// sent to the identity provider; everything else falls back to Basic auth so
+// scripts and other headless clients keep working.
+func (h *Handler) AdminAuthMiddleware(next http.Handler) http.Handler {
+	basicAuth := BasicAuthMiddleware(h.AdminUsername, h.AdminPassword)(next)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if h.APIKey == "" {
-			http.Error(w, "API access is not configured", http.StatusServiceUnavailable)
+		if h.OIDC == nil {
+			basicAuth.ServeHTTP(w, r)
+			return
+		}
+		if session := currentSession(r); session != nil && session.Admin {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet && r.Header.Get("Authorization") == "" && currentSession(r) == nil {
+			http.Redirect(w, r, "/auth/oidc/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+			return
+		}
+		if h.AdminUsername == "" || h.AdminPassword == "" {
+			http.Error(w, "administrator access required", http.StatusForbidden)
 			return
 		}
+		basicAuth.ServeHTTP(w, r)
+	})
+}
+
+type contextKey string
+
+const apiKeyContextKey contextKey = "api-key"
+
+// APIAuthMiddleware accepts either the configured API_KEY, which acts as an
+// unrestricted admin key, or an active key stored in the database. Each key
+// has its own token bucket; failed attempts are throttled per client IP.
+func (h *Handler) APIAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		provided := strings.TrimSpace(r.Header.Get("X-API-Key"))
 		if auth := strings.TrimSpace(r.Header.Get("Authorization")); strings.HasPrefix(strings.ToLower(auth), "bearer ") {
 			provided = strings.TrimSpace(auth[7:])
 		}
-		if subtle.ConstantTimeCompare([]byte(provided), []byte(h.APIKey)) != 1 {
+		key, ok := h.authenticateAPIKey(r, provided)
+		if !ok {
+			if !h.allowClientIP(w, r) {
+				return
+			}
 			w.Header().Set("WWW-Authenticate", "Bearer")
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		if !h.allowAPIRequest() {
-			w.Header().Set("Retry-After", "60")
+		decision := h.apiKeyLimiter.allow(key.ID, time.Now())
+		writeRateLimitHeaders(w, decision)
+		if !decision.allowed {
 			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key)))
 	})
 }
 
-func (h *Handler) allowAPIRequest() bool {
-	h.apiRateMu.Lock()
-	defer h.apiRateMu.Unlock()
-	now := time.Now()
-	if h.apiRateWindow.IsZero() || now.Sub(h.apiRateWindow) >= time.Minute {
-		h.apiRateWindow = now
-		h.apiRateCount = 0
+// authenticateAPIKey also records key use in the audit log, at the same
+// minute granularity as the last-used timestamp.
+func (h *Handler) authenticateAPIKey(r *http.Request, provided string) (*store.APIKey, bool) {
+	ctx := r.Context()
+	if provided == "" {
+		return nil, false
 	}
-	if h.apiRateCount >= 300 {
-		return false
+	if configured := h.runtimeConfig().APIKey; configured != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(configured)) == 1 {
+		return &store.APIKey{ID: "config", Name: "API_KEY", Scopes: []string{store.APIScopeAdmin}}, true
 	}
-	h.apiRateCount++
-	return true
-}
-
-func browserIDFromRequest(r *http.Request) string {
-	cookie, err := r.Cookie(browserIDCookieName)
-	if err != nil {
-		return ""
+	key, err := h.Store.GetAPIKeyByHash(ctx, hashToken(provided))
+	now := time.Now()
+	if err != nil || !key.Active(now) {
+		return nil, false
+	}
+	// Last-used timestamps only need minute precision; avoid a write per call.
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > time.Minute {
+		if err := h.Store.TouchAPIKey(ctx, key.ID, now); err != nil {
+			slog.ErrorContext(r.Context(), "failed to record API key use", "api_key", key.ID, "error", err)
+		}
+		h.audit(r.WithContext(context.WithValue(ctx, apiKeyContextKey, key)), auditAPIKeyUse, "api_key", key.ID,
+			map[string]string{"method": r.Method, "path": r.URL.Path})
 	}
-	return cookie.Value
+	return key, true
 }
 
-func (h *Handler) canAccessEndpoint(r *http.Request, endpoint *store.Endpoint) bool {
-	return h.IsAdminAuthenticated(r) || (endpoint.CreatorID != "" && endpoint.CreatorID == browserIDFromRequest(r))
+func apiKeyFromContext(ctx context.Context) *store.APIKey {
+	key, _ := ctx.Value(apiKeyContextKey).(*store.APIKey)
+	return key
 }
 
-func (h *Handler) requireEndpointAccess(w http.ResponseWriter, r *http.Request, endpointID string) (*store.Endpoint, bool) {
-	endpoint, err := h.Store.GetEndpoint(r.Context(), endpointID)
-	if err != nil {
-		http.Error(w, "endpoint not found", http.StatusNotFound)
-		return nil, false
-	}
-	if !h.canAccessEndpoint(r, endpoint) {
-		http.Error(w, "forbidden", http.StatusForbidden)
-		return nil, false
+// rejectRestrictedAPIKey refuses requests made with an endpoint-restricted
+// key to routes that act on the whole instance, reporting whether it did.
+func rejectRestrictedAPIKey(w http.ResponseWriter, r *http.Request, action string) bool {
+	if key := apiKeyFromContext(r.Context()); key != nil && len(key.EndpointIDs) > 0 {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "endpoint-restricted API keys cannot " + action})
+		return true
 	}
-	return endpoint, true
+	return false
 }
 
-func (h *Handler) requireRequestAccess(w http.ResponseWriter, r *http.Request, requestID int64) (*store.Request, bool) {
-	request, err := h.Store.GetRequest(r.Context(), requestID)
-	if err != nil {
-		http.Error(w, "request not found", http.StatusNotFound)
-		return nil, false
-	}
-	if _, ok := h.requireEndpointAccess(w, r, request.EndpointID); !ok {
-		return nil, false
+// RequireAPIScope rejects API requests whose key does not grant scope.
+func (h *Handler) RequireAPIScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := apiKeyFromContext(r.Context()); key == nil || !key.HasScope(scope) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "API key lacks the " + scope + " scope"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
-	return request, true
 }
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+// permission is an action on an endpoint. Each level includes the ones below.
+type permission int
+
+const (
+	// permView reads an endpoint, its captured requests and live updates.
+	permView permission = iota + 1
+	// permEdit changes settings, replays and deletes requests.
+	permEdit
+	// permManage deletes the endpoint and moves it between workspaces.
+	permManage
+)
+
+func rolePermission(role string) permission {
+	switch role {
+	case store.WorkspaceRoleOwner:
+		return permManage
+	case store.WorkspaceRoleEditor:
+		return permEdit
+	case store.WorkspaceRoleViewer:
+		return permView
+	default:
+		return 0
+	}
+}
+
+// authorize is the single access decision for endpoints, shared by the UI,
+// the API and WebSocket subscriptions.
+//
+// API keys are limited to their endpoint restriction here; their scopes are
+// enforced per route by RequireAPIScope. Administrators and the endpoint's
+// owning account may do anything, workspace members act according to their
+// role, and the creating browser cookie only counts while the endpoint
+// belongs to no account or workspace. Creator IDs that are not browser IDs,
+// such as those of endpoints created through the API, match no cookie.
+func (h *Handler) authorize(r *http.Request, endpoint *store.Endpoint, perm permission) bool {
+	if key := apiKeyFromContext(r.Context()); key != nil {
+		return key.AllowsEndpoint(endpoint.ID)
+	}
+	if h.IsAdminAuthenticated(r) {
+		return true
+	}
+	if user := currentUser(r); user != nil {
+		if endpoint.OwnerUserID == user.ID {
+			return true
+		}
+		if endpoint.WorkspaceID != "" {
+			role, err := h.Store.GetWorkspaceRole(r.Context(), endpoint.WorkspaceID, user.ID)
+			if err == nil && rolePermission(role) >= perm {
+				return true
+			}
+		}
+	}
+	if endpoint.OwnerUserID != "" || endpoint.WorkspaceID != "" {
+		return false
+	}
+	return store.ValidBrowserID(endpoint.CreatorID) && endpoint.CreatorID == browserIDFromRequest(r)
+}
+
+// browserIDFromRequest returns the browser ID cookie, or "" when it is
+// missing or was not issued by the server.
+func browserIDFromRequest(r *http.Request) string {
+	cookie, err := r.Cookie(browserIDCookieName)
+	if err != nil || !store.ValidBrowserID(cookie.Value) {
+		return ""
+	}
+	return cookie.Value
+}
+
+func (h *Handler) requireEndpointAccess(w http.ResponseWriter, r *http.Request, endpointID string, perm permission) (*store.Endpoint, bool) {
+	endpoint, err := h.Store.GetEndpoint(r.Context(), endpointID)
+	if err != nil {
+		http.Error(w, "endpoint not found", http.StatusNotFound)
+		return nil, false
+	}
+	if !h.authorize(r, endpoint, perm) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil, false
+	}
+	return endpoint, true
+}
+
+func (h *Handler) requireRequestAccess(w http.ResponseWriter, r *http.Request, requestID int64, perm permission) (*store.Request, bool) {
+	request, err := h.Store.GetRequest(r.Context(), requestID)
+	if err != nil {
+		http.Error(w, "request not found", http.StatusNotFound)
+		return nil, false
+	}
+	if _, ok := h.requireEndpointAccess(w, r, request.EndpointID, perm); !ok {
+		return nil, false
+	}
+	return request, true
+}
@@ -0,0 +1,413 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxDecodedDownloadSize = 32 * 1024 * 1024
+	maxViewerNodes         = 2000
+	maxViewerDepth         = 64
+	maxViewerFields        = 200
+	maxViewerFieldValue    = 1024
+)
+
+// inlineImageTypes are the image types the body route serves inline for the
+// image viewer. SVG is left out as it can carry scripts.
+var inlineImageTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/gif": true, "image/webp": true,
+	"image/avif": true, "image/bmp": true, "image/x-icon": true, "image/vnd.microsoft.icon": true,
+}
+
+var bodyExtensions = map[string]string{
+	"application/json": ".json", "application/xml": ".xml", "text/xml": ".xml", "text/plain": ".txt",
+	"text/html": ".html", "text/csv": ".csv", "application/x-www-form-urlencoded": ".txt",
+	"application/pdf": ".pdf", "image/png": ".png", "image/jpeg": ".jpg", "image/gif": ".gif", "image/webp": ".webp",
+}
+
+// bodyFilename names a downloaded body after the request, so the name never
+// contains anything the sender chose.
+func bodyFilename(requestID int64, mediaType, contentEncoding string) string {
+	extension := bodyExtensions[mediaType]
+	switch {
+	case extension == "" && strings.HasSuffix(mediaType, "+json"):
+		extension = ".json"
+	case extension == "" && strings.HasSuffix(mediaType, "+xml"):
+		extension = ".xml"
+	case extension == "":
+		extension = ".bin"
+	}
+	if strings.EqualFold(contentEncoding, "gzip") {
+		extension += ".gz"
+	}
+	return fmt.Sprintf("pipehook-request-%d%s", requestID, extension)
+}
+
+// writeRequestBody serves the stored body with its original Content-Type,
+// decoded per its Content-Encoding when ?decode=true. Bodies are downloaded
+// as attachments, and only raster images are shown inline with ?inline=true,
+// so a captured HTML or SVG body never runs in the app's origin.
+func writeRequestBody(w http.ResponseWriter, r *http.Request, captured *store.Request) (int, error) {
+	headers := parseRequestHeaders(captured.ID, captured.Headers)
+	body := captured.Body
+	truncated := captured.BodyTruncated
+	contentEncoding := strings.TrimSpace(headerValue(headers, "Content-Encoding"))
+	decode, _ := strconv.ParseBool(r.URL.Query().Get("decode"))
+	if decode && contentEncoding != "" {
+		decoded, decodedTruncated, err := decodeBodyForDisplay(body, contentEncoding, maxDecodedDownloadSize)
+		if err != nil {
+			return http.StatusUnprocessableEntity, fmt.Errorf("failed to decode %s body", contentEncoding)
+		}
+		body, truncated, contentEncoding = decoded, truncated || decodedTruncated, ""
+	}
+
+	contentType := "application/octet-stream"
+	mediaType, params, err := mime.ParseMediaType(headerValue(headers, "Content-Type"))
+	if err == nil {
+		contentType = mime.FormatMediaType(mediaType, params)
+	}
+	if contentType == "" {
+		contentType, mediaType = "application/octet-stream", ""
+	}
+	disposition := "attachment"
+	if inline, _ := strconv.ParseBool(r.URL.Query().Get("inline")); inline && inlineImageTypes[mediaType] && contentEncoding == "" {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`%s; filename="%s"`, disposition, bodyFilename(captured.ID, mediaType, contentEncoding)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if truncated {
+		w.Header().Set("X-Pipehook-Body-Truncated", "true")
+	}
+	http.ServeContent(w, r, "", captured.CreatedAt, bytes.NewReader(body))
+	return http.StatusOK, nil
+}
+
+// RequestBody downloads the body of a request for the dashboard.
+func (h *Handler) RequestBody(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "requestID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid request ID", http.StatusBadRequest)
+		return
+	}
+	captured, ok := h.requireRequestAccess(w, r, id, permView)
+	if !ok {
+		return
+	}
+	if status, err := writeRequestBody(w, r, captured); err != nil {
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// APIRequestBody downloads the body of a request as stored.
+func (h *Handler) APIRequestBody(w http.ResponseWriter, r *http.Request) {
+	captured, ok := h.apiRequest(w, r, permView)
+	if !ok {
+		return
+	}
+	if status, err := writeRequestBody(w, r, captured); err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+	}
+}
+
+// bodyViewer is a structured view of a body the request view understands.
+// Kind is "json", "xml", "form", "multipart" or "image".
+type bodyViewer struct {
+	Kind      string
+	JSON      *jsonNode
+	XML       string
+	Fields    []formField
+	Truncated bool
+}
+
+// jsonNode is one value of a JSON tree, with object keys in body order.
+type jsonNode struct {
+	Key      string
+	Kind     string
+	Value    string
+	Children []*jsonNode
+}
+
+// formField is a form-urlencoded or multipart field.
+type formField struct {
+	Name        string
+	Value       string
+	Filename    string
+	ContentType string
+	Size        int
+	Binary      bool
+}
+
+// buildBodyViewer picks a viewer for the decoded body by its Content-Type.
+// Bodies that do not parse get no viewer and are shown as text or hex only.
+func buildBodyViewer(body []byte, contentType string) *bodyViewer {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || len(body) == 0 {
+		return nil
+	}
+	switch {
+	case inlineImageTypes[mediaType]:
+		return &bodyViewer{Kind: "image"}
+	case strings.HasPrefix(mediaType, "multipart/"):
+		fields, truncated, err := parseMultipartFields(body, params["boundary"])
+		if err != nil {
+			return nil
+		}
+		return &bodyViewer{Kind: "multipart", Fields: fields, Truncated: truncated}
+	case len(body) > maxDisplayTextBytes:
+		return nil
+	case strings.Contains(mediaType, "json"):
+		node, truncated, err := parseJSONTree(body)
+		if err != nil {
+			return nil
+		}
+		return &bodyViewer{Kind: "json", JSON: node, Truncated: truncated}
+	case strings.Contains(mediaType, "xml"):
+		pretty, err := prettyXML(body)
+		if err != nil {
+			return nil
+		}
+		return &bodyViewer{Kind: "xml", XML: pretty}
+	case mediaType == "application/x-www-form-urlencoded":
+		fields, truncated, err := parseFormFields(body)
+		if err != nil {
+			return nil
+		}
+		return &bodyViewer{Kind: "form", Fields: fields, Truncated: truncated}
+	}
+	return nil
+}
+
+// parseJSONTree reads body token by token so object keys keep their order.
+// Nodes past maxViewerNodes are dropped and reported as truncated.
+func parseJSONTree(body []byte) (*jsonNode, bool, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	nodes := 0
+	var parse func(key string, depth int) (*jsonNode, error)
+	parse = func(key string, depth int) (*jsonNode, error) {
+		if depth > maxViewerDepth {
+			return nil, errors.New("JSON is nested too deeply")
+		}
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		nodes++
+		node := &jsonNode{Key: key}
+		switch value := token.(type) {
+		case json.Delim:
+			node.Kind = "array"
+			if value == '{' {
+				node.Kind = "object"
+			}
+			for index := 0; decoder.More(); index++ {
+				childKey := strconv.Itoa(index)
+				if node.Kind == "object" {
+					keyToken, err := decoder.Token()
+					if err != nil {
+						return nil, err
+					}
+					childKey, _ = keyToken.(string)
+				}
+				child, err := parse(childKey, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				if nodes <= maxViewerNodes {
+					node.Children = append(node.Children, child)
+				}
+			}
+			if _, err := decoder.Token(); err != nil {
+				return nil, err
+			}
+		case string:
+			node.Kind, node.Value = "string", quoteString(value)
+		case json.Number:
+			node.Kind, node.Value = "number", value.String()
+		case bool:
+			node.Kind, node.Value = "bool", strconv.FormatBool(value)
+		default:
+			node.Kind, node.Value = "null", "null"
+		}
+		return node, nil
+	}
+	root, err := parse("", 0)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, false, errors.New("unexpected data after JSON value")
+	}
+	return root, nodes > maxViewerNodes, nil
+}
+
+// prettyXML indents XML one element per line, keeping elements that only
+// hold text on a single line. Namespace prefixes are kept as written.
+func prettyXML(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	var out bytes.Buffer
+	var open []xml.Name
+	afterStart := false
+	newline := func() {
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(strings.Repeat("  ", len(open)))
+	}
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch token := token.(type) {
+		case xml.StartElement:
+			newline()
+			out.WriteString("<" + xmlName(token.Name))
+			for _, attr := range token.Attr {
+				out.WriteString(" " + xmlName(attr.Name) + `="`)
+				_ = xml.EscapeText(&out, []byte(attr.Value))
+				out.WriteString(`"`)
+			}
+			out.WriteString(">")
+			open = append(open, token.Name)
+			afterStart = true
+		case xml.EndElement:
+			if len(open) == 0 || open[len(open)-1] != token.Name {
+				return "", fmt.Errorf("unexpected closing tag %s", xmlName(token.Name))
+			}
+			open = open[:len(open)-1]
+			if !afterStart {
+				newline()
+			}
+			out.WriteString("</" + xmlName(token.Name) + ">")
+			afterStart = false
+		case xml.CharData:
+			text := bytes.TrimSpace(token)
+			if len(text) == 0 {
+				continue
+			}
+			if !afterStart {
+				newline()
+			}
+			_ = xml.EscapeText(&out, text)
+		case xml.Comment:
+			newline()
+			out.WriteString("<!--" + string(token) + "-->")
+			afterStart = false
+		case xml.ProcInst:
+			newline()
+			out.WriteString("<?" + token.Target + " " + string(token.Inst) + "?>")
+		case xml.Directive:
+			newline()
+			out.WriteString("<!" + string(token) + ">")
+		}
+	}
+	if len(open) > 0 {
+		return "", fmt.Errorf("unclosed tag %s", xmlName(open[len(open)-1]))
+	}
+	if out.Len() == 0 {
+		return "", errors.New("no XML elements")
+	}
+	return out.String(), nil
+}
+
+func xmlName(name xml.Name) string {
+	if name.Space != "" {
+		return name.Space + ":" + name.Local
+	}
+	return name.Local
+}
+
+// parseFormFields lists form-urlencoded fields in body order, which
+// url.ParseQuery would lose.
+func parseFormFields(body []byte) ([]formField, bool, error) {
+	var fields []formField
+	for _, pair := range strings.Split(string(body), "&") {
+		if pair == "" {
+			continue
+		}
+		if len(fields) == maxViewerFields {
+			return fields, true, nil
+		}
+		rawName, rawValue, _ := strings.Cut(pair, "=")
+		name, err := url.QueryUnescape(rawName)
+		if err != nil {
+			return nil, false, err
+		}
+		value, err := url.QueryUnescape(rawValue)
+		if err != nil {
+			return nil, false, err
+		}
+		fields = append(fields, formField{Name: name, Value: value, Size: len(value)})
+	}
+	if len(fields) == 0 {
+		return nil, false, errors.New("no form fields")
+	}
+	return fields, false, nil
+}
+
+// parseMultipartFields lists the parts of a multipart body. Text values are
+// cut to maxViewerFieldValue bytes and binary ones are only sized. A body
+// cut short at capture keeps the parts read before the break.
+func parseMultipartFields(body []byte, boundary string) ([]formField, bool, error) {
+	if boundary == "" {
+		return nil, false, errors.New("missing multipart boundary")
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	var fields []formField
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return fields, false, nil
+		}
+		if err != nil {
+			if len(fields) > 0 {
+				return fields, true, nil
+			}
+			return nil, false, err
+		}
+		if len(fields) == maxViewerFields {
+			return fields, true, nil
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			if len(fields) > 0 {
+				return fields, true, nil
+			}
+			return nil, false, err
+		}
+		field := formField{
+			Name: part.FormName(), Filename: part.FileName(), ContentType: part.Header.Get("Content-Type"), Size: len(value),
+		}
+		switch {
+		case !utf8.Valid(value) || isBinaryBody(value, normalizeContentType(field.ContentType)):
+			field.Binary = true
+		case len(value) > maxViewerFieldValue:
+			field.Value = strings.ToValidUTF8(string(value[:maxViewerFieldValue]), "") + "…"
+		default:
+			field.Value = string(value)
+		}
+		fields = append(fields, field)
+	}
+}
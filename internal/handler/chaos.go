@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+const (
+	maxChaosLatencyMS      = 30000
+	maxChaosDripIntervalMS = 5000
+	maxChaosFailFirst      = 100
+	defaultChaosKeyHeader  = "Idempotency-Key"
+)
+
+// chaosErrorStatuses are picked from when an endpoint injects errors without
+// listing its own statuses.
+var chaosErrorStatuses = []int{
+	http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+}
+
+// planChaos decides which faults to inject into the response to r. It
+// returns nil when the endpoint has no chaos settings or none fired.
+func (h *Handler) planChaos(r *http.Request, endpoint *store.Endpoint) *store.ChaosOutcome {
+	chaos := endpoint.Chaos
+	if !chaos.Enabled() {
+		return nil
+	}
+	outcome := &store.ChaosOutcome{}
+	if key := r.Header.Get(chaos.FailKeyHeader); chaos.FailFirst > 0 && key != "" {
+		attempt, err := h.Store.RecordChaosAttempt(r.Context(), endpoint.ID, key)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to count chaos attempt", "endpoint_id", endpoint.ID, "error", err)
+		} else {
+			outcome.Attempt = attempt
+			if attempt <= chaos.FailFirst {
+				outcome.Status = chaosErrorStatus(chaos)
+			}
+		}
+	}
+	if outcome.Status == 0 && chance(chaos.ErrorPercent) {
+		outcome.Status = chaosErrorStatus(chaos)
+	}
+	if chaos.LatencyMaxMS > 0 {
+		outcome.LatencyMS = chaos.LatencyMinMS + rand.IntN(chaos.LatencyMaxMS-chaos.LatencyMinMS+1)
+	}
+	outcome.Reset = chance(chaos.ResetPercent)
+	if !outcome.Reset && chance(chaos.DripPercent) {
+		outcome.DripIntervalMS = chaos.DripIntervalMS
+	}
+	if *outcome == (store.ChaosOutcome{}) {
+		return nil
+	}
+	return outcome
+}
+
+func chance(percent int) bool {
+	return percent > 0 && rand.IntN(100) < percent
+}
+
+func chaosErrorStatus(chaos store.Chaos) int {
+	if len(chaos.ErrorStatuses) > 0 {
+		return chaos.ErrorStatuses[rand.IntN(len(chaos.ErrorStatuses))]
+	}
+	return chaosErrorStatuses[rand.IntN(len(chaosErrorStatuses))]
+}
+
+// writeChaosBody writes body honouring a planned reset or slow drip. A reset
+// sends the headers and half the body, then drops the connection. A drip
+// sends what is left at once when the next pause would pass deadline.
+func writeChaosBody(w http.ResponseWriter, r *http.Request, outcome *store.ChaosOutcome, body []byte, deadline time.Time) {
+	controller := http.NewResponseController(w)
+	switch {
+	case outcome != nil && outcome.Reset:
+		_, _ = w.Write(body[:len(body)/2])
+		_ = controller.Flush()
+		panic(http.ErrAbortHandler)
+	case outcome != nil && outcome.DripIntervalMS > 0:
+		for i := range body {
+			if _, err := w.Write(body[i : i+1]); err != nil {
+				return
+			}
+			_ = controller.Flush()
+			if i == len(body)-1 {
+				return
+			}
+			interval := time.Duration(outcome.DripIntervalMS) * time.Millisecond
+			if !deadline.IsZero() && time.Until(deadline) < interval {
+				// Send the rest at once rather than let the write timeout
+				// cut the response off.
+				_, _ = w.Write(body[i+1:])
+				return
+			}
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	default:
+		_, _ = w.Write(body)
+	}
+}
+
+// normalizeChaos fills in the default attempt header and orders the latency
+// range.
+func normalizeChaos(chaos store.Chaos) store.Chaos {
+	chaos.FailKeyHeader = http.CanonicalHeaderKey(strings.TrimSpace(chaos.FailKeyHeader))
+	if chaos.FailFirst > 0 && chaos.FailKeyHeader == "" {
+		chaos.FailKeyHeader = defaultChaosKeyHeader
+	}
+	if chaos.LatencyMinMS > chaos.LatencyMaxMS {
+		chaos.LatencyMinMS, chaos.LatencyMaxMS = chaos.LatencyMaxMS, chaos.LatencyMinMS
+	}
+	return chaos
+}
+
+func validateChaos(chaos store.Chaos) error {
+	for _, percent := range []int{chaos.ErrorPercent, chaos.ResetPercent, chaos.DripPercent} {
+		if percent < 0 || percent > 100 {
+			return errors.New("chaos percentages must be between 0 and 100")
+		}
+	}
+	for _, status := range chaos.ErrorStatuses {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("chaos error status %d must be between 400 and 599", status)
+		}
+	}
+	if len(chaos.ErrorStatuses) > 20 {
+		return errors.New("at most 20 chaos error statuses are supported")
+	}
+	if chaos.LatencyMinMS < 0 || chaos.LatencyMaxMS > maxChaosLatencyMS {
+		return fmt.Errorf("chaos latency must be between 0 and %d milliseconds", maxChaosLatencyMS)
+	}
+	if chaos.DripIntervalMS < 0 || chaos.DripIntervalMS > maxChaosDripIntervalMS {
+		return fmt.Errorf("slow drip interval must be between 0 and %d milliseconds", maxChaosDripIntervalMS)
+	}
+	if chaos.DripPercent > 0 && chaos.DripIntervalMS == 0 {
+		return errors.New("slow drip needs an interval")
+	}
+	if chaos.FailFirst < 0 || chaos.FailFirst > maxChaosFailFirst {
+		return fmt.Errorf("failed attempts per key must be between 0 and %d", maxChaosFailFirst)
+	}
+	if chaos.FailKeyHeader != "" && !validHeaderName(chaos.FailKeyHeader) {
+		return fmt.Errorf("%q is not a valid header name", chaos.FailKeyHeader)
+	}
+	return nil
+}
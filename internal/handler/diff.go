@@ -0,0 +1,523 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// maxDiffChanges caps the JSON changes and diff lines of one comparison.
+	maxDiffChanges = 500
+	// maxDiffCells bounds the line diff table. Longer texts that differ
+	// throughout are shown as replaced wholesale.
+	maxDiffCells = 1 << 22
+	// diffContext is how many unchanged lines are kept around each change.
+	diffContext = 3
+	// maxDiffValueSize shortens long values in JSON changes.
+	maxDiffValueSize = 200
+)
+
+// Kinds of change in a request diff.
+const (
+	diffAdded   = "added"
+	diffRemoved = "removed"
+	diffChanged = "changed"
+	diffSame    = "same"
+	diffSkipped = "skipped"
+)
+
+// requestDiff compares request A with request B. Only differences are
+// listed; an empty list means that part matches.
+type requestDiff struct {
+	A       diffSide      `json:"a"`
+	B       diffSide      `json:"b"`
+	Fields  []fieldChange `json:"fields"`
+	Query   []valueChange `json:"query"`
+	Headers []valueChange `json:"headers"`
+	Body    bodyDiff      `json:"body"`
+}
+
+type diffSide struct {
+	ID          int64     `json:"id"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	QueryString string    `json:"query_string,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Size        int       `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Notices say how the body was decoded before it was compared.
+	Notices []string `json:"notices,omitempty"`
+}
+
+type fieldChange struct {
+	Name string `json:"name"`
+	A    string `json:"a"`
+	B    string `json:"b"`
+}
+
+// valueChange is a header or query parameter that differs. Change is
+// added, removed or changed.
+type valueChange struct {
+	Name   string   `json:"name"`
+	Change string   `json:"change"`
+	A      []string `json:"a,omitempty"`
+	B      []string `json:"b,omitempty"`
+}
+
+// bodyDiff holds the comparison for Mode: JSON changes by key, text lines,
+// or a binary summary.
+type bodyDiff struct {
+	Mode      string       `json:"mode"`
+	Equal     bool         `json:"equal"`
+	Changes   []jsonChange `json:"changes,omitempty"`
+	Lines     []diffLine   `json:"lines,omitempty"`
+	Binary    *binaryDiff  `json:"binary,omitempty"`
+	Truncated bool         `json:"truncated,omitempty"`
+}
+
+// jsonChange is one differing value, addressed by JSON pointer. A and B are
+// compact JSON.
+type jsonChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	A      string `json:"a,omitempty"`
+	B      string `json:"b,omitempty"`
+}
+
+// diffLine is one line of a text diff. Skipped lines stand for a run of
+// unchanged lines that was left out.
+type diffLine struct {
+	Op      string `json:"op"`
+	Text    string `json:"text,omitempty"`
+	Skipped int    `json:"skipped,omitempty"`
+}
+
+type binaryDiff struct {
+	SHA256A string `json:"sha256_a"`
+	SHA256B string `json:"sha256_b"`
+	// FirstDifference is the offset of the first differing byte, or -1 when
+	// the bodies are equal.
+	FirstDifference int `json:"first_difference"`
+}
+
+// diffRequests compares two captures. Bodies are decoded as in the request
+// view, so compressed payloads are compared by content.
+func diffRequests(a, b *store.Request) *requestDiff {
+	headersA, headersB := parseRequestHeaders(a.ID, a.Headers), parseRequestHeaders(b.ID, b.Headers)
+	bodyA, noticesA := decodeRequestBody(a, headersA)
+	bodyB, noticesB := decodeRequestBody(b, headersB)
+	typeA, typeB := normalizeContentType(headerValue(headersA, "Content-Type")), normalizeContentType(headerValue(headersB, "Content-Type"))
+	diff := &requestDiff{
+		A: diffSide{ID: a.ID, Method: a.Method, Path: a.Path, QueryString: a.QueryString, ContentType: typeA, Size: len(bodyA), CreatedAt: a.CreatedAt, Notices: noticesA},
+		B: diffSide{ID: b.ID, Method: b.Method, Path: b.Path, QueryString: b.QueryString, ContentType: typeB, Size: len(bodyB), CreatedAt: b.CreatedAt, Notices: noticesB},
+	}
+	for _, field := range []fieldChange{{"method", a.Method, b.Method}, {"path", a.Path, b.Path}, {"query", a.QueryString, b.QueryString}} {
+		if field.A != field.B {
+			diff.Fields = append(diff.Fields, field)
+		}
+	}
+	queryA, _ := url.ParseQuery(a.QueryString)
+	queryB, _ := url.ParseQuery(b.QueryString)
+	diff.Query = diffValues(queryA, queryB, func(name string) string { return name })
+	diff.Headers = diffValues(headersA, headersB, http.CanonicalHeaderKey)
+
+	switch {
+	case isBinaryBody(bodyA, typeA) || isBinaryBody(bodyB, typeB):
+		diff.Body = diffBinary(bodyA, bodyB)
+	case len(bytes.TrimSpace(bodyA)) > 0 && len(bytes.TrimSpace(bodyB)) > 0 && json.Valid(bodyA) && json.Valid(bodyB):
+		diff.Body = diffJSON(bodyA, bodyB)
+	default:
+		diff.Body = diffText(bodyA, bodyB)
+	}
+	return diff
+}
+
+// diffValues compares multi-valued maps such as headers, matching names
+// after canonical.
+func diffValues(a, b map[string][]string, canonical func(string) string) []valueChange {
+	merged := func(values map[string][]string) map[string][]string {
+		out := make(map[string][]string, len(values))
+		for name, list := range values {
+			key := canonical(name)
+			out[key] = append(out[key], list...)
+		}
+		return out
+	}
+	a, b = merged(a), merged(b)
+	names := make([]string, 0, len(a)+len(b))
+	for name := range a {
+		names = append(names, name)
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	changes := make([]valueChange, 0)
+	for _, name := range names {
+		valuesA, inA := a[name]
+		valuesB, inB := b[name]
+		switch {
+		case !inA:
+			changes = append(changes, valueChange{Name: name, Change: diffAdded, B: valuesB})
+		case !inB:
+			changes = append(changes, valueChange{Name: name, Change: diffRemoved, A: valuesA})
+		case !slices.Equal(valuesA, valuesB):
+			changes = append(changes, valueChange{Name: name, Change: diffChanged, A: valuesA, B: valuesB})
+		}
+	}
+	return changes
+}
+
+func diffBinary(a, b []byte) bodyDiff {
+	sumA, sumB := sha256.Sum256(a), sha256.Sum256(b)
+	first := -1
+	if !bytes.Equal(a, b) {
+		first = min(len(a), len(b))
+		for i := range first {
+			if a[i] != b[i] {
+				first = i
+				break
+			}
+		}
+	}
+	return bodyDiff{
+		Mode: "binary", Equal: first == -1,
+		Binary: &binaryDiff{SHA256A: hex.EncodeToString(sumA[:]), SHA256B: hex.EncodeToString(sumB[:]), FirstDifference: first},
+	}
+}
+
+// diffJSON compares two JSON documents by key, so reordered object keys do
+// not count as changes. Arrays are compared by index.
+func diffJSON(a, b []byte) bodyDiff {
+	valueA, errA := decodeJSONValue(a)
+	valueB, errB := decodeJSONValue(b)
+	if errA != nil || errB != nil {
+		return diffText(a, b)
+	}
+	diff := bodyDiff{Mode: "json"}
+	walkJSONDiff(&diff, "", valueA, valueB)
+	diff.Equal = len(diff.Changes) == 0
+	return diff
+}
+
+func decodeJSONValue(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func walkJSONDiff(diff *bodyDiff, pointer string, a, b any) {
+	if len(diff.Changes) >= maxDiffChanges {
+		diff.Truncated = true
+		return
+	}
+	objectA, isObjectA := a.(map[string]any)
+	objectB, isObjectB := b.(map[string]any)
+	if isObjectA && isObjectB {
+		keys := make([]string, 0, len(objectA)+len(objectB))
+		for key := range objectA {
+			keys = append(keys, key)
+		}
+		for key := range objectB {
+			if _, ok := objectA[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := pointer + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+			valueA, inA := objectA[key]
+			valueB, inB := objectB[key]
+			switch {
+			case !inA:
+				addJSONChange(diff, child, diffAdded, nil, valueB)
+			case !inB:
+				addJSONChange(diff, child, diffRemoved, valueA, nil)
+			default:
+				walkJSONDiff(diff, child, valueA, valueB)
+			}
+		}
+		return
+	}
+	arrayA, isArrayA := a.([]any)
+	arrayB, isArrayB := b.([]any)
+	if isArrayA && isArrayB {
+		for i := range max(len(arrayA), len(arrayB)) {
+			child := pointer + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(arrayA):
+				addJSONChange(diff, child, diffAdded, nil, arrayB[i])
+			case i >= len(arrayB):
+				addJSONChange(diff, child, diffRemoved, arrayA[i], nil)
+			default:
+				walkJSONDiff(diff, child, arrayA[i], arrayB[i])
+			}
+		}
+		return
+	}
+	if !jsonScalarsEqual(a, b) {
+		addJSONChange(diff, pointer, diffChanged, a, b)
+	}
+}
+
+// jsonScalarsEqual compares numbers by value, so 1.0 equals 1. Objects and
+// arrays only get here when the other side is not of the same kind.
+func jsonScalarsEqual(a, b any) bool {
+	numberA, isNumberA := a.(json.Number)
+	numberB, isNumberB := b.(json.Number)
+	if isNumberA && isNumberB {
+		if numberA == numberB {
+			return true
+		}
+		floatA, errA := numberA.Float64()
+		floatB, errB := numberB.Float64()
+		return errA == nil && errB == nil && floatA == floatB
+	}
+	switch a.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	switch b.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	return a == b
+}
+
+func addJSONChange(diff *bodyDiff, pointer, change string, a, b any) {
+	if len(diff.Changes) >= maxDiffChanges {
+		diff.Truncated = true
+		return
+	}
+	if pointer == "" {
+		pointer = "/"
+	}
+	entry := jsonChange{Path: pointer, Change: change}
+	if change != diffAdded {
+		entry.A = compactJSONValue(a)
+	}
+	if change != diffRemoved {
+		entry.B = compactJSONValue(b)
+	}
+	diff.Changes = append(diff.Changes, entry)
+}
+
+func compactJSONValue(value any) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	if len(encoded) > maxDiffValueSize {
+		return strings.ToValidUTF8(string(encoded[:maxDiffValueSize]), "") + "…"
+	}
+	return string(encoded)
+}
+
+// diffText compares bodies line by line and keeps diffContext unchanged
+// lines around each change.
+func diffText(a, b []byte) bodyDiff {
+	diff := bodyDiff{Mode: "text", Equal: bytes.Equal(a, b)}
+	if diff.Equal {
+		return diff
+	}
+	if len(a) > maxDisplayTextBytes || len(b) > maxDisplayTextBytes {
+		a, b, diff.Truncated = a[:min(len(a), maxDisplayTextBytes)], b[:min(len(b), maxDisplayTextBytes)], true
+	}
+	lines := diffLines(splitLines(a), splitLines(b))
+	diff.Lines = collapseDiffLines(lines)
+	if len(diff.Lines) > maxDiffChanges {
+		diff.Lines, diff.Truncated = diff.Lines[:maxDiffChanges], true
+	}
+	return diff
+}
+
+func splitLines(body []byte) []string {
+	if len(body) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+}
+
+// diffLines returns a shortest edit from a to b using the longest common
+// subsequence of the lines between their shared prefix and suffix.
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	lines := make([]diffLine, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		lines = append(lines, diffLine{Op: diffSame, Text: line})
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	rows, cols := len(middleA)+1, len(middleB)+1
+	if rows*cols > maxDiffCells {
+		for _, line := range middleA {
+			lines = append(lines, diffLine{Op: diffRemoved, Text: line})
+		}
+		for _, line := range middleB {
+			lines = append(lines, diffLine{Op: diffAdded, Text: line})
+		}
+	} else {
+		// common[i*cols+j] is the LCS length of middleA[i:] and middleB[j:].
+		common := make([]int32, rows*cols)
+		for i := len(middleA) - 1; i >= 0; i-- {
+			for j := len(middleB) - 1; j >= 0; j-- {
+				if middleA[i] == middleB[j] {
+					common[i*cols+j] = common[(i+1)*cols+j+1] + 1
+				} else {
+					common[i*cols+j] = max(common[(i+1)*cols+j], common[i*cols+j+1])
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(middleA) || j < len(middleB) {
+			switch {
+			case i < len(middleA) && j < len(middleB) && middleA[i] == middleB[j]:
+				lines = append(lines, diffLine{Op: diffSame, Text: middleA[i]})
+				i, j = i+1, j+1
+			case i < len(middleA) && (j == len(middleB) || common[(i+1)*cols+j] >= common[i*cols+j+1]):
+				lines = append(lines, diffLine{Op: diffRemoved, Text: middleA[i]})
+				i++
+			default:
+				lines = append(lines, diffLine{Op: diffAdded, Text: middleB[j]})
+				j++
+			}
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{Op: diffSame, Text: line})
+	}
+	return lines
+}
+
+// collapseDiffLines replaces unchanged lines further than diffContext from
+// any change with a skipped marker.
+func collapseDiffLines(lines []diffLine) []diffLine {
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if line.Op == diffSame {
+			continue
+		}
+		for j := max(0, i-diffContext); j <= min(len(lines)-1, i+diffContext); j++ {
+			keep[j] = true
+		}
+	}
+	collapsed := make([]diffLine, 0, len(lines))
+	skipped := 0
+	for i, line := range lines {
+		if keep[i] {
+			if skipped > 0 {
+				collapsed = append(collapsed, diffLine{Op: diffSkipped, Skipped: skipped})
+				skipped = 0
+			}
+			collapsed = append(collapsed, line)
+			continue
+		}
+		skipped++
+	}
+	if skipped > 0 {
+		collapsed = append(collapsed, diffLine{Op: diffSkipped, Skipped: skipped})
+	}
+	return collapsed
+}
+
+// loadDiffRequests reads requests ?a= and ?b=, which must both belong to
+// endpointID. The returned status tells the caller whether an error was the
+// input's fault.
+func (h *Handler) loadDiffRequests(r *http.Request, endpointID string) (*store.Request, *store.Request, int, error) {
+	var requests [2]*store.Request
+	for i, name := range []string{"a", "b"} {
+		id, err := strconv.ParseInt(r.URL.Query().Get(name), 10, 64)
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, errors.New(name + " must be a request ID")
+		}
+		request, err := h.Store.GetRequest(r.Context(), id)
+		if err != nil || request.EndpointID != endpointID {
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				slog.ErrorContext(r.Context(), "failed to load request for diff", "endpoint_id", endpointID, "request", id, "error", err)
+			}
+			return nil, nil, http.StatusNotFound, errors.New("request " + strconv.FormatInt(id, 10) + " not found")
+		}
+		requests[i] = request
+	}
+	return requests[0], requests[1], http.StatusOK, nil
+}
+
+// APIDiffRequests compares requests ?a= and ?b= of the endpoint.
+func (h *Handler) APIDiffRequests(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
+		return
+	}
+	a, b, status, err := h.loadDiffRequests(r, endpoint.ID)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, diffRequests(a, b))
+}
+
+// DiffRequests renders the compare page. Without both IDs it shows the
+// form to pick them.
+func (h *Handler) DiffRequests(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
+		return
+	}
+	data := struct {
+		BaseTemplateData
+		Endpoint *store.Endpoint
+		A, B     string
+		Diff     *requestDiff
+		Error    string
+	}{
+		BaseTemplateData: h.baseTemplateData(r),
+		Endpoint:         endpoint,
+		A:                r.URL.Query().Get("a"),
+		B:                r.URL.Query().Get("b"),
+	}
+	status := http.StatusOK
+	if data.A != "" && data.B != "" {
+		a, b, code, err := h.loadDiffRequests(r, endpoint.ID)
+		if err != nil {
+			status, data.Error = code, err.Error()
+		} else {
+			data.Diff = diffRequests(a, b)
+		}
+	}
+	var buf bytes.Buffer
+	if err := diffTemplate.ExecuteTemplate(&buf, "layout", data); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -57,7 +57,7 @@ func (h *Handler) ExportRequestsCSV(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) exportRequests(w http.ResponseWriter, r *http.Request, asCSV bool) {
 	endpointID := chi.URLParam(r, "endpointID")
-	if _, ok := h.requireEndpointAccess(w, r, endpointID); !ok {
+	if _, ok := h.requireEndpointAccess(w, r, endpointID, permView); !ok {
 		return
 	}
 	query := strings.TrimSpace(r.URL.Query().Get("q"))
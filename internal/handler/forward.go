@@ -11,13 +11,49 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/PipeOpsHQ/pipehook/internal/tracing"
 )
 
-func newForwardClient(allowPrivate bool) *http.Client {
+// forwardPool counts the forwarding client's connections and requests for
+// the diagnostics page. It outlives client rebuilds on reload.
+type forwardPool struct {
+	open     atomic.Int64
+	dialed   atomic.Int64
+	inFlight atomic.Int64
+}
+
+// countedConn decrements the pool's open count once when closed.
+type countedConn struct {
+	net.Conn
+	pool   *forwardPool
+	closed atomic.Bool
+}
+
+func (c *countedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.pool.open.Add(-1)
+	}
+	return c.Conn.Close()
+}
+
+func newForwardClient(allowPrivate bool, timeout time.Duration, pool *forwardPool) *http.Client {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
 	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		pool.open.Add(1)
+		pool.dialed.Add(1)
+		return &countedConn{Conn: conn, pool: pool}, nil
+	}
 	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
@@ -33,7 +69,7 @@ func newForwardClient(allowPrivate bool) *http.Client {
 				if !allowPrivate && isPrivateOrReservedIP(ip) {
 					continue
 				}
-				return dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+				return dial(ctx, network, net.JoinHostPort(ip.String(), port))
 			}
 			return nil, errors.New("forward target resolves only to private or reserved addresses")
 		},
@@ -44,7 +80,7 @@ func newForwardClient(allowPrivate bool) *http.Client {
 		TLSHandshakeTimeout:   5 * time.Second,
 		ResponseHeaderTimeout: 8 * time.Second,
 	}
-	client := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+	client := &http.Client{Transport: transport, Timeout: timeout}
 	client.CheckRedirect = func(request *http.Request, via []*http.Request) error {
 		if len(via) >= 5 {
 			return errors.New("too many forwarding redirects")
@@ -75,29 +111,50 @@ func isPrivateOrReservedIP(ip net.IP) bool {
 	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast()
 }
 
-func (h *Handler) forwardRequest(ctx context.Context, endpoint *store.Endpoint, captured *store.Request) error {
+// forwardRequest sends a copy of captured to the endpoint's forwarding
+// target. The target joins the capture's trace through traceparent.
+func (h *Handler) forwardRequest(ctx context.Context, endpoint *store.Endpoint, captured *store.Request) (err error) {
 	if endpoint.ForwardURL == "" {
 		return nil
 	}
+	ctx, span := h.Tracer.Start(ctx, "forwardRequest", tracing.KindClient)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
 	if err := validateForwardURL(endpoint.ForwardURL); err != nil {
+		h.metrics.forwards.Inc(outboundResult(nil, err))
 		return err
 	}
 
 	target, _ := url.Parse(endpoint.ForwardURL)
 	target.Path = strings.TrimSuffix(target.Path, "/") + replayRelativePath(captured)
 	target.RawQuery = captured.QueryString
+	span.SetAttribute("http.request.method", captured.Method)
+	span.SetAttribute("server.address", target.Host)
+	span.SetAttribute("url.path", target.Path)
 	request, err := http.NewRequestWithContext(ctx, captured.Method, target.String(), bytes.NewReader(captured.Body))
 	if err != nil {
 		return err
 	}
 	copyReplayHeaders(request.Header, captured.Headers)
 	request.Header.Set("X-Pipehook-Forwarded", "true")
+	tracing.Inject(ctx, request.Header)
 
-	response, err := h.ForwardClient.Do(request)
+	started := time.Now()
+	h.forwardPool.inFlight.Add(1)
+	response, err := h.forwarder().Do(request)
+	h.forwardPool.inFlight.Add(-1)
+	h.metrics.forwardDuration.Observe(time.Since(started).Seconds())
+	h.metrics.forwards.Inc(outboundResult(response, err))
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
+	span.SetAttribute("http.response.status_code", response.StatusCode)
+	if response.StatusCode >= http.StatusBadRequest {
+		span.SetError(errors.New(response.Status))
+	}
 	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 32*1024))
 	return nil
 }
@@ -130,9 +187,13 @@ func replayRelativePath(request *store.Request) string {
 	return path
 }
 
-func requestScheme(r *http.Request) string {
-	if forwarded := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]); forwarded == "http" || forwarded == "https" {
-		return forwarded
+// requestScheme returns the scheme the client used. X-Forwarded-Proto is only
+// believed from a trusted proxy.
+func (h *Handler) requestScheme(r *http.Request) string {
+	if h.fromTrustedProxy(r) {
+		if forwarded := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-Proto"), ",")[0]); forwarded == "http" || forwarded == "https" {
+			return forwarded
+		}
 	}
 	if r.TLS != nil {
 		return "https"
@@ -163,5 +224,14 @@ func validateEndpointSettings(settings store.EndpointSettings) error {
 	if len(settings.Alias) > 120 || len(settings.DefaultContentType) > 200 || len(settings.ForwardURL) > 2048 {
 		return errors.New("one or more settings exceed their maximum length")
 	}
+	if err := validateInboundAuth(settings.InboundAuth); err != nil {
+		return err
+	}
+	if err := validateThrottle(settings.Throttle); err != nil {
+		return err
+	}
+	if err := validateChaos(settings.Chaos); err != nil {
+		return err
+	}
 	return validateForwardURL(settings.ForwardURL)
 }
@@ -2,15 +2,23 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/PipeOpsHQ/pipehook/internal/metrics"
+	"github.com/PipeOpsHQ/pipehook/internal/notify"
+	"github.com/PipeOpsHQ/pipehook/internal/oidc"
 	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/PipeOpsHQ/pipehook/internal/tracing"
 	"github.com/PipeOpsHQ/pipehook/ui"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -21,6 +29,7 @@ const browserIDCookieName = "pipehook_browser_id"
 // BaseTemplateData contains common data for all templates
 type BaseTemplateData struct {
 	IsAdmin bool
+	User    *store.User
 }
 
 // Template functions
@@ -28,6 +37,9 @@ var funcMap = template.FuncMap{
 	"sub":          func(a, b int) int { return a - b },
 	"add":          func(a, b int) int { return a + b },
 	"assetVersion": func() string { return appCSSVersion },
+	"join":         strings.Join,
+	"bytes":        formatBytes,
+	"percent":      func(ratio float64) string { return strconv.FormatFloat(ratio*100, 'f', 0, 64) + "%" },
 }
 
 var appCSSVersion = func() string {
@@ -40,59 +52,162 @@ var appCSSVersion = func() string {
 }()
 
 var (
-	homeTemplate      = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/home.html"))
-	dashboardTemplate = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/dashboard.html", "templates/request-detail.html"))
-	detailTemplate    = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/request-detail.html"))
-	adminTemplate     = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/admin.html"))
+	homeTemplate        = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/home.html"))
+	dashboardTemplate   = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/dashboard.html", "templates/request-detail.html"))
+	detailTemplate      = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/request-detail.html"))
+	adminTemplate       = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/admin.html"))
+	diagnosticsTemplate = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/diagnostics.html"))
+	loginTemplate       = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/login.html"))
+	workspaceTemplate   = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/workspace.html"))
+	diffTemplate        = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/diff.html"))
+	shapesTemplate      = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/shapes.html"))
+	shareTemplate       = template.Must(template.New("").Funcs(funcMap).ParseFS(ui.FS, "templates/layout.html", "templates/share.html", "templates/request-detail.html"))
 
 	upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
-		CheckOrigin: func(r *http.Request) bool {
-			origin := r.Header.Get("Origin")
-			if origin == "" {
-				return true
-			}
-			return origin == requestScheme(r)+"://"+r.Host
-		},
+		// WebSocket compares the origin itself, since the scheme depends on
+		// the handler's trusted proxies.
+		CheckOrigin: func(*http.Request) bool { return true },
 	}
 )
 
-type Handler struct {
-	Store               store.Store
-	clients             map[string][]*websocket.Conn // endpointID -> WebSocket connections
-	clientsMu           sync.RWMutex
-	AdminUsername       string
-	AdminPassword       string
+// RuntimeConfig holds the settings that may be replaced while the server is
+// running, for example when the configuration file is reloaded.
+type RuntimeConfig struct {
 	APIKey              string
+	APIKeyRateLimit     RateLimit
+	ClientIPRateLimit   RateLimit
+	CaptureIPRateLimit  RateLimit
 	MaxWebhookBodyBytes int64
 	AllowPrivateForward bool
-	ForwardClient       *http.Client
-	apiRateMu           sync.Mutex
-	apiRateWindow       time.Time
-	apiRateCount        int
+	AllowSignup         bool
+	ForwardTimeout      time.Duration
+	ReplayTimeout       time.Duration
+	MetricsToken        string
+	MetricsEndpoints    int
+	MinFreeDiskBytes    int64
+	SMTP                notify.SMTP
+	// TrustedProxies are the peers whose X-Forwarded-For and X-Real-IP
+	// headers name the client.
+	TrustedProxies []netip.Prefix
+	// PublicURL is the address users reach the server at, without a
+	// trailing slash. Notification links are built from it and left out
+	// when it is empty.
+	PublicURL string
 }
 
-func NewHandler(s store.Store) *Handler {
-	return &Handler{
-		Store:               s,
-		clients:             make(map[string][]*websocket.Conn),
+func DefaultRuntimeConfig() RuntimeConfig {
+	return RuntimeConfig{
+		APIKeyRateLimit:     RateLimit{RequestsPerMinute: 300, Burst: 60},
+		ClientIPRateLimit:   RateLimit{RequestsPerMinute: 60, Burst: 20},
+		CaptureIPRateLimit:  RateLimit{RequestsPerMinute: 6000, Burst: 200},
 		MaxWebhookBodyBytes: 2 * 1024 * 1024, // 2MB default
-		ForwardClient:       newForwardClient(false),
+		AllowSignup:         true,
+		ForwardTimeout:      10 * time.Second,
+		ReplayTimeout:       10 * time.Second,
+		MetricsEndpoints:    100,
+		MinFreeDiskBytes:    100 * 1024 * 1024,
+	}
+}
+
+type Handler struct {
+	Store         store.Store
+	clients       map[string][]*websocket.Conn // endpointID -> WebSocket connections
+	clientsMu     sync.RWMutex
+	AdminUsername string
+	AdminPassword string
+	// OIDC enables single sign-on when set. Basic auth keeps working for
+	// headless access to the admin routes.
+	OIDC *oidc.Provider
+	// Tracer records spans for captures when tracing is configured. TraceURL
+	// links a request's trace ID to a tracing backend; {trace_id} is
+	// replaced with the ID.
+	Tracer   *tracing.Tracer
+	TraceURL string
+	// WriteTimeout is the server's write timeout. Response delays and chaos
+	// latency stop short of it so that the sender still gets the response.
+	WriteTimeout     time.Duration
+	configMu         sync.RWMutex
+	config           RuntimeConfig
+	forwardClient    *http.Client
+	forwardPool      forwardPool
+	apiKeyLimiter    *tokenBucketLimiter
+	clientIPLimiter  *tokenBucketLimiter
+	captureIPLimiter *tokenBucketLimiter
+	endpointLimiters *endpointLimiters
+	// Metrics is written by ServeMetrics. Callers may register their own
+	// metrics, such as the database size, alongside the handler's.
+	Metrics   *metrics.Registry
+	metrics   *handlerMetrics
+	notifier  *notify.Notifier
+	schemas   *schemaCache
+	throttled *throttledCounter
+}
+
+func NewHandler(s store.Store) *Handler {
+	defaults := DefaultRuntimeConfig()
+	h := &Handler{
+		Metrics:          metrics.NewRegistry(),
+		clients:          make(map[string][]*websocket.Conn),
+		apiKeyLimiter:    newTokenBucketLimiter(defaults.APIKeyRateLimit),
+		clientIPLimiter:  newTokenBucketLimiter(defaults.ClientIPRateLimit),
+		captureIPLimiter: newTokenBucketLimiter(defaults.CaptureIPRateLimit),
+		endpointLimiters: newEndpointLimiters(),
+		schemas:          newSchemaCache(),
+		throttled:        newThrottledCounter(),
 	}
+	h.registerMetrics()
+	h.Store = store.Instrument(s, h.observeStore)
+	h.ApplyRuntimeConfig(defaults)
+	h.notifier = notify.New(notify.Config{
+		Client: h.forwarder,
+		SMTP:   func() notify.SMTP { return h.runtimeConfig().SMTP },
+		Result: func(kind, result string) { h.metrics.notifications.Inc(kind, result) },
+	})
+	return h
+}
+
+// Shutdown stores the pending dropped-capture counts and sends the
+// notifications still queued, giving up when ctx ends.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	if err := h.FlushThrottledCounts(ctx); err != nil {
+		slog.ErrorContext(ctx, "failed to store throttled capture counts", "error", err)
+	}
+	return h.notifier.Close(ctx)
+}
+
+// ApplyRuntimeConfig swaps in new live settings. The forwarding client is only
+// rebuilt when its policy changes so idle connections survive unrelated reloads.
+func (h *Handler) ApplyRuntimeConfig(config RuntimeConfig) {
+	h.configMu.Lock()
+	defer h.configMu.Unlock()
+	if h.forwardClient == nil || config.AllowPrivateForward != h.config.AllowPrivateForward || config.ForwardTimeout != h.config.ForwardTimeout {
+		h.forwardClient = newForwardClient(config.AllowPrivateForward, config.ForwardTimeout, &h.forwardPool)
+	}
+	h.apiKeyLimiter.configure(config.APIKeyRateLimit)
+	h.clientIPLimiter.configure(config.ClientIPRateLimit)
+	h.captureIPLimiter.configure(config.CaptureIPRateLimit)
+	h.config = config
+}
+
+func (h *Handler) runtimeConfig() RuntimeConfig {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+	return h.config
 }
 
-func (h *Handler) SetAllowPrivateForwarding(allow bool) {
-	h.AllowPrivateForward = allow
-	h.ForwardClient = newForwardClient(allow)
+func (h *Handler) forwarder() *http.Client {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+	return h.forwardClient
 }
 
 // GetBrowserID retrieves or creates a browser fingerprint ID from cookies
 func (h *Handler) GetBrowserID(w http.ResponseWriter, r *http.Request) string {
 	// Try to get existing browser ID from cookie
-	cookie, err := r.Cookie(browserIDCookieName)
-	if err == nil && cookie.Value != "" {
-		return cookie.Value
+	if browserID := browserIDFromRequest(r); browserID != "" {
+		return browserID
 	}
 
 	// Generate new browser ID
@@ -106,7 +221,7 @@ func (h *Handler) GetBrowserID(w http.ResponseWriter, r *http.Request) string {
 		MaxAge:   365 * 24 * 60 * 60, // 1 year
 		HttpOnly: true,
 		SameSite: http.SameSiteLaxMode,
-		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		Secure:   h.requestScheme(r) == "https",
 	})
 
 	return browserID
@@ -124,7 +239,7 @@ func (h *Handler) Broadcast(endpointID string, req *store.Request) {
 	var buf bytes.Buffer
 	err := dashboardTemplate.ExecuteTemplate(&buf, "request-item", req)
 	if err != nil {
-		log.Printf("Broadcast template error: %v", err)
+		slog.Error("broadcast template error", "endpoint_id", endpointID, "error", err)
 		return
 	}
 
@@ -135,7 +250,7 @@ func (h *Handler) Broadcast(endpointID string, req *store.Request) {
 			"type":    "new-request",
 			"payload": buf.String(),
 		}); err != nil {
-			log.Printf("WebSocket send error, removing client: %v", err)
+			slog.Warn("websocket send failed, removing client", "endpoint_id", endpointID, "error", err)
 			// Remove disconnected client
 			clients = append(clients[:i], clients[i+1:]...)
 			conn.Close()
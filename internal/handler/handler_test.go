@@ -2,17 +2,40 @@ package handler
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/PipeOpsHQ/pipehook/internal/logging"
+	"github.com/PipeOpsHQ/pipehook/internal/notify"
+	"github.com/PipeOpsHQ/pipehook/internal/notify/notifytest"
+	"github.com/PipeOpsHQ/pipehook/internal/oidc"
+	"github.com/PipeOpsHQ/pipehook/internal/oidc/oidctest"
 	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/PipeOpsHQ/pipehook/internal/tracing"
+	"github.com/PipeOpsHQ/pipehook/internal/tracing/tracingtest"
 	"github.com/go-chi/chi/v5"
 )
 
+// testBrowserID stands for a browser ID cookie issued by the server.
+const testBrowserID = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+
 func testHandler(t *testing.T) (*Handler, *store.SQLiteStore) {
 	t.Helper()
 	database, err := store.NewSQLiteStore(":memory:")
@@ -26,7 +49,7 @@ func testHandler(t *testing.T) (*Handler, *store.SQLiteStore) {
 
 func TestCaptureWebhookPreservesMetadataAndCustomResponse(t *testing.T) {
 	handler, database := testHandler(t)
-	endpoint, err := database.CreateEndpoint(t.Context(), "endpoint", "", "browser", store.DefaultTTL)
+	endpoint, err := database.CreateEndpoint(t.Context(), "endpoint", "", testBrowserID, store.DefaultTTL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -73,7 +96,7 @@ func TestCaptureWebhookPreservesMetadataAndCustomResponse(t *testing.T) {
 
 func TestReplayDoesNotDuplicateCapturePath(t *testing.T) {
 	handler, database := testHandler(t)
-	endpoint, err := database.CreateEndpoint(t.Context(), "endpoint", "", "browser", store.DefaultTTL)
+	endpoint, err := database.CreateEndpoint(t.Context(), "endpoint", "", testBrowserID, store.DefaultTTL)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -95,7 +118,7 @@ func TestReplayDoesNotDuplicateCapturePath(t *testing.T) {
 	}
 
 	replay, _ := http.NewRequest(http.MethodPost, server.URL+"/r/"+strconv.FormatInt(requests[0].ID, 10)+"/replay", nil)
-	replay.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: "browser"})
+	replay.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: testBrowserID})
 	replayed, err := http.DefaultClient.Do(replay)
 	if err != nil {
 		t.Fatal(err)
@@ -113,7 +136,9 @@ func TestReplayDoesNotDuplicateCapturePath(t *testing.T) {
 
 func TestAPIAuthentication(t *testing.T) {
 	handler, _ := testHandler(t)
-	handler.APIKey = "secret"
+	config := DefaultRuntimeConfig()
+	config.APIKey = "secret"
+	handler.ApplyRuntimeConfig(config)
 	router := chi.NewRouter()
 	router.Route("/api/v1", func(router chi.Router) {
 		router.Use(handler.APIAuthMiddleware)
@@ -163,3 +188,2150 @@ func TestLayoutUsesContentVersionedStylesheet(t *testing.T) {
 		t.Fatalf("layout does not use versioned stylesheet %q", want)
 	}
 }
+
+func TestScopedAPIKeys(t *testing.T) {
+	handler, database := testHandler(t)
+	for _, id := range []string{"allowed", "other"} {
+		if _, err := database.CreateEndpoint(t.Context(), id, "", testBrowserID, store.DefaultTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{
+		Name: "ci", Scopes: []string{store.APIScopeRead}, EndpointIDs: []string{"allowed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(router chi.Router) {
+		router.Use(handler.APIAuthMiddleware)
+		router.With(handler.RequireAPIScope(store.APIScopeRead)).Get("/endpoints/{endpointID}", handler.APIGetEndpoint)
+		router.With(handler.RequireAPIScope(store.APIScopeDelete)).Delete("/endpoints/{endpointID}", handler.APIDeleteEndpoint)
+		router.Group(func(router chi.Router) {
+			router.Use(handler.RequireAPIScope(store.APIScopeAdmin))
+			router.Get("/keys", handler.APIListKeys)
+			router.Post("/keys", handler.APICreateKey)
+			router.Delete("/keys/{keyID}", handler.APIRevokeKey)
+			router.Get("/export", handler.APIExportNDJSON)
+		})
+	})
+	call := func(method, path string) int {
+		request := httptest.NewRequest(method, path, nil)
+		request.Header.Set("X-API-Key", created.Token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response.Code
+	}
+
+	if code := call(http.MethodGet, "/api/v1/endpoints/allowed"); code != http.StatusOK {
+		t.Fatalf("expected read access, got %d", code)
+	}
+	if code := call(http.MethodGet, "/api/v1/endpoints/other"); code != http.StatusForbidden {
+		t.Fatalf("expected endpoint restriction, got %d", code)
+	}
+	if code := call(http.MethodDelete, "/api/v1/endpoints/allowed"); code != http.StatusForbidden {
+		t.Fatalf("expected missing delete scope, got %d", code)
+	}
+	admin, err := handler.createAPIKey(t.Context(), apiKeyInput{
+		Name: "endpoint admin", Scopes: []string{store.APIScopeAdmin}, EndpointIDs: []string{"allowed"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete, "EXPORT"} {
+		path := "/api/v1/keys"
+		switch method {
+		case http.MethodDelete:
+			path += "/" + created.ID
+		case "EXPORT":
+			method, path = http.MethodGet, "/api/v1/export"
+		}
+		request := httptest.NewRequest(method, path, strings.NewReader(`{"name": "escalated", "scopes": ["admin"]}`))
+		request.Header.Set("X-API-Key", admin.Token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		if response.Code != http.StatusForbidden {
+			t.Fatalf("%s %s: expected an endpoint-restricted key to be refused, got %d", method, path, response.Code)
+		}
+	}
+	keys, _ := database.ListAPIKeys(t.Context())
+	keys = slices.DeleteFunc(keys, func(key *store.APIKey) bool { return key.ID != created.ID })
+	if len(keys) != 1 || keys[0].LastUsedAt == nil {
+		t.Fatalf("expected last-used timestamp: %+v", keys)
+	}
+	if err := database.RevokeAPIKey(t.Context(), created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(http.MethodGet, "/api/v1/endpoints/allowed"); code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked key to be rejected, got %d", code)
+	}
+}
+
+func TestClientIPTrustsOnlyConfiguredProxies(t *testing.T) {
+	handler, _ := testHandler(t)
+	request := func(remoteAddr string, header http.Header) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/h/guarded", nil)
+		r.RemoteAddr, r.Header = remoteAddr, header
+		return r
+	}
+	spoofed := http.Header{"X-Forwarded-For": {"203.0.113.9"}, "X-Real-Ip": {"203.0.113.9"}}
+	if ip := handler.clientIP(request("198.51.100.1:1234", spoofed)); ip != "198.51.100.1" {
+		t.Fatalf("expected forwarding headers to be ignored without trusted proxies, got %s", ip)
+	}
+
+	config := DefaultRuntimeConfig()
+	config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler.ApplyRuntimeConfig(config)
+	for _, tc := range []struct {
+		remoteAddr string
+		header     http.Header
+		want       string
+	}{
+		{"198.51.100.1:1234", spoofed, "198.51.100.1"},
+		{"10.0.0.2:1234", http.Header{"X-Forwarded-For": {"203.0.113.66, 198.51.100.7, 10.0.0.3"}}, "198.51.100.7"},
+		{"10.0.0.2:1234", http.Header{"X-Forwarded-For": {"10.0.0.4", "10.0.0.3"}}, "10.0.0.4"},
+		{"10.0.0.2:1234", http.Header{"X-Real-Ip": {"203.0.113.9"}}, "203.0.113.9"},
+		{"10.0.0.2:1234", http.Header{"X-Forwarded-For": {"not an address"}}, "10.0.0.2"},
+	} {
+		if ip := handler.clientIP(request(tc.remoteAddr, tc.header)); ip != tc.want {
+			t.Fatalf("%s %v: expected %s, got %s", tc.remoteAddr, tc.header, tc.want, ip)
+		}
+	}
+	auth := normalizeInboundAuth(store.InboundAuth{AllowedCIDRs: []string{"203.0.113.9"}})
+	if rejection := handler.checkInboundAuth(request("10.0.0.2:1234", spoofed), auth); rejection != nil {
+		t.Fatalf("expected the allowlist to see the forwarded client: %+v", rejection)
+	}
+	if rejection := handler.checkInboundAuth(request("198.51.100.1:1234", spoofed), auth); rejection == nil {
+		t.Fatal("expected an untrusted peer to be checked by its own address")
+	}
+
+	https := http.Header{"X-Forwarded-Proto": {"https"}}
+	if scheme := handler.requestScheme(request("198.51.100.1:1234", https)); scheme != "http" {
+		t.Fatalf("expected an untrusted peer not to pick the scheme, got %s", scheme)
+	}
+	if scheme := handler.requestScheme(request("10.0.0.2:1234", https)); scheme != "https" {
+		t.Fatalf("expected a trusted proxy to set the scheme, got %s", scheme)
+	}
+	for remoteAddr, secure := range map[string]bool{"198.51.100.1:1234": false, "10.0.0.2:1234": true} {
+		response := httptest.NewRecorder()
+		handler.GetBrowserID(response, request(remoteAddr, https))
+		if cookies := response.Result().Cookies(); len(cookies) != 1 || cookies[0].Secure != secure {
+			t.Fatalf("%s: expected a browser cookie with Secure %v, got %v", remoteAddr, secure, cookies)
+		}
+	}
+}
+
+func TestTokenBucketLimiterRefillsPerKey(t *testing.T) {
+	limiter := newTokenBucketLimiter(RateLimit{RequestsPerMinute: 60, Burst: 2})
+	now := time.Now()
+	if !limiter.allow("a", now).allowed || !limiter.allow("a", now).allowed {
+		t.Fatal("expected burst to be available")
+	}
+	denied := limiter.allow("a", now)
+	if denied.allowed || denied.remaining != 0 || denied.retryAfter <= 0 || denied.retryAfter > time.Second {
+		t.Fatalf("expected denial with retry hint: %+v", denied)
+	}
+	if !limiter.allow("b", now).allowed {
+		t.Fatal("buckets must be independent per key")
+	}
+	if !limiter.allow("a", now.Add(time.Second)).allowed {
+		t.Fatal("expected one token after a second")
+	}
+	if limiter.rejected.Load() != 1 {
+		t.Fatalf("expected one rejection, got %d", limiter.rejected.Load())
+	}
+}
+
+func TestThrottledCounterKeepsConcurrentCounts(t *testing.T) {
+	counter := newThrottledCounter()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 1000 {
+				counter.add("flooded")
+			}
+		}()
+	}
+	var total int64
+	for range 20 {
+		total += counter.take()["flooded"]
+	}
+	wg.Wait()
+	total += counter.take()["flooded"]
+	if total != 8000 {
+		t.Fatalf("expected every dropped capture to be counted once, got %d", total)
+	}
+}
+
+func TestAPIRateLimitHeaders(t *testing.T) {
+	handler, _ := testHandler(t)
+	config := DefaultRuntimeConfig()
+	config.APIKey = "secret"
+	config.APIKeyRateLimit = RateLimit{RequestsPerMinute: 1, Burst: 1}
+	handler.ApplyRuntimeConfig(config)
+	router := chi.NewRouter()
+	router.With(handler.APIAuthMiddleware).Get("/api/v1/endpoints", handler.APIListEndpoints)
+
+	codes := []int{}
+	var last *httptest.ResponseRecorder
+	for range 2 {
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/endpoints", nil)
+		request.Header.Set("X-API-Key", "secret")
+		last = httptest.NewRecorder()
+		router.ServeHTTP(last, request)
+		codes = append(codes, last.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status codes %v", codes)
+	}
+	if last.Header().Get("X-RateLimit-Limit") != "1" || last.Header().Get("X-RateLimit-Remaining") != "0" ||
+		last.Header().Get("Retry-After") == "" || last.Header().Get("X-RateLimit-Reset") == "" {
+		t.Fatalf("missing rate limit headers: %v", last.Header())
+	}
+}
+
+func TestAccountsOwnAndClaimEndpoints(t *testing.T) {
+	handler, database := testHandler(t)
+	if _, err := database.CreateEndpoint(t.Context(), "legacy", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.CreateEndpoint(t.Context(), "from-api", "", "api", store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Use(handler.SessionMiddleware)
+	router.Post("/signup", handler.Signup)
+	router.Post("/account/claim", handler.ClaimBrowserEndpoints)
+	router.Get("/{endpointID}", handler.Dashboard)
+
+	serve := func(method, target, form string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(form))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if response := serve(http.MethodPost, "/signup", "username=al&password=secret-password"); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected short username to be rejected, got %d", response.Code)
+	}
+	signup := serve(http.MethodPost, "/signup", "username=alice&password=secret-password")
+	if signup.Code != http.StatusSeeOther || len(signup.Result().Cookies()) != 1 {
+		t.Fatalf("expected session cookie after sign-up: %d %v", signup.Code, signup.Result().Cookies())
+	}
+	session := signup.Result().Cookies()[0]
+	browser := &http.Cookie{Name: browserIDCookieName, Value: testBrowserID}
+
+	forged := &http.Cookie{Name: browserIDCookieName, Value: "api"}
+	if response := serve(http.MethodPost, "/account/claim", "", session, forged); response.Code != http.StatusSeeOther {
+		t.Fatalf("claim failed with %d", response.Code)
+	}
+	if endpoint, err := database.GetEndpoint(t.Context(), "from-api"); err != nil || endpoint.OwnerUserID != "" {
+		t.Fatalf("a forged browser ID must not claim API endpoints: %+v %v", endpoint, err)
+	}
+	if response := serve(http.MethodGet, "/from-api", "", forged); response.Code != http.StatusForbidden {
+		t.Fatalf("a forged browser ID must not open API endpoints, got %d", response.Code)
+	}
+	if response := serve(http.MethodPost, "/account/claim", "", session, browser); response.Code != http.StatusSeeOther {
+		t.Fatalf("claim failed with %d", response.Code)
+	}
+	if response := serve(http.MethodGet, "/legacy", "", browser); response.Code != http.StatusForbidden {
+		t.Fatalf("browser cookie must not grant access after claiming, got %d", response.Code)
+	}
+	if response := serve(http.MethodGet, "/legacy", "", session); response.Code != http.StatusOK {
+		t.Fatalf("owner should reach the dashboard from any browser, got %d", response.Code)
+	}
+}
+
+func TestWorkspaceRolesGateEndpointAccess(t *testing.T) {
+	handler, database := testHandler(t)
+	ctx := t.Context()
+	for _, username := range []string{"owner", "viewer", "editor", "outsider"} {
+		if err := database.CreateUser(ctx, &store.User{ID: username, Username: username}, "hash"); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.CreateSession(ctx, &store.Session{TokenHash: hashToken(username + "-session"), UserID: username, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := database.CreateWorkspace(ctx, &store.Workspace{ID: "team", Name: "Team"}, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	_ = database.SetWorkspaceMember(ctx, "team", "viewer", store.WorkspaceRoleViewer)
+	_ = database.SetWorkspaceMember(ctx, "team", "editor", store.WorkspaceRoleEditor)
+	if _, err := database.CreateEndpoint(ctx, "shared", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	_ = database.SetEndpointOwner(ctx, "shared", "owner")
+	_ = database.SetEndpointWorkspace(ctx, "shared", "team")
+
+	router := chi.NewRouter()
+	router.Use(handler.SessionMiddleware)
+	router.Get("/ws/{endpointID}", handler.WebSocket)
+	router.Post("/endpoint/{endpointID}/settings", handler.UpdateEndpointSettings)
+	router.Delete("/endpoint/{endpointID}", handler.DeleteEndpoint)
+	router.Get("/workspaces/{workspaceID}", handler.WorkspacePage)
+	router.Get("/endpoint/{endpointID}/export.ndjson", handler.ExportEndpointNDJSON)
+
+	serve := func(method, target, username string) int {
+		request := httptest.NewRequest(method, target, strings.NewReader("default_status=200&response_delay_ms=0&request_limit=10"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(&http.Cookie{Name: sessionCookieName, Value: username + "-session"})
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := serve(http.MethodGet, "/workspaces/team", "viewer"); code != http.StatusOK {
+		t.Fatalf("viewer should see the workspace, got %d", code)
+	}
+	if code := serve(http.MethodGet, "/workspaces/team", "outsider"); code != http.StatusForbidden {
+		t.Fatalf("outsider must not see the workspace, got %d", code)
+	}
+	// The WebSocket route answers 400 once access is granted because the test
+	// request is not an upgrade.
+	if code := serve(http.MethodGet, "/ws/shared", "viewer"); code != http.StatusBadRequest {
+		t.Fatalf("viewer should pass the live-update access check, got %d", code)
+	}
+	if code := serve(http.MethodGet, "/ws/shared", "outsider"); code != http.StatusForbidden {
+		t.Fatalf("outsider must not subscribe, got %d", code)
+	}
+	if code := serve(http.MethodPost, "/endpoint/shared/settings", "viewer"); code != http.StatusForbidden {
+		t.Fatalf("viewer must not change settings, got %d", code)
+	}
+	if code := serve(http.MethodPost, "/endpoint/shared/settings", "editor"); code != http.StatusOK {
+		t.Fatalf("editor should change settings, got %d", code)
+	}
+	// The export carries assertions, whose expected headers can be secrets.
+	if code := serve(http.MethodGet, "/endpoint/shared/export.ndjson", "viewer"); code != http.StatusForbidden {
+		t.Fatalf("viewer must not export the endpoint, got %d", code)
+	}
+	if code := serve(http.MethodGet, "/endpoint/shared/export.ndjson", "editor"); code != http.StatusOK {
+		t.Fatalf("editor should export the endpoint, got %d", code)
+	}
+	if code := serve(http.MethodDelete, "/endpoint/shared", "editor"); code != http.StatusForbidden {
+		t.Fatalf("editor must not delete the endpoint, got %d", code)
+	}
+	if code := serve(http.MethodDelete, "/endpoint/shared", "owner"); code != http.StatusOK {
+		t.Fatalf("owner should delete the endpoint, got %d", code)
+	}
+}
+
+func TestSingleSignOnGrantsAdminWithBasicAuthFallback(t *testing.T) {
+	handler, _ := testHandler(t)
+	identity := oidctest.NewServer("pipehook", "secret")
+	defer identity.Close()
+	handler.AdminUsername, handler.AdminPassword = "admin", "password"
+	handler.OIDC = oidc.NewProvider(oidc.Config{
+		IssuerURL: identity.URL, ClientID: "pipehook", ClientSecret: "secret",
+		RedirectURL: "http://example.com/auth/oidc/callback", AllowedDomains: []string{"example.com"},
+		AdminClaim: "groups", AdminValues: []string{"pipehook-admins"},
+	}, identity.Client())
+	router := chi.NewRouter()
+	router.Use(handler.SessionMiddleware)
+	router.Get("/auth/oidc/login", handler.OIDCLogin)
+	router.Get("/auth/oidc/callback", handler.OIDCCallback)
+	router.With(handler.AdminAuthMiddleware).Get("/admin", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, currentUser(r).Username)
+	})
+	router.With(handler.AdminAuthMiddleware).Get("/admin/basic", func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(target string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	// signIn follows the redirects through the stand-in identity provider
+	// and returns the callback response.
+	signIn := func(claims map[string]any) *httptest.ResponseRecorder {
+		identity.SetClaims(claims)
+		start := serve("/auth/oidc/login?next=/admin")
+		if start.Code != http.StatusFound || len(start.Result().Cookies()) != 1 {
+			t.Fatalf("expected redirect to the identity provider, got %d", start.Code)
+		}
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		response, err := client.Get(start.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		callback, err := url.Parse(response.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return serve(callback.RequestURI(), start.Result().Cookies()[0])
+	}
+
+	if response := serve("/admin"); response.Code != http.StatusFound || response.Header().Get("Location") != "/auth/oidc/login?next=%2Fadmin" {
+		t.Fatalf("expected browsers to be sent to single sign-on, got %d %q", response.Code, response.Header().Get("Location"))
+	}
+	if response := serve("/auth/oidc/callback?code=x&state=forged"); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected a callback without the state cookie to fail, got %d", response.Code)
+	}
+	if response := signIn(map[string]any{"sub": "2", "email": "eve@elsewhere.com"}); response.Code != http.StatusForbidden {
+		t.Fatalf("expected a disallowed domain to be refused, got %d", response.Code)
+	}
+
+	if response := signIn(map[string]any{"sub": "3", "email": "bob@example.com"}); response.Code != http.StatusForbidden {
+		t.Fatalf("expected an email without email_verified to be refused, got %d", response.Code)
+	}
+
+	member := signIn(map[string]any{"sub": "3", "email": "bob@example.com", "email_verified": true, "groups": []string{"dev"}})
+	if member.Code != http.StatusSeeOther || member.Header().Get("Location") != "/admin" {
+		t.Fatalf("expected sign-in to return to /admin, got %d %q", member.Code, member.Header().Get("Location"))
+	}
+	if response := serve("/admin", member.Result().Cookies()...); response.Code != http.StatusUnauthorized {
+		t.Fatalf("a signed-in non-admin should fall back to Basic auth, got %d", response.Code)
+	}
+
+	admin := signIn(map[string]any{"sub": "1", "email": "ada@example.com", "email_verified": true, "preferred_username": "ada", "groups": []string{"pipehook-admins"}})
+	if admin.Code != http.StatusSeeOther {
+		t.Fatalf("expected admin sign-in to succeed, got %d: %s", admin.Code, admin.Body.String())
+	}
+	if response := serve("/admin", admin.Result().Cookies()...); response.Code != http.StatusOK || response.Body.String() != "ada" {
+		t.Fatalf("expected admin access through single sign-on, got %d %q", response.Code, response.Body.String())
+	}
+	again := signIn(map[string]any{"sub": "1", "email": "ada@example.com", "email_verified": true, "preferred_username": "ada", "groups": []string{"pipehook-admins"}})
+	if response := serve("/admin", again.Result().Cookies()...); response.Body.String() != "ada" {
+		t.Fatalf("expected the same account on a second sign-in, got %q", response.Body.String())
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/admin/basic", nil)
+	request.SetBasicAuth("admin", "password")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected Basic auth to keep working, got %d", recorder.Code)
+	}
+}
+
+func TestShareLinksGrantRevocableReadOnlyAccess(t *testing.T) {
+	handler, database := testHandler(t)
+	ctx := t.Context()
+	if _, err := database.CreateEndpoint(ctx, "shared", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/first", "/second"} {
+		request := &store.Request{EndpointID: "shared", Method: http.MethodPost, Path: path, Headers: `{"Authorization":["Bearer secret-token"]}`, Body: []byte("payload")}
+		if err := database.SaveRequest(ctx, request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summaries, err := database.SearchRequestSummaries(ctx, "shared", "", 10, 0)
+	if err != nil || len(summaries) != 2 {
+		t.Fatalf("unexpected requests: %v %v", summaries, err)
+	}
+	router := chi.NewRouter()
+	router.Use(handler.SessionMiddleware)
+	router.Post("/endpoint/{endpointID}/shares", handler.CreateShareLink)
+	router.Delete("/endpoint/{endpointID}/shares/{shareID}", handler.RevokeShareLink)
+	router.Get("/s/{token}", handler.SharedView)
+
+	serve := func(method, target, form, browserID string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, strings.NewReader(form))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if browserID != "" {
+			request.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: browserID})
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+	share := func(form string) (string, string) {
+		response := serve(http.MethodPost, "/endpoint/shared/shares", form, testBrowserID)
+		var created struct {
+			Link store.ShareLink `json:"link"`
+			URL  string          `json:"url"`
+		}
+		if response.Code != http.StatusCreated || json.Unmarshal(response.Body.Bytes(), &created) != nil {
+			t.Fatalf("failed to create share link: %d %s", response.Code, response.Body.String())
+		}
+		return created.Link.ID, strings.TrimPrefix(created.URL, "http://example.com")
+	}
+
+	if response := serve(http.MethodPost, "/endpoint/shared/shares", "scope=endpoint&expires_in=24h", "stranger"); response.Code != http.StatusForbidden {
+		t.Fatalf("only people with access may share, got %d", response.Code)
+	}
+	if response := serve(http.MethodPost, "/endpoint/shared/shares", "scope=endpoint&expires_in=forever", testBrowserID); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown expiry to be rejected, got %d", response.Code)
+	}
+
+	requestID := strconv.FormatInt(summaries[1].ID, 10)
+	_, requestLink := share("scope=request&request_id=" + requestID + "&expires_in=1h&redact_headers=authorization")
+	page := serve(http.MethodGet, requestLink, "", "")
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "/first") || strings.Contains(page.Body.String(), "/second") {
+		t.Fatalf("expected only the shared request, got %d", page.Code)
+	}
+	if strings.Contains(page.Body.String(), "secret-token") || !strings.Contains(page.Body.String(), "[redacted]") || strings.Contains(page.Body.String(), "/replay") {
+		t.Fatal("shared request must be read-only with the Authorization header redacted")
+	}
+	if response := serve(http.MethodGet, strings.TrimSuffix(requestLink, requestLink[len(requestLink)-2:])+"xx", "", ""); response.Code != http.StatusNotFound {
+		t.Fatalf("expected a tampered token to be rejected, got %d", response.Code)
+	}
+
+	endpointLinkID, endpointLink := share("scope=endpoint&expires_in=7d")
+	page = serve(http.MethodGet, endpointLink+"?request="+requestID, "", "")
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "/second") || !strings.Contains(page.Body.String(), "secret-token") {
+		t.Fatalf("expected the endpoint view without redaction, got %d", page.Code)
+	}
+	if response := serve(http.MethodDelete, "/endpoint/shared/shares/"+endpointLinkID, "", testBrowserID); response.Code != http.StatusOK {
+		t.Fatalf("revoke failed with %d", response.Code)
+	}
+	if response := serve(http.MethodGet, endpointLink, "", ""); response.Code != http.StatusNotFound {
+		t.Fatalf("expected revoked link to stop working, got %d", response.Code)
+	}
+}
+
+func TestAuditLogRecordsActorsAndSettingChanges(t *testing.T) {
+	handler, database := testHandler(t)
+	handler.AdminUsername, handler.AdminPassword = "admin", "secret"
+	for _, id := range []string{"first", "second"} {
+		if _, err := database.CreateEndpoint(t.Context(), id, "", testBrowserID, store.DefaultTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{
+		Name: "ops", Scopes: []string{store.APIScopeWrite, store.APIScopeDelete, store.APIScopeAdmin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(router chi.Router) {
+		router.Use(handler.APIAuthMiddleware)
+		router.Put("/endpoints/{endpointID}", handler.APIUpdateEndpoint)
+		router.Delete("/endpoints/{endpointID}", handler.APIDeleteEndpoint)
+		router.Get("/audit", handler.APIListAuditEvents)
+	})
+	router.Delete("/admin/endpoint/{endpointID}", handler.AdminDeleteEndpoint)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("X-API-Key", created.Token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	if response := call(http.MethodPut, "/api/v1/endpoints/first", `{"alias":"orders","request_limit":50}`); response.Code != http.StatusOK {
+		t.Fatalf("expected update, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := call(http.MethodDelete, "/api/v1/endpoints/first", ""); response.Code != http.StatusNoContent {
+		t.Fatalf("expected delete, got %d", response.Code)
+	}
+	adminDelete := httptest.NewRequest(http.MethodDelete, "/admin/endpoint/second", nil)
+	adminDelete.SetBasicAuth("admin", "secret")
+	router.ServeHTTP(httptest.NewRecorder(), adminDelete)
+
+	response := call(http.MethodGet, "/api/v1/audit?action=endpoint.update", "")
+	var updates []store.AuditEvent
+	if err := json.Unmarshal(response.Body.Bytes(), &updates); err != nil || len(updates) != 1 {
+		t.Fatalf("expected one update event, got %s %v", response.Body.String(), err)
+	}
+	var changes map[string]auditChange
+	if err := json.Unmarshal(updates[0].Details, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if updates[0].ActorType != store.AuditActorAPIKey || updates[0].Actor != "ops" || updates[0].TargetID != "first" ||
+		changes["alias"].To != "orders" || changes["request_limit"].From != float64(store.DefaultRequestLimit) {
+		t.Fatalf("unexpected update event: %+v %+v", updates[0], changes)
+	}
+	if _, changed := changes["enable_cors"]; changed {
+		t.Fatalf("unchanged settings must not be recorded: %+v", changes)
+	}
+
+	response = call(http.MethodGet, "/api/v1/audit?format=ndjson&action=endpoint.delete", "")
+	lines := strings.Split(strings.TrimSpace(response.Body.String()), "\n")
+	if response.Header().Get("Content-Type") != "application/x-ndjson" || len(lines) != 2 {
+		t.Fatalf("expected two exported deletes, got %q", response.Body.String())
+	}
+	var adminEvent store.AuditEvent
+	if err := json.Unmarshal([]byte(lines[0]), &adminEvent); err != nil || adminEvent.ActorType != store.AuditActorAdmin || adminEvent.TargetID != "second" {
+		t.Fatalf("expected the admin delete first, got %+v %v", adminEvent, err)
+	}
+	if uses, _ := database.ListAuditEvents(t.Context(), store.AuditFilter{Action: auditAPIKeyUse}); len(uses) != 1 || uses[0].ActorID != created.ID {
+		t.Fatalf("expected key use to be recorded once per minute, got %+v", uses)
+	}
+
+	page := httptest.NewRecorder()
+	handler.AdminPage(page, httptest.NewRequest(http.MethodGet, "/admin?actor=ops&action=endpoint.update", nil))
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "orders") ||
+		!strings.Contains(page.Body.String(), `href="/admin/audit.ndjson?action=endpoint.update&amp;actor=ops"`) {
+		t.Fatalf("expected the filtered audit log on the admin page, got %d", page.Code)
+	}
+}
+
+func TestInboundAuthRejectsAndRecordsSenders(t *testing.T) {
+	handler, database := testHandler(t)
+	endpoint, err := database.CreateEndpoint(t.Context(), "guarded", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := store.DefaultEndpointSettings()
+	settings.InboundAuth = normalizeInboundAuth(store.InboundAuth{
+		BearerToken: "s3cret", AllowedCIDRs: []string{"192.0.2.0/24", " 203.0.113.9 "}, StoreRejected: true,
+	})
+	if err := validateInboundAuth(settings.InboundAuth); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	capture := func(remoteAddr, authorization string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/h/guarded", strings.NewReader(`{}`))
+		request.RemoteAddr = remoteAddr
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if response := capture("198.51.100.1:1234", "Bearer s3cret"); response.Code != http.StatusForbidden {
+		t.Fatalf("expected unlisted source to be forbidden, got %d", response.Code)
+	}
+	response := capture("203.0.113.9:1234", "Bearer wrong")
+	if response.Code != http.StatusUnauthorized || response.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Fatalf("expected bearer challenge, got %d %q", response.Code, response.Header().Get("WWW-Authenticate"))
+	}
+	if response := capture("192.0.2.10:1234", "bearer s3cret"); response.Code != http.StatusOK {
+		t.Fatalf("expected authenticated capture, got %d", response.Code)
+	}
+
+	requests, err := database.GetRequests(t.Context(), endpoint.ID, 10)
+	if err != nil || len(requests) != 3 {
+		t.Fatalf("expected rejected attempts to be kept: len=%d err=%v", len(requests), err)
+	}
+	if requests[0].RejectedReason != "" || requests[1].RejectedReason != "invalid bearer token" ||
+		requests[2].RejectedReason != "source address not allowed" || requests[2].StatusCode != http.StatusForbidden {
+		t.Fatalf("unexpected rejection records: %+v %+v %+v", requests[0], requests[1], requests[2])
+	}
+	stored, err := database.GetEndpoint(t.Context(), endpoint.ID)
+	if err != nil || stored.RejectedCount != 2 {
+		t.Fatalf("expected two rejected attempts: %+v err=%v", stored, err)
+	}
+
+	certificate := &x509.Certificate{Raw: []byte("client certificate")}
+	sum := sha256.Sum256(certificate.Raw)
+	pinned := normalizeInboundAuth(store.InboundAuth{ClientCertSHA256: []string{strings.ToUpper(hex.EncodeToString(sum[:]))}})
+	request := httptest.NewRequest(http.MethodPost, "/h/guarded", nil)
+	if rejection := handler.checkInboundAuth(request, pinned); rejection == nil || rejection.reason != "client certificate required" {
+		t.Fatalf("expected missing certificate to be rejected: %+v", rejection)
+	}
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+	if rejection := handler.checkInboundAuth(request, pinned); rejection != nil {
+		t.Fatalf("expected pinned certificate to pass: %+v", rejection)
+	}
+
+	for _, invalid := range []store.InboundAuth{
+		{BasicUsername: "user", BasicPassword: "pass", BearerToken: "token"},
+		{BasicUsername: "user"},
+		{HeaderName: "X-Token"},
+		{AllowedCIDRs: []string{"not-a-network"}},
+		{ClientCertSHA256: []string{"abcd"}},
+	} {
+		if err := validateInboundAuth(normalizeInboundAuth(invalid)); err == nil {
+			t.Fatalf("expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestInboundAuthSecretsAreWriteOnly(t *testing.T) {
+	handler, database := testHandler(t)
+	if _, err := database.CreateEndpoint(t.Context(), "guarded", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ops", Scopes: []string{store.APIScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(router chi.Router) {
+		router.Use(handler.APIAuthMiddleware)
+		router.Get("/endpoints/{endpointID}", handler.APIGetEndpoint)
+		router.Put("/endpoints/{endpointID}", handler.APIUpdateEndpoint)
+		router.Get("/endpoints/{endpointID}/ndjson", handler.APIExportEndpointNDJSON)
+	})
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set("X-API-Key", created.Token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+	stored := func() store.InboundAuth {
+		endpoint, err := database.GetEndpoint(t.Context(), "guarded")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return endpoint.InboundAuth
+	}
+
+	response := call(http.MethodPut, "/api/v1/endpoints/guarded", `{"inbound_auth": {"header_name": "X-Token", "header_value": "h3ader", "bearer_token": "s3cret"}}`)
+	if response.Code != http.StatusOK || strings.Contains(response.Body.String(), "s3cret") || strings.Contains(response.Body.String(), "h3ader") ||
+		!strings.Contains(response.Body.String(), `"bearer_token_set":true`) {
+		t.Fatalf("expected the secrets to be saved but not returned, got %d %s", response.Code, response.Body.String())
+	}
+	var endpoint struct {
+		InboundAuth json.RawMessage `json:"inbound_auth"`
+	}
+	if err := json.Unmarshal(call(http.MethodGet, "/api/v1/endpoints/guarded", "").Body.Bytes(), &endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if response := call(http.MethodPut, "/api/v1/endpoints/guarded", `{"inbound_auth": `+string(endpoint.InboundAuth)+`}`); response.Code != http.StatusOK {
+		t.Fatalf("expected a read endpoint to be accepted back, got %d %s", response.Code, response.Body.String())
+	}
+	if auth := stored(); auth.BearerToken != store.InboundSecretDigest("s3cret") || auth.HeaderValue != store.InboundSecretDigest("h3ader") {
+		t.Fatalf("expected blank secrets to keep the stored digests: %+v", auth)
+	}
+	if exported := call(http.MethodGet, "/api/v1/endpoints/guarded/ndjson", ""); strings.Contains(exported.Body.String(), "s3cret") {
+		t.Fatalf("expected the export to leave out secrets: %s", exported.Body.String())
+	} else {
+		result, err := handler.ImportNDJSON(t.Context(), exported.Body, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		imported, err := database.GetEndpoint(t.Context(), result.Endpoints[0].ID)
+		if err != nil || imported.InboundAuth.BearerToken == "" || imported.InboundAuth.BearerToken == "s3cret" || imported.InboundAuth.HeaderValue == "" {
+			t.Fatalf("expected imported secrets to be replaced rather than dropped: %+v %v", imported, err)
+		}
+		if replaced := strings.Join(result.Endpoints[0].ReplacedSecrets, ","); replaced != "bearer_token,header_value" {
+			t.Fatalf("expected the import to name the replaced secrets, got %q", replaced)
+		}
+	}
+
+	if response := call(http.MethodPut, "/api/v1/endpoints/guarded", `{"inbound_auth": {"bearer_token_set": false}}`); response.Code != http.StatusOK {
+		t.Fatalf("expected the secrets to be cleared, got %d %s", response.Code, response.Body.String())
+	}
+	if auth := stored(); auth.Enabled() {
+		t.Fatalf("expected sender authentication to be off: %+v", auth)
+	}
+}
+
+func TestCaptureRateLimitsDropAndCountFloods(t *testing.T) {
+	handler, database := testHandler(t)
+	throttled, err := database.CreateEndpoint(t.Context(), "throttled", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := store.DefaultEndpointSettings()
+	settings.DefaultContentType = "application/json"
+	settings.Throttle = normalizeThrottle(store.Throttle{RequestsPerSecond: 1, Burst: 2, Body: `{"error":"slow down"}`})
+	if err := validateEndpointSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdateEndpointSettings(t.Context(), throttled.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	open, err := database.CreateEndpoint(t.Context(), "open", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := DefaultRuntimeConfig()
+	config.CaptureIPRateLimit = RateLimit{RequestsPerMinute: 60, Burst: 1}
+	handler.ApplyRuntimeConfig(config)
+
+	router := chi.NewRouter()
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	capture := func(endpointID, remoteAddr string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/h/"+endpointID, strings.NewReader(`{}`))
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	for i, sender := range []string{"192.0.2.1:1000", "192.0.2.2:1000"} {
+		if response := capture(throttled.ID, sender); response.Code != http.StatusOK {
+			t.Fatalf("capture %d within the burst was refused: %d", i, response.Code)
+		}
+	}
+	response := capture(throttled.ID, "192.0.2.3:1000")
+	if response.Code != http.StatusTooManyRequests || response.Body.String() != settings.Throttle.Body ||
+		response.Header().Get("Content-Type") != "application/json" || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected configured 429, got %d %q headers=%v", response.Code, response.Body.String(), response.Header())
+	}
+	if requests, err := database.GetRequests(t.Context(), throttled.ID, 10); err != nil || len(requests) != 2 {
+		t.Fatalf("throttled capture must not be stored: len=%d err=%v", len(requests), err)
+	}
+
+	if response := capture(open.ID, "198.51.100.7:1000"); response.Code != http.StatusOK {
+		t.Fatalf("first capture from a sender was refused: %d", response.Code)
+	}
+	if response := capture(open.ID, "198.51.100.7:1001"); response.Code != http.StatusTooManyRequests || response.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected sender limit to apply, got %d", response.Code)
+	}
+
+	if endpoint, err := database.GetEndpoint(t.Context(), throttled.ID); err != nil || endpoint.ThrottledCount != 0 {
+		t.Fatalf("expected drops to be counted in memory until flushed: %+v err=%v", endpoint, err)
+	}
+	capture("unknown", "198.51.100.7:1002")
+	if err := handler.FlushThrottledCounts(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	for id, expected := range map[string]int64{throttled.ID: 1, open.ID: 1} {
+		endpoint, err := database.GetEndpoint(t.Context(), id)
+		if err != nil || endpoint.ThrottledCount != expected {
+			t.Fatalf("unexpected dropped count for %s: %+v err=%v", id, endpoint, err)
+		}
+	}
+	if stats := handler.RateLimitStats(); stats.EndpointRejected != 1 || stats.CaptureIPRejected != 2 {
+		t.Fatalf("unexpected rate limit stats: %+v", stats)
+	}
+	if err := validateThrottle(store.Throttle{RequestsPerSecond: -1}); err == nil {
+		t.Fatal("expected negative rate to be rejected")
+	}
+}
+
+func TestChaosInjectsFaultsAndRecordsThem(t *testing.T) {
+	handler, database := testHandler(t)
+	endpoint, err := database.CreateEndpoint(t.Context(), "flaky", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configure := func(chaos store.Chaos) {
+		t.Helper()
+		settings := store.DefaultEndpointSettings()
+		settings.Chaos = normalizeChaos(chaos)
+		if err := validateEndpointSettings(settings); err != nil {
+			t.Fatal(err)
+		}
+		if err := database.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := chi.NewRouter()
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	send := func(key string) (*http.Response, error) {
+		request, _ := http.NewRequest(http.MethodPost, server.URL+"/h/flaky", strings.NewReader(`{}`))
+		if key != "" {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadAll(response.Body)
+		response.Body.Close()
+		return response, err
+	}
+	latest := func() *store.Request {
+		t.Helper()
+		requests, err := database.GetRequests(t.Context(), endpoint.ID, 1)
+		if err != nil || len(requests) != 1 {
+			t.Fatalf("expected a captured request: %v", err)
+		}
+		return requests[0]
+	}
+
+	configure(store.Chaos{FailFirst: 2, ErrorStatuses: []int{503}, LatencyMinMS: 5, LatencyMaxMS: 5})
+	for attempt, expected := range []int{503, 503, 200} {
+		response, err := send("delivery-1")
+		if err != nil || response.StatusCode != expected {
+			t.Fatalf("attempt %d: expected %d, got %v %v", attempt+1, expected, response, err)
+		}
+		captured := latest()
+		if captured.StatusCode != expected || captured.Chaos == nil || captured.Chaos.Attempt != attempt+1 || captured.Chaos.LatencyMS != 5 {
+			t.Fatalf("attempt %d was not recorded: %+v %+v", attempt+1, captured, captured.Chaos)
+		}
+	}
+	if response, err := send("delivery-2"); err != nil || response.StatusCode != 503 {
+		t.Fatalf("a new key must start failing again: %v %v", response, err)
+	}
+
+	configure(store.Chaos{ErrorPercent: 100, ErrorStatuses: []int{429}})
+	if response, err := send(""); err != nil || response.StatusCode != 429 || latest().Chaos.Status != 429 {
+		t.Fatalf("expected injected 429: %v %v", response, err)
+	}
+
+	configure(store.Chaos{ResetPercent: 100})
+	if _, err := send(""); err == nil {
+		t.Fatal("expected the connection to be reset mid-response")
+	}
+	if captured := latest(); captured.Chaos == nil || !captured.Chaos.Reset {
+		t.Fatalf("reset was not recorded: %+v", captured)
+	}
+
+	configure(store.Chaos{})
+	if response, err := send("delivery-1"); err != nil || response.StatusCode != 200 || latest().Chaos != nil {
+		t.Fatalf("expected a clean response without chaos: %v %v", response, err)
+	}
+
+	for _, invalid := range []store.Chaos{{ErrorPercent: 101}, {ErrorStatuses: []int{200}}, {DripPercent: 10}, {LatencyMaxMS: 60000}} {
+		if err := validateChaos(normalizeChaos(invalid)); err == nil {
+			t.Fatalf("expected %+v to be rejected", invalid)
+		}
+	}
+}
+
+func TestInjectedDelaysStopShortOfTheWriteTimeout(t *testing.T) {
+	handler, database := testHandler(t)
+	handler.WriteTimeout = writeTimeoutMargin + 200*time.Millisecond
+	endpoint, err := database.CreateEndpoint(t.Context(), "slow", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := store.DefaultEndpointSettings()
+	settings.ResponseDelayMS = store.MaxResponseDelayMS
+	settings.Chaos = store.Chaos{LatencyMinMS: maxChaosLatencyMS, LatencyMaxMS: maxChaosLatencyMS, DripPercent: 100, DripIntervalMS: maxChaosDripIntervalMS}
+	if err := validateEndpointSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+
+	started := time.Now()
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/h/slow", nil))
+	if elapsed := time.Since(started); elapsed > handler.WriteTimeout {
+		t.Fatalf("expected the delays to end before the write timeout, took %s", elapsed)
+	}
+	if response.Code != http.StatusOK || response.Body.String() != store.DefaultResponseBody {
+		t.Fatalf("expected the whole response, got %d %q", response.Code, response.Body.String())
+	}
+}
+
+func TestResponseSequencesScriptCaptures(t *testing.T) {
+	handler, database := testHandler(t)
+	endpoint, err := database.CreateEndpoint(t.Context(), "scripted", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeRead, store.APIScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(router chi.Router) {
+		router.Use(handler.APIAuthMiddleware)
+		router.Get("/endpoints/{endpointID}/sequences", handler.APIListSequences)
+		router.Put("/endpoints/{endpointID}/sequences", handler.APISetSequence)
+		router.Post("/endpoints/{endpointID}/sequences/reset", handler.APIResetSequence)
+	})
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	router.HandleFunc("/h/{endpointID}/*", handler.CaptureWebhook)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if strings.HasPrefix(path, "/api/") {
+			request.Header.Set("X-API-Key", created.Token)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	if response := call(http.MethodPut, "/api/v1/endpoints/scripted/sequences", `{"mode":"hold","steps":[{"status":42}]}`); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid status to be rejected, got %d", response.Code)
+	}
+	steps, err := parseSequenceSteps("500\n429 slow down\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps = append(steps, store.SequenceStep{Status: 200, Body: `{"ok":true}`, ContentType: "application/json"})
+	body, _ := json.Marshal(sequenceInput{Mode: store.SequenceLoop, Path: "orders", Steps: steps})
+	if response := call(http.MethodPut, "/api/v1/endpoints/scripted/sequences", string(body)); response.Code != http.StatusOK {
+		t.Fatalf("expected the sequence to be saved, got %d: %s", response.Code, response.Body.String())
+	}
+
+	for i, expected := range []int{500, 429, 200, 500} {
+		response := call(http.MethodPost, "/h/scripted/orders", "{}")
+		if response.Code != expected {
+			t.Fatalf("capture %d: expected %d, got %d", i+1, expected, response.Code)
+		}
+		if expected == 200 && (response.Header().Get("Content-Type") != "application/json" || response.Body.String() != `{"ok":true}`) {
+			t.Fatalf("unexpected scripted response: %v %q", response.Header(), response.Body.String())
+		}
+	}
+	if response := call(http.MethodPost, "/h/scripted/other", "{}"); response.Code != http.StatusOK || response.Body.String() != store.DefaultResponseBody {
+		t.Fatalf("other paths must get the default response, got %d %q", response.Code, response.Body.String())
+	}
+	requests, err := database.GetRequests(t.Context(), endpoint.ID, 10)
+	if err != nil || len(requests) != 5 {
+		t.Fatalf("expected five captures: %d %v", len(requests), err)
+	}
+	if requests[0].SequenceStep != 0 || requests[1].SequenceStep != 1 || requests[1].StatusCode != 500 || requests[3].StatusCode != 429 {
+		t.Fatalf("served responses were not recorded: %+v %+v %+v", requests[0], requests[1], requests[3])
+	}
+
+	if response := call(http.MethodPost, "/api/v1/endpoints/scripted/sequences/reset?path=/orders", ""); response.Code != http.StatusNoContent {
+		t.Fatalf("expected reset, got %d", response.Code)
+	}
+	if response := call(http.MethodPost, "/h/scripted/orders", "{}"); response.Code != 500 {
+		t.Fatalf("expected the reset sequence to start over, got %d", response.Code)
+	}
+	if response := call(http.MethodPost, "/api/v1/endpoints/scripted/sequences/reset?path=/missing", ""); response.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown sequence to be missing, got %d", response.Code)
+	}
+	response := call(http.MethodGet, "/api/v1/endpoints/scripted/sequences", "")
+	var sequences []store.ResponseSequence
+	if err := json.Unmarshal(response.Body.Bytes(), &sequences); err != nil || len(sequences) != 1 || sequences[0].Path != "/orders" || sequences[0].Position != 1 {
+		t.Fatalf("unexpected sequences: %s %v", response.Body.String(), err)
+	}
+
+	// Responses replaced by an assertion rejection or a chaos error do not
+	// use up a step.
+	if err := database.SetAssertion(t.Context(), &store.Assertion{EndpointID: endpoint.ID, Path: "/orders", Methods: []string{http.MethodPost}, RejectStatus: 422}); err != nil {
+		t.Fatal(err)
+	}
+	if response := call(http.MethodPut, "/h/scripted/orders", "{}"); response.Code != 422 {
+		t.Fatalf("expected the assertion to reject the capture, got %d", response.Code)
+	}
+	settings := endpoint.Settings()
+	settings.Chaos = store.Chaos{ErrorPercent: 100, ErrorStatuses: []int{503}}
+	if err := database.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	if response := call(http.MethodPost, "/h/scripted/orders", "{}"); response.Code != 503 {
+		t.Fatalf("expected a chaos error, got %d", response.Code)
+	}
+	settings.Chaos = store.Chaos{}
+	if err := database.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	if response := call(http.MethodPost, "/h/scripted/orders", "{}"); response.Code != 429 {
+		t.Fatalf("expected the sequence to resume at its second step, got %d", response.Code)
+	}
+	requests, err = database.GetRequests(t.Context(), endpoint.ID, 3)
+	if err != nil || requests[1].SequenceStep != 0 || requests[2].SequenceStep != 0 || requests[0].SequenceStep != 2 {
+		t.Fatalf("overridden responses must not record a step: %+v %v", requests, err)
+	}
+}
+
+func TestAssertionsValidateAndRejectCaptures(t *testing.T) {
+	handler, database := testHandler(t)
+	endpoint, err := database.CreateEndpoint(t.Context(), "checked", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeRead, store.APIScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(router chi.Router) {
+		router.Use(handler.APIAuthMiddleware)
+		router.Get("/endpoints/{endpointID}/assertions", handler.APIListAssertions)
+		router.Put("/endpoints/{endpointID}/assertions", handler.APISetAssertion)
+		router.Delete("/endpoints/{endpointID}/assertions", handler.APIDeleteAssertion)
+	})
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	router.HandleFunc("/h/{endpointID}/*", handler.CaptureWebhook)
+	call := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		for name, values := range header {
+			request.Header[name] = values
+		}
+		if strings.HasPrefix(path, "/api/") {
+			request.Header.Set("X-API-Key", created.Token)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	for _, invalid := range []string{
+		`{"methods": ["POST"], "reject_status": 200}`,
+		`{"schema": {"type": "text"}}`,
+		`{"headers": {"bad header": ""}}`,
+		`{"path": "/orders"}`,
+		`{"schema": {"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}}`,
+	} {
+		if response := call(http.MethodPut, "/api/v1/endpoints/checked/assertions", invalid, nil); response.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected a bad request, got %d", invalid, response.Code)
+		}
+	}
+	orders := `{"path": "orders", "methods": ["post"], "headers": {"content-type": "application/json"},
+		"schema": {"type": "object", "required": ["id"], "properties": {"id": {"type": "integer"}}}, "reject_status": 422}`
+	if response := call(http.MethodPut, "/api/v1/endpoints/checked/assertions", orders, nil); response.Code != http.StatusOK {
+		t.Fatalf("expected the assertion to be saved, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := call(http.MethodPut, "/api/v1/endpoints/checked/assertions", `{"headers": {"X-Signature": ""}}`, nil); response.Code != http.StatusOK {
+		t.Fatalf("expected the endpoint-wide assertion to be saved, got %d: %s", response.Code, response.Body.String())
+	}
+
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	if response := call(http.MethodPost, "/h/checked/orders", `{"id": 7}`, jsonHeader); response.Code != http.StatusOK {
+		t.Fatalf("expected a valid capture to get the endpoint's response, got %d", response.Code)
+	}
+	response := call(http.MethodPost, "/h/checked/orders", `{"id": "seven"}`, jsonHeader)
+	if response.Code != http.StatusUnprocessableEntity || !strings.Contains(response.Body.String(), `"/id: must be integer, got string"`) {
+		t.Fatalf("expected the invalid capture to be rejected, got %d %s", response.Code, response.Body.String())
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write([]byte(`{"id": 8}`))
+	_ = writer.Close()
+	gzipHeader := http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}}
+	if response := call(http.MethodPost, "/h/checked/orders", compressed.String(), gzipHeader); response.Code != http.StatusOK {
+		t.Fatalf("expected a gzip body to be decoded before validation, got %d %s", response.Code, response.Body.String())
+	}
+	if response := call(http.MethodGet, "/h/checked/other", "", nil); response.Code != http.StatusOK {
+		t.Fatalf("an assertion without a reject status must only record, got %d", response.Code)
+	}
+
+	requests, err := database.GetRequests(t.Context(), endpoint.ID, 10)
+	if err != nil || len(requests) != 4 {
+		t.Fatalf("expected four captures: %d %v", len(requests), err)
+	}
+	if validation := requests[0].Validation; validation == nil || validation.Passed || validation.Path != "" || validation.Violations[0] != "header X-Signature: missing" {
+		t.Fatalf("unexpected endpoint-wide validation: %+v", validation)
+	}
+	if !requests[1].Validation.Passed || !requests[3].Validation.Passed || requests[1].Validation.Path != "/orders" {
+		t.Fatalf("expected valid captures to pass: %+v %+v", requests[1].Validation, requests[3].Validation)
+	}
+	if validation := requests[2].Validation; validation.Passed || requests[2].StatusCode != http.StatusUnprocessableEntity || len(validation.Violations) != 1 {
+		t.Fatalf("expected the rejection to be recorded: %d %+v", requests[2].StatusCode, validation)
+	}
+	failed, err := database.SearchRequests(t.Context(), endpoint.ID, store.SearchValidationFailed, 10, 0)
+	if err != nil || len(failed) != 2 {
+		t.Fatalf("expected two failed captures, got %d %v", len(failed), err)
+	}
+
+	if response := call(http.MethodDelete, "/api/v1/endpoints/checked/assertions?path=/orders", "", nil); response.Code != http.StatusNoContent {
+		t.Fatalf("expected the assertion to be deleted, got %d", response.Code)
+	}
+	response = call(http.MethodGet, "/api/v1/endpoints/checked/assertions", "", nil)
+	var assertions []store.Assertion
+	if err := json.Unmarshal(response.Body.Bytes(), &assertions); err != nil || len(assertions) != 1 || assertions[0].Path != "" {
+		t.Fatalf("unexpected assertions: %s %v", response.Body.String(), err)
+	}
+}
+
+func TestDiffRequestsComparesDecodedBodies(t *testing.T) {
+	handler, database := testHandler(t)
+	for _, id := range []string{"compared", "elsewhere"} {
+		if _, err := database.CreateEndpoint(t.Context(), id, "", testBrowserID, store.DefaultTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write([]byte(`{"type": "order.paid", "order": {"id": 7, "total": 10.0, "items": ["a"]}, "currency": "EUR"}`))
+	_ = writer.Close()
+	save := func(endpointID, method, query, headers string, body []byte) *store.Request {
+		request := &store.Request{EndpointID: endpointID, Method: method, Path: "/h/" + endpointID, QueryString: query, Headers: headers, Body: body}
+		if err := database.SaveRequest(t.Context(), request); err != nil {
+			t.Fatal(err)
+		}
+		return request
+	}
+	before := save("compared", "POST", "v=1", `{"Content-Type":["application/json"],"X-Old":["1"]}`,
+		[]byte(`{"currency": "EUR", "order": {"items": ["a", "b"], "id": 7, "total": 10}, "type": "order.paid"}`))
+	after := save("compared", "POST", "v=2&debug=1", `{"Content-Type":["application/json"],"Content-Encoding":["gzip"]}`, compressed.Bytes())
+	text := save("compared", "PUT", "", `{"Content-Type":["text/plain"]}`, []byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\n"))
+	edited := save("compared", "PUT", "", `{"Content-Type":["text/plain"]}`, []byte("one\ntwo\nthree\nfour\nfive\nsix\nseven\nEIGHT\nnine\n"))
+	binary := save("compared", "PUT", "", `{"Content-Type":["application/octet-stream"]}`, []byte{0, 1, 2, 3})
+	other := save("elsewhere", "POST", "", `{}`, nil)
+
+	diff := diffRequests(before, after)
+	if len(diff.Fields) != 1 || diff.Fields[0].Name != "query" {
+		t.Fatalf("unexpected field changes: %+v", diff.Fields)
+	}
+	if len(diff.Query) != 2 || diff.Query[0].Name != "debug" || diff.Query[0].Change != diffAdded || diff.Query[1].Name != "v" || diff.Query[1].Change != diffChanged {
+		t.Fatalf("unexpected query changes: %+v", diff.Query)
+	}
+	if len(diff.Headers) != 2 || diff.Headers[0].Name != "Content-Encoding" || diff.Headers[0].Change != diffAdded || diff.Headers[1].Change != diffRemoved {
+		t.Fatalf("unexpected header changes: %+v", diff.Headers)
+	}
+	expected := []jsonChange{{Path: "/order/items/1", Change: diffRemoved, A: `"b"`}}
+	if diff.Body.Mode != "json" || diff.Body.Equal || !slices.Equal(diff.Body.Changes, expected) || len(diff.B.Notices) == 0 {
+		t.Fatalf("expected only the removed item after decoding, got %+v notices %v", diff.Body, diff.B.Notices)
+	}
+
+	diff = diffRequests(text, edited)
+	var ops []string
+	for _, line := range diff.Body.Lines {
+		ops = append(ops, line.Op+":"+line.Text+strconv.Itoa(line.Skipped))
+	}
+	if diff.Body.Mode != "text" || strings.Join(ops, " ") != "skipped:4 same:five0 same:six0 same:seven0 removed:eight0 added:EIGHT0 same:nine0" {
+		t.Fatalf("unexpected line diff: %v", ops)
+	}
+	diff = diffRequests(text, binary)
+	if diff.Body.Mode != "binary" || diff.Body.Binary.FirstDifference != 0 || diff.Body.Equal {
+		t.Fatalf("unexpected binary diff: %+v", diff.Body)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/{endpointID}/diff", handler.DiffRequests)
+	router.With(handler.APIAuthMiddleware).Get("/api/v1/endpoints/{endpointID}/diff", handler.APIDiffRequests)
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("X-API-Key", created.Token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+	response := call(fmt.Sprintf("/api/v1/endpoints/compared/diff?a=%d&b=%d", before.ID, after.ID))
+	var decoded requestDiff
+	if err := json.Unmarshal(response.Body.Bytes(), &decoded); err != nil || response.Code != http.StatusOK || decoded.B.ID != after.ID || len(decoded.Body.Changes) != 1 {
+		t.Fatalf("unexpected API diff: %d %s %v", response.Code, response.Body.String(), err)
+	}
+	if response := call(fmt.Sprintf("/api/v1/endpoints/compared/diff?a=%d&b=%d", before.ID, other.ID)); response.Code != http.StatusNotFound {
+		t.Fatalf("expected another endpoint's request to be out of reach, got %d", response.Code)
+	}
+	if response := call("/api/v1/endpoints/compared/diff?a=x&b=1"); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request ID to be rejected, got %d", response.Code)
+	}
+	request := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/compared/diff?a=%d&b=%d", text.ID, edited.ID), nil)
+	request.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: testBrowserID})
+	page := httptest.NewRecorder()
+	router.ServeHTTP(page, request)
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "+ EIGHT") || !strings.Contains(page.Body.String(), "4 unchanged lines") {
+		t.Fatalf("unexpected compare page: %d %s", page.Code, page.Body.String())
+	}
+}
+
+func TestEndpointShapesGroupsEvents(t *testing.T) {
+	handler, database := testHandler(t)
+	if _, err := database.CreateEndpoint(t.Context(), "shaped", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	for _, capture := range []struct{ headers, body string }{
+		{`{"X-GitHub-Event":["push"]}`, `{"ref": "main", "commits": [{"id": "a1"}]}`},
+		{`{"X-GitHub-Event":["push"]}`, `{"ref": "dev", "commits": [], "forced": true}`},
+		{`{}`, `{"type": "order.paid", "order": {"id": 7}}`},
+		{`{"Content-Type":["text/plain"]}`, `not json`},
+	} {
+		request := &store.Request{EndpointID: "shaped", Method: "POST", Path: "/h/shaped", Headers: capture.headers, Body: []byte(capture.body)}
+		if err := database.SaveRequest(t.Context(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Get("/endpoint/{endpointID}/shapes", handler.EndpointShapes)
+	router.Get("/endpoint/{endpointID}/shapes/types.go", handler.DownloadShapeGo)
+	router.With(handler.APIAuthMiddleware).Get("/api/v1/endpoints/{endpointID}/shapes", handler.APIEndpointShapes)
+	router.With(handler.APIAuthMiddleware).Get("/api/v1/endpoints/{endpointID}/shapes/schema", handler.APIShapeSchema)
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("X-API-Key", created.Token)
+		request.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: testBrowserID})
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	response := call("/api/v1/endpoints/shaped/shapes")
+	var report struct {
+		Samples int `json:"samples"`
+		Skipped int `json:"skipped"`
+		Shapes  []struct {
+			Event   string `json:"event"`
+			Samples int    `json:"samples"`
+			Fields  []struct {
+				Path     string `json:"path"`
+				Optional bool   `json:"optional"`
+			} `json:"fields"`
+		} `json:"shapes"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &report); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("unexpected shapes response: %d %s %v", response.Code, response.Body.String(), err)
+	}
+	if report.Samples != 3 || report.Skipped != 1 || len(report.Shapes) != 2 || report.Shapes[0].Event != "push" || report.Shapes[0].Samples != 2 || report.Shapes[1].Event != "order.paid" {
+		t.Fatalf("expected push and order.paid shapes, got %+v", report)
+	}
+	optional := map[string]bool{}
+	for _, field := range report.Shapes[0].Fields {
+		optional[field.Path] = field.Optional
+	}
+	if !optional["forced"] || optional["ref"] || optional["commits[].id"] {
+		t.Fatalf("unexpected push fields: %+v", report.Shapes[0].Fields)
+	}
+
+	if response := call("/api/v1/endpoints/shaped/shapes?group=none"); !strings.Contains(response.Body.String(), `"samples":3`) {
+		t.Fatalf("expected a single shape without grouping, got %s", response.Body.String())
+	}
+	if response := call("/api/v1/endpoints/shaped/shapes?group=bogus"); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad grouping to be rejected, got %d", response.Code)
+	}
+	response = call("/api/v1/endpoints/shaped/shapes/schema?event=order.paid")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"title": "order.paid"`) {
+		t.Fatalf("unexpected schema: %d %s", response.Code, response.Body.String())
+	}
+	if response := call("/api/v1/endpoints/shaped/shapes/schema?event=missing"); response.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown event to be missing, got %d", response.Code)
+	}
+	response = call("/endpoint/shaped/shapes/types.go?event=push")
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "type Push struct") ||
+		response.Header().Get("Content-Disposition") != `attachment; filename="pipehook-shaped-Push.go"` {
+		t.Fatalf("unexpected Go download: %d %v %s", response.Code, response.Header(), response.Body.String())
+	}
+	if response := call("/endpoint/shaped/shapes"); response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "commits[].id") {
+		t.Fatalf("unexpected shapes pane: %d %s", response.Code, response.Body.String())
+	}
+}
+
+func TestRequestSnippetsCopyReplayHeaders(t *testing.T) {
+	handler, database := testHandler(t)
+	if _, err := database.CreateEndpoint(t.Context(), "snippets", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	text := &store.Request{
+		EndpointID: "snippets", Method: "POST", Path: "/h/snippets/orders", QueryString: "v=1",
+		Headers: `{"Content-Type":["application/json"],"Host":["old.example"],"Content-Length":["28"],"X-Tag":["a","b"]}`,
+		Body:    []byte(`{"note": "it's <b>bold</b>"}`),
+	}
+	binary := &store.Request{EndpointID: "snippets", Method: "PUT", Path: "/h/snippets", Headers: `{"Content-Type":["application/octet-stream"]}`, Body: []byte{0, 1, 2, 0xff}}
+	for _, request := range []*store.Request{text, binary} {
+		if err := database.SaveRequest(t.Context(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := chi.NewRouter()
+	router.Get("/r/{requestID}/snippet", handler.RequestSnippet)
+	router.With(handler.APIAuthMiddleware).Get("/api/v1/requests/{requestID}/snippet", handler.APIRequestSnippet)
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, "http://hooks.example"+path, nil)
+		request.Header.Set("X-API-Key", created.Token)
+		request.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: testBrowserID})
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+	snippet := func(request *store.Request, lang string) string {
+		t.Helper()
+		response := call(fmt.Sprintf("/api/v1/requests/%d/snippet?lang=%s", request.ID, lang))
+		if response.Code != http.StatusOK || response.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+			t.Fatalf("unexpected %s snippet: %d %s", lang, response.Code, response.Body.String())
+		}
+		return response.Body.String()
+	}
+
+	expected := "curl -X 'POST' 'http://hooks.example/h/snippets/orders?v=1' \\\n" +
+		"  -H 'Content-Type: application/json' \\\n" +
+		"  -H 'X-Tag: a' \\\n" +
+		"  -H 'X-Tag: b' \\\n" +
+		"  --data-raw '{\"note\": \"it'\\''s <b>bold</b>\"}'\n"
+	if code := snippet(text, "curl"); code != expected {
+		t.Fatalf("unexpected curl snippet:\n%s", code)
+	}
+	if code := snippet(text, "httpie"); !strings.HasPrefix(code, "http --raw '{") || !strings.Contains(code, "'X-Tag:b'") {
+		t.Fatalf("unexpected HTTPie snippet:\n%s", code)
+	}
+	if code := snippet(text, "python"); !strings.Contains(code, `"X-Tag": "a, b",`) || !strings.Contains(code, `data="{\"note\": \"it's <b>bold</b>\"}".encode(),`) {
+		t.Fatalf("unexpected Python snippet:\n%s", code)
+	}
+	if code := snippet(text, "javascript"); !strings.Contains(code, `fetch("http://hooks.example/h/snippets/orders?v=1", {`) || strings.Contains(code, "Host") {
+		t.Fatalf("unexpected JavaScript snippet:\n%s", code)
+	}
+	if code := snippet(text, "go"); !strings.Contains(code, `request.Header.Add("X-Tag", "b")`) || !strings.Contains(code, "strings.NewReader(") {
+		t.Fatalf("unexpected Go snippet:\n%s", code)
+	}
+
+	if code := snippet(binary, "curl"); !strings.HasPrefix(code, "base64 --decode > body.bin <<'EOF'\nAAEC/w==\nEOF\n") || !strings.HasSuffix(code, "--data-binary @body.bin\n") {
+		t.Fatalf("unexpected binary curl snippet:\n%s", code)
+	}
+	if code := snippet(binary, "go"); !strings.Contains(code, `base64.StdEncoding.DecodeString("AAEC/w==")`) {
+		t.Fatalf("unexpected binary Go snippet:\n%s", code)
+	}
+	if code := snippet(binary, "javascript"); !strings.Contains(code, `body: Uint8Array.from(atob("AAEC/w=="), (c) => c.charCodeAt(0)),`) {
+		t.Fatalf("unexpected binary JavaScript snippet:\n%s", code)
+	}
+
+	if response := call(fmt.Sprintf("/api/v1/requests/%d/snippet?lang=cobol", text.ID)); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown language to be rejected, got %d", response.Code)
+	}
+	response := call(fmt.Sprintf("/r/%d/snippet?lang=curl", text.ID))
+	if response.Code != http.StatusOK || !strings.Contains(response.Body.String(), "&lt;b&gt;bold&lt;/b&gt;") {
+		t.Fatalf("expected an escaped snippet pane, got %d %s", response.Code, response.Body.String())
+	}
+}
+
+func TestRequestBodyDownloadIsSafe(t *testing.T) {
+	handler, database := testHandler(t)
+	if _, err := database.CreateEndpoint(t.Context(), "bodies", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, _ = writer.Write([]byte(`{"ok": true}`))
+	_ = writer.Close()
+	save := func(headers string, body []byte) *store.Request {
+		request := &store.Request{EndpointID: "bodies", Method: "POST", Path: "/h/bodies", Headers: headers, Body: body}
+		if err := database.SaveRequest(t.Context(), request); err != nil {
+			t.Fatal(err)
+		}
+		return request
+	}
+	gzipped := save(`{"Content-Type":["application/json"],"Content-Encoding":["gzip"]}`, compressed.Bytes())
+	page := save(`{"Content-Type":["text/html; charset=utf-8"]}`, []byte("<script>alert(1)</script>"))
+	image := save(`{"Content-Type":["image/png"]}`, []byte("\x89PNG\r\n\x1a\n"))
+	svg := save(`{"Content-Type":["image/svg+xml"]}`, []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`))
+	bogus := save(`{"Content-Type":["text/html\"; x"]}`, []byte("<b>hi</b>"))
+
+	router := chi.NewRouter()
+	router.Get("/r/{requestID}/body", handler.RequestBody)
+	router.With(handler.APIAuthMiddleware).Get("/api/v1/requests/{requestID}/body", handler.APIRequestBody)
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func(path string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("X-API-Key", created.Token)
+		request.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: testBrowserID})
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	response := call(fmt.Sprintf("/api/v1/requests/%d/body", gzipped.ID))
+	if response.Code != http.StatusOK || !bytes.Equal(response.Body.Bytes(), compressed.Bytes()) || response.Header().Get("Content-Encoding") != "" ||
+		response.Header().Get("Content-Disposition") != fmt.Sprintf(`attachment; filename="pipehook-request-%d.json.gz"`, gzipped.ID) {
+		t.Fatalf("expected the raw gzip body as an attachment, got %d %v", response.Code, response.Header())
+	}
+	response = call(fmt.Sprintf("/r/%d/body?decode=true", gzipped.ID))
+	if response.Code != http.StatusOK || response.Body.String() != `{"ok": true}` || response.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("expected the decoded body, got %d %v %q", response.Code, response.Header(), response.Body.String())
+	}
+	response = call(fmt.Sprintf("/r/%d/body?inline=true", page.ID))
+	if response.Header().Get("Content-Disposition") != fmt.Sprintf(`attachment; filename="pipehook-request-%d.html"`, page.ID) ||
+		response.Header().Get("X-Content-Type-Options") != "nosniff" || !strings.Contains(response.Header().Get("Content-Security-Policy"), "sandbox") {
+		t.Fatalf("expected an HTML body to be a sandboxed attachment, got %v", response.Header())
+	}
+	if response := call(fmt.Sprintf("/r/%d/body?inline=true", image.ID)); !strings.HasPrefix(response.Header().Get("Content-Disposition"), "inline;") {
+		t.Fatalf("expected a PNG body to be shown inline, got %v", response.Header())
+	}
+	if response := call(fmt.Sprintf("/r/%d/body?inline=true", svg.ID)); !strings.HasPrefix(response.Header().Get("Content-Disposition"), "attachment;") {
+		t.Fatalf("expected an SVG body to stay an attachment, got %v", response.Header())
+	}
+	if response := call(fmt.Sprintf("/r/%d/body", bogus.ID)); response.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("expected a malformed Content-Type to be replaced, got %v", response.Header())
+	}
+	if response := call("/api/v1/requests/999999/body"); response.Code != http.StatusNotFound {
+		t.Fatalf("expected a missing request to be 404, got %d", response.Code)
+	}
+}
+
+func TestBodyViewers(t *testing.T) {
+	viewer := buildBodyViewer([]byte(`{"zeta": 1.50, "alpha": [true, null, "x"], "empty": {}}`), "application/json")
+	if viewer == nil || viewer.Kind != "json" {
+		t.Fatalf("expected a JSON viewer, got %+v", viewer)
+	}
+	var keys []string
+	for _, child := range viewer.JSON.Children {
+		keys = append(keys, child.Key+"="+child.Kind)
+	}
+	if strings.Join(keys, " ") != "zeta=number alpha=array empty=object" || viewer.JSON.Children[0].Value != "1.50" || viewer.JSON.Children[1].Children[2].Value != `"x"` {
+		t.Fatalf("expected keys in body order, got %v", keys)
+	}
+	if viewer := buildBodyViewer([]byte(`{"a": 1} trailing`), "application/json"); viewer != nil {
+		t.Fatalf("expected invalid JSON to have no viewer, got %+v", viewer)
+	}
+
+	viewer = buildBodyViewer([]byte(`<?xml version="1.0"?><soap:Envelope xmlns:soap="urn:s"><soap:Body><id>7</id><!-- note --><empty/></soap:Body></soap:Envelope>`), "text/xml; charset=utf-8")
+	expected := "<?xml version=\"1.0\"?>\n<soap:Envelope xmlns:soap=\"urn:s\">\n  <soap:Body>\n    <id>7</id>\n    <!-- note -->\n    <empty></empty>\n  </soap:Body>\n</soap:Envelope>"
+	if viewer == nil || viewer.XML != expected {
+		t.Fatalf("unexpected XML viewer: %+v", viewer)
+	}
+	if viewer := buildBodyViewer([]byte(`<a><b></a></b>`), "application/xml"); viewer != nil {
+		t.Fatalf("expected mismatched XML to have no viewer, got %+v", viewer)
+	}
+
+	viewer = buildBodyViewer([]byte("b=2&a=hello+world&a=%F0%9F%91%8B"), "application/x-www-form-urlencoded")
+	if viewer == nil || len(viewer.Fields) != 3 || viewer.Fields[0].Name != "b" || viewer.Fields[1].Value != "hello world" || viewer.Fields[2].Value != "👋" {
+		t.Fatalf("unexpected form viewer: %+v", viewer)
+	}
+
+	body := "--XyZ\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\nHello\r\n" +
+		"--XyZ\r\nContent-Disposition: form-data; name=\"file\"; filename=\"a.bin\"\r\nContent-Type: application/octet-stream\r\n\r\n\x00\x01\x02\r\n--XyZ--\r\n"
+	viewer = buildBodyViewer([]byte(body), `multipart/form-data; boundary=XyZ`)
+	if viewer == nil || len(viewer.Fields) != 2 || viewer.Fields[0].Value != "Hello" || !viewer.Fields[1].Binary || viewer.Fields[1].Filename != "a.bin" || viewer.Fields[1].Size != 3 {
+		t.Fatalf("unexpected multipart viewer: %+v", viewer)
+	}
+	if viewer := buildBodyViewer([]byte("\x89PNG"), "image/png"); viewer == nil || viewer.Kind != "image" {
+		t.Fatalf("expected an image viewer, got %+v", viewer)
+	}
+	if viewer := buildBodyViewer([]byte("<svg/>"), "image/svg+xml"); viewer == nil || viewer.Kind != "xml" {
+		t.Fatalf("expected SVG to be shown as XML, got %+v", viewer)
+	}
+
+	data := (&Handler{}).buildRequestDetailData(&store.Request{
+		ID: 5, EndpointID: "viewed", Method: "POST", Headers: `{"Content-Type":["application/json"]}`,
+		Body: []byte(`{"items": [1, 2, "<b>"], "empty": []}`),
+	})
+	var page bytes.Buffer
+	if err := detailTemplate.ExecuteTemplate(&page, "request-detail", data); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page.String(), "3 items") || !strings.Contains(page.String(), "&#34;&lt;b&gt;&#34;") || !strings.Contains(page.String(), `href="/r/5/body"`) {
+		t.Fatalf("expected the JSON tree in the request view, got %s", page.String())
+	}
+}
+
+func TestCaptureRecordsServedResponse(t *testing.T) {
+	handler, database := testHandler(t)
+	endpoint, err := database.CreateEndpoint(t.Context(), "served", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := store.DefaultEndpointSettings()
+	settings.DefaultStatus = http.StatusCreated
+	settings.DefaultBody = `{"created":true}`
+	settings.DefaultContentType = "application/json"
+	settings.EnableCORS = true
+	settings.ResponseDelayMS = 20
+	if err := database.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	response, err := http.Post(server.URL+"/h/served", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	requests, err := database.GetRequests(t.Context(), endpoint.ID, 1)
+	if err != nil || len(requests) != 1 {
+		t.Fatalf("expected a captured request: %v", err)
+	}
+	served := requests[0]
+	var headers map[string][]string
+	if err := json.Unmarshal([]byte(served.ResponseHeaders), &headers); err != nil {
+		t.Fatalf("response headers were not recorded: %q %v", served.ResponseHeaders, err)
+	}
+	if served.StatusCode != http.StatusCreated || string(served.ResponseBody) != settings.DefaultBody || served.ClientAborted ||
+		served.ResponseTimeMS < 20 || headers["Access-Control-Allow-Origin"][0] != "*" || headers["Content-Type"][0] != "application/json" {
+		t.Fatalf("unexpected served response: %+v headers=%v", served, headers)
+	}
+	data := handler.buildRequestDetailData(served)
+	if !strings.Contains(data.ResponseHeadersJSON, "Access-Control-Allow-Origin") || data.ResponseBodyString != settings.DefaultBody {
+		t.Fatalf("detail view lacks the response: %+v", data)
+	}
+
+	settings.ResponseDelayMS = 2000
+	if err := database.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Timeout: 100 * time.Millisecond}
+	if _, err := client.Post(server.URL+"/h/served", "application/json", strings.NewReader(`{}`)); err == nil {
+		t.Fatal("expected the client to give up before the delayed response")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		requests, err := database.GetRequests(t.Context(), endpoint.ID, 1)
+		if err == nil && len(requests) == 1 && requests[0].ClientAborted {
+			if requests[0].ResponseBody != nil {
+				t.Fatalf("no body should be recorded for an abandoned response: %q", requests[0].ResponseBody)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the abandoned response to be flagged: %+v %v", requests, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHARExportImportRoundTrip(t *testing.T) {
+	handler, database := testHandler(t)
+	for _, id := range []string{"source", "copy"} {
+		if _, err := database.CreateEndpoint(t.Context(), id, "", testBrowserID, store.DefaultTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeRead, store.APIScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(router chi.Router) {
+		router.Use(handler.APIAuthMiddleware)
+		router.Get("/endpoints/{endpointID}/har", handler.APIExportHAR)
+		router.Post("/endpoints/{endpointID}/har", handler.APIImportHAR)
+	})
+	router.HandleFunc("/h/{endpointID}/*", handler.CaptureWebhook)
+	call := func(method, path string, body io.Reader) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, body)
+		request.Header.Set("X-API-Key", created.Token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	payload := []byte{0x00, 0xff, 0x10}
+	capture := httptest.NewRequest(http.MethodPut, "/h/source/files?part=one&part=two", bytes.NewReader(payload))
+	capture.Header.Set("Content-Type", "application/octet-stream")
+	router.ServeHTTP(httptest.NewRecorder(), capture)
+
+	exported := call(http.MethodGet, "/api/v1/endpoints/source/har", nil)
+	var archive harFile
+	if err := json.Unmarshal(exported.Body.Bytes(), &archive); err != nil || archive.Log.Version != "1.2" || len(archive.Log.Entries) != 1 {
+		t.Fatalf("unexpected HAR export: %s %v", exported.Body.String(), err)
+	}
+	entry := archive.Log.Entries[0]
+	if entry.Request.Method != http.MethodPut || entry.Request.URL != "http://example.com/h/source/files?part=one&part=two" ||
+		len(entry.Request.QueryString) != 2 || entry.Request.PostData == nil || entry.Request.PostData.Encoding != "base64" ||
+		entry.Response.Status != http.StatusOK || entry.Response.Content.Text != store.DefaultResponseBody {
+		t.Fatalf("unexpected HAR entry: %+v", entry)
+	}
+
+	devtools := `{"log":{"version":"1.2","entries":[
+		{"startedDateTime":"2024-05-01T10:00:02Z","time":12.5,
+		 "request":{"method":"POST","url":"https://api.example.com/v1/orders?debug=1",
+		  "headers":[{"name":":authority","value":"api.example.com"},{"name":"content-type","value":"application/json"}],
+		  "postData":{"mimeType":"application/json","text":"{\"id\":7}"}},
+		 "response":{"status":201,"headers":[{"name":"Location","value":"/v1/orders/7"}],"content":{"size":2,"mimeType":"application/json","text":"e30=","encoding":"base64"}}},
+		{"startedDateTime":"2024-05-01T10:00:01Z","time":3,"request":{"method":"GET","url":"https://api.example.com/"},"response":{"status":0}}
+	]}}`
+	if response := call(http.MethodPost, "/api/v1/endpoints/copy/har", strings.NewReader(devtools)); response.Code != http.StatusOK {
+		t.Fatalf("expected the archive to import, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := call(http.MethodPost, "/api/v1/endpoints/copy/har", strings.NewReader(exported.Body.String())); response.Code != http.StatusOK {
+		t.Fatalf("expected the export to import, got %d: %s", response.Code, response.Body.String())
+	}
+	if response := call(http.MethodPost, "/api/v1/endpoints/copy/har", strings.NewReader(`not json`)); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected an invalid archive to be rejected, got %d", response.Code)
+	}
+
+	requests, err := database.GetRequests(t.Context(), "copy", 10)
+	if err != nil || len(requests) != 3 {
+		t.Fatalf("expected three imported requests: %d %v", len(requests), err)
+	}
+	roundTripped, order, root := requests[0], requests[1], requests[2]
+	if roundTripped.Path != "/h/copy/h/source/files" || !bytes.Equal(roundTripped.Body, payload) || roundTripped.QueryString != "part=one&part=two" {
+		t.Fatalf("exported capture did not round-trip: %+v", roundTripped)
+	}
+	var headers map[string][]string
+	_ = json.Unmarshal([]byte(order.Headers), &headers)
+	if order.Method != http.MethodPost || order.Path != "/h/copy/v1/orders" || order.QueryString != "debug=1" || order.Host != "api.example.com" ||
+		order.Scheme != "https" || string(order.Body) != `{"id":7}` || headers["Content-Type"][0] != "application/json" || headers[":authority"] != nil ||
+		order.StatusCode != http.StatusCreated || string(order.ResponseBody) != "{}" || order.ResponseTimeMS != 12 ||
+		!order.CreatedAt.Equal(time.Date(2024, 5, 1, 10, 0, 2, 0, time.UTC)) {
+		t.Fatalf("devtools entry was not imported faithfully: %+v headers=%v", order, headers)
+	}
+	if root.Path != "/h/copy" || root.ResponseHeaders != "" || !root.CreatedAt.Before(order.CreatedAt) {
+		t.Fatalf("unexpected root entry: %+v", root)
+	}
+}
+
+func TestNDJSONExportImportMovesEndpoints(t *testing.T) {
+	source, sourceDB := testHandler(t)
+	endpoint, err := sourceDB.CreateEndpoint(t.Context(), "staging", "orders", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := store.DefaultEndpointSettings()
+	settings.Alias, settings.DefaultStatus, settings.Throttle = "orders", http.StatusAccepted, store.Throttle{RequestsPerSecond: 5, Burst: 5}
+	if err := sourceDB.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := sourceDB.SetResponseSequence(t.Context(), &store.ResponseSequence{
+		EndpointID: endpoint.ID, Path: "/pay", Mode: store.SequenceLoop, Steps: []store.SequenceStep{{Status: 500}, {Status: 200}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, body := range [][]byte{[]byte("first"), {0x00, 0xff}, []byte("third")} {
+		request := &store.Request{
+			EndpointID: endpoint.ID, Method: http.MethodPost, Path: "/h/staging/pay", Headers: `{"X-Seq":["` + strconv.Itoa(i) + `"]}`,
+			Body: body, StatusCode: 202, ResponseHeaders: `{}`, ResponseBody: []byte("ok"), CreatedAt: started,
+		}
+		if err := sourceDB.SaveRequest(t.Context(), request); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	created, err := source.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := source.createAPIKey(t.Context(), apiKeyInput{Name: "viewer", Scopes: []string{store.APIScopeRead}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.With(source.APIAuthMiddleware, source.RequireAPIScope(store.APIScopeWrite)).Get("/api/v1/endpoints/{endpointID}/ndjson", source.APIExportEndpointNDJSON)
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/endpoints/staging/ndjson", nil)
+	request.Header.Set("X-API-Key", readOnly.Token)
+	refused := httptest.NewRecorder()
+	router.ServeHTTP(refused, request)
+	if refused.Code != http.StatusForbidden {
+		t.Fatalf("expected read-only keys to be refused the export, got %d", refused.Code)
+	}
+	request = httptest.NewRequest(http.MethodGet, "/api/v1/endpoints/staging/ndjson", nil)
+	request.Header.Set("X-API-Key", created.Token)
+	exported := httptest.NewRecorder()
+	router.ServeHTTP(exported, request)
+	if exported.Code != http.StatusOK || strings.Count(exported.Body.String(), "\n") != 4 {
+		t.Fatalf("unexpected export: %d %s", exported.Code, exported.Body.String())
+	}
+
+	target, targetDB := testHandler(t)
+	if _, err := targetDB.CreateEndpoint(t.Context(), "staging", "", "someone", store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	result, err := target.ImportNDJSON(t.Context(), strings.NewReader(exported.Body.String()), "")
+	if err != nil || len(result.Endpoints) != 1 || result.Endpoints[0].Requests != 3 {
+		t.Fatalf("unexpected import: %+v %v", result, err)
+	}
+	moved := result.Endpoints[0]
+	if moved.SourceID != "staging" || moved.ID == "staging" {
+		t.Fatalf("expected a taken ID to be remapped: %+v", moved)
+	}
+	imported, err := targetDB.GetEndpoint(t.Context(), moved.ID)
+	if err != nil || imported.Alias != "orders" || imported.DefaultStatus != http.StatusAccepted || imported.Throttle.RequestsPerSecond != 5 ||
+		imported.CreatorID != "" || imported.OwnerUserID != "" || !imported.CreatedAt.Equal(endpoint.CreatedAt) {
+		t.Fatalf("settings were not carried over: %+v %v", imported, err)
+	}
+	if sequences, err := targetDB.ListResponseSequences(t.Context(), moved.ID); err != nil || len(sequences) != 1 || sequences[0].Path != "/pay" {
+		t.Fatalf("sequences were not carried over: %+v %v", sequences, err)
+	}
+	requests, err := targetDB.GetRequests(t.Context(), moved.ID, 10)
+	if err != nil || len(requests) != 3 {
+		t.Fatalf("expected three requests: %v", err)
+	}
+	for i, request := range requests {
+		if !request.CreatedAt.Equal(started) || request.Path != "/h/"+moved.ID+"/pay" || string(request.ResponseBody) != "ok" ||
+			request.Headers != `{"X-Seq":["`+strconv.Itoa(2-i)+`"]}` {
+			t.Fatalf("request %d was not preserved in order: %+v", i, request)
+		}
+	}
+	if !bytes.Equal(requests[1].Body, []byte{0x00, 0xff}) {
+		t.Fatalf("binary body was not preserved: %v", requests[1].Body)
+	}
+
+	if _, err := target.ImportNDJSON(t.Context(), strings.NewReader(`{"type":"request","request":{"endpoint_id":"missing"}}`), ""); err == nil {
+		t.Fatal("expected requests without their endpoint to be rejected")
+	}
+
+	// Imports through the API belong to the account named by ?owner=.
+	if err := targetDB.CreateUser(t.Context(), &store.User{ID: "alice-id", Username: "alice"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := target.createAPIKey(t.Context(), apiKeyInput{Name: "ops", Scopes: []string{store.APIScopeAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router = chi.NewRouter()
+	router.With(target.APIAuthMiddleware).Post("/api/v1/import", target.APIImportNDJSON)
+	importAs := func(owner string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/import?owner="+owner, strings.NewReader(exported.Body.String()))
+		request.Header.Set("X-API-Key", admin.Token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+	if response := importAs("nobody"); response.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown owner to be refused, got %d", response.Code)
+	}
+	response := importAs("alice")
+	var owned ImportResult
+	if err := json.Unmarshal(response.Body.Bytes(), &owned); err != nil || len(owned.Endpoints) != 1 {
+		t.Fatalf("unexpected API import: %d %s", response.Code, response.Body.String())
+	}
+	if imported, err := targetDB.GetEndpoint(t.Context(), owned.Endpoints[0].ID); err != nil || imported.OwnerUserID != "alice-id" || imported.CreatorID != "" {
+		t.Fatalf("expected the import to belong to alice: %+v %v", imported, err)
+	}
+}
+
+func TestMetricsCountCapturesAndAPIRequests(t *testing.T) {
+	handler, database := testHandler(t)
+	config := DefaultRuntimeConfig()
+	config.APIKey = "secret"
+	config.MetricsToken = "scrape"
+	config.MetricsEndpoints = 1
+	config.MaxWebhookBodyBytes = 4
+	handler.ApplyRuntimeConfig(config)
+	for _, id := range []string{"first", "second"} {
+		if _, err := database.CreateEndpoint(t.Context(), id, "", testBrowserID, store.DefaultTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := chi.NewRouter()
+	router.Get("/metrics", handler.ServeMetrics)
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	router.Route("/api/v1", func(router chi.Router) {
+		router.Use(handler.APIMetrics)
+		router.Use(handler.APIAuthMiddleware)
+		router.Get("/endpoints/{endpointID}", handler.APIGetEndpoint)
+	})
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+	serve(httptest.NewRequest(http.MethodPost, "/h/first", strings.NewReader("truncated body")))
+	serve(httptest.NewRequest(http.MethodPost, "/h/second", strings.NewReader("{}")))
+	serve(httptest.NewRequest(http.MethodPost, "/h/missing", nil))
+	serve(httptest.NewRequest(http.MethodGet, "/api/v1/endpoints/first", nil))
+
+	if code := serve(httptest.NewRequest(http.MethodGet, "/metrics", nil)).Code; code != http.StatusUnauthorized {
+		t.Fatalf("expected the metrics token to be required, got %d", code)
+	}
+	scrape := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	scrape.Header.Set("Authorization", "Bearer scrape")
+	response := serve(scrape)
+	if response.Code != http.StatusOK || !strings.HasPrefix(response.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected metrics response %d %q", response.Code, response.Header().Get("Content-Type"))
+	}
+	body := response.Body.String()
+	for _, want := range []string{
+		`pipehook_captures_total{endpoint="first",outcome="stored"} 1`,
+		`pipehook_captures_total{endpoint="other",outcome="stored"} 1`,
+		`pipehook_captures_total{endpoint="unknown",outcome="not_found"} 1`,
+		`pipehook_capture_truncated_total{endpoint="first"} 1`,
+		`pipehook_capture_body_bytes_count 2`,
+		`pipehook_api_requests_total{route="/api/v1/endpoints/{endpointID}",method="GET",status="401"} 1`,
+		`pipehook_websocket_clients 0`,
+		`pipehook_rate_limit_requests_total{limiter="capture_ip",result="allowed"} 3`,
+		`pipehook_store_query_duration_seconds_count{method="SaveRequest"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics are missing %s:\n%s", want, body)
+		}
+	}
+}
+
+func TestMetricsLabelEndpointsOnlyBehindAToken(t *testing.T) {
+	handler, database := testHandler(t)
+	if _, err := database.CreateEndpoint(t.Context(), "private", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Get("/metrics", handler.ServeMetrics)
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	router.Delete("/endpoints/{endpointID}", handler.APIDeleteEndpoint)
+	scrape := func() string {
+		request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		request.Header.Set("Authorization", "Bearer scrape")
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response.Body.String()
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/h/private", nil))
+	if body := scrape(); strings.Contains(body, `endpoint="private"`) ||
+		!strings.Contains(body, `pipehook_captures_total{endpoint="other",outcome="stored"} 1`) {
+		t.Fatalf("expected public metrics not to name endpoints:\n%s", body)
+	}
+
+	config := DefaultRuntimeConfig()
+	config.MetricsToken = "scrape"
+	handler.ApplyRuntimeConfig(config)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/h/private", nil))
+	if body := scrape(); !strings.Contains(body, `pipehook_captures_total{endpoint="private",outcome="stored"} 1`) {
+		t.Fatalf("expected the endpoint label behind a token:\n%s", body)
+	}
+
+	request := httptest.NewRequest(http.MethodDelete, "/endpoints/private", nil)
+	request.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: testBrowserID})
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("delete returned %d: %s", response.Code, response.Body.String())
+	}
+	if body := scrape(); strings.Contains(body, `endpoint="private"`) {
+		t.Fatalf("expected the deleted endpoint's series to be dropped:\n%s", body)
+	}
+	handler.metrics.labelMu.Lock()
+	labelled := len(handler.metrics.labelled)
+	handler.metrics.labelMu.Unlock()
+	if labelled != 0 {
+		t.Fatalf("expected the deleted endpoint's label to be released, %d remain", labelled)
+	}
+}
+
+func TestCaptureTracesStoreAndForwarding(t *testing.T) {
+	handler, database := testHandler(t)
+	collector := tracingtest.NewCollector()
+	defer collector.Close()
+	handler.Tracer = tracing.New(tracing.Config{Endpoint: collector.URL})
+	handler.TraceURL = "https://traces.example.com/trace/{trace_id}"
+	config := DefaultRuntimeConfig()
+	config.AllowPrivateForward = true
+	handler.ApplyRuntimeConfig(config)
+
+	forwarded := make(chan string, 1)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded <- r.Header.Get("Traceparent")
+	}))
+	defer target.Close()
+	endpoint, err := database.CreateEndpoint(t.Context(), "traced", "", testBrowserID, store.DefaultTTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := store.DefaultEndpointSettings()
+	settings.ForwardURL = target.URL
+	if err := database.UpdateEndpointSettings(t.Context(), endpoint.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	request := httptest.NewRequest(http.MethodPost, "/h/traced", strings.NewReader("{}"))
+	request.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+	if err := handler.Tracer.Shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	requests, err := database.GetRequests(t.Context(), endpoint.ID, 1)
+	if err != nil || len(requests) != 1 || requests[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the caller's trace ID on the capture: %+v %v", requests, err)
+	}
+	if link := handler.buildRequestDetailData(requests[0]).TraceLink; link != "https://traces.example.com/trace/4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("unexpected trace link %q", link)
+	}
+	spans := make(map[string]tracingtest.Span)
+	for _, span := range collector.Spans() {
+		if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("span %s left the caller's trace", span.Name)
+		}
+		spans[span.Name] = span
+	}
+	capture, forward := spans["CaptureWebhook"], spans["forwardRequest"]
+	if capture.ParentSpanID != "00f067aa0ba902b7" || capture.Attributes["pipehook.capture.outcome"] != captureStored {
+		t.Fatalf("unexpected capture span %+v", capture)
+	}
+	for _, name := range []string{"store.GetEndpoint", "store.SaveRequest", "Broadcast", "forwardRequest"} {
+		if spans[name].ParentSpanID != capture.SpanID {
+			t.Errorf("expected %s to be a child of the capture span, got %+v", name, spans[name])
+		}
+	}
+	if got := <-forwarded; got != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+forward.SpanID+"-01" {
+		t.Fatalf("forward target got traceparent %q, want the forward span %s", got, forward.SpanID)
+	}
+}
+
+func TestHealthReadinessAndDiagnostics(t *testing.T) {
+	handler, database := testHandler(t)
+	if _, err := database.CreateEndpoint(t.Context(), "endpoint", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	probe := func(serve http.HandlerFunc, path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		serve(response, httptest.NewRequest(http.MethodGet, path, nil))
+		return response
+	}
+	if response := probe(handler.Healthz, "/healthz"); response.Code != http.StatusOK || !strings.Contains(response.Body.String(), `"ok"`) {
+		t.Fatalf("unexpected liveness response: %d %s", response.Code, response.Body.String())
+	}
+	if response := probe(handler.Readyz, "/readyz"); response.Code != http.StatusOK {
+		t.Fatalf("expected an open database to be ready, got %d %s", response.Code, response.Body.String())
+	}
+	page := probe(handler.AdminDiagnostics, "/admin/diagnostics")
+	if page.Code != http.StatusOK || !strings.Contains(page.Body.String(), "endpoints") || !strings.Contains(page.Body.String(), "answers 200") {
+		t.Fatalf("expected table counts and readiness on the diagnostics page, got %d", page.Code)
+	}
+
+	if err := database.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if response := probe(handler.Readyz, "/readyz"); response.Code != http.StatusServiceUnavailable || !strings.Contains(response.Body.String(), "unavailable") {
+		t.Fatalf("expected a closed database to fail readiness, got %d %s", response.Code, response.Body.String())
+	}
+	if response := probe(handler.Healthz, "/healthz"); response.Code != http.StatusOK {
+		t.Fatalf("expected liveness to ignore the database, got %d", response.Code)
+	}
+}
+
+func TestCaptureLogsAndStoresRequestID(t *testing.T) {
+	handler, database := testHandler(t)
+	if _, err := database.CreateEndpoint(t.Context(), "logged", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	router := chi.NewRouter()
+	router.Use(logging.RequestID)
+	router.HandleFunc("/h/{endpointID}", handler.CaptureWebhook)
+	request := httptest.NewRequest(http.MethodPost, "/h/logged", strings.NewReader(`{"id":1}`))
+	request.Header.Set("X-Request-ID", "provider-7f3a")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK || response.Header().Get("X-Request-ID") != "provider-7f3a" {
+		t.Fatalf("unexpected capture response: %d %v", response.Code, response.Header())
+	}
+	requests, err := database.GetRequests(t.Context(), "logged", 1)
+	if err != nil || len(requests) != 1 || requests[0].CorrelationID != "provider-7f3a" {
+		t.Fatalf("expected the request ID to be stored, got %+v %v", requests, err)
+	}
+
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON log line, got %q: %v", out.String(), err)
+	}
+	if line["msg"] != "webhook capture" || line["request_id"] != "provider-7f3a" || line["endpoint_id"] != "logged" ||
+		line["outcome"] != captureStored || line["size"] != float64(8) || line["request"] != float64(requests[0].ID) {
+		t.Fatalf("unexpected capture log line: %v", line)
+	}
+
+	out.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/h/missing", strings.NewReader("{}")))
+	var missing map[string]any
+	if err := json.Unmarshal(out.Bytes(), &missing); err != nil || missing["outcome"] != captureNotFound || missing["request_id"] == nil {
+		t.Fatalf("expected a not found capture line with a generated request ID, got %q", out.String())
+	}
+}
+
+func TestNotificationsReportMatchingCaptures(t *testing.T) {
+	handler, database := testHandler(t)
+	config := DefaultRuntimeConfig()
+	config.AllowPrivateForward = true
+	config.PublicURL = "https://hooks.example.com"
+	handler.ApplyRuntimeConfig(config)
+	receiver := notifytest.NewReceiver(1)
+	defer receiver.Close()
+	if _, err := database.CreateEndpoint(t.Context(), "notified", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeRead, store.APIScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.Route("/api/v1", func(router chi.Router) {
+		router.Use(handler.APIAuthMiddleware)
+		router.Get("/endpoints/{endpointID}/notifications", handler.APIListNotifications)
+		router.Post("/endpoints/{endpointID}/notifications", handler.APICreateNotification)
+		router.Delete("/endpoints/{endpointID}/notifications/{channelID}", handler.APIDeleteNotification)
+	})
+	router.HandleFunc("/h/{endpointID}/*", handler.CaptureWebhook)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if strings.HasPrefix(path, "/api/") {
+			request.Header.Set("X-API-Key", created.Token)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	for _, invalid := range []string{
+		`{"kind":"pager","target":"https://example.com"}`,
+		`{"kind":"webhook","target":"ftp://example.com"}`,
+		`{"kind":"email","target":"ops@example.com"}`,
+		`{"kind":"slack","target":"https://example.com","digest_seconds":-1}`,
+	} {
+		if response := call(http.MethodPost, "/api/v1/endpoints/notified/notifications", invalid); response.Code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be refused, got %d", invalid, response.Code)
+		}
+	}
+	response := call(http.MethodPost, "/api/v1/endpoints/notified/notifications",
+		`{"kind":"webhook","target":"`+receiver.URL+`","match":"payment_failed"}`)
+	var channel store.NotificationChannel
+	if err := json.Unmarshal(response.Body.Bytes(), &channel); err != nil || response.Code != http.StatusCreated || channel.ID == "" {
+		t.Fatalf("expected the channel to be created, got %d %s", response.Code, response.Body.String())
+	}
+
+	call(http.MethodPost, "/h/notified/orders", `{"type":"payment_succeeded"}`)
+	call(http.MethodPost, "/h/notified/orders?attempt=2", `{"type":"payment_failed"}`)
+	if !receiver.Wait(1, 10*time.Second) {
+		t.Fatal("expected the matching capture to be delivered after a retry")
+	}
+	var payload struct {
+		Event  string `json:"event"`
+		Latest struct {
+			EndpointID  string `json:"endpoint_id"`
+			Path        string `json:"path"`
+			QueryString string `json:"query_string"`
+			URL         string `json:"url"`
+		} `json:"latest"`
+	}
+	if err := json.Unmarshal(receiver.Bodies()[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "capture" || payload.Latest.QueryString != "attempt=2" || payload.Latest.URL != "https://hooks.example.com/notified" {
+		t.Fatalf("unexpected notification: %s", receiver.Bodies()[0])
+	}
+
+	response = call(http.MethodGet, "/api/v1/endpoints/notified/notifications", "")
+	var channels []store.NotificationChannel
+	if err := json.Unmarshal(response.Body.Bytes(), &channels); err != nil || len(channels) != 1 || channels[0].Match != "payment_failed" {
+		t.Fatalf("unexpected channels: %s %v", response.Body.String(), err)
+	}
+	if response := call(http.MethodDelete, "/api/v1/endpoints/notified/notifications/"+channel.ID, ""); response.Code != http.StatusNoContent {
+		t.Fatalf("expected the channel to be deleted, got %d", response.Code)
+	}
+	if response := call(http.MethodDelete, "/api/v1/endpoints/notified/notifications/"+channel.ID, ""); response.Code != http.StatusNotFound {
+		t.Fatalf("expected a deleted channel to be missing, got %d", response.Code)
+	}
+	call(http.MethodPost, "/h/notified/orders", `{"type":"payment_failed"}`)
+	if err := handler.Shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if bodies := receiver.Bodies(); len(bodies) != 1 {
+		t.Fatalf("expected only the one matching capture to be reported, got %d", len(bodies))
+	}
+}
+
+func TestEmailNotificationsNeedAnAccountAndADigest(t *testing.T) {
+	handler, database := testHandler(t)
+	config := DefaultRuntimeConfig()
+	config.SMTP = notify.SMTP{Host: "mail.example.com", Port: 587, From: "pipehook@example.com"}
+	handler.ApplyRuntimeConfig(config)
+	if _, err := database.CreateEndpoint(t.Context(), "mailed", "", testBrowserID, store.DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	created, err := handler.createAPIKey(t.Context(), apiKeyInput{Name: "ci", Scopes: []string{store.APIScopeWrite}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := chi.NewRouter()
+	router.With(handler.APIAuthMiddleware).Post("/api/v1/endpoints/{endpointID}/notifications", handler.APICreateNotification)
+	router.Post("/endpoint/{endpointID}/notifications", handler.CreateNotification)
+
+	form := httptest.NewRequest(http.MethodPost, "/endpoint/mailed/notifications", strings.NewReader("kind=email&target=victim%40example.com&digest_seconds=300"))
+	form.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	form.AddCookie(&http.Cookie{Name: browserIDCookieName, Value: testBrowserID})
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, form)
+	if response.Code != http.StatusForbidden {
+		t.Fatalf("expected an anonymous browser to be refused email, got %d %s", response.Code, response.Body.String())
+	}
+	for body, status := range map[string]int{
+		`{"kind":"email","target":"ops@example.com"}`:                          http.StatusBadRequest,
+		`{"kind":"email","target":"ops@example.com","digest_seconds":60}`:      http.StatusBadRequest,
+		`{"kind":"email","target":"ops@example.com","digest_seconds":300}`:     http.StatusCreated,
+		`{"kind":"webhook","target":"https://example.com","digest_seconds":0}`: http.StatusCreated,
+	} {
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/endpoints/mailed/notifications", strings.NewReader(body))
+		request.Header.Set("X-API-Key", created.Token)
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		if response.Code != status {
+			t.Fatalf("%s: expected %d, got %d %s", body, status, response.Code, response.Body.String())
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxImportBytes caps an uploaded archive.
+const maxImportBytes = 32 * 1024 * 1024
+
+// The har types follow HTTP Archive 1.2. Fields pipehook cannot fill are
+// written with the spec's "unknown" values (-1, "") rather than left out.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// harPostData has no encoding field in HAR 1.2, so binary bodies are marked
+// with the custom _encoding field that some tools also read.
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harText returns body as HAR text, base64 encoded when it is not UTF-8.
+func harText(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
+
+func harHeaders(requestID int64, raw string) []harNameValue {
+	headers := parseRequestHeaders(requestID, raw)
+	pairs := make([]harNameValue, 0, len(headers))
+	for _, name := range slices.Sorted(maps.Keys(headers)) {
+		for _, value := range headers[name] {
+			pairs = append(pairs, harNameValue{Name: name, Value: value})
+		}
+	}
+	return pairs
+}
+
+// exportHAREntry maps a capture and the response pipehook served into a HAR
+// entry. The whole time to respond is reported as wait.
+func exportHAREntry(request *store.Request) harEntry {
+	target := url.URL{Scheme: request.Scheme, Host: request.Host, Path: request.Path, RawQuery: request.QueryString}
+	if target.Scheme == "" {
+		target.Scheme = "http"
+	}
+	query := []harNameValue{}
+	values, _ := url.ParseQuery(request.QueryString)
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		for _, value := range values[name] {
+			query = append(query, harNameValue{Name: name, Value: value})
+		}
+	}
+	headers := harHeaders(request.ID, request.Headers)
+	entry := harEntry{
+		StartedDateTime: request.CreatedAt,
+		Time:            float64(request.ResponseTimeMS),
+		Request: harRequest{
+			Method: request.Method, URL: target.String(), HTTPVersion: "HTTP/1.1", Cookies: []harNameValue{},
+			Headers: headers, QueryString: query, HeadersSize: -1, BodySize: int64(len(request.Body)),
+		},
+		Response: harResponse{
+			Status: request.StatusCode, StatusText: http.StatusText(request.StatusCode), HTTPVersion: "HTTP/1.1",
+			Cookies: []harNameValue{}, Headers: []harNameValue{}, HeadersSize: -1, BodySize: -1,
+		},
+		Timings: harTimings{Wait: float64(request.ResponseTimeMS)},
+	}
+	if len(request.Body) > 0 {
+		text, encoding := harText(request.Body)
+		entry.Request.PostData = &harPostData{
+			MimeType: headerValue(parseRequestHeaders(request.ID, request.Headers), "Content-Type"), Text: text, Encoding: encoding,
+		}
+	}
+	if request.ResponseHeaders != "" {
+		entry.Response.Headers = harHeaders(request.ID, request.ResponseHeaders)
+		text, encoding := harText(request.ResponseBody)
+		entry.Response.Content = harContent{
+			Size:     int64(len(request.ResponseBody)),
+			MimeType: headerValue(parseRequestHeaders(request.ID, request.ResponseHeaders), "Content-Type"),
+			Text:     text, Encoding: encoding,
+		}
+		entry.Response.BodySize = int64(len(request.ResponseBody))
+	}
+	var notes []string
+	if request.RejectedReason != "" {
+		notes = append(notes, "rejected: "+request.RejectedReason)
+	}
+	if request.BodyTruncated {
+		notes = append(notes, "request body truncated")
+	}
+	if request.ClientAborted {
+		notes = append(notes, "client aborted")
+	}
+	entry.Comment = strings.Join(notes, "; ")
+	return entry
+}
+
+// ExportRequestsHAR streams the endpoint's requests, filtered like the other
+// exports, as a HAR 1.2 archive.
+func (h *Handler) ExportRequestsHAR(w http.ResponseWriter, r *http.Request) {
+	endpointID := chi.URLParam(r, "endpointID")
+	if _, ok := h.requireEndpointAccess(w, r, endpointID, permView); !ok {
+		return
+	}
+	h.writeHAR(w, r, endpointID)
+}
+
+// APIExportHAR is ExportRequestsHAR for API keys.
+func (h *Handler) APIExportHAR(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
+		return
+	}
+	h.writeHAR(w, r, endpoint.ID)
+}
+
+func (h *Handler) writeHAR(w http.ResponseWriter, r *http.Request, endpointID string) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(query) > 200 {
+		query = query[:200]
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pipehook-%s.har"`, endpointID))
+	_, _ = w.Write([]byte(`{"log":{"version":"1.2","creator":{"name":"pipehook","version":"1.0"},"entries":[`))
+	first := true
+	for offset := 0; ; offset += exportPageSize {
+		requests, err := h.Store.SearchRequests(r.Context(), endpointID, query, exportPageSize, offset)
+		if err != nil {
+			return
+		}
+		for _, request := range requests {
+			if !first {
+				_, _ = w.Write([]byte(","))
+			}
+			first = false
+			payload, _ := json.Marshal(exportHAREntry(request))
+			_, _ = w.Write(payload)
+		}
+		if len(requests) < exportPageSize || r.Context().Err() != nil {
+			break
+		}
+	}
+	_, _ = w.Write([]byte("]}}"))
+}
+
+// decodeHARText reverses harText.
+func decodeHARText(text, encoding string) ([]byte, error) {
+	if strings.EqualFold(encoding, "base64") {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
+
+func harHeaderJSON(pairs []harNameValue) string {
+	headers := make(map[string][]string, len(pairs))
+	for _, pair := range pairs {
+		// HTTP/2 pseudo-headers such as :authority are not real headers.
+		if strings.HasPrefix(pair.Name, ":") || pair.Name == "" {
+			continue
+		}
+		name := http.CanonicalHeaderKey(pair.Name)
+		headers[name] = append(headers[name], pair.Value)
+	}
+	encoded, _ := json.Marshal(headers)
+	return string(encoded)
+}
+
+// importHAREntry turns a HAR entry into a request of endpoint. The original
+// URL path is kept below the endpoint's capture path so replays and
+// path-based features treat it like a capture.
+func importHAREntry(endpoint *store.Endpoint, entry harEntry, maxBodyBytes int64) (*store.Request, error) {
+	target, err := url.Parse(entry.Request.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q", entry.Request.URL)
+	}
+	method := strings.ToUpper(strings.TrimSpace(entry.Request.Method))
+	if method == "" || !validHeaderName(method) {
+		return nil, fmt.Errorf("invalid method %q", entry.Request.Method)
+	}
+	path := target.Path
+	if path == "/" {
+		path = ""
+	}
+	request := &store.Request{
+		EndpointID: endpoint.ID, Method: method, Path: "/h/" + endpoint.ID + path,
+		QueryString: target.RawQuery, Host: target.Host, Scheme: target.Scheme,
+		Headers: harHeaderJSON(entry.Request.Headers), StatusCode: entry.Response.Status,
+		ResponseTimeMS: int64(entry.Time), CreatedAt: entry.StartedDateTime,
+	}
+	if request.CreatedAt.IsZero() {
+		request.CreatedAt = time.Now()
+	}
+	if data := entry.Request.PostData; data != nil {
+		if request.Body, err = decodeHARText(data.Text, data.Encoding); err != nil {
+			return nil, errors.New("post data is not valid base64")
+		}
+	}
+	if maxBodyBytes > 0 && int64(len(request.Body)) > maxBodyBytes {
+		request.Body, request.BodyTruncated = request.Body[:maxBodyBytes], true
+	}
+	request.ContentLength = int64(len(request.Body))
+	if entry.Response.Status > 0 {
+		request.ResponseHeaders = harHeaderJSON(entry.Response.Headers)
+		if request.ResponseBody, err = decodeHARText(entry.Response.Content.Text, entry.Response.Content.Encoding); err != nil {
+			return nil, errors.New("response content is not valid base64")
+		}
+		if len(request.ResponseBody) > maxRecordedResponseBytes {
+			request.ResponseBody = request.ResponseBody[:maxRecordedResponseBytes]
+		}
+	}
+	return request, nil
+}
+
+// importHAR stores the entries of a HAR archive as requests of endpoint,
+// oldest first, and applies the endpoint's request limit afterwards.
+func (h *Handler) importHAR(r *http.Request, endpoint *store.Endpoint, archive []byte) (int, int, error) {
+	var file harFile
+	if err := json.Unmarshal(archive, &file); err != nil {
+		return 0, http.StatusBadRequest, errors.New("not a valid HAR file")
+	}
+	entries := file.Log.Entries
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StartedDateTime.Before(entries[j].StartedDateTime) })
+	requests := make([]*store.Request, 0, len(entries))
+	for i, entry := range entries {
+		request, err := importHAREntry(endpoint, entry, h.runtimeConfig().MaxWebhookBodyBytes)
+		if err != nil {
+			return 0, http.StatusBadRequest, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		requests = append(requests, request)
+	}
+	for _, request := range requests {
+		if err := h.Store.SaveRequest(r.Context(), request); err != nil {
+			slog.ErrorContext(r.Context(), "failed to import request", "endpoint_id", endpoint.ID, "error", err)
+			return 0, http.StatusInternalServerError, errors.New("failed to save imported requests")
+		}
+	}
+	if err := h.Store.TrimRequests(r.Context(), endpoint.ID, endpoint.RequestLimit); err != nil {
+		slog.ErrorContext(r.Context(), "failed to enforce request retention", "endpoint_id", endpoint.ID, "error", err)
+	}
+	h.audit(r, auditRequestImport, "endpoint", endpoint.ID, map[string]any{"format": "har", "count": len(requests)})
+	return len(requests), http.StatusOK, nil
+}
+
+// ImportRequestsHAR loads an uploaded HAR file into the endpoint.
+func (h *Handler) ImportRequestsHAR(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes+64*1024)
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "choose a HAR file to import", http.StatusBadRequest)
+		return
+	}
+	archive, truncated, err := readRequestBodyWithLimit(file, maxImportBytes)
+	if err != nil || truncated {
+		http.Error(w, "HAR file is too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if _, status, err := h.importHAR(r, endpoint, archive); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+// APIImportHAR loads the HAR archive in the request body into the endpoint.
+func (h *Handler) APIImportHAR(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	archive, truncated, err := readRequestBodyWithLimit(r.Body, maxImportBytes)
+	if err != nil || truncated {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": "HAR file is too large"})
+		return
+	}
+	imported, status, err := h.importHAR(r, endpoint, archive)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"imported": imported})
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+var processStarted = time.Now()
+
+// Healthz answers as long as the process is serving HTTP. It does not touch
+// the database, so a slow disk does not get the process restarted.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz answers 503 while the database cannot take captures: unreachable,
+// read-only, missing migrations or short of disk space.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := h.Store.Ready(r.Context(), h.runtimeConfig().MinFreeDiskBytes); err != nil {
+		slog.WarnContext(r.Context(), "readiness check failed", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "unavailable", "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// buildInfo describes the running binary.
+type buildInfo struct {
+	GoVersion string
+	Module    string
+	Version   string
+	Revision  string
+	Time      string
+	Modified  bool
+}
+
+func readBuildInfo() buildInfo {
+	info := buildInfo{GoVersion: runtime.Version(), Version: "unknown"}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	info.Module, info.Version = build.Main.Path, build.Main.Version
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
+
+// forwardPoolState is the forwarding client's configuration and usage.
+type forwardPoolState struct {
+	OpenConns           int64
+	DialedConns         int64
+	InFlight            int64
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	Timeout             time.Duration
+	AllowPrivate        bool
+}
+
+func (h *Handler) forwardPoolState() forwardPoolState {
+	client := h.forwarder()
+	state := forwardPoolState{
+		OpenConns: h.forwardPool.open.Load(), DialedConns: h.forwardPool.dialed.Load(), InFlight: h.forwardPool.inFlight.Load(),
+		Timeout: client.Timeout, AllowPrivate: h.runtimeConfig().AllowPrivateForward,
+	}
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		state.MaxIdleConns, state.MaxIdleConnsPerHost, state.IdleConnTimeout = transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout
+	}
+	return state
+}
+
+// AdminDiagnostics shows the state of the database, live viewers, the
+// forwarding client and the running binary.
+func (h *Handler) AdminDiagnostics(w http.ResponseWriter, r *http.Request) {
+	database, err := h.Store.Diagnostics(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to read database diagnostics", "error", err)
+		http.Error(w, "failed to read database diagnostics", http.StatusInternalServerError)
+		return
+	}
+	readiness := "ready"
+	if err := h.Store.Ready(r.Context(), h.runtimeConfig().MinFreeDiskBytes); err != nil {
+		readiness = err.Error()
+	}
+	h.clientsMu.RLock()
+	websocketClients, watchedEndpoints := 0, len(h.clients)
+	for _, conns := range h.clients {
+		websocketClients += len(conns)
+	}
+	h.clientsMu.RUnlock()
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+
+	data := struct {
+		BaseTemplateData
+		Database         *store.Diagnostics
+		Readiness        string
+		WebSocketClients int
+		WatchedEndpoints int
+		Forward          forwardPoolState
+		Build            buildInfo
+		Started          time.Time
+		Uptime           time.Duration
+		Goroutines       int
+		HeapBytes        int64
+	}{
+		BaseTemplateData: h.baseTemplateData(r),
+		Database:         database,
+		Readiness:        readiness,
+		WebSocketClients: websocketClients,
+		WatchedEndpoints: watchedEndpoints,
+		Forward:          h.forwardPoolState(),
+		Build:            readBuildInfo(),
+		Started:          processStarted,
+		Uptime:           time.Since(processStarted).Truncate(time.Second),
+		Goroutines:       runtime.NumGoroutine(),
+		HeapBytes:        int64(memory.HeapAlloc),
+	}
+	if err := diagnosticsTemplate.ExecuteTemplate(w, "layout", data); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+		http.Error(w, "failed to render page", http.StatusInternalServerError)
+	}
+}
+
+// formatBytes renders a byte count with a binary unit, such as 1.5 MB.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, suffix := float64(size)/unit, 0
+	for value >= unit && suffix < 4 {
+		value /= unit
+		suffix++
+	}
+	return fmt.Sprintf("%.1f %cB", value, "KMGTP"[suffix])
+}
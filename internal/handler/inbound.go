@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+const maxInboundAuthEntries = 50
+
+// inboundRejection explains why a capture attempt was refused.
+type inboundRejection struct {
+	status    int
+	reason    string
+	challenge string
+}
+
+// checkInboundAuth evaluates the endpoint's sender requirements. The source
+// address and client certificate are checked before any credential so
+// unknown senders learn as little as possible.
+func (h *Handler) checkInboundAuth(r *http.Request, auth store.InboundAuth) *inboundRejection {
+	if len(auth.AllowedCIDRs) > 0 && !sourceAllowed(h.clientIP(r), auth.AllowedCIDRs) {
+		return &inboundRejection{status: http.StatusForbidden, reason: "source address not allowed"}
+	}
+	if len(auth.ClientCertSHA256) > 0 {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return &inboundRejection{status: http.StatusForbidden, reason: "client certificate required"}
+		}
+		sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		if !slices.Contains(auth.ClientCertSHA256, hex.EncodeToString(sum[:])) {
+			return &inboundRejection{status: http.StatusForbidden, reason: "client certificate not allowed"}
+		}
+	}
+	if auth.BasicUsername != "" {
+		username, password, ok := r.BasicAuth()
+		if !ok || !secretEqual(username, auth.BasicUsername) || !secretEqual(store.InboundSecretDigest(password), auth.BasicPassword) {
+			return &inboundRejection{status: http.StatusUnauthorized, reason: "invalid basic credentials", challenge: `Basic realm="pipehook"`}
+		}
+	}
+	if auth.BearerToken != "" {
+		header := r.Header.Get("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") || !secretEqual(store.InboundSecretDigest(strings.TrimSpace(header[7:])), auth.BearerToken) {
+			return &inboundRejection{status: http.StatusUnauthorized, reason: "invalid bearer token", challenge: "Bearer"}
+		}
+	}
+	if auth.HeaderName != "" && !secretEqual(store.InboundSecretDigest(r.Header.Get(auth.HeaderName)), auth.HeaderValue) {
+		return &inboundRejection{status: http.StatusUnauthorized, reason: "invalid " + auth.HeaderName + " header"}
+	}
+	return nil
+}
+
+func secretEqual(provided, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+}
+
+func sourceAllowed(address string, cidrs []string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeInboundAuth trims the settings and rewrites them in the form the
+// checks expect and the store keeps: digests for the secrets, canonical
+// header names, CIDR notation for bare addresses and lowercase fingerprints
+// without separators. It takes settings as entered, never stored ones, whose
+// secrets are digests already.
+func normalizeInboundAuth(auth store.InboundAuth) store.InboundAuth {
+	auth.BasicUsername = strings.TrimSpace(auth.BasicUsername)
+	auth.BasicPassword = inboundSecretDigest(auth.BasicPassword)
+	auth.BearerToken = inboundSecretDigest(strings.TrimSpace(auth.BearerToken))
+	auth.HeaderName = http.CanonicalHeaderKey(strings.TrimSpace(auth.HeaderName))
+	auth.HeaderValue = inboundSecretDigest(strings.TrimSpace(auth.HeaderValue))
+	cidrs := make([]string, 0, len(auth.AllowedCIDRs))
+	for _, cidr := range auth.AllowedCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		if ip := net.ParseIP(cidr); ip != nil {
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		if cidr != "" && !slices.Contains(cidrs, cidr) {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	auth.AllowedCIDRs = cidrs
+	fingerprints := make([]string, 0, len(auth.ClientCertSHA256))
+	for _, fingerprint := range auth.ClientCertSHA256 {
+		fingerprint = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+		if fingerprint != "" && !slices.Contains(fingerprints, fingerprint) {
+			fingerprints = append(fingerprints, fingerprint)
+		}
+	}
+	auth.ClientCertSHA256 = fingerprints
+	return auth
+}
+
+// inboundSecretDigest hashes a secret for storage. A blank secret stays blank,
+// since it means the secret is not set or the stored one is kept.
+func inboundSecretDigest(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return store.InboundSecretDigest(secret)
+}
+
+// inboundSecrets names the sender authentication secrets.
+type inboundSecrets struct {
+	basicPassword, bearerToken, headerValue bool
+}
+
+// keepInboundSecrets fills the blank secrets of auth from stored, since
+// secrets are never shown again once saved. A secret is not kept when the
+// caller cleared it, nor when the setting it belongs to is gone: the basic
+// password goes with the username, the header value with the header name
+// and the bearer token when basic auth replaces it.
+func keepInboundSecrets(auth, stored store.InboundAuth, cleared inboundSecrets) store.InboundAuth {
+	if auth.BasicPassword == "" && auth.BasicUsername != "" && !cleared.basicPassword {
+		auth.BasicPassword = stored.BasicPassword
+	}
+	if auth.BearerToken == "" && auth.BasicUsername == "" && !cleared.bearerToken {
+		auth.BearerToken = stored.BearerToken
+	}
+	if auth.HeaderValue == "" && auth.HeaderName != "" && !cleared.headerValue {
+		auth.HeaderValue = stored.HeaderValue
+	}
+	return auth
+}
+
+// replaceMissingSecrets gives secrets that were set at the source but left
+// out of an export a random value, so an imported endpoint rejects senders
+// until its secrets are entered again instead of accepting everyone. It
+// returns the names of the replaced secrets, so the importer can say which
+// ones to enter.
+func replaceMissingSecrets(auth store.InboundAuth) (store.InboundAuth, []string, error) {
+	var replaced []string
+	for _, secret := range []struct {
+		name  string
+		set   bool
+		value *string
+	}{
+		{"basic_password", auth.BasicPasswordSet, &auth.BasicPassword},
+		{"bearer_token", auth.BearerTokenSet, &auth.BearerToken},
+		{"header_value", auth.HeaderValueSet, &auth.HeaderValue},
+	} {
+		if secret.set && *secret.value == "" {
+			placeholder := make([]byte, 24)
+			if _, err := rand.Read(placeholder); err != nil {
+				return auth, nil, err
+			}
+			*secret.value = store.InboundSecretDigest(hex.EncodeToString(placeholder))
+			replaced = append(replaced, secret.name)
+		}
+	}
+	auth.BasicPasswordSet, auth.BearerTokenSet, auth.HeaderValueSet = false, false, false
+	return auth, replaced, nil
+}
+
+func validateInboundAuth(auth store.InboundAuth) error {
+	if (auth.BasicUsername == "") != (auth.BasicPassword == "") {
+		return errors.New("basic auth needs both a username and a password")
+	}
+	if strings.Contains(auth.BasicUsername, ":") {
+		return errors.New("basic auth username must not contain a colon")
+	}
+	if auth.BasicUsername != "" && auth.BearerToken != "" {
+		return errors.New("basic auth and a bearer token both use the Authorization header; choose one")
+	}
+	if (auth.HeaderName == "") != (auth.HeaderValue == "") {
+		return errors.New("a required header needs both a name and a value")
+	}
+	if auth.HeaderName != "" && !validHeaderName(auth.HeaderName) {
+		return fmt.Errorf("%q is not a valid header name", auth.HeaderName)
+	}
+	if len(auth.BasicUsername) > 200 || len(auth.BasicPassword) > 200 || len(auth.BearerToken) > 500 ||
+		len(auth.HeaderName) > 200 || len(auth.HeaderValue) > 500 {
+		return errors.New("one or more sender authentication settings exceed their maximum length")
+	}
+	if len(auth.AllowedCIDRs) > maxInboundAuthEntries || len(auth.ClientCertSHA256) > maxInboundAuthEntries {
+		return fmt.Errorf("at most %d allowed networks and %d certificate fingerprints are supported", maxInboundAuthEntries, maxInboundAuthEntries)
+	}
+	for _, cidr := range auth.AllowedCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("%q is not an IP address or CIDR range", cidr)
+		}
+	}
+	for _, fingerprint := range auth.ClientCertSHA256 {
+		if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("%q is not a SHA-256 certificate fingerprint", fingerprint)
+		}
+	}
+	return nil
+}
+
+func validHeaderName(name string) bool {
+	for _, c := range name {
+		if c > 127 || !(c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// splitInboundList reads a comma or newline separated form value.
+func splitInboundList(value string) []string {
+	return strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == '\n' || c == '\r' || c == ' ' })
+}
+
+// rejectCapture counts a refused attempt, keeps it when the endpoint asks for
+// that, and answers the sender. It returns the kept request, if any.
+func (h *Handler) rejectCapture(w http.ResponseWriter, r *http.Request, endpoint *store.Endpoint, rejection *inboundRejection, started time.Time) (kept *store.Request) {
+	if err := h.Store.IncrementRejectedCount(r.Context(), endpoint.ID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to count rejected capture", "endpoint_id", endpoint.ID, "error", err)
+	}
+	if endpoint.InboundAuth.StoreRejected {
+		if captured, _, err := h.saveCapture(r, endpoint, &store.Request{StatusCode: rejection.status, RejectedReason: rejection.reason}, nil); err == nil {
+			recorder := &responseRecorder{ResponseWriter: w}
+			defer h.recordResponse(r, captured, recorder, started)
+			w = recorder
+			h.broadcastCapture(r.Context(), captured)
+			kept = captured
+		}
+	}
+	if rejection.challenge != "" {
+		w.Header().Set("WWW-Authenticate", rejection.challenge)
+	}
+	http.Error(w, http.StatusText(rejection.status), rejection.status)
+	return kept
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/metrics"
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/PipeOpsHQ/pipehook/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Capture outcomes used as the outcome label of pipehook_captures_total.
+const (
+	captureStored    = "stored"
+	captureRejected  = "rejected"
+	captureThrottled = "throttled"
+	captureNotFound  = "not_found"
+	captureFailed    = "error"
+)
+
+// Endpoint label values for captures that cannot be attributed to one
+// labelled endpoint.
+const (
+	endpointLabelUnknown = "unknown"
+	endpointLabelOther   = "other"
+)
+
+// handlerMetrics holds the series the handler updates as it serves requests.
+type handlerMetrics struct {
+	captures        *metrics.CounterVec
+	captureDuration *metrics.HistogramVec
+	captureBytes    *metrics.HistogramVec
+	truncations     *metrics.CounterVec
+	forwards        *metrics.CounterVec
+	forwardDuration *metrics.HistogramVec
+	replays         *metrics.CounterVec
+	apiRequests     *metrics.CounterVec
+	storeDuration   *metrics.HistogramVec
+	notifications   *metrics.CounterVec
+
+	// labelled holds the endpoints that have their own label. Endpoints past
+	// the configured limit share the "other" label so that a busy instance
+	// cannot grow the series count without bound.
+	labelMu  sync.Mutex
+	labelled map[string]struct{}
+}
+
+func (h *Handler) registerMetrics() {
+	registry := h.Metrics
+	h.metrics = &handlerMetrics{
+		captures: registry.NewCounter("pipehook_captures_total",
+			"Capture attempts by endpoint and outcome.", "endpoint", "outcome"),
+		captureDuration: registry.NewHistogram("pipehook_capture_duration_seconds",
+			"Time from receiving a capture to finishing its response.", metrics.DefaultDurationBuckets, "outcome"),
+		captureBytes: registry.NewHistogram("pipehook_capture_body_bytes",
+			"Stored capture body sizes in bytes.", metrics.SizeBuckets),
+		truncations: registry.NewCounter("pipehook_capture_truncated_total",
+			"Captures whose body was cut at the configured limit.", "endpoint"),
+		forwards: registry.NewCounter("pipehook_forward_requests_total",
+			"Forwarding attempts by result: success, http_error or failed.", "result"),
+		forwardDuration: registry.NewHistogram("pipehook_forward_duration_seconds",
+			"Time taken by forwarding attempts.", metrics.DefaultDurationBuckets),
+		replays: registry.NewCounter("pipehook_replays_total",
+			"Replays by result: success, http_error or failed.", "result"),
+		apiRequests: registry.NewCounter("pipehook_api_requests_total",
+			"API requests by route, method and status code.", "route", "method", "status"),
+		storeDuration: registry.NewHistogram("pipehook_store_query_duration_seconds",
+			"Time taken by database calls by store method.", metrics.DefaultDurationBuckets, "method"),
+		notifications: registry.NewCounter("pipehook_notifications_total",
+			"Notification messages by channel kind and result: sent, failed or dropped.", "kind", "result"),
+		labelled: make(map[string]struct{}),
+	}
+	registry.NewGaugeFunc("pipehook_websocket_clients", "Connected live view WebSocket clients.", nil, func() []metrics.Sample {
+		h.clientsMu.RLock()
+		defer h.clientsMu.RUnlock()
+		total := 0
+		for _, conns := range h.clients {
+			total += len(conns)
+		}
+		return []metrics.Sample{{Value: float64(total)}}
+	})
+	registry.NewCounterFunc("pipehook_rate_limit_requests_total", "Requests checked by each rate limiter by result.",
+		[]string{"limiter", "result"}, func() []metrics.Sample {
+			stats := h.RateLimitStats()
+			return []metrics.Sample{
+				{Labels: []string{"api_key", "allowed"}, Value: float64(stats.APIKeyAllowed)},
+				{Labels: []string{"api_key", "rejected"}, Value: float64(stats.APIKeyRejected)},
+				{Labels: []string{"client_ip", "allowed"}, Value: float64(stats.ClientIPAllowed)},
+				{Labels: []string{"client_ip", "rejected"}, Value: float64(stats.ClientIPRejected)},
+				{Labels: []string{"capture_ip", "allowed"}, Value: float64(stats.CaptureIPAllowed)},
+				{Labels: []string{"capture_ip", "rejected"}, Value: float64(stats.CaptureIPRejected)},
+				{Labels: []string{"endpoint", "rejected"}, Value: float64(stats.EndpointRejected)},
+			}
+		})
+}
+
+// endpointLabel returns the label for captures to endpoint: its ID while
+// fewer than limit endpoints are labelled, otherwise "other".
+func (m *handlerMetrics) endpointLabel(endpoint *store.Endpoint, limit int) string {
+	if endpoint == nil {
+		return endpointLabelUnknown
+	}
+	if limit <= 0 {
+		return endpointLabelOther
+	}
+	m.labelMu.Lock()
+	defer m.labelMu.Unlock()
+	if _, ok := m.labelled[endpoint.ID]; ok {
+		return endpoint.ID
+	}
+	if len(m.labelled) < limit {
+		m.labelled[endpoint.ID] = struct{}{}
+		return endpoint.ID
+	}
+	return endpointLabelOther
+}
+
+// labelledEndpoints is how many endpoints may have their own label. Endpoint
+// IDs are capture URLs, so they are only used as labels while /metrics
+// needs a token.
+func (h *Handler) labelledEndpoints() int {
+	config := h.runtimeConfig()
+	if config.MetricsToken == "" {
+		return 0
+	}
+	return config.MetricsEndpoints
+}
+
+// observeCapture counts a finished capture attempt. endpoint is nil when the
+// attempt was refused before the endpoint was looked up or it does not exist.
+func (h *Handler) observeCapture(endpoint *store.Endpoint, outcome string, started time.Time) {
+	h.metrics.captures.Inc(h.metrics.endpointLabel(endpoint, h.labelledEndpoints()), outcome)
+	h.metrics.captureDuration.Observe(time.Since(started).Seconds(), outcome)
+}
+
+func (h *Handler) observeCaptureBody(endpoint *store.Endpoint, captured *store.Request) {
+	h.metrics.captureBytes.Observe(float64(len(captured.Body)))
+	if captured.BodyTruncated {
+		h.metrics.truncations.Inc(h.metrics.endpointLabel(endpoint, h.labelledEndpoints()))
+	}
+}
+
+// forgetEndpointMetrics drops a deleted endpoint's series and frees its
+// label for another endpoint.
+func (h *Handler) forgetEndpointMetrics(endpointID string) {
+	h.metrics.labelMu.Lock()
+	_, labelled := h.metrics.labelled[endpointID]
+	delete(h.metrics.labelled, endpointID)
+	h.metrics.labelMu.Unlock()
+	if labelled {
+		h.metrics.captures.DeleteLabel("endpoint", endpointID)
+		h.metrics.truncations.DeleteLabel("endpoint", endpointID)
+	}
+}
+
+// ForgetDeletedEndpointMetrics releases the labels of endpoints that no
+// longer exist, such as those removed by cleanup once they expire.
+func (h *Handler) ForgetDeletedEndpointMetrics(ctx context.Context) {
+	h.metrics.labelMu.Lock()
+	ids := slices.Collect(maps.Keys(h.metrics.labelled))
+	h.metrics.labelMu.Unlock()
+	for _, id := range ids {
+		if _, err := h.Store.GetEndpoint(ctx, id); errors.Is(err, sql.ErrNoRows) {
+			h.forgetEndpointMetrics(id)
+		}
+	}
+}
+
+// outboundResult names the outcome of a forward or replay for metrics.
+func outboundResult(response *http.Response, err error) string {
+	switch {
+	case err != nil:
+		return "failed"
+	case response.StatusCode >= http.StatusBadRequest:
+		return "http_error"
+	}
+	return "success"
+}
+
+// observeStore times store calls and, within a traced request, records a
+// span for each. It is passed to store.Instrument.
+func (h *Handler) observeStore(ctx context.Context, method string) func(error) {
+	started := time.Now()
+	var span *tracing.Span
+	if tracing.SpanFromContext(ctx) != nil {
+		_, span = h.Tracer.Start(ctx, "store."+method, tracing.KindInternal)
+		span.SetAttribute("db.system", "sqlite")
+	}
+	return func(err error) {
+		h.metrics.storeDuration.Observe(time.Since(started).Seconds(), method)
+		if !errors.Is(err, sql.ErrNoRows) {
+			span.SetError(err)
+		}
+		span.End()
+	}
+}
+
+// APIMetrics counts API requests by route pattern so that IDs in paths do
+// not create new series.
+func (h *Handler) APIMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		route := "unmatched"
+		if routeContext := chi.RouteContext(r.Context()); routeContext != nil {
+			pattern := routeContext.RoutePattern()
+			// Requests refused by an earlier middleware never reach their
+			// route, so look up the pattern they would have matched.
+			if strings.HasSuffix(pattern, "*") && routeContext.Routes != nil {
+				pattern = routeContext.Routes.Find(chi.NewRouteContext(), r.Method, r.URL.Path)
+			}
+			if pattern != "" && !strings.HasSuffix(pattern, "*") {
+				route = pattern
+			}
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		method := r.Method
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		default:
+			method = "OTHER"
+		}
+		h.metrics.apiRequests.Inc(route, method, strconv.Itoa(status))
+	})
+}
+
+// ServeMetrics writes every metric in the Prometheus text format. When a
+// metrics token is configured it must be sent as a bearer token.
+func (h *Handler) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	if token := h.runtimeConfig().MetricsToken; token != "" {
+		header := r.Header.Get("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") || !secretEqual(strings.TrimSpace(header[7:]), token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = h.Metrics.WriteText(w)
+}
@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	ndjsonEndpoint = "endpoint"
+	ndjsonRequest  = "request"
+
+	// maxNDJSONImportBytes caps an import through the API. The import
+	// command reads files of any size.
+	maxNDJSONImportBytes = 512 * 1024 * 1024
+
+	// maxEndpointIDSize bounds the endpoint IDs an import may bring along.
+	maxEndpointIDSize = 128
+)
+
+// ndjsonRecord is one line of an NDJSON export. Each endpoint line, with the
+// endpoint's response sequences and assertions, comes before the lines of its requests,
+// which are in the order they were stored.
+type ndjsonRecord struct {
+	Type       string                    `json:"type"`
+	Endpoint   *store.Endpoint           `json:"endpoint,omitempty"`
+	Sequences  []*store.ResponseSequence `json:"sequences,omitempty"`
+	Assertions []*store.Assertion        `json:"assertions,omitempty"`
+	Request    *store.Request            `json:"request,omitempty"`
+}
+
+// ImportedEndpoint reports where an exported endpoint ended up. ID differs
+// from SourceID when the source ID was already taken. ReplacedSecrets names
+// the sender authentication secrets that the export left out and that were
+// given random values; they must be entered again.
+type ImportedEndpoint struct {
+	SourceID        string   `json:"source_id"`
+	ID              string   `json:"id"`
+	Requests        int      `json:"requests"`
+	ReplacedSecrets []string `json:"replaced_secrets,omitempty"`
+}
+
+type ImportResult struct {
+	Endpoints []*ImportedEndpoint `json:"endpoints"`
+}
+
+// writeNDJSON streams endpoints and all of their requests.
+func (h *Handler) writeNDJSON(ctx context.Context, w io.Writer, endpoints []*store.Endpoint) error {
+	encoder := json.NewEncoder(w)
+	for _, endpoint := range endpoints {
+		sequences, err := h.Store.ListResponseSequences(ctx, endpoint.ID)
+		if err != nil {
+			return err
+		}
+		assertions, err := h.Store.ListAssertions(ctx, endpoint.ID)
+		if err != nil {
+			return err
+		}
+		record := ndjsonRecord{Type: ndjsonEndpoint, Endpoint: endpoint, Sequences: sequences, Assertions: assertions}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+		for afterID := int64(0); ; {
+			requests, err := h.Store.ListRequestsAfter(ctx, endpoint.ID, afterID, exportPageSize)
+			if err != nil {
+				return err
+			}
+			for _, request := range requests {
+				if err := encoder.Encode(ndjsonRecord{Type: ndjsonRequest, Request: request}); err != nil {
+					return err
+				}
+				afterID = request.ID
+			}
+			if len(requests) < exportPageSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ImportNDJSON loads an NDJSON export. Endpoints keep their IDs unless one
+// is taken, in which case they get a new ID and their requests' capture
+// paths follow. Requests get new IDs in file order and keep their
+// timestamps, bodies and responses. Ownership is not carried over: imported
+// endpoints have no creator and belong to the account ownerUserID, or to no
+// one when it is empty. Import stops at the first invalid line, keeping what
+// was imported before it.
+func (h *Handler) ImportNDJSON(ctx context.Context, r io.Reader, ownerUserID string) (*ImportResult, error) {
+	result := &ImportResult{Endpoints: []*ImportedEndpoint{}}
+	imported := make(map[string]*ImportedEndpoint)
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var record ndjsonRecord
+		if err := decoder.Decode(&record); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return result, fmt.Errorf("line %d: invalid JSON", line)
+		}
+		switch {
+		case record.Type == ndjsonEndpoint && record.Endpoint != nil:
+			endpoint, err := h.importNDJSONEndpoint(ctx, record, ownerUserID)
+			if err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+			imported[record.Endpoint.ID] = endpoint
+			result.Endpoints = append(result.Endpoints, endpoint)
+		case record.Type == ndjsonRequest && record.Request != nil:
+			endpoint := imported[record.Request.EndpointID]
+			if endpoint == nil {
+				return result, fmt.Errorf("line %d: request for endpoint %q that is not in the file", line, record.Request.EndpointID)
+			}
+			request := record.Request
+			request.ID, request.EndpointID = 0, endpoint.ID
+			if rest, ok := strings.CutPrefix(request.Path, "/h/"+endpoint.SourceID); ok {
+				request.Path = "/h/" + endpoint.ID + rest
+			}
+			if err := h.Store.SaveRequest(ctx, request); err != nil {
+				slog.ErrorContext(ctx, "failed to import request", "endpoint_id", endpoint.ID, "error", err)
+				return result, fmt.Errorf("line %d: failed to save request", line)
+			}
+			endpoint.Requests++
+		default:
+			return result, fmt.Errorf("line %d: unknown record type %q", line, record.Type)
+		}
+	}
+	for _, endpoint := range result.Endpoints {
+		settings, err := h.Store.GetEndpoint(ctx, endpoint.ID)
+		if err != nil {
+			continue
+		}
+		if err := h.Store.TrimRequests(ctx, endpoint.ID, settings.RequestLimit); err != nil {
+			slog.ErrorContext(ctx, "failed to enforce request retention", "endpoint_id", endpoint.ID, "error", err)
+		}
+	}
+	return result, nil
+}
+
+func (h *Handler) importNDJSONEndpoint(ctx context.Context, record ndjsonRecord, ownerUserID string) (*ImportedEndpoint, error) {
+	source := record.Endpoint
+	if source.ID == "" || len(source.ID) > maxEndpointIDSize {
+		return nil, fmt.Errorf("endpoint needs an ID of at most %d characters", maxEndpointIDSize)
+	}
+	auth, replaced, err := replaceMissingSecrets(source.InboundAuth)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint %s: failed to protect sender authentication", source.ID)
+	}
+	source.InboundAuth = auth
+	if err := validateEndpointSettings(source.Settings()); err != nil {
+		return nil, fmt.Errorf("endpoint %s: %w", source.ID, err)
+	}
+	sequences := make([]sequenceInput, len(record.Sequences))
+	for i, sequence := range record.Sequences {
+		sequences[i] = normalizeSequence(sequenceInput{Path: sequence.Path, Mode: sequence.Mode, Steps: sequence.Steps})
+		if err := validateSequence(sequences[i]); err != nil {
+			return nil, fmt.Errorf("endpoint %s: response sequence: %w", source.ID, err)
+		}
+	}
+	assertions := make([]assertionInput, len(record.Assertions))
+	for i, assertion := range record.Assertions {
+		assertions[i] = normalizeAssertion(assertionInput{
+			Path: assertion.Path, Methods: assertion.Methods, Headers: assertion.Headers, Schema: assertion.Schema, RejectStatus: assertion.RejectStatus,
+		})
+		if _, err := validateAssertion(assertions[i]); err != nil {
+			return nil, fmt.Errorf("endpoint %s: assertion: %w", source.ID, err)
+		}
+	}
+
+	endpoint := *source
+	endpoint.CreatorID, endpoint.OwnerUserID, endpoint.WorkspaceID = "", ownerUserID, ""
+	if _, err := h.Store.GetEndpoint(ctx, endpoint.ID); err == nil {
+		endpoint.ID = uuid.NewString()
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(ctx, "failed to check endpoint", "endpoint_id", source.ID, "error", err)
+		return nil, fmt.Errorf("endpoint %s: failed to check for an existing endpoint", source.ID)
+	}
+	if err := h.Store.ImportEndpoint(ctx, &endpoint); err != nil {
+		slog.ErrorContext(ctx, "failed to import endpoint", "endpoint_id", source.ID, "error", err)
+		return nil, fmt.Errorf("endpoint %s: failed to save endpoint", source.ID)
+	}
+	for _, sequence := range sequences {
+		if err := h.Store.SetResponseSequence(ctx, &store.ResponseSequence{
+			EndpointID: endpoint.ID, Path: sequence.Path, Mode: sequence.Mode, Steps: sequence.Steps,
+		}); err != nil {
+			slog.ErrorContext(ctx, "failed to import response sequence", "endpoint_id", endpoint.ID, "error", err)
+			return nil, fmt.Errorf("endpoint %s: failed to save response sequence", source.ID)
+		}
+	}
+	for _, assertion := range assertions {
+		if err := h.Store.SetAssertion(ctx, &store.Assertion{
+			EndpointID: endpoint.ID, Path: assertion.Path, Methods: assertion.Methods, Headers: assertion.Headers,
+			Schema: assertion.Schema, RejectStatus: assertion.RejectStatus,
+		}); err != nil {
+			slog.ErrorContext(ctx, "failed to import assertion", "endpoint_id", endpoint.ID, "error", err)
+			return nil, fmt.Errorf("endpoint %s: failed to save assertion", source.ID)
+		}
+	}
+	return &ImportedEndpoint{SourceID: source.ID, ID: endpoint.ID, ReplacedSecrets: replaced}, nil
+}
+
+func startNDJSON(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+}
+
+// ExportEndpointNDJSON downloads the endpoint, its settings and every
+// request as NDJSON for importing into another instance. Assertions can
+// expect secret header values, so the export needs edit access, like the
+// assertion list.
+func (h *Handler) ExportEndpointNDJSON(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	startNDJSON(w, "pipehook-"+endpoint.ID+".ndjson")
+	if err := h.writeNDJSON(r.Context(), w, []*store.Endpoint{endpoint}); err != nil {
+		slog.ErrorContext(r.Context(), "failed to export endpoint", "endpoint_id", endpoint.ID, "error", err)
+	}
+}
+
+// APIExportEndpointNDJSON is ExportEndpointNDJSON for API keys, which need
+// the write scope.
+func (h *Handler) APIExportEndpointNDJSON(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	startNDJSON(w, "pipehook-"+endpoint.ID+".ndjson")
+	if err := h.writeNDJSON(r.Context(), w, []*store.Endpoint{endpoint}); err != nil {
+		slog.ErrorContext(r.Context(), "failed to export endpoint", "endpoint_id", endpoint.ID, "error", err)
+	}
+}
+
+// APIExportNDJSON exports every unexpired endpoint, for moving a whole
+// instance.
+func (h *Handler) APIExportNDJSON(w http.ResponseWriter, r *http.Request) {
+	if rejectRestrictedAPIKey(w, r, "export every endpoint") {
+		return
+	}
+	startNDJSON(w, "pipehook.ndjson")
+	for offset := 0; ; offset += exportPageSize {
+		endpoints, err := h.Store.ListAllEndpoints(r.Context(), exportPageSize, offset)
+		if err == nil {
+			err = h.writeNDJSON(r.Context(), w, endpoints)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to export endpoints", "error", err)
+			return
+		}
+		if len(endpoints) < exportPageSize {
+			return
+		}
+	}
+}
+
+// APIImportNDJSON imports the NDJSON export in the request body. ?owner=
+// names the account that gets the imported endpoints.
+func (h *Handler) APIImportNDJSON(w http.ResponseWriter, r *http.Request) {
+	if rejectRestrictedAPIKey(w, r, "import endpoints") {
+		return
+	}
+	var ownerUserID string
+	if username := strings.TrimSpace(r.URL.Query().Get("owner")); username != "" {
+		owner, _, err := h.Store.GetUserByUsername(r.Context(), username)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("no account is called %q", username)})
+			return
+		} else if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to look up the owner"})
+			return
+		}
+		ownerUserID = owner.ID
+	}
+	result, err := h.ImportNDJSON(r.Context(), http.MaxBytesReader(w, r.Body, maxNDJSONImportBytes), ownerUserID)
+	for _, endpoint := range result.Endpoints {
+		h.audit(r, auditRequestImport, "endpoint", endpoint.ID, map[string]any{
+			"format": "ndjson", "source_id": endpoint.SourceID, "count": endpoint.Requests,
+		})
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "imported": result})
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PipeOpsHQ/pipehook/internal/notify"
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	maxNotificationChannels   = 10
+	maxNotificationRecipients = 10
+	maxNotificationMatch      = 200
+	maxNotificationDigest     = 24 * 60 * 60
+	minEmailDigest            = 5 * 60
+)
+
+type notificationInput struct {
+	Kind          string `json:"kind"`
+	Target        string `json:"target"`
+	Match         string `json:"match"`
+	DigestSeconds int    `json:"digest_seconds"`
+}
+
+// validateNotification checks a channel before it is stored. Webhook and
+// Slack targets follow the forwarding URL rules; email targets need SMTP and
+// a digest, so a flood of captures sends at most one message per interval.
+func (h *Handler) validateNotification(input *notificationInput) error {
+	input.Kind, input.Target, input.Match = strings.TrimSpace(input.Kind), strings.TrimSpace(input.Target), strings.TrimSpace(input.Match)
+	switch input.Kind {
+	case store.NotifyWebhook, store.NotifySlack:
+		if input.Target == "" || validateForwardURL(input.Target) != nil {
+			return errors.New("target must be an absolute http(s) URL without credentials")
+		}
+	case store.NotifyEmail:
+		if !h.runtimeConfig().SMTP.Enabled() {
+			return errors.New("email notifications need SMTP settings on the server")
+		}
+		recipients, err := notify.Recipients(input.Target)
+		if err != nil {
+			return err
+		}
+		if len(recipients) > maxNotificationRecipients {
+			return fmt.Errorf("at most %d email recipients are allowed", maxNotificationRecipients)
+		}
+		if input.DigestSeconds < minEmailDigest {
+			return fmt.Errorf("email notifications need a digest of at least %d seconds", minEmailDigest)
+		}
+	default:
+		return errors.New("kind must be webhook, slack or email")
+	}
+	if len(input.Match) > maxNotificationMatch {
+		return fmt.Errorf("match must be at most %d characters", maxNotificationMatch)
+	}
+	if input.DigestSeconds < 0 || input.DigestSeconds > maxNotificationDigest {
+		return fmt.Errorf("digest_seconds must be between 0 and %d", maxNotificationDigest)
+	}
+	return nil
+}
+
+// createNotification validates and stores a channel. The returned status
+// tells the caller whether an error was the input's fault.
+func (h *Handler) createNotification(r *http.Request, endpointID string, input notificationInput) (*store.NotificationChannel, int, error) {
+	if err := h.validateNotification(&input); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	// Anyone can create an endpoint, so email, which reaches arbitrary
+	// inboxes from this server's address, is kept to accountable callers.
+	if input.Kind == store.NotifyEmail && apiKeyFromContext(r.Context()) == nil && currentUser(r) == nil && !h.IsAdminAuthenticated(r) {
+		return nil, http.StatusForbidden, errors.New("email notifications need a signed-in account")
+	}
+	existing, err := h.Store.ListNotificationChannels(r.Context(), endpointID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list notification channels", "endpoint_id", endpointID, "error", err)
+		return nil, http.StatusInternalServerError, errors.New("failed to save notification channel")
+	}
+	if len(existing) >= maxNotificationChannels {
+		return nil, http.StatusBadRequest, fmt.Errorf("an endpoint can have at most %d notification channels", maxNotificationChannels)
+	}
+	channel := &store.NotificationChannel{
+		ID: uuid.NewString(), EndpointID: endpointID, Kind: input.Kind, Target: input.Target, Match: input.Match, DigestSeconds: input.DigestSeconds,
+	}
+	if err := h.Store.CreateNotificationChannel(r.Context(), channel); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save notification channel", "endpoint_id", endpointID, "error", err)
+		return nil, http.StatusInternalServerError, errors.New("failed to save notification channel")
+	}
+	// The target can hold a webhook secret, so it stays out of the audit log.
+	h.audit(r, auditNotificationCreate, "endpoint", endpointID, map[string]any{
+		"channel": channel.ID, "kind": channel.Kind, "match": channel.Match, "digest_seconds": channel.DigestSeconds,
+	})
+	return channel, http.StatusCreated, nil
+}
+
+func (h *Handler) deleteNotification(r *http.Request, endpointID, channelID string) (int, error) {
+	if err := h.Store.DeleteNotificationChannel(r.Context(), endpointID, channelID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return http.StatusNotFound, errors.New("notification channel not found")
+		}
+		slog.ErrorContext(r.Context(), "failed to delete notification channel", "endpoint_id", endpointID, "error", err)
+		return http.StatusInternalServerError, errors.New("failed to delete notification channel")
+	}
+	h.notifier.Forget(channelID)
+	h.audit(r, auditNotificationDelete, "endpoint", endpointID, map[string]string{"channel": channelID})
+	return http.StatusNoContent, nil
+}
+
+// notifyCapture hands a stored capture to the endpoint's channels whose
+// match filter, a dashboard search, it passes.
+func (h *Handler) notifyCapture(r *http.Request, captured *store.Request) {
+	channels, err := h.Store.ListNotificationChannels(r.Context(), captured.EndpointID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list notification channels", "endpoint_id", captured.EndpointID, "error", err)
+		return
+	}
+	if len(channels) == 0 {
+		return
+	}
+	capture := notify.Capture{
+		EndpointID: captured.EndpointID, RequestID: captured.ID, Method: captured.Method, Path: captured.Path,
+		QueryString: captured.QueryString, RemoteAddr: captured.RemoteAddr, StatusCode: captured.StatusCode,
+		Size: len(captured.Body), RejectedReason: captured.RejectedReason, CapturedAt: captured.CreatedAt,
+	}
+	// The sender controls the request's host, so links only come from the
+	// configured public URL.
+	if publicURL := h.runtimeConfig().PublicURL; publicURL != "" {
+		capture.URL = publicURL + "/" + captured.EndpointID
+	}
+	for _, channel := range channels {
+		if captured.MatchesSearch(channel.Match) {
+			h.notifier.Notify(channel, capture)
+		}
+	}
+}
+
+// APIListNotifications returns the endpoint's channels. Targets can hold
+// secrets, so listing needs edit access.
+func (h *Handler) APIListNotifications(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	channels, err := h.Store.ListNotificationChannels(r.Context(), endpoint.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list notification channels"})
+		return
+	}
+	writeJSON(w, http.StatusOK, channels)
+}
+
+func (h *Handler) APICreateNotification(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	var input notificationInput
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	channel, status, err := h.createNotification(r, endpoint.ID, input)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, status, channel)
+}
+
+func (h *Handler) APIDeleteNotification(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	if status, err := h.deleteNotification(r, endpoint.ID, chi.URLParam(r, "channelID")); err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateNotification handles the settings form.
+func (h *Handler) CreateNotification(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 64*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	input := notificationInput{Kind: r.FormValue("kind"), Target: r.FormValue("target"), Match: r.FormValue("match")}
+	if digest := strings.TrimSpace(r.FormValue("digest_seconds")); digest != "" {
+		seconds, err := strconv.Atoi(digest)
+		if err != nil {
+			http.Error(w, "digest_seconds must be a number", http.StatusBadRequest)
+			return
+		}
+		input.DigestSeconds = seconds
+	}
+	if _, status, err := h.createNotification(r, endpoint.ID, input); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("HX-Redirect", "/"+endpoint.ID)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) DeleteNotification(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	if status, err := h.deleteNotification(r, endpoint.ID, chi.URLParam(r, "channelID")); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,379 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+const (
+	maxThrottleRequestsPerSecond = 10000
+	maxThrottleBurst             = 100000
+	// maxPendingThrottled caps the endpoint IDs counted between flushes, as
+	// the sender limit counts drops before the ID is known to exist.
+	maxPendingThrottled = 10000
+)
+
+// RateLimit describes a token bucket: it refills at RequestsPerMinute and
+// holds at most Burst tokens.
+type RateLimit struct {
+	RequestsPerMinute int
+	Burst             int
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type rateLimitDecision struct {
+	allowed    bool
+	limit      int
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// tokenBucketLimiter keeps an independent bucket per key (API key ID or
+// client IP). Buckets that have refilled completely carry no state and are
+// pruned periodically.
+type tokenBucketLimiter struct {
+	mu        sync.Mutex
+	perSecond float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+	allowed   atomic.Uint64
+	rejected  atomic.Uint64
+}
+
+func newTokenBucketLimiter(limit RateLimit) *tokenBucketLimiter {
+	limiter := &tokenBucketLimiter{buckets: make(map[string]*tokenBucket)}
+	limiter.configure(limit)
+	return limiter
+}
+
+func (l *tokenBucketLimiter) configure(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.perSecond = math.Max(float64(limit.RequestsPerMinute), 1) / 60
+	l.burst = math.Max(float64(limit.Burst), 1)
+	for _, bucket := range l.buckets {
+		bucket.tokens = math.Min(bucket.tokens, l.burst)
+	}
+}
+
+func (l *tokenBucketLimiter) allow(key string, now time.Time) rateLimitDecision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.updated).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.perSecond)
+		bucket.updated = now
+	}
+
+	decision := rateLimitDecision{limit: int(l.burst)}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.allowed = true
+		l.allowed.Add(1)
+	} else {
+		decision.retryAfter = time.Duration((1 - bucket.tokens) / l.perSecond * float64(time.Second))
+		l.rejected.Add(1)
+	}
+	decision.remaining = int(math.Floor(bucket.tokens))
+	decision.reset = time.Duration((l.burst - bucket.tokens) / l.perSecond * float64(time.Second))
+	return decision
+}
+
+func (l *tokenBucketLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	fullAfter := time.Duration(l.burst / l.perSecond * float64(time.Second))
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) > fullAfter {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// writeRateLimitHeaders reports the bucket state using the conventional
+// X-RateLimit headers. Reset is the number of seconds until the bucket is full.
+func writeRateLimitHeaders(w http.ResponseWriter, decision rateLimitDecision) {
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.limit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.reset)))
+	if !decision.allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(decision.retryAfter))))
+	}
+}
+
+func ceilSeconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
+
+// clientIP returns the address of the client. Requests from a trusted proxy
+// are attributed to the nearest untrusted address in X-Forwarded-For, read
+// from the right, or else to X-Real-IP. Other peers cannot pick their address.
+func (h *Handler) clientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+	proxies := h.runtimeConfig().TrustedProxies
+	trusted := func(address string) (netip.Addr, bool) {
+		addr, err := netip.ParseAddr(strings.TrimSpace(address))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = addr.Unmap()
+		return addr, slices.ContainsFunc(proxies, func(proxy netip.Prefix) bool { return proxy.Contains(addr) })
+	}
+	if !h.fromTrustedProxy(r) {
+		return peer
+	}
+	if forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ","); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := trusted(hops[i])
+			if !addr.IsValid() {
+				break
+			}
+			if !ok || i == 0 {
+				return addr.String()
+			}
+		}
+	}
+	if addr, _ := trusted(r.Header.Get("X-Real-IP")); addr.IsValid() {
+		return addr.String()
+	}
+	return peer
+}
+
+// fromTrustedProxy reports whether the request comes straight from one of the
+// trusted proxies, whose forwarding headers are believed.
+func (h *Handler) fromTrustedProxy(r *http.Request) bool {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		peer = host
+	}
+	addr, err := netip.ParseAddr(peer)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(h.runtimeConfig().TrustedProxies, func(proxy netip.Prefix) bool { return proxy.Contains(addr) })
+}
+
+// ClientIPRateLimit throttles unauthenticated routes per client IP.
+func (h *Handler) ClientIPRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.allowClientIP(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) allowClientIP(w http.ResponseWriter, r *http.Request) bool {
+	decision := h.clientIPLimiter.allow(h.clientIP(r), time.Now())
+	writeRateLimitHeaders(w, decision)
+	if !decision.allowed {
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	}
+	return decision.allowed
+}
+
+// endpointLimiters holds one single-bucket limiter per throttled endpoint.
+// Each limiter is reconfigured when the endpoint's settings change and
+// dropped once its throttle is turned off.
+type endpointLimiters struct {
+	mu       sync.Mutex
+	limiters map[string]*endpointLimiter
+	rejected atomic.Uint64
+}
+
+type endpointLimiter struct {
+	throttle store.Throttle
+	limiter  *tokenBucketLimiter
+}
+
+func newEndpointLimiters() *endpointLimiters {
+	return &endpointLimiters{limiters: make(map[string]*endpointLimiter)}
+}
+
+func (e *endpointLimiters) allow(endpoint *store.Endpoint, now time.Time) rateLimitDecision {
+	e.mu.Lock()
+	entry, ok := e.limiters[endpoint.ID]
+	if !endpoint.Throttle.Enabled() {
+		delete(e.limiters, endpoint.ID)
+		e.mu.Unlock()
+		return rateLimitDecision{allowed: true}
+	}
+	limit := RateLimit{RequestsPerMinute: endpoint.Throttle.RequestsPerSecond * 60, Burst: endpoint.Throttle.Burst}
+	if !ok {
+		entry = &endpointLimiter{throttle: endpoint.Throttle, limiter: newTokenBucketLimiter(limit)}
+		e.limiters[endpoint.ID] = entry
+	} else if entry.throttle != endpoint.Throttle {
+		entry.throttle = endpoint.Throttle
+		entry.limiter.configure(limit)
+	}
+	e.mu.Unlock()
+
+	decision := entry.limiter.allow(endpoint.ID, now)
+	if !decision.allowed {
+		e.rejected.Add(1)
+	}
+	return decision
+}
+
+// normalizeThrottle defaults the burst to one second of requests.
+func normalizeThrottle(throttle store.Throttle) store.Throttle {
+	if throttle.Burst == 0 {
+		throttle.Burst = throttle.RequestsPerSecond
+	}
+	if !throttle.Enabled() {
+		throttle.Burst = 0
+	}
+	return throttle
+}
+
+func validateThrottle(throttle store.Throttle) error {
+	if throttle.RequestsPerSecond < 0 || throttle.RequestsPerSecond > maxThrottleRequestsPerSecond {
+		return fmt.Errorf("rate limit must be between 0 and %d requests per second", maxThrottleRequestsPerSecond)
+	}
+	if throttle.Burst < 0 || throttle.Burst > maxThrottleBurst {
+		return fmt.Errorf("rate limit burst must be between 0 and %d requests", maxThrottleBurst)
+	}
+	if len(throttle.Body) > 64*1024 {
+		return errors.New("rate limit response body must not exceed 64KB")
+	}
+	return nil
+}
+
+// allowCaptureSource applies the per-sender limit to a capture. It runs before
+// the endpoint is loaded so a flood costs no database reads; dropped attempts
+// are still counted on the endpoint they targeted.
+func (h *Handler) allowCaptureSource(w http.ResponseWriter, r *http.Request, endpointID string) bool {
+	decision := h.captureIPLimiter.allow(h.clientIP(r), time.Now())
+	if decision.allowed {
+		return true
+	}
+	h.countThrottled(endpointID)
+	writeRateLimitHeaders(w, decision)
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	return false
+}
+
+// allowCaptureEndpoint applies the endpoint's own throttle, answering with
+// its configured body when the bucket is empty.
+func (h *Handler) allowCaptureEndpoint(w http.ResponseWriter, r *http.Request, endpoint *store.Endpoint) bool {
+	decision := h.endpointLimiters.allow(endpoint, time.Now())
+	if decision.allowed {
+		return true
+	}
+	h.countThrottled(endpoint.ID)
+	writeRateLimitHeaders(w, decision)
+	body := endpoint.Throttle.Body
+	if body == "" {
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	if endpoint.EnableCORS {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	if endpoint.DefaultContentType != "" {
+		w.Header().Set("Content-Type", endpoint.DefaultContentType)
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write([]byte(body))
+	}
+	return false
+}
+
+// throttledCounter counts dropped captures per endpoint in memory, so a
+// flood costs no database writes. flush adds the counts to the endpoints.
+type throttledCounter struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func newThrottledCounter() *throttledCounter {
+	return &throttledCounter{counts: make(map[string]int64)}
+}
+
+func (c *throttledCounter) add(endpointID string) {
+	if len(endpointID) > maxEndpointIDSize {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.counts[endpointID]; !ok && len(c.counts) >= maxPendingThrottled {
+		return
+	}
+	c.counts[endpointID]++
+}
+
+// take returns the counts since the last call and starts over.
+func (c *throttledCounter) take() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	taken := c.counts
+	c.counts = make(map[string]int64)
+	return taken
+}
+
+func (h *Handler) countThrottled(endpointID string) {
+	h.throttled.add(endpointID)
+}
+
+// FlushThrottledCounts stores the captures dropped since the last flush on
+// their endpoints. Counts for IDs of no endpoint are discarded.
+func (h *Handler) FlushThrottledCounts(ctx context.Context) error {
+	counts := h.throttled.take()
+	if len(counts) == 0 {
+		return nil
+	}
+	return h.Store.AddThrottledCounts(ctx, counts)
+}
+
+// RateLimitStats counts allowed and rejected requests per limiter.
+type RateLimitStats struct {
+	APIKeyAllowed     uint64
+	APIKeyRejected    uint64
+	ClientIPAllowed   uint64
+	ClientIPRejected  uint64
+	CaptureIPAllowed  uint64
+	CaptureIPRejected uint64
+	EndpointRejected  uint64
+}
+
+func (h *Handler) RateLimitStats() RateLimitStats {
+	return RateLimitStats{
+		APIKeyAllowed: h.apiKeyLimiter.allowed.Load(), APIKeyRejected: h.apiKeyLimiter.rejected.Load(),
+		ClientIPAllowed: h.clientIPLimiter.allowed.Load(), ClientIPRejected: h.clientIPLimiter.rejected.Load(),
+		CaptureIPAllowed: h.captureIPLimiter.allowed.Load(), CaptureIPRejected: h.captureIPLimiter.rejected.Load(),
+		EndpointRejected: h.endpointLimiters.rejected.Load(),
+	}
+}
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -18,13 +17,13 @@ func (h *Handler) ReplayRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request ID", http.StatusBadRequest)
 		return
 	}
-	captured, ok := h.requireRequestAccess(w, r, id)
+	captured, ok := h.requireRequestAccess(w, r, id, permEdit)
 	if !ok {
 		return
 	}
 
 	target := url.URL{
-		Scheme: requestScheme(r), Host: r.Host, Path: "/h/" + captured.EndpointID + replayRelativePath(captured),
+		Scheme: h.requestScheme(r), Host: r.Host, Path: "/h/" + captured.EndpointID + replayRelativePath(captured),
 		RawQuery: captured.QueryString,
 	}
 	replay, err := http.NewRequestWithContext(r.Context(), captured.Method, target.String(), bytes.NewReader(captured.Body))
@@ -34,13 +33,17 @@ func (h *Handler) ReplayRequest(w http.ResponseWriter, r *http.Request) {
 	}
 	copyReplayHeaders(replay.Header, captured.Headers)
 
-	client := &http.Client{Timeout: 10 * time.Second}
+	client := &http.Client{Timeout: h.runtimeConfig().ReplayTimeout}
 	response, err := client.Do(replay)
+	h.metrics.replays.Inc(outboundResult(response, err))
 	if err != nil {
 		http.Error(w, "failed to replay request", http.StatusBadGateway)
 		return
 	}
 	defer response.Body.Close()
+	h.audit(r, auditRequestReplay, "request", strconv.FormatInt(captured.ID, 10), map[string]any{
+		"endpoint_id": captured.EndpointID, "status_code": response.StatusCode,
+	})
 	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 32*1024))
 	w.Header().Set("HX-Trigger", "requestReplayed")
 	w.WriteHeader(http.StatusOK)
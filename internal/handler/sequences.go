@@ -0,0 +1,273 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxSequenceSteps    = 100
+	maxSequencePathSize = 512
+)
+
+type sequenceInput struct {
+	Path  string               `json:"path"`
+	Mode  string               `json:"mode"`
+	Steps []store.SequenceStep `json:"steps"`
+}
+
+// sequenceView adds the editable text form of the steps for the settings page.
+type sequenceView struct {
+	*store.ResponseSequence
+	StepsText string
+}
+
+// NextStep is the 1-based step the next capture will get.
+func (v sequenceView) NextStep() int {
+	return min(v.Position, len(v.Steps)-1) + 1
+}
+
+// capturePath is the part of the capture URL after the endpoint ID, which is
+// what path-specific sequences match.
+func capturePath(r *http.Request, endpointID string) string {
+	return strings.TrimPrefix(r.URL.Path, "/h/"+endpointID)
+}
+
+// nextSequenceStep returns the scripted response for r, if a sequence applies.
+func (h *Handler) nextSequenceStep(r *http.Request, endpoint *store.Endpoint) (*store.SequenceStep, int) {
+	step, number, err := h.Store.NextSequenceStep(r.Context(), endpoint.ID, capturePath(r, endpoint.ID))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to advance response sequence", "endpoint_id", endpoint.ID, "error", err)
+		return nil, 0
+	}
+	return step, number
+}
+
+// normalizeCapturePath turns a configured path into the form capturePath
+// returns, where "" stands for the whole endpoint.
+func normalizeCapturePath(path string) string {
+	path = strings.TrimSpace(path)
+	if path == "/" {
+		return ""
+	}
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+func normalizeSequence(input sequenceInput) sequenceInput {
+	input.Path = normalizeCapturePath(input.Path)
+	input.Mode = strings.TrimSpace(input.Mode)
+	if input.Mode == "" {
+		input.Mode = store.SequenceHold
+	}
+	for i := range input.Steps {
+		input.Steps[i].ContentType = strings.TrimSpace(input.Steps[i].ContentType)
+	}
+	return input
+}
+
+func validateSequence(input sequenceInput) error {
+	if input.Mode != store.SequenceLoop && input.Mode != store.SequenceHold {
+		return errors.New(`mode must be "loop" or "hold"`)
+	}
+	if len(input.Path) > maxSequencePathSize || strings.ContainsAny(input.Path, "?#") {
+		return fmt.Errorf("path must be a URL path of at most %d characters", maxSequencePathSize)
+	}
+	if len(input.Steps) == 0 || len(input.Steps) > maxSequenceSteps {
+		return fmt.Errorf("a sequence needs between 1 and %d steps", maxSequenceSteps)
+	}
+	for i, step := range input.Steps {
+		if step.Status < 200 || step.Status > 599 {
+			return fmt.Errorf("step %d: status must be between 200 and 599", i+1)
+		}
+		if len(step.Body) > 64*1024 {
+			return fmt.Errorf("step %d: body must not exceed 64KB", i+1)
+		}
+		if len(step.ContentType) > 200 {
+			return fmt.Errorf("step %d: content type must not exceed 200 characters", i+1)
+		}
+	}
+	return nil
+}
+
+// parseSequenceSteps reads one step per line as a status optionally followed
+// by the response body, for example "429 slow down".
+func parseSequenceSteps(text string) ([]store.SequenceStep, error) {
+	var steps []store.SequenceStep
+	for number, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		code, body, _ := strings.Cut(line, " ")
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %q does not start with a status code", number+1, line)
+		}
+		steps = append(steps, store.SequenceStep{Status: status, Body: strings.TrimSpace(body)})
+	}
+	return steps, nil
+}
+
+func formatSequenceSteps(steps []store.SequenceStep) string {
+	lines := make([]string, len(steps))
+	for i, step := range steps {
+		lines[i] = strings.TrimSpace(strconv.Itoa(step.Status) + " " + strings.ReplaceAll(step.Body, "\n", " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (h *Handler) sequenceViews(r *http.Request, endpointID string) []sequenceView {
+	sequences, err := h.Store.ListResponseSequences(r.Context(), endpointID)
+	if err != nil {
+		slog.WarnContext(r.Context(), "failed to list response sequences", "endpoint_id", endpointID, "error", err)
+		return nil
+	}
+	views := make([]sequenceView, len(sequences))
+	for i, sequence := range sequences {
+		views[i] = sequenceView{ResponseSequence: sequence, StepsText: formatSequenceSteps(sequence.Steps)}
+	}
+	return views
+}
+
+// saveSequence validates and stores a sequence. The returned status tells the
+// caller whether an error was the input's fault.
+func (h *Handler) saveSequence(r *http.Request, endpointID string, input sequenceInput) (*store.ResponseSequence, int, error) {
+	input = normalizeSequence(input)
+	if err := validateSequence(input); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	sequence := &store.ResponseSequence{EndpointID: endpointID, Path: input.Path, Mode: input.Mode, Steps: input.Steps}
+	if err := h.Store.SetResponseSequence(r.Context(), sequence); err != nil {
+		slog.ErrorContext(r.Context(), "failed to save response sequence", "endpoint_id", endpointID, "error", err)
+		return nil, http.StatusInternalServerError, errors.New("failed to save response sequence")
+	}
+	h.audit(r, auditSequenceSet, "endpoint", endpointID, sequence)
+	return sequence, http.StatusOK, nil
+}
+
+// APIListSequences returns the endpoint's response sequences with their
+// current positions.
+func (h *Handler) APIListSequences(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
+		return
+	}
+	sequences, err := h.Store.ListResponseSequences(r.Context(), endpoint.ID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list response sequences"})
+		return
+	}
+	writeJSON(w, http.StatusOK, sequences)
+}
+
+// APISetSequence creates or replaces the sequence for a path and starts it
+// from the first step.
+func (h *Handler) APISetSequence(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	var input sequenceInput
+	if !decodeJSON(w, r, &input) {
+		return
+	}
+	sequence, status, err := h.saveSequence(r, endpoint.ID, input)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, status, sequence)
+}
+
+// APIResetSequence starts the sequence for ?path= over from its first step.
+func (h *Handler) APIResetSequence(w http.ResponseWriter, r *http.Request) {
+	h.apiChangeSequence(w, r, auditSequenceReset, h.Store.ResetResponseSequence)
+}
+
+// APIDeleteSequence removes the sequence for ?path=.
+func (h *Handler) APIDeleteSequence(w http.ResponseWriter, r *http.Request) {
+	h.apiChangeSequence(w, r, auditSequenceDelete, h.Store.DeleteResponseSequence)
+}
+
+func (h *Handler) apiChangeSequence(w http.ResponseWriter, r *http.Request, action string, change func(ctx context.Context, endpointID, path string) error) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	path := normalizeSequence(sequenceInput{Path: r.URL.Query().Get("path")}).Path
+	if err := change(r.Context(), endpoint.ID, path); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "response sequence not found"})
+			return
+		}
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to update response sequence"})
+		return
+	}
+	h.audit(r, action, "endpoint", endpoint.ID, map[string]string{"path": path})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SaveResponseSequence handles the settings form, which lists one step per line.
+func (h *Handler) SaveResponseSequence(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 256*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	steps, err := parseSequenceSteps(r.FormValue("steps"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, status, err := h.saveSequence(r, endpoint.ID, sequenceInput{Path: r.FormValue("path"), Mode: r.FormValue("mode"), Steps: steps}); err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("HX-Redirect", "/"+endpoint.ID)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResetResponseSequence and DeleteResponseSequence act on the sequence for
+// ?path= from the settings page.
+func (h *Handler) ResetResponseSequence(w http.ResponseWriter, r *http.Request) {
+	h.changeSequence(w, r, auditSequenceReset, h.Store.ResetResponseSequence)
+}
+
+func (h *Handler) DeleteResponseSequence(w http.ResponseWriter, r *http.Request) {
+	h.changeSequence(w, r, auditSequenceDelete, h.Store.DeleteResponseSequence)
+}
+
+func (h *Handler) changeSequence(w http.ResponseWriter, r *http.Request, action string, change func(ctx context.Context, endpointID, path string) error) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	path := normalizeSequence(sequenceInput{Path: r.URL.Query().Get("path")}).Path
+	if err := change(r.Context(), endpoint.ID, path); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "response sequence not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to update response sequence", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, action, "endpoint", endpoint.ID, map[string]string{"path": path})
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
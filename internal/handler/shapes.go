@@ -0,0 +1,276 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/PipeOpsHQ/pipehook/internal/shape"
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultShapeSamples = 200
+	maxShapeSamples     = 1000
+	shapePageSize       = 50
+	maxShapeEventSize   = 100
+)
+
+// shapeEventHeaders and shapeEventFields are where providers name the event
+// a payload is for, tried in order when grouping is automatic.
+var (
+	shapeEventHeaders = []string{"X-GitHub-Event", "X-Gitlab-Event", "X-Event-Key", "X-Shopify-Topic"}
+	shapeEventFields  = []string{"type", "event", "event_type"}
+)
+
+// shapeGrouping says how captures are split into events: by a header, by a
+// body field, automatically, or not at all.
+type shapeGrouping struct {
+	auto   bool
+	header string
+	field  string
+}
+
+// parseShapeGrouping reads "auto", "none", "header:<name>" or "field:<path>",
+// where path is a dotted path into the body such as "data.type".
+func parseShapeGrouping(value string) (shapeGrouping, error) {
+	value = strings.TrimSpace(value)
+	kind, name, _ := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	switch {
+	case value == "" || value == "auto":
+		return shapeGrouping{auto: true}, nil
+	case value == "none":
+		return shapeGrouping{}, nil
+	case kind == "header" && validHeaderName(name):
+		return shapeGrouping{header: name}, nil
+	case kind == "field" && name != "" && len(name) <= 200:
+		return shapeGrouping{field: name}, nil
+	}
+	return shapeGrouping{}, errors.New(`group must be "auto", "none", "header:<name>" or "field:<path>"`)
+}
+
+func (g shapeGrouping) event(headers map[string][]string, body any) string {
+	var event string
+	switch {
+	case g.header != "":
+		event = headerValue(headers, g.header)
+	case g.field != "":
+		event = shapeFieldValue(body, g.field)
+	case g.auto:
+		for _, name := range shapeEventHeaders {
+			if event = headerValue(headers, name); event != "" {
+				break
+			}
+		}
+		for _, name := range shapeEventFields {
+			if event != "" {
+				break
+			}
+			event = shapeFieldValue(body, name)
+		}
+	}
+	event = strings.TrimSpace(event)
+	if len(event) > maxShapeEventSize {
+		event = strings.ToValidUTF8(event[:maxShapeEventSize], "")
+	}
+	return event
+}
+
+// shapeFieldValue returns the string or number at a dotted path of body.
+func shapeFieldValue(body any, path string) string {
+	value := body
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+	switch value := value.(type) {
+	case string:
+		return value
+	case fmt.Stringer:
+		return value.String()
+	}
+	return ""
+}
+
+// shapeReport is the inferred shapes of an endpoint's recent JSON captures.
+// Skipped counts captures whose bodies are not JSON.
+type shapeReport struct {
+	Group   string         `json:"group"`
+	Samples int            `json:"samples"`
+	Skipped int            `json:"skipped"`
+	Shapes  []*shape.Shape `json:"shapes"`
+}
+
+// inferShapes walks the most recent ?limit= captures of the endpoint,
+// grouped per ?group=. The returned status tells the caller whether an
+// error was the input's fault.
+func (h *Handler) inferShapes(r *http.Request, endpointID string) (*shapeReport, int, error) {
+	query := r.URL.Query()
+	grouping, err := parseShapeGrouping(query.Get("group"))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	limit := defaultShapeSamples
+	if raw := query.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 1 || limit > maxShapeSamples {
+			return nil, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %d", maxShapeSamples)
+		}
+	}
+	report := &shapeReport{Group: strings.TrimSpace(query.Get("group"))}
+	if report.Group == "" {
+		report.Group = "auto"
+	}
+	inferrer := shape.New()
+	for offset := 0; offset < limit; offset += shapePageSize {
+		requests, err := h.Store.SearchRequests(r.Context(), endpointID, "", min(shapePageSize, limit-offset), offset)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to list requests for shapes", "endpoint_id", endpointID, "error", err)
+			return nil, http.StatusInternalServerError, errors.New("failed to read requests")
+		}
+		for _, request := range requests {
+			headers := parseRequestHeaders(request.ID, request.Headers)
+			body, _ := decodeRequestBody(request, headers)
+			value, err := shape.Decode(body)
+			if err != nil {
+				report.Skipped++
+				continue
+			}
+			report.Samples++
+			inferrer.Add(grouping.event(headers, value), value)
+		}
+		if len(requests) < shapePageSize {
+			break
+		}
+	}
+	report.Shapes = inferrer.Shapes()
+	return report, http.StatusOK, nil
+}
+
+// shapeFile renders the shape of ?event= as a JSON Schema or a Go type.
+func (h *Handler) shapeFile(r *http.Request, endpoint *store.Endpoint, kind string) ([]byte, string, int, error) {
+	report, status, err := h.inferShapes(r, endpoint.ID)
+	if err != nil {
+		return nil, "", status, err
+	}
+	event := r.URL.Query().Get("event")
+	for _, found := range report.Shapes {
+		if found.Event != event {
+			continue
+		}
+		name := shape.GoName(event, "Payload")
+		var data []byte
+		if kind == "go" {
+			data, err = found.GoType(name)
+		} else {
+			data, err = found.JSONSchema()
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to render shape", "endpoint_id", endpoint.ID, "kind", kind, "error", err)
+			return nil, "", http.StatusInternalServerError, errors.New("failed to render shape")
+		}
+		return data, name, http.StatusOK, nil
+	}
+	return nil, "", http.StatusNotFound, errors.New("no captures for this event")
+}
+
+func writeShapeFile(w http.ResponseWriter, endpointID, name, kind string, data []byte) {
+	if kind == "go" {
+		w.Header().Set("Content-Type", "text/x-go; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pipehook-%s-%s.go"`, endpointID, name))
+	} else {
+		w.Header().Set("Content-Type", "application/schema+json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="pipehook-%s-%s.schema.json"`, endpointID, name))
+	}
+	_, _ = w.Write(data)
+}
+
+// EndpointShapes renders the shapes pane of the dashboard.
+func (h *Handler) EndpointShapes(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
+		return
+	}
+	report, status, err := h.inferShapes(r, endpoint.ID)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	limit := r.URL.Query().Get("limit")
+	if limit == "" {
+		limit = strconv.Itoa(defaultShapeSamples)
+	}
+	data := struct {
+		EndpointID string
+		Limit      string
+		Report     *shapeReport
+	}{endpoint.ID, limit, report}
+	if err := shapesTemplate.ExecuteTemplate(w, "shapes", data); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+		http.Error(w, "failed to render shapes", http.StatusInternalServerError)
+	}
+}
+
+// DownloadShapeSchema and DownloadShapeGo download the shape of ?event=.
+func (h *Handler) DownloadShapeSchema(w http.ResponseWriter, r *http.Request) {
+	h.downloadShape(w, r, "schema")
+}
+
+func (h *Handler) DownloadShapeGo(w http.ResponseWriter, r *http.Request) {
+	h.downloadShape(w, r, "go")
+}
+
+func (h *Handler) downloadShape(w http.ResponseWriter, r *http.Request, kind string) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
+		return
+	}
+	data, name, status, err := h.shapeFile(r, endpoint, kind)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	writeShapeFile(w, endpoint.ID, name, kind, data)
+}
+
+// APIEndpointShapes returns the inferred shapes as JSON.
+func (h *Handler) APIEndpointShapes(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
+		return
+	}
+	report, status, err := h.inferShapes(r, endpoint.ID)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+func (h *Handler) APIShapeSchema(w http.ResponseWriter, r *http.Request) {
+	h.apiShape(w, r, "schema")
+}
+
+func (h *Handler) APIShapeGo(w http.ResponseWriter, r *http.Request) {
+	h.apiShape(w, r, "go")
+}
+
+func (h *Handler) apiShape(w http.ResponseWriter, r *http.Request, kind string) {
+	endpoint, ok := h.apiEndpoint(w, r, chi.URLParam(r, "endpointID"), permView)
+	if !ok {
+		return
+	}
+	data, name, status, err := h.shapeFile(r, endpoint, kind)
+	if err != nil {
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeShapeFile(w, endpoint.ID, name, kind, data)
+}
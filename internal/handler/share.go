@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	shareSigningKeyName = "share-links"
+	redactedHeaderValue = "[redacted]"
+)
+
+// shareDurations are the lifetimes offered when creating a share link.
+var shareDurations = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+var errInvalidShareToken = errors.New("invalid share token")
+
+type shareLinkView struct {
+	*store.ShareLink
+	URL    string
+	Active bool
+}
+
+type sharePageData struct {
+	BaseTemplateData
+	Token     string
+	Link      *store.ShareLink
+	Endpoint  *store.Endpoint
+	Requests  []*store.Request
+	Selected  *requestDetailData
+	Redacted  string
+	ExpiresAt time.Time
+}
+
+// shareToken signs the link ID and expiry so forged or altered tokens are
+// rejected before the database is consulted. Revocation still needs the
+// stored link.
+func (h *Handler) shareToken(r *http.Request, link *store.ShareLink) (string, error) {
+	key, err := h.Store.SigningKey(r.Context(), shareSigningKeyName)
+	if err != nil {
+		return "", err
+	}
+	payload := link.ID + "." + strconv.FormatInt(link.ExpiresAt.Unix(), 10)
+	return payload + "." + signSharePayload(key, payload), nil
+}
+
+func signSharePayload(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// resolveShareToken returns the active link behind a token.
+func (h *Handler) resolveShareToken(r *http.Request, token string) (*store.ShareLink, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidShareToken
+	}
+	key, err := h.Store.SigningKey(r.Context(), shareSigningKeyName)
+	if err != nil {
+		return nil, err
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(signSharePayload(key, payload))) {
+		return nil, errInvalidShareToken
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expiresAt, 0)) {
+		return nil, errInvalidShareToken
+	}
+	link, err := h.Store.GetShareLink(r.Context(), parts[0])
+	if err != nil || !link.Active(time.Now()) || link.ExpiresAt.Unix() != expiresAt {
+		return nil, errInvalidShareToken
+	}
+	return link, nil
+}
+
+func (h *Handler) shareLinkViews(r *http.Request, links []*store.ShareLink) []shareLinkView {
+	now := time.Now()
+	views := make([]shareLinkView, 0, len(links))
+	for _, link := range links {
+		token, err := h.shareToken(r, link)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "failed to sign share link", "share_link", link.ID, "error", err)
+			continue
+		}
+		views = append(views, shareLinkView{ShareLink: link, URL: h.requestScheme(r) + "://" + r.Host + "/s/" + token, Active: link.Active(now)})
+	}
+	return views
+}
+
+// CreateShareLink creates a read-only link to one request (request_id) or the
+// whole endpoint. Editors and above may share.
+func (h *Handler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	duration, ok := shareDurations[r.FormValue("expires_in")]
+	if !ok {
+		http.Error(w, "expires_in must be one of 1h, 24h, 7d or 30d", http.StatusBadRequest)
+		return
+	}
+	link := &store.ShareLink{
+		EndpointID:    endpoint.ID,
+		RedactHeaders: splitHeaderNames(r.FormValue("redact_headers")),
+		CreatedBy:     h.actorName(r),
+		ExpiresAt:     time.Now().Add(duration).Truncate(time.Second),
+	}
+	if r.FormValue("scope") == "request" {
+		requestID, err := strconv.ParseInt(r.FormValue("request_id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid request ID", http.StatusBadRequest)
+			return
+		}
+		request, err := h.Store.GetRequest(r.Context(), requestID)
+		if err != nil || request.EndpointID != endpoint.ID {
+			http.Error(w, "request not found", http.StatusNotFound)
+			return
+		}
+		link.RequestID = request.ID
+	}
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		http.Error(w, "failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	link.ID = base64.RawURLEncoding.EncodeToString(secret)
+	if err := h.Store.CreateShareLink(r.Context(), link); err != nil {
+		slog.ErrorContext(r.Context(), "failed to create share link", "endpoint_id", endpoint.ID, "error", err)
+		http.Error(w, "failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, auditShareCreate, "endpoint", endpoint.ID, map[string]any{
+		"share_id": link.ID, "request_id": link.RequestID, "expires_at": link.ExpiresAt, "redact_headers": link.RedactHeaders,
+	})
+	views := h.shareLinkViews(r, []*store.ShareLink{link})
+	if len(views) == 0 {
+		http.Error(w, "failed to create share link", http.StatusInternalServerError)
+		return
+	}
+	if r.Header.Get("HX-Request") == "" {
+		writeJSON(w, http.StatusCreated, map[string]any{"link": link, "url": views[0].URL})
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	if err := dashboardTemplate.ExecuteTemplate(w, "share-created", views[0]); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+	}
+}
+
+// RevokeShareLink revokes a link immediately and returns its updated row.
+func (h *Handler) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permEdit)
+	if !ok {
+		return
+	}
+	shareID := chi.URLParam(r, "shareID")
+	if err := h.Store.RevokeShareLink(r.Context(), endpoint.ID, shareID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "share link not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "failed to revoke share link", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, auditShareRevoke, "endpoint", endpoint.ID, map[string]string{"share_id": shareID})
+	link, err := h.Store.GetShareLink(r.Context(), shareID)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	views := h.shareLinkViews(r, []*store.ShareLink{link})
+	if len(views) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.ExecuteTemplate(w, "share-link-row", views[0]); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+	}
+}
+
+// SharedView renders a share link without any other authentication. Endpoint
+// links list the recent requests and select one with ?request=ID.
+func (h *Handler) SharedView(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	token := chi.URLParam(r, "token")
+	link, err := h.resolveShareToken(r, token)
+	if err != nil {
+		if !errors.Is(err, errInvalidShareToken) {
+			slog.ErrorContext(r.Context(), "failed to resolve share link", "error", err)
+		}
+		http.Error(w, "this share link is invalid, expired or revoked", http.StatusNotFound)
+		return
+	}
+	endpoint, err := h.Store.GetEndpoint(r.Context(), link.EndpointID)
+	if err != nil {
+		http.Error(w, "this share link is invalid, expired or revoked", http.StatusNotFound)
+		return
+	}
+	data := sharePageData{
+		BaseTemplateData: h.baseTemplateData(r),
+		Token:            token,
+		Link:             link,
+		Endpoint:         endpoint,
+		Redacted:         strings.Join(link.RedactHeaders, ", "),
+		ExpiresAt:        link.ExpiresAt,
+	}
+
+	selectedID := link.RequestID
+	if selectedID == 0 {
+		data.Requests, err = h.Store.SearchRequestSummaries(r.Context(), endpoint.ID, "", 100, 0)
+		if err != nil {
+			http.Error(w, "failed to fetch requests", http.StatusInternalServerError)
+			return
+		}
+		if requested, err := strconv.ParseInt(r.URL.Query().Get("request"), 10, 64); err == nil {
+			selectedID = requested
+		} else if len(data.Requests) > 0 {
+			selectedID = data.Requests[0].ID
+		}
+	}
+	if selectedID != 0 {
+		request, err := h.Store.GetRequest(r.Context(), selectedID)
+		if err != nil || request.EndpointID != endpoint.ID {
+			http.Error(w, "request not found", http.StatusNotFound)
+			return
+		}
+		data.Selected = h.buildRequestDetailData(request)
+		data.Selected.ReadOnly = true
+		redactRequestHeaders(data.Selected, link.RedactHeaders)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := shareTemplate.ExecuteTemplate(w, "layout", data); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+	}
+}
+
+// redactRequestHeaders masks the named headers, matched case-insensitively.
+func redactRequestHeaders(data *requestDetailData, names []string) {
+	if len(names) == 0 {
+		return
+	}
+	for key, values := range data.HeadersMap {
+		for _, name := range names {
+			if strings.EqualFold(key, name) {
+				masked := make([]string, len(values))
+				for i := range masked {
+					masked[i] = redactedHeaderValue
+				}
+				data.HeadersMap[key] = masked
+			}
+		}
+	}
+	headersJSON, _ := json.MarshalIndent(data.HeadersMap, "", "  ")
+	data.HeadersJSON = string(headersJSON)
+}
+
+func splitHeaderNames(value string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" && len(names) < 50 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// actorName describes who is making the request: the signed-in username,
+// "admin" for Basic auth administrators, or "browser".
+func (h *Handler) actorName(r *http.Request) string {
+	if user := currentUser(r); user != nil {
+		return user.Username
+	}
+	if h.IsAdminAuthenticated(r) {
+		return "admin"
+	}
+	return "browser"
+}
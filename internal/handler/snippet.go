@@ -0,0 +1,349 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// snippetLanguages are the languages a captured request can be rendered in,
+// in the order the request view offers them.
+var snippetLanguages = []snippetLanguage{
+	{Name: "curl", Label: "curl"},
+	{Name: "httpie", Label: "HTTPie"},
+	{Name: "go", Label: "Go"},
+	{Name: "python", Label: "Python"},
+	{Name: "javascript", Label: "JavaScript"},
+}
+
+type snippetLanguage struct {
+	Name  string
+	Label string
+}
+
+var errUnknownSnippetLanguage = errors.New(`lang must be one of "curl", "httpie", "go", "python" or "javascript"`)
+
+// snippetRequest is a captured request the way a snippet sends it again:
+// to the endpoint's webhook URL, with the headers replay would copy.
+type snippetRequest struct {
+	Method    string
+	URL       string
+	Headers   []snippetHeader
+	Body      []byte
+	Binary    bool
+	Truncated bool
+}
+
+type snippetHeader struct {
+	Name  string
+	Value string
+}
+
+func (h *Handler) newSnippetRequest(r *http.Request, captured *store.Request) snippetRequest {
+	target := url.URL{
+		Scheme: h.requestScheme(r), Host: r.Host, Path: "/h/" + captured.EndpointID + replayRelativePath(captured),
+		RawQuery: captured.QueryString,
+	}
+	header := http.Header{}
+	copyReplayHeaders(header, captured.Headers)
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	request := snippetRequest{
+		Method: captured.Method, URL: target.String(), Body: captured.Body, Truncated: captured.BodyTruncated,
+	}
+	for _, name := range names {
+		for _, value := range header[name] {
+			request.Headers = append(request.Headers, snippetHeader{Name: name, Value: value})
+		}
+	}
+	if request.Method == "" {
+		request.Method = http.MethodGet
+	}
+	// The stored body is sent as is, so a compressed body stays compressed
+	// and matches its Content-Encoding header. Shell arguments cannot hold
+	// NUL bytes, so any body with one is sent from a file too.
+	request.Binary = isBinaryBody(captured.Body, normalizeContentType(header.Get("Content-Type"))) ||
+		!utf8.Valid(captured.Body) || bytes.IndexByte(captured.Body, 0) >= 0
+	return request
+}
+
+// renderSnippet renders request as code in the named language.
+func renderSnippet(language string, request snippetRequest) (string, error) {
+	switch language {
+	case "curl":
+		return curlSnippet(request), nil
+	case "httpie":
+		return httpieSnippet(request), nil
+	case "go":
+		return goSnippet(request)
+	case "python":
+		return pythonSnippet(request), nil
+	case "javascript":
+		return javascriptSnippet(request), nil
+	}
+	return "", errUnknownSnippetLanguage
+}
+
+// shellQuote quotes value for a POSIX shell.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// quoteString quotes value as a JSON string, which Python and JavaScript
+// both read as a string literal.
+func quoteString(value string) string {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(value)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func truncatedNote(request snippetRequest, comment string) string {
+	if !request.Truncated {
+		return ""
+	}
+	return fmt.Sprintf("%s The captured body was truncated, so only its first %d bytes are sent.\n", comment, len(request.Body))
+}
+
+// bodyFile writes the commands that save a binary body to body.bin, for the
+// shell snippets to send with --data-binary @body.bin or stdin.
+func bodyFile(body []byte) string {
+	var b strings.Builder
+	b.WriteString("base64 --decode > body.bin <<'EOF'\n")
+	encoded := base64.StdEncoding.EncodeToString(body)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\nEOF\n")
+	return b.String()
+}
+
+func curlSnippet(request snippetRequest) string {
+	var b strings.Builder
+	b.WriteString(truncatedNote(request, "#"))
+	if request.Binary {
+		b.WriteString(bodyFile(request.Body))
+	}
+	b.WriteString("curl")
+	switch {
+	case request.Method == http.MethodHead:
+		b.WriteString(" --head")
+	case request.Method != http.MethodGet || len(request.Body) > 0:
+		b.WriteString(" -X " + shellQuote(request.Method))
+	}
+	b.WriteString(" " + shellQuote(request.URL))
+	for _, header := range request.Headers {
+		// curl drops headers given as "Name:", so empty values use "Name;".
+		if header.Value == "" {
+			b.WriteString(" \\\n  -H " + shellQuote(header.Name+";"))
+		} else {
+			b.WriteString(" \\\n  -H " + shellQuote(header.Name+": "+header.Value))
+		}
+	}
+	switch {
+	case request.Binary:
+		b.WriteString(" \\\n  --data-binary @body.bin")
+	case len(request.Body) > 0:
+		b.WriteString(" \\\n  --data-raw " + shellQuote(string(request.Body)))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func httpieSnippet(request snippetRequest) string {
+	var b strings.Builder
+	b.WriteString(truncatedNote(request, "#"))
+	switch {
+	case request.Binary:
+		b.WriteString(bodyFile(request.Body))
+		b.WriteString("http")
+	case len(request.Body) > 0:
+		b.WriteString("http --raw " + shellQuote(string(request.Body)))
+	default:
+		b.WriteString("http --ignore-stdin")
+	}
+	b.WriteString(" " + shellQuote(request.Method) + " " + shellQuote(request.URL))
+	for _, header := range request.Headers {
+		// HTTPie drops headers given as "Name:", so empty values use "Name;".
+		if header.Value == "" {
+			b.WriteString(" \\\n  " + shellQuote(header.Name+";"))
+		} else {
+			b.WriteString(" \\\n  " + shellQuote(header.Name+":"+header.Value))
+		}
+	}
+	if request.Binary {
+		b.WriteString(" \\\n  < body.bin")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+func goSnippet(request snippetRequest) (string, error) {
+	var b strings.Builder
+	b.WriteString("package main\n\nimport (\n")
+	switch {
+	case request.Binary:
+		b.WriteString("\"bytes\"\n\"encoding/base64\"\n")
+	case len(request.Body) > 0:
+		b.WriteString("\"strings\"\n")
+	}
+	b.WriteString("\"fmt\"\n\"io\"\n\"net/http\"\n)\n\nfunc main() {\n")
+	b.WriteString(truncatedNote(request, "//"))
+	body := "nil"
+	switch {
+	case request.Binary:
+		fmt.Fprintf(&b, "data, err := base64.StdEncoding.DecodeString(%q)\nif err != nil {\npanic(err)\n}\n", base64.StdEncoding.EncodeToString(request.Body))
+		body = "bytes.NewReader(data)"
+	case len(request.Body) > 0:
+		body = "strings.NewReader(" + strconv.Quote(string(request.Body)) + ")"
+	}
+	fmt.Fprintf(&b, "request, err := http.NewRequest(%q, %q, %s)\nif err != nil {\npanic(err)\n}\n", request.Method, request.URL, body)
+	for _, header := range request.Headers {
+		fmt.Fprintf(&b, "request.Header.Add(%q, %q)\n", header.Name, header.Value)
+	}
+	b.WriteString("response, err := http.DefaultClient.Do(request)\nif err != nil {\npanic(err)\n}\n")
+	b.WriteString("defer response.Body.Close()\nreply, err := io.ReadAll(response.Body)\nif err != nil {\npanic(err)\n}\n")
+	b.WriteString("fmt.Println(response.Status)\nfmt.Println(string(reply))\n}\n")
+	formatted, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+// joinedHeaders merges repeated headers for languages that take headers as
+// a map, the way HTTP allows them to be combined.
+func joinedHeaders(headers []snippetHeader) []snippetHeader {
+	var joined []snippetHeader
+	for _, header := range headers {
+		if last := len(joined) - 1; last >= 0 && joined[last].Name == header.Name {
+			joined[last].Value += ", " + header.Value
+			continue
+		}
+		joined = append(joined, header)
+	}
+	return joined
+}
+
+func pythonSnippet(request snippetRequest) string {
+	var b strings.Builder
+	b.WriteString(truncatedNote(request, "#"))
+	if request.Binary {
+		b.WriteString("import base64\n\n")
+	}
+	b.WriteString("import requests\n\nresponse = requests.request(\n")
+	fmt.Fprintf(&b, "    %s,\n    %s,\n", quoteString(request.Method), quoteString(request.URL))
+	if headers := joinedHeaders(request.Headers); len(headers) > 0 {
+		b.WriteString("    headers={\n")
+		for _, header := range headers {
+			fmt.Fprintf(&b, "        %s: %s,\n", quoteString(header.Name), quoteString(header.Value))
+		}
+		b.WriteString("    },\n")
+	}
+	switch {
+	case request.Binary:
+		fmt.Fprintf(&b, "    data=base64.b64decode(%s),\n", quoteString(base64.StdEncoding.EncodeToString(request.Body)))
+	case len(request.Body) > 0:
+		fmt.Fprintf(&b, "    data=%s.encode(),\n", quoteString(string(request.Body)))
+	}
+	b.WriteString(")\nprint(response.status_code)\nprint(response.text)\n")
+	return b.String()
+}
+
+func javascriptSnippet(request snippetRequest) string {
+	var b strings.Builder
+	b.WriteString(truncatedNote(request, "//"))
+	fmt.Fprintf(&b, "const response = await fetch(%s, {\n  method: %s,\n", quoteString(request.URL), quoteString(request.Method))
+	if headers := joinedHeaders(request.Headers); len(headers) > 0 {
+		b.WriteString("  headers: {\n")
+		for _, header := range headers {
+			fmt.Fprintf(&b, "    %s: %s,\n", quoteString(header.Name), quoteString(header.Value))
+		}
+		b.WriteString("  },\n")
+	}
+	switch {
+	case request.Binary:
+		fmt.Fprintf(&b, "  body: Uint8Array.from(atob(%s), (c) => c.charCodeAt(0)),\n", quoteString(base64.StdEncoding.EncodeToString(request.Body)))
+	case len(request.Body) > 0:
+		fmt.Fprintf(&b, "  body: %s,\n", quoteString(string(request.Body)))
+	}
+	b.WriteString("});\nconsole.log(response.status);\nconsole.log(await response.text());\n")
+	return b.String()
+}
+
+// RequestSnippet renders the snippet pane of the request view.
+func (h *Handler) RequestSnippet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "requestID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid request ID", http.StatusBadRequest)
+		return
+	}
+	captured, ok := h.requireRequestAccess(w, r, id, permView)
+	if !ok {
+		return
+	}
+	language := r.URL.Query().Get("lang")
+	if language == "" {
+		language = snippetLanguages[0].Name
+	}
+	code, err := renderSnippet(language, h.newSnippetRequest(r, captured))
+	if errors.Is(err, errUnknownSnippetLanguage) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to render snippet", "request_id", captured.ID, "lang", language, "error", err)
+		http.Error(w, "failed to render snippet", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Language string
+		Code     string
+	}{language, code}
+	if err := detailTemplate.ExecuteTemplate(w, "request-snippet", data); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+		http.Error(w, "failed to render snippet", http.StatusInternalServerError)
+	}
+}
+
+// APIRequestSnippet returns the snippet as plain text for scripts and the CLI.
+func (h *Handler) APIRequestSnippet(w http.ResponseWriter, r *http.Request) {
+	captured, ok := h.apiRequest(w, r, permView)
+	if !ok {
+		return
+	}
+	language := r.URL.Query().Get("lang")
+	if language == "" {
+		language = snippetLanguages[0].Name
+	}
+	code, err := renderSnippet(language, h.newSnippetRequest(r, captured))
+	if errors.Is(err, errUnknownSnippetLanguage) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to render snippet", "request_id", captured.ID, "lang", language, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to render snippet"})
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(code))
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/PipeOpsHQ/pipehook/internal/oidc"
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcCookieName = "pipehook_oidc"
+	oidcCookiePath = "/auth/oidc"
+	// oidcCookieMaxAge bounds how long a user may spend at the identity
+	// provider before the sign-in has to start over.
+	oidcCookieMaxAge = 10 * 60
+)
+
+var usernameDisallowed = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// OIDCLogin starts a single sign-on. The state, nonce and PKCE verifier live
+// in a short-lived cookie scoped to the callback.
+func (h *Handler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+	state, err := oidc.NewState()
+	if err != nil {
+		http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.NewState()
+	if err != nil {
+		http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		http.Error(w, "failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	target, err := h.OIDC.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to start single sign-on", "error", err)
+		http.Error(w, "the identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	next := base64.RawURLEncoding.EncodeToString([]byte(localRedirect(r.URL.Query().Get("next"))))
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    strings.Join([]string{state, nonce, verifier, next}, "."),
+		Path:     oidcCookiePath,
+		MaxAge:   oidcCookieMaxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   h.requestScheme(r) == "https",
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallback completes a single sign-on: it checks the state, redeems the
+// code, applies the domain and group restrictions and signs the linked local
+// account in.
+func (h *Handler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Value: "", Path: oidcCookiePath, MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteLaxMode})
+	query := r.URL.Query()
+	if providerError := query.Get("error"); providerError != "" {
+		h.renderLogin(w, r, http.StatusUnauthorized, loginPageData{Mode: "login", Error: "single sign-on was refused: " + providerError})
+		return
+	}
+	cookie, err := r.Cookie(oidcCookieName)
+	var parts []string
+	if err == nil {
+		parts = strings.Split(cookie.Value, ".")
+	}
+	if len(parts) != 4 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(query.Get("state"))) != 1 {
+		h.renderLogin(w, r, http.StatusBadRequest, loginPageData{Mode: "login", Error: "the sign-in expired, please try again"})
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+	next, _ := base64.RawURLEncoding.DecodeString(parts[3])
+
+	claims, err := h.OIDC.Exchange(r.Context(), query.Get("code"), verifier, nonce)
+	if err != nil {
+		slog.WarnContext(r.Context(), "single sign-on failed", "error", err)
+		h.renderLogin(w, r, http.StatusUnauthorized, loginPageData{Mode: "login", Error: "single sign-on failed"})
+		return
+	}
+	admin, err := h.OIDC.Authorize(claims)
+	if err != nil {
+		slog.WarnContext(r.Context(), "single sign-on refused", "subject", claims.Subject, "error", err)
+		h.renderLogin(w, r, http.StatusForbidden, loginPageData{Mode: "login", Error: "your account is not allowed to sign in here"})
+		return
+	}
+	user, err := h.oidcUser(r.Context(), claims)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to link single sign-on account", "subject", claims.Subject, "error", err)
+		http.Error(w, "failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	h.startSession(w, r, user, admin, localRedirect(string(next)))
+}
+
+// oidcUser returns the local account linked to the identity provider subject,
+// creating it on first sign-in. The username is derived from the claims and
+// gets a numeric suffix if it is already taken.
+func (h *Handler) oidcUser(ctx context.Context, claims *oidc.Claims) (*store.User, error) {
+	subject := claims.Issuer + "|" + claims.Subject
+	user, err := h.Store.GetUserByOIDCSubject(ctx, subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = strings.Trim(usernameDisallowed.ReplaceAllString(base, "-"), "-")
+	if len(base) > 56 {
+		base = base[:56]
+	}
+	if len(base) < 3 {
+		base = "user"
+	}
+	for attempt := 1; attempt <= 20; attempt++ {
+		username := base
+		if attempt > 1 {
+			username = fmt.Sprintf("%s-%d", base, attempt)
+		}
+		user = &store.User{ID: uuid.NewString(), Username: username}
+		err = h.Store.CreateOIDCUser(ctx, user, subject)
+		if !errors.Is(err, store.ErrUsernameTaken) {
+			return user, err
+		}
+	}
+	user = &store.User{ID: uuid.NewString(), Username: base + "-" + uuid.NewString()[:8]}
+	return user, h.Store.CreateOIDCUser(ctx, user, subject)
+}
+
+// localRedirect only allows paths on this server so the next parameter
+// cannot be used as an open redirect.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -8,7 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"mime"
 	"net/http"
 	"net/url"
@@ -36,35 +36,58 @@ type requestDetailData struct {
 	ContentType   string
 	IsBinary      bool
 	DisplayNotice string
+	// ResponseHeadersJSON and ResponseBodyString show the served response;
+	// both are empty when it was not recorded.
+	ResponseHeadersJSON string
+	ResponseBodyString  string
+	// TraceLink opens the request's trace in the configured tracing backend.
+	TraceLink string
+	// ReadOnly hides the replay, delete and share actions in shared views.
+	ReadOnly bool
+	// SnippetLanguages lists the languages the request can be copied as.
+	SnippetLanguages []snippetLanguage
+	// Viewer is the structured view of the body, when it has one.
+	Viewer *bodyViewer
+	// ContentEncoding offers a decoded download of compressed bodies.
+	ContentEncoding string
 }
 
 func (h *Handler) Home(w http.ResponseWriter, r *http.Request) {
 	// Get or create browser ID for this user
 	browserID := h.GetBrowserID(w, r)
 
-	// Only list endpoints created by this browser
-	endpoints, err := h.Store.ListEndpoints(r.Context(), browserID, 50)
+	// Only list endpoints owned by this account or created by this browser
+	endpoints, unclaimed, err := h.listOwnEndpoints(r, browserID, 50)
 	if err != nil {
-		log.Printf("failed to list endpoints: %v", err)
+		slog.ErrorContext(r.Context(), "failed to list endpoints", "error", err)
 		endpoints = []*store.Endpoint{}
 	}
 
+	var workspaces []*store.Workspace
+	if user := currentUser(r); user != nil {
+		if workspaces, err = h.Store.ListUserWorkspaces(r.Context(), user.ID); err != nil {
+			slog.ErrorContext(r.Context(), "failed to list workspaces", "error", err)
+		}
+	}
+
 	data := struct {
 		BaseTemplateData
-		Endpoints []*store.Endpoint
-		Host      string
-		Scheme    string
+		Endpoints  []*store.Endpoint
+		Unclaimed  []*store.Endpoint
+		Workspaces []*store.Workspace
+		Host       string
+		Scheme     string
 	}{
-		BaseTemplateData: BaseTemplateData{
-			IsAdmin: h.IsAdminAuthenticated(r),
-		},
-		Endpoints: endpoints,
-		Host:      r.Host,
-		Scheme:    requestScheme(r),
+		BaseTemplateData: h.baseTemplateData(r),
+		Endpoints:        endpoints,
+		Unclaimed:        unclaimed,
+		Workspaces:       workspaces,
+		Host:             r.Host,
+		Scheme:           h.requestScheme(r),
 	}
 
 	if err := homeTemplate.ExecuteTemplate(w, "layout", data); err != nil {
-		log.Printf("template execution error: %v", err)
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
 		http.Error(w, "failed to render page", http.StatusInternalServerError)
 		return
 	}
@@ -80,6 +103,12 @@ func (h *Handler) CreateEndpoint(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to create endpoint", http.StatusInternalServerError)
 		return
 	}
+	if user := currentUser(r); user != nil {
+		if err := h.Store.SetEndpointOwner(r.Context(), id, user.ID); err != nil {
+			http.Error(w, "failed to create endpoint", http.StatusInternalServerError)
+			return
+		}
+	}
 	http.Redirect(w, r, "/"+id, http.StatusSeeOther)
 }
 
@@ -87,7 +116,7 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 	// Panic recovery
 	defer func() {
 		if rec := recover(); rec != nil {
-			log.Printf("PANIC in Dashboard handler: %v", rec)
+			slog.ErrorContext(r.Context(), "panic in dashboard handler", "panic", rec)
 			http.Error(w, "internal server error", http.StatusInternalServerError)
 		}
 	}()
@@ -101,7 +130,7 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	endpoint, ok := h.requireEndpointAccess(w, r, endpointID)
+	endpoint, ok := h.requireEndpointAccess(w, r, endpointID, permView)
 	if !ok {
 		return
 	}
@@ -120,13 +149,13 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 	}
 	requests, err := h.Store.SearchRequestSummaries(r.Context(), endpointID, searchQuery, limit, 0)
 	if err != nil {
-		log.Printf("Error getting requests for endpoint %s: %v", endpointID, err)
+		slog.ErrorContext(r.Context(), "failed to get requests", "endpoint_id", endpointID, "error", err)
 		http.Error(w, "failed to fetch requests", http.StatusInternalServerError)
 		return
 	}
 
 	// Get other endpoints for switching (only this user's endpoints)
-	allEndpoints, _ := h.Store.ListEndpoints(r.Context(), browserID, 20)
+	allEndpoints, _, _ := h.listOwnEndpoints(r, browserID, 20)
 	otherEndpoints := []*store.Endpoint{}
 	for _, e := range allEndpoints {
 		if e.ID != endpointID {
@@ -138,7 +167,7 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 	if len(requests) > 0 {
 		fullRequest, err := h.Store.GetRequest(r.Context(), requests[0].ID)
 		if err != nil {
-			log.Printf("Warning: failed to load full request %d: %v", requests[0].ID, err)
+			slog.WarnContext(r.Context(), "failed to load full request", "request", requests[0].ID, "error", err)
 		}
 
 		if fullRequest != nil {
@@ -155,6 +184,23 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		host = "webhook.pipeops.app" // fallback
 	}
 
+	var shareLinks []shareLinkView
+	var sequences []sequenceView
+	var assertions []assertionView
+	var notifications []*store.NotificationChannel
+	if h.authorize(r, endpoint, permEdit) {
+		links, err := h.Store.ListShareLinks(r.Context(), endpointID)
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to list share links", "endpoint_id", endpointID, "error", err)
+		}
+		shareLinks = h.shareLinkViews(r, links)
+		sequences = h.sequenceViews(r, endpointID)
+		assertions = h.assertionViews(r, endpointID)
+		if notifications, err = h.Store.ListNotificationChannels(r.Context(), endpointID); err != nil {
+			slog.WarnContext(r.Context(), "failed to list notification channels", "endpoint_id", endpointID, "error", err)
+		}
+	}
+
 	// Get total count for pagination
 	totalCount, _ := h.Store.CountRequestsFiltered(r.Context(), endpointID, searchQuery)
 	hasMore := len(requests) < totalCount
@@ -165,6 +211,14 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		Requests       []*store.Request
 		FirstRequest   *requestDetailData
 		OtherEndpoints []*store.Endpoint
+		Workspaces     []*store.Workspace
+		CanEdit        bool
+		CanManage      bool
+		ShareLinks     []shareLinkView
+		Sequences      []sequenceView
+		Assertions     []assertionView
+		Notifications  []*store.NotificationChannel
+		EmailEnabled   bool
 		Host           string
 		Scheme         string
 		TotalCount     int
@@ -172,25 +226,30 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		Limit          int
 		SearchQuery    string
 	}{
-		BaseTemplateData: BaseTemplateData{
-			IsAdmin: h.IsAdminAuthenticated(r),
-		},
-		Endpoint:       endpoint,
-		Requests:       requests,
-		FirstRequest:   firstRequest,
-		OtherEndpoints: otherEndpoints,
-		Host:           host,
-		Scheme:         requestScheme(r),
-		TotalCount:     totalCount,
-		HasMore:        hasMore,
-		Limit:          limit,
-		SearchQuery:    searchQuery,
+		BaseTemplateData: h.baseTemplateData(r),
+		Endpoint:         endpoint,
+		Requests:         requests,
+		FirstRequest:     firstRequest,
+		OtherEndpoints:   otherEndpoints,
+		Workspaces:       h.editableWorkspaces(r),
+		CanEdit:          h.authorize(r, endpoint, permEdit),
+		CanManage:        h.authorize(r, endpoint, permManage),
+		ShareLinks:       shareLinks,
+		Sequences:        sequences,
+		Assertions:       assertions,
+		Notifications:    notifications,
+		EmailEnabled:     h.runtimeConfig().SMTP.Enabled() && (currentUser(r) != nil || h.IsAdminAuthenticated(r)),
+		Host:             host,
+		Scheme:           h.requestScheme(r),
+		TotalCount:       totalCount,
+		HasMore:          hasMore,
+		Limit:            limit,
+		SearchQuery:      searchQuery,
 	}
 
 	if err := dashboardTemplate.ExecuteTemplate(w, "layout", data); err != nil {
-		log.Printf("template execution error: %v", err)
-		log.Printf("Template data: Endpoint=%v, Requests=%d, FirstRequest=%v, Host=%s",
-			endpoint != nil, len(requests), firstRequest != nil, host)
+		slog.ErrorContext(r.Context(), "template execution error", "error", err, "endpoint", endpoint != nil,
+			"requests", len(requests), "first_request", firstRequest != nil, "host", host)
 		http.Error(w, "failed to render page", http.StatusInternalServerError)
 		return
 	}
@@ -202,7 +261,7 @@ func (h *Handler) LoadMoreRequests(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing endpoint ID", http.StatusBadRequest)
 		return
 	}
-	if _, ok := h.requireEndpointAccess(w, r, endpointID); !ok {
+	if _, ok := h.requireEndpointAccess(w, r, endpointID, permView); !ok {
 		return
 	}
 
@@ -268,14 +327,14 @@ func (h *Handler) RequestDetail(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "invalid request ID", http.StatusBadRequest)
 		return
 	}
-	req, ok := h.requireRequestAccess(w, r, id)
+	req, ok := h.requireRequestAccess(w, r, id, permView)
 	if !ok {
 		return
 	}
 
 	data := h.buildRequestDetailData(req)
 	if err := detailTemplate.ExecuteTemplate(w, "request-detail", data); err != nil {
-		log.Printf("template execution error: %v", err)
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
 		http.Error(w, "failed to render request", http.StatusInternalServerError)
 	}
 }
@@ -288,7 +347,8 @@ func (h *Handler) DeleteRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, ok := h.requireRequestAccess(w, r, id); !ok {
+	request, ok := h.requireRequestAccess(w, r, id, permEdit)
+	if !ok {
 		return
 	}
 
@@ -297,6 +357,7 @@ func (h *Handler) DeleteRequest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to delete request", http.StatusInternalServerError)
 		return
 	}
+	h.audit(r, auditRequestDelete, "request", idStr, map[string]string{"endpoint_id": request.EndpointID})
 
 	// Return empty response for HTMX to handle removal
 	w.WriteHeader(http.StatusOK)
@@ -309,7 +370,8 @@ func (h *Handler) DeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, ok := h.requireEndpointAccess(w, r, endpointID); !ok {
+	endpoint, ok := h.requireEndpointAccess(w, r, endpointID, permManage)
+	if !ok {
 		return
 	}
 
@@ -320,6 +382,8 @@ func (h *Handler) DeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to delete endpoint", http.StatusInternalServerError)
 		return
 	}
+	h.forgetEndpointMetrics(endpointID)
+	h.audit(r, auditEndpointDelete, "endpoint", endpointID, map[string]string{"alias": endpoint.Alias})
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -331,7 +395,8 @@ func (h *Handler) UpdateEndpointSettings(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if _, ok := h.requireEndpointAccess(w, r, endpointID); !ok {
+	endpoint, ok := h.requireEndpointAccess(w, r, endpointID, permEdit)
+	if !ok {
 		return
 	}
 
@@ -374,6 +439,35 @@ func (h *Handler) UpdateEndpointSettings(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "invalid request limit", http.StatusBadRequest)
 		return
 	}
+	var throttle store.Throttle
+	var chaos store.Chaos
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{
+		{"throttle_requests_per_second", &throttle.RequestsPerSecond}, {"throttle_burst", &throttle.Burst},
+		{"chaos_error_percent", &chaos.ErrorPercent}, {"chaos_latency_min_ms", &chaos.LatencyMinMS},
+		{"chaos_latency_max_ms", &chaos.LatencyMaxMS}, {"chaos_reset_percent", &chaos.ResetPercent},
+		{"chaos_drip_percent", &chaos.DripPercent}, {"chaos_drip_interval_ms", &chaos.DripIntervalMS},
+		{"chaos_fail_first", &chaos.FailFirst},
+	} {
+		if raw := strings.TrimSpace(r.FormValue(field.name)); raw != "" {
+			if *field.value, err = strconv.Atoi(raw); err != nil {
+				http.Error(w, "invalid "+strings.ReplaceAll(field.name, "_", " "), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	for _, raw := range splitInboundList(r.FormValue("chaos_error_statuses")) {
+		status, err := strconv.Atoi(raw)
+		if err != nil {
+			http.Error(w, "invalid chaos error status", http.StatusBadRequest)
+			return
+		}
+		chaos.ErrorStatuses = append(chaos.ErrorStatuses, status)
+	}
+	chaos.FailKeyHeader = r.FormValue("chaos_fail_key_header")
+	throttle.Body = r.FormValue("throttle_body")
 	contentType := strings.TrimSpace(r.FormValue("default_content_type"))
 	if contentType == "" {
 		contentType = store.DefaultResponseContentType
@@ -383,18 +477,32 @@ func (h *Handler) UpdateEndpointSettings(w http.ResponseWriter, r *http.Request)
 		DefaultContentType: contentType, ResponseDelayMS: responseDelay,
 		EnableCORS: r.FormValue("enable_cors") == "on", ForwardURL: strings.TrimSpace(r.FormValue("forward_url")),
 		RequestLimit: requestLimit,
-	}
+		InboundAuth: normalizeInboundAuth(store.InboundAuth{
+			BasicUsername: r.FormValue("inbound_basic_username"), BasicPassword: r.FormValue("inbound_basic_password"),
+			BearerToken: r.FormValue("inbound_bearer_token"), HeaderName: r.FormValue("inbound_header_name"),
+			HeaderValue: r.FormValue("inbound_header_value"), AllowedCIDRs: splitInboundList(r.FormValue("inbound_allowed_cidrs")),
+			ClientCertSHA256: splitInboundList(r.FormValue("inbound_client_cert_sha256")),
+			StoreRejected:    r.FormValue("inbound_store_rejected") == "on",
+		}),
+		Throttle: normalizeThrottle(throttle),
+		Chaos:    normalizeChaos(chaos),
+	}
+	settings.InboundAuth = keepInboundSecrets(settings.InboundAuth, endpoint.InboundAuth,
+		inboundSecrets{bearerToken: r.FormValue("inbound_bearer_token_clear") == "on"})
 	if err := validateEndpointSettings(settings); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	if err := h.Store.UpdateEndpointSettings(r.Context(), endpointID, settings); err != nil {
-		log.Printf("Error updating endpoint %s: %v", endpointID, err)
+		slog.ErrorContext(r.Context(), "failed to update endpoint", "endpoint_id", endpointID, "error", err)
 		http.Error(w, "failed to update endpoint", http.StatusInternalServerError)
 		return
 	}
+	if updated, err := h.Store.GetEndpoint(r.Context(), endpointID); err == nil {
+		h.audit(r, auditEndpointUpdate, "endpoint", endpointID, endpointSettingsChanges(endpoint, updated))
+	}
 	if err := h.Store.TrimRequests(r.Context(), endpointID, settings.RequestLimit); err != nil {
-		log.Printf("Error applying request limit to endpoint %s: %v", endpointID, err)
+		slog.ErrorContext(r.Context(), "failed to apply request limit", "endpoint_id", endpointID, "error", err)
 	}
 
 	// Return success with HX-Trigger to refresh the page
@@ -412,6 +520,61 @@ func (h *Handler) buildRequestDetailData(req *store.Request) *requestDetailData
 		contentType = "text/plain"
 	}
 
+	displayBody, notices := decodeRequestBody(req, headers)
+
+	isBinary := isBinaryBody(displayBody, contentType)
+	bodyString := ""
+	bodyHex := ""
+
+	if isBinary {
+		hexBytes := displayBody
+		if len(hexBytes) > maxDisplayHexBytes {
+			hexBytes = hexBytes[:maxDisplayHexBytes]
+			notices = append(notices, "Showing first 65536 bytes as hex.")
+		}
+		bodyHex = hex.Dump(hexBytes)
+	} else {
+		textBytes := displayBody
+		if len(textBytes) > maxDisplayTextBytes {
+			textBytes = textBytes[:maxDisplayTextBytes]
+			notices = append(notices, "Showing first 262144 bytes in viewer.")
+		}
+		bodyString = string(textBytes)
+	}
+
+	data := &requestDetailData{
+		Request:          req,
+		HeadersMap:       headers,
+		HeadersJSON:      string(headersJSON),
+		BodyString:       bodyString,
+		BodyHex:          bodyHex,
+		ContentType:      contentType,
+		IsBinary:         isBinary,
+		DisplayNotice:    strings.Join(notices, " "),
+		SnippetLanguages: snippetLanguages,
+		Viewer:           buildBodyViewer(displayBody, headerValue(headers, "Content-Type")),
+		ContentEncoding:  strings.TrimSpace(headerValue(headers, "Content-Encoding")),
+	}
+	if req.TraceID != "" && h.TraceURL != "" {
+		data.TraceLink = strings.ReplaceAll(h.TraceURL, "{trace_id}", req.TraceID)
+	}
+	if req.ResponseHeaders != "" {
+		responseHeaders := parseRequestHeaders(req.ID, req.ResponseHeaders)
+		responseHeadersJSON, _ := json.MarshalIndent(responseHeaders, "", "  ")
+		data.ResponseHeadersJSON = string(responseHeadersJSON)
+		responseContentType := normalizeContentType(headerValue(responseHeaders, "Content-Type"))
+		if isBinaryBody(req.ResponseBody, responseContentType) {
+			data.ResponseBodyString = hex.Dump(req.ResponseBody)
+		} else {
+			data.ResponseBodyString = string(req.ResponseBody)
+		}
+	}
+	return data
+}
+
+// decodeRequestBody returns the stored body the way the request view shows
+// it, decoded per its Content-Encoding, with notices saying what was done.
+func decodeRequestBody(req *store.Request, headers map[string][]string) ([]byte, []string) {
 	displayBody := req.Body
 	notices := []string{}
 
@@ -437,37 +600,7 @@ func (h *Handler) buildRequestDetailData(req *store.Request) *requestDetailData
 			notices = append(notices, "Stored body was truncated at capture time.")
 		}
 	}
-
-	isBinary := isBinaryBody(displayBody, contentType)
-	bodyString := ""
-	bodyHex := ""
-
-	if isBinary {
-		hexBytes := displayBody
-		if len(hexBytes) > maxDisplayHexBytes {
-			hexBytes = hexBytes[:maxDisplayHexBytes]
-			notices = append(notices, "Showing first 65536 bytes as hex.")
-		}
-		bodyHex = hex.Dump(hexBytes)
-	} else {
-		textBytes := displayBody
-		if len(textBytes) > maxDisplayTextBytes {
-			textBytes = textBytes[:maxDisplayTextBytes]
-			notices = append(notices, "Showing first 262144 bytes in viewer.")
-		}
-		bodyString = string(textBytes)
-	}
-
-	return &requestDetailData{
-		Request:       req,
-		HeadersMap:    headers,
-		HeadersJSON:   string(headersJSON),
-		BodyString:    bodyString,
-		BodyHex:       bodyHex,
-		ContentType:   contentType,
-		IsBinary:      isBinary,
-		DisplayNotice: strings.Join(notices, " "),
-	}
+	return displayBody, notices
 }
 
 func parseRequestHeaders(requestID int64, rawHeaders string) map[string][]string {
@@ -488,7 +621,7 @@ func parseRequestHeaders(requestID int64, rawHeaders string) map[string][]string
 		return headers
 	}
 
-	log.Printf("Warning: Failed to parse headers for request %d", requestID)
+	slog.Warn("failed to parse headers", "request", requestID)
 	return headers
 }
 
@@ -1,38 +1,178 @@
 package handler
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/PipeOpsHQ/pipehook/internal/logging"
 	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/PipeOpsHQ/pipehook/internal/tracing"
 	"github.com/go-chi/chi/v5"
 )
 
 func (h *Handler) CaptureWebhook(w http.ResponseWriter, r *http.Request) {
+	started := time.Now()
 	endpointID := chi.URLParam(r, "endpointID")
 	if endpointID == "" {
 		http.Error(w, "missing endpoint ID", http.StatusBadRequest)
 		return
 	}
-	endpoint, err := h.Store.GetEndpoint(r.Context(), endpointID)
-	if err != nil || endpoint.ExpiresAt.Before(time.Now()) {
+	ctx, span := h.Tracer.Start(tracing.Extract(r.Context(), r.Header), "CaptureWebhook", tracing.KindServer)
+	r = r.WithContext(ctx)
+	span.SetAttribute("http.request.method", r.Method)
+	span.SetAttribute("url.path", r.URL.Path)
+	span.SetAttribute("pipehook.endpoint_id", endpointID)
+	var endpoint *store.Endpoint
+	var stored *store.Request
+	result := captureThrottled
+	defer func() {
+		h.observeCapture(endpoint, result, started)
+		h.logCapture(r, endpointID, stored, result, started)
+		span.SetAttribute("pipehook.capture.outcome", result)
+		span.End()
+	}()
+	if !h.allowCaptureSource(w, r, endpointID) {
+		return
+	}
+	found, err := h.Store.GetEndpoint(r.Context(), endpointID)
+	if err != nil || found.ExpiresAt.Before(time.Now()) {
+		result = captureNotFound
 		http.Error(w, "endpoint not found", http.StatusNotFound)
 		return
 	}
+	endpoint = found
+	if !h.allowCaptureEndpoint(w, r, endpoint) {
+		return
+	}
+	if rejection := h.checkInboundAuth(r, endpoint.InboundAuth); rejection != nil {
+		result = captureRejected
+		stored = h.rejectCapture(w, r, endpoint, rejection, started)
+		return
+	}
+
+	status, body, contentType := responseStatus(endpoint), []byte(endpoint.DefaultBody), endpoint.DefaultContentType
+	outcome := h.planChaos(r, endpoint)
+	if outcome != nil && outcome.Status != 0 {
+		status, body, contentType = outcome.Status, []byte(http.StatusText(outcome.Status)), "text/plain; charset=utf-8"
+	}
+	assertion := h.matchAssertion(r, endpoint)
+	captured := &store.Request{StatusCode: status, Chaos: outcome}
+	message, err := h.readCapture(r, endpoint, captured, assertion)
+	if err == nil {
+		switch {
+		case rejectsInvalid(assertion, captured):
+			status, body, contentType = assertion.RejectStatus, validationRejection(captured.Validation), "application/json"
+		case outcome == nil || outcome.Status == 0 && !outcome.Reset:
+			// A sequence only moves on when its step is what the sender gets.
+			if step, number := h.nextSequenceStep(r, endpoint); step != nil {
+				status, body = step.Status, []byte(step.Body)
+				if step.ContentType != "" {
+					contentType = step.ContentType
+				}
+				captured.StatusCode, captured.SequenceStep = status, number
+			}
+		}
+		message, err = h.storeCapture(r, endpoint, captured)
+	}
+	if err != nil {
+		result = captureFailed
+		span.SetError(err)
+		http.Error(w, message, http.StatusInternalServerError)
+		return
+	}
+	result, stored = captureStored, captured
+	wasTruncated := captured.BodyTruncated
+	recorder := &responseRecorder{ResponseWriter: w}
+	defer h.recordResponse(r, captured, recorder, started)
+	w = recorder
+	h.broadcastCapture(r.Context(), captured)
+	if endpoint.ForwardURL != "" {
+		if err := h.forwardRequest(r.Context(), endpoint, captured); err != nil {
+			slog.WarnContext(r.Context(), "forwarding failed", "endpoint_id", endpoint.ID, "request", captured.ID, "error", err)
+		}
+	}
+
+	if endpoint.EnableCORS {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS, HEAD")
+	}
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if wasTruncated {
+		w.Header().Set("X-Pipehook-Body-Truncated", "true")
+	}
+	delay := time.Duration(endpoint.ResponseDelayMS) * time.Millisecond
+	if outcome != nil {
+		delay += time.Duration(outcome.LatencyMS) * time.Millisecond
+	}
+	deadline := h.responseDeadline(started)
+	if !deadline.IsZero() {
+		delay = min(delay, time.Until(deadline))
+	}
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if r.Method == http.MethodHead {
+		body = nil
+	}
+	if outcome != nil && outcome.Reset {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.WriteHeader(status)
+	writeChaosBody(w, r, outcome, body, deadline)
+}
+
+// writeTimeoutMargin is the time left for writing a response after injected
+// delays, before the server's write timeout would cut it off.
+const writeTimeoutMargin = time.Second
 
-	maxBodyBytes := h.MaxWebhookBodyBytes
+// responseDeadline is when injected delays must end for a capture that
+// arrived at started. It is zero when the server has no write timeout.
+func (h *Handler) responseDeadline(started time.Time) time.Time {
+	if h.WriteTimeout <= 0 {
+		return time.Time{}
+	}
+	return started.Add(max(h.WriteTimeout-writeTimeoutMargin, 0))
+}
+
+// saveCapture reads the request body up to the configured limit and stores
+// the request. captured carries the response details decided by the caller
+// and is completed from r, including the result of assertion when one
+// applies. On error the returned message is safe to show the sender.
+func (h *Handler) saveCapture(r *http.Request, endpoint *store.Endpoint, captured *store.Request, assertion *store.Assertion) (*store.Request, string, error) {
+	if message, err := h.readCapture(r, endpoint, captured, assertion); err != nil {
+		return nil, message, err
+	}
+	if message, err := h.storeCapture(r, endpoint, captured); err != nil {
+		return nil, message, err
+	}
+	return captured, "", nil
+}
+
+// readCapture is the first half of saveCapture: it fills captured from r and
+// checks it against assertion without storing it.
+func (h *Handler) readCapture(r *http.Request, endpoint *store.Endpoint, captured *store.Request, assertion *store.Assertion) (string, error) {
+	maxBodyBytes := h.runtimeConfig().MaxWebhookBodyBytes
 	if maxBodyBytes <= 0 {
 		maxBodyBytes = 2 * 1024 * 1024
 	}
 	body, wasTruncated, err := readRequestBodyWithLimit(r.Body, maxBodyBytes)
 	if err != nil {
-		log.Printf("Error reading body for %s %s: %v", r.Method, r.URL.Path, err)
-		http.Error(w, "failed to read body", http.StatusInternalServerError)
-		return
+		slog.WarnContext(r.Context(), "failed to read capture body", "endpoint_id", endpoint.ID, "error", err)
+		return "failed to read body", err
 	}
 
 	headersToStore := make(map[string][]string, len(r.Header)+2)
@@ -45,51 +185,119 @@ func (h *Handler) CaptureWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 	headersJSON, _ := json.Marshal(headersToStore)
 
-	captured := &store.Request{
-		EndpointID: endpointID, Method: r.Method, Path: r.URL.Path, QueryString: r.URL.RawQuery,
-		Host: r.Host, Scheme: requestScheme(r), RemoteAddr: r.RemoteAddr, Headers: string(headersJSON),
-		Body: body, ContentLength: r.ContentLength, BodyTruncated: wasTruncated, StatusCode: responseStatus(endpoint),
+	captured.EndpointID, captured.Method, captured.Path, captured.QueryString = endpoint.ID, r.Method, r.URL.Path, r.URL.RawQuery
+	captured.Host, captured.Scheme, captured.RemoteAddr, captured.Headers = r.Host, h.requestScheme(r), r.RemoteAddr, string(headersJSON)
+	captured.Body, captured.ContentLength, captured.BodyTruncated = body, r.ContentLength, wasTruncated
+	captured.TraceID, captured.CorrelationID = tracing.TraceID(r.Context()), logging.RequestIDFromContext(r.Context())
+	if assertion != nil {
+		captured.Validation = h.checkAssertion(assertion, captured, r.Header, int(maxBodyBytes))
+		if rejectsInvalid(assertion, captured) {
+			captured.StatusCode = assertion.RejectStatus
+		}
 	}
+	return "", nil
+}
+
+// storeCapture is the second half of saveCapture: it saves captured and
+// tells subscribers about it.
+func (h *Handler) storeCapture(r *http.Request, endpoint *store.Endpoint, captured *store.Request) (string, error) {
 	if err := h.Store.SaveRequest(r.Context(), captured); err != nil {
-		log.Printf("Error saving request: %v", err)
-		http.Error(w, "failed to save request", http.StatusInternalServerError)
-		return
+		slog.ErrorContext(r.Context(), "failed to save capture", "endpoint_id", endpoint.ID, "error", err)
+		return "failed to save request", err
 	}
-	if err := h.Store.TrimRequests(r.Context(), endpointID, endpoint.RequestLimit); err != nil {
-		log.Printf("Error enforcing request retention for %s: %v", endpointID, err)
+	h.observeCaptureBody(endpoint, captured)
+	h.notifyCapture(r, captured)
+	if err := h.Store.TrimRequests(r.Context(), endpoint.ID, endpoint.RequestLimit); err != nil {
+		slog.ErrorContext(r.Context(), "failed to enforce request retention", "endpoint_id", endpoint.ID, "error", err)
 	}
+	return "", nil
+}
+
+// logCapture writes one line per capture attempt. stored is the saved
+// request, if any; otherwise size is the sender's declared Content-Length.
+func (h *Handler) logCapture(r *http.Request, endpointID string, stored *store.Request, outcome string, started time.Time) {
+	attrs := []slog.Attr{
+		slog.String("endpoint_id", endpointID), slog.String("method", r.Method), slog.String("path", r.URL.Path),
+		slog.String("outcome", outcome), slog.Duration("duration", time.Since(started)), slog.String("remote_addr", r.RemoteAddr),
+	}
+	if stored != nil {
+		attrs = append(attrs, slog.Int64("request", stored.ID), slog.Int("size", len(stored.Body)), slog.Bool("truncated", stored.BodyTruncated))
+	} else if r.ContentLength >= 0 {
+		attrs = append(attrs, slog.Int64("size", r.ContentLength))
+	}
+	level := slog.LevelInfo
+	if outcome == captureFailed {
+		level = slog.LevelError
+	}
+	slog.LogAttrs(r.Context(), level, "webhook capture", attrs...)
+}
 
-	h.Broadcast(endpointID, &store.Request{
+// broadcastCapture sends the list view fields of a new capture to viewers.
+func (h *Handler) broadcastCapture(ctx context.Context, captured *store.Request) {
+	_, span := h.Tracer.Start(ctx, "Broadcast", tracing.KindInternal)
+	defer span.End()
+	h.Broadcast(captured.EndpointID, &store.Request{
 		ID: captured.ID, EndpointID: captured.EndpointID, Method: captured.Method, Path: captured.Path,
-		QueryString: captured.QueryString, RemoteAddr: captured.RemoteAddr, CreatedAt: captured.CreatedAt,
+		QueryString: captured.QueryString, RemoteAddr: captured.RemoteAddr, RejectedReason: captured.RejectedReason,
+		Validation: captured.Validation, CreatedAt: captured.CreatedAt,
 	})
-	if endpoint.ForwardURL != "" {
-		if err := h.forwardRequest(r.Context(), endpoint, captured); err != nil {
-			log.Printf("Forwarding request %d failed: %v", captured.ID, err)
-		}
+}
+
+// maxRecordedResponseBytes caps the response body kept with a capture. Every
+// configurable response body is smaller, so this only guards against surprises.
+const maxRecordedResponseBytes = 64 * 1024
+
+// responseRecorder passes a capture's response through while keeping a copy
+// of what was sent.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     []byte
+	writeErr error
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status, rec.header = status, rec.ResponseWriter.Header().Clone()
 	}
+	rec.ResponseWriter.WriteHeader(status)
+}
 
-	if endpoint.EnableCORS {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Headers", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS, HEAD")
+func (rec *responseRecorder) Write(p []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
 	}
-	if endpoint.DefaultContentType != "" {
-		w.Header().Set("Content-Type", endpoint.DefaultContentType)
+	if room := maxRecordedResponseBytes - len(rec.body); room > 0 {
+		rec.body = append(rec.body, p[:min(room, len(p))]...)
 	}
-	if wasTruncated {
-		w.Header().Set("X-Pipehook-Body-Truncated", "true")
+	n, err := rec.ResponseWriter.Write(p)
+	if err != nil && rec.writeErr == nil {
+		rec.writeErr = err
 	}
-	if endpoint.ResponseDelayMS > 0 {
-		select {
-		case <-time.After(time.Duration(endpoint.ResponseDelayMS) * time.Millisecond):
-		case <-r.Context().Done():
-			return
-		}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// recordResponse stores what rec saw of the response to captured. It runs
+// deferred, so it also sees responses cut short by a chaos reset.
+func (h *Handler) recordResponse(r *http.Request, captured *store.Request, rec *responseRecorder, started time.Time) {
+	header := rec.header
+	if rec.status != 0 {
+		captured.StatusCode = rec.status
+	} else {
+		header = rec.ResponseWriter.Header().Clone()
 	}
-	w.WriteHeader(responseStatus(endpoint))
-	if r.Method != http.MethodHead {
-		_, _ = w.Write([]byte(endpoint.DefaultBody))
+	headersJSON, _ := json.Marshal(header)
+	captured.ResponseHeaders, captured.ResponseBody = string(headersJSON), rec.body
+	captured.ResponseTimeMS = time.Since(started).Milliseconds()
+	captured.ClientAborted = rec.writeErr != nil || r.Context().Err() != nil
+	if err := h.Store.RecordResponse(context.WithoutCancel(r.Context()), captured); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(r.Context(), "failed to record response", "request", captured.ID, "error", err)
 	}
 }
 
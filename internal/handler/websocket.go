@@ -1,7 +1,7 @@
 package handler
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -15,13 +15,17 @@ func (h *Handler) WebSocket(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing endpoint ID", http.StatusBadRequest)
 		return
 	}
-	if _, ok := h.requireEndpointAccess(w, r, endpointID); !ok {
+	if _, ok := h.requireEndpointAccess(w, r, endpointID, permView); !ok {
 		return
 	}
 
+	if origin := r.Header.Get("Origin"); origin != "" && origin != h.requestScheme(r)+"://"+r.Host {
+		http.Error(w, "cross-origin websocket connections are not allowed", http.StatusForbidden)
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("websocket upgrade error: %v", err)
+		slog.WarnContext(r.Context(), "websocket upgrade failed", "error", err)
 		return
 	}
 	// We don't consume application payloads from clients, so keep inbound frame size tiny.
@@ -77,7 +81,7 @@ func (h *Handler) WebSocket(w http.ResponseWriter, r *http.Request) {
 		_, _, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("websocket error: %v", err)
+				slog.WarnContext(r.Context(), "websocket error", "endpoint_id", endpointID, "error", err)
 			}
 			break
 		}
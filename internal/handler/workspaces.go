@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+type workspacePageData struct {
+	BaseTemplateData
+	Workspace *store.Workspace
+	Role      string
+	Members   []*store.WorkspaceMember
+	Endpoints []*store.Endpoint
+	Roles     []string
+	Error     string
+}
+
+// workspaceRole returns the current user's role in the workspace. Admins act
+// as owners of every workspace.
+func (h *Handler) workspaceRole(r *http.Request, workspaceID string) string {
+	if h.IsAdminAuthenticated(r) {
+		return store.WorkspaceRoleOwner
+	}
+	user := currentUser(r)
+	if user == nil {
+		return ""
+	}
+	role, err := h.Store.GetWorkspaceRole(r.Context(), workspaceID, user.ID)
+	if err != nil {
+		return ""
+	}
+	return role
+}
+
+func (h *Handler) requireWorkspaceRole(w http.ResponseWriter, r *http.Request, perm permission) (*store.Workspace, string, bool) {
+	workspace, err := h.Store.GetWorkspace(r.Context(), chi.URLParam(r, "workspaceID"))
+	if err != nil {
+		http.Error(w, "workspace not found", http.StatusNotFound)
+		return nil, "", false
+	}
+	role := h.workspaceRole(r, workspace.ID)
+	if rolePermission(role) < perm {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return nil, "", false
+	}
+	return workspace, role, true
+}
+
+func (h *Handler) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r)
+	if user == nil {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" || len(name) > 120 {
+		http.Error(w, "workspace name is required and must not exceed 120 characters", http.StatusBadRequest)
+		return
+	}
+	workspace := &store.Workspace{ID: uuid.NewString(), Name: name}
+	if err := h.Store.CreateWorkspace(r.Context(), workspace, user.ID); err != nil {
+		slog.ErrorContext(r.Context(), "failed to create workspace", "error", err)
+		http.Error(w, "failed to create workspace", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/workspaces/"+workspace.ID, http.StatusSeeOther)
+}
+
+func (h *Handler) WorkspacePage(w http.ResponseWriter, r *http.Request) {
+	workspace, role, ok := h.requireWorkspaceRole(w, r, permView)
+	if !ok {
+		return
+	}
+	h.renderWorkspace(w, r, http.StatusOK, workspace, role, "")
+}
+
+func (h *Handler) renderWorkspace(w http.ResponseWriter, r *http.Request, status int, workspace *store.Workspace, role, message string) {
+	members, err := h.Store.ListWorkspaceMembers(r.Context(), workspace.ID)
+	if err != nil {
+		http.Error(w, "failed to load workspace members", http.StatusInternalServerError)
+		return
+	}
+	endpoints, err := h.Store.ListWorkspaceEndpoints(r.Context(), workspace.ID, 100)
+	if err != nil {
+		http.Error(w, "failed to load workspace endpoints", http.StatusInternalServerError)
+		return
+	}
+	data := workspacePageData{
+		BaseTemplateData: h.baseTemplateData(r),
+		Workspace:        workspace,
+		Role:             role,
+		Members:          members,
+		Endpoints:        endpoints,
+		Roles:            store.WorkspaceRoles,
+		Error:            message,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := workspaceTemplate.ExecuteTemplate(w, "layout", data); err != nil {
+		slog.ErrorContext(r.Context(), "template execution error", "error", err)
+	}
+}
+
+// SetWorkspaceMember adds a member by username or changes their role.
+func (h *Handler) SetWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	workspace, role, ok := h.requireWorkspaceRole(w, r, permManage)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	memberRole := r.FormValue("role")
+	if !slices.Contains(store.WorkspaceRoles, memberRole) {
+		h.renderWorkspace(w, r, http.StatusBadRequest, workspace, role, "unknown role")
+		return
+	}
+	member, _, err := h.Store.GetUserByUsername(r.Context(), strings.TrimSpace(r.FormValue("username")))
+	if err != nil {
+		h.renderWorkspace(w, r, http.StatusBadRequest, workspace, role, "no account with that username")
+		return
+	}
+	if err := h.Store.SetWorkspaceMember(r.Context(), workspace.ID, member.ID, memberRole); err != nil {
+		if errors.Is(err, store.ErrLastWorkspaceOwner) {
+			h.renderWorkspace(w, r, http.StatusConflict, workspace, role, err.Error())
+			return
+		}
+		slog.ErrorContext(r.Context(), "failed to set workspace member", "error", err)
+		http.Error(w, "failed to update member", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, auditWorkspaceMember, "workspace", workspace.ID, map[string]string{
+		"user_id": member.ID, "username": member.Username, "role": memberRole,
+	})
+	http.Redirect(w, r, "/workspaces/"+workspace.ID, http.StatusSeeOther)
+}
+
+// RemoveWorkspaceMember lets owners remove anyone and members leave on their own.
+func (h *Handler) RemoveWorkspaceMember(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "userID")
+	perm := permManage
+	if user := currentUser(r); user != nil && user.ID == userID {
+		perm = permView
+	}
+	workspace, _, ok := h.requireWorkspaceRole(w, r, perm)
+	if !ok {
+		return
+	}
+	if err := h.Store.RemoveWorkspaceMember(r.Context(), workspace.ID, userID); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			http.Error(w, "member not found", http.StatusNotFound)
+		case errors.Is(err, store.ErrLastWorkspaceOwner):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "failed to remove member", http.StatusInternalServerError)
+		}
+		return
+	}
+	h.audit(r, auditWorkspaceRemoved, "workspace", workspace.ID, map[string]string{"user_id": userID})
+	w.Header().Set("HX-Refresh", "true")
+	w.WriteHeader(http.StatusOK)
+}
+
+// MoveEndpointWorkspace shares an endpoint with a workspace, or makes it
+// personal again when workspace_id is empty. The caller needs permManage on
+// the endpoint and at least the editor role in the target workspace.
+func (h *Handler) MoveEndpointWorkspace(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := h.requireEndpointAccess(w, r, chi.URLParam(r, "endpointID"), permManage)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, 16*1024)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form data", http.StatusBadRequest)
+		return
+	}
+	workspaceID := strings.TrimSpace(r.FormValue("workspace_id"))
+	if workspaceID != "" && rolePermission(h.workspaceRole(r, workspaceID)) < permEdit {
+		http.Error(w, "you need the editor role in the target workspace", http.StatusForbidden)
+		return
+	}
+	// An endpoint leaving the browser-cookie model must belong to someone, or
+	// nobody but administrators could reach it again.
+	if user := currentUser(r); user != nil && endpoint.OwnerUserID == "" {
+		if err := h.Store.SetEndpointOwner(r.Context(), endpoint.ID, user.ID); err != nil {
+			http.Error(w, "failed to move endpoint", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := h.Store.SetEndpointWorkspace(r.Context(), endpoint.ID, workspaceID); err != nil {
+		http.Error(w, "failed to move endpoint", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, auditEndpointMove, "endpoint", endpoint.ID, map[string]auditChange{
+		"workspace_id": {From: endpoint.WorkspaceID, To: workspaceID},
+	})
+	http.Redirect(w, r, "/"+endpoint.ID, http.StatusSeeOther)
+}
+
+// editableWorkspaces lists the workspaces the current user may move
+// endpoints into.
+func (h *Handler) editableWorkspaces(r *http.Request) []*store.Workspace {
+	user := currentUser(r)
+	if user == nil {
+		return nil
+	}
+	workspaces, err := h.Store.ListUserWorkspaces(r.Context(), user.ID)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list workspaces", "user_id", user.ID, "error", err)
+		return nil
+	}
+	return slices.DeleteFunc(workspaces, func(workspace *store.Workspace) bool {
+		return rolePermission(workspace.Role) < permEdit
+	})
+}
@@ -0,0 +1,699 @@
+// Package jsonschema checks JSON documents against the commonly used part of
+// JSON Schema, drafts 2020-12 and 07: type, enum, const, the string, number,
+// array and object limits, properties, required, additionalProperties,
+// patternProperties, items, prefixItems, allOf, anyOf, oneOf, not,
+// if/then/else and $ref to "#" or a JSON pointer into the same document.
+// Other keywords, such as format, are ignored as annotations.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxDepth bounds how deep schemas and $ref chains are followed, so a
+	// schema that refers to itself cannot loop forever.
+	maxDepth = 64
+	// maxViolations bounds the violations reported for one document.
+	maxViolations = 20
+	// maxSteps bounds the subschemas visited for one document, so $ref
+	// chains that fan out through anyOf and friends cannot take
+	// exponential time.
+	maxSteps = 500000
+)
+
+var typeNames = map[string]bool{
+	"null": true, "boolean": true, "integer": true, "number": true, "string": true, "array": true, "object": true,
+}
+
+// Schema is a compiled schema. It is safe for concurrent use.
+type Schema struct {
+	root     any
+	patterns map[string]*regexp.Regexp
+	// objects lists every subschema while compiling, for checkCycles.
+	objects []map[string]any
+}
+
+// Compile parses a schema and checks that its types, patterns and
+// references can be used.
+func Compile(data []byte) (*Schema, error) {
+	root, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	schema := &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := schema.check(root, "#"); err != nil {
+		return nil, err
+	}
+	if err := schema.checkCycles(); err != nil {
+		return nil, err
+	}
+	schema.objects = nil
+	return schema, nil
+}
+
+// Validate reports where document breaks the schema, as "pointer: problem"
+// lines, or an error when document is not JSON. No violations means the
+// document is valid.
+func (s *Schema) Validate(document []byte) ([]string, error) {
+	instance, err := decode(document)
+	if err != nil {
+		return nil, err
+	}
+	steps := 0
+	v := &validator{schema: s, limit: maxViolations, steps: &steps}
+	v.validate(s.root, instance, "", 0)
+	if v.dropped > 0 {
+		v.violations = append(v.violations, fmt.Sprintf("and %d more", v.dropped))
+	}
+	if steps > maxSteps {
+		v.violations = append(v.violations, "/: the schema is too expensive to check against this document")
+	}
+	return v.violations, nil
+}
+
+// decode reads one JSON value, keeping numbers exact.
+func decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// check walks every subschema once, at compile time.
+func (s *Schema) check(schema any, location string) error {
+	object, ok := schema.(map[string]any)
+	if !ok {
+		if _, ok := schema.(bool); ok {
+			return nil
+		}
+		return fmt.Errorf("%s: a schema must be an object or a boolean", location)
+	}
+	s.objects = append(s.objects, object)
+	if value, ok := object["type"]; ok {
+		names, ok := stringList(value)
+		if !ok || len(names) == 0 {
+			return fmt.Errorf("%s/type: must be a type name or a list of them", location)
+		}
+		for _, name := range names {
+			if !typeNames[name] {
+				return fmt.Errorf("%s/type: unknown type %q", location, name)
+			}
+		}
+	}
+	if value, ok := object["$ref"]; ok {
+		ref, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s/$ref: must be a string", location)
+		}
+		if _, err := s.resolve(ref); err != nil {
+			return fmt.Errorf("%s/$ref: %w", location, err)
+		}
+	}
+	if value, ok := object["pattern"]; ok {
+		pattern, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s/pattern: must be a string", location)
+		}
+		if err := s.compilePattern(pattern); err != nil {
+			return fmt.Errorf("%s/pattern: %w", location, err)
+		}
+	}
+	for _, keyword := range []string{"properties", "patternProperties", "$defs", "definitions"} {
+		value, ok := object[keyword]
+		if !ok {
+			continue
+		}
+		children, ok := value.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s/%s: must be an object", location, keyword)
+		}
+		for _, name := range sortedKeys(children) {
+			if keyword == "patternProperties" {
+				if err := s.compilePattern(name); err != nil {
+					return fmt.Errorf("%s/%s: %w", location, keyword, err)
+				}
+			}
+			if err := s.check(children[name], location+"/"+keyword+"/"+escape(name)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf", "prefixItems", "items"} {
+		value, ok := object[keyword]
+		if !ok {
+			continue
+		}
+		children, ok := value.([]any)
+		if !ok {
+			if keyword == "items" {
+				// items is a single schema since draft 2020-12.
+				if err := s.check(value, location+"/items"); err != nil {
+					return err
+				}
+				continue
+			}
+			return fmt.Errorf("%s/%s: must be an array of schemas", location, keyword)
+		}
+		for i, child := range children {
+			if err := s.check(child, location+"/"+keyword+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, keyword := range []string{"additionalProperties", "additionalItems", "not", "if", "then", "else"} {
+		if value, ok := object[keyword]; ok {
+			if err := s.check(value, location+"/"+keyword); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// link is a subschema applied to the same value as its parent.
+type link struct {
+	schema     any
+	location   string
+	combinator bool
+}
+
+// sameValue lists the subschemas of object that apply to the value object
+// applies to, rather than to a nested one.
+func (s *Schema) sameValue(object map[string]any, location string) []link {
+	var links []link
+	if ref, ok := object["$ref"].(string); ok {
+		if target, err := s.resolve(ref); err == nil {
+			links = append(links, link{schema: target, location: location + "/$ref"})
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		children, _ := object[keyword].([]any)
+		for i, child := range children {
+			links = append(links, link{schema: child, location: location + "/" + keyword + "/" + strconv.Itoa(i), combinator: true})
+		}
+	}
+	for _, keyword := range []string{"not", "if", "then", "else"} {
+		if child, ok := object[keyword]; ok {
+			links = append(links, link{schema: child, location: location + "/" + keyword, combinator: true})
+		}
+	}
+	return links
+}
+
+// checkCycles rejects schemas that reach themselves again through a
+// combinator without descending into the value, like
+// {"anyOf": [{"$ref": "#"}, {"$ref": "#"}]}, which would fan out on every
+// level until the depth limit. A plain {"$ref": "#"} only recurses in a line
+// and is left to the depth limit.
+func (s *Schema) checkCycles() error {
+	const onPath, done = 1, 2
+	state := make(map[uintptr]int, len(s.objects))
+	type step struct {
+		id         uintptr
+		combinator bool
+	}
+	var path []step
+	var visit func(object map[string]any, location string) error
+	visit = func(object map[string]any, location string) error {
+		id := reflect.ValueOf(object).Pointer()
+		state[id] = onPath
+		path = append(path, step{id: id})
+		for _, next := range s.sameValue(object, location) {
+			child, ok := next.schema.(map[string]any)
+			if !ok {
+				continue
+			}
+			path[len(path)-1].combinator = next.combinator
+			childID := reflect.ValueOf(child).Pointer()
+			switch state[childID] {
+			case onPath:
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i].combinator {
+						return fmt.Errorf("%s: refers back to an enclosing schema through a combinator without descending into the value", next.location)
+					}
+					if path[i].id == childID {
+						break
+					}
+				}
+			case 0:
+				if err := visit(child, next.location); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = done
+		return nil
+	}
+	for _, object := range s.objects {
+		if state[reflect.ValueOf(object).Pointer()] == 0 {
+			if err := visit(object, "#"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compilePattern(pattern string) error {
+	if _, ok := s.patterns[pattern]; ok {
+		return nil
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	s.patterns[pattern] = compiled
+	return nil
+}
+
+// resolve follows a $ref of "#" or "#/json/pointer" within the schema.
+func (s *Schema) resolve(ref string) (any, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only references within the schema, like #/$defs/name, are supported, not %q", ref)
+	}
+	current := s.root
+	if ref == "#" {
+		return current, nil
+	}
+	for _, token := range strings.Split(ref[2:], "/") {
+		if unescaped, err := url.PathUnescape(token); err == nil {
+			token = unescaped
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch node := current.(type) {
+		case map[string]any:
+			next, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("reference %q does not resolve", ref)
+			}
+			current = next
+		case []any:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("reference %q does not resolve", ref)
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("reference %q does not resolve", ref)
+		}
+	}
+	switch current.(type) {
+	case map[string]any, bool:
+		return current, nil
+	}
+	return nil, fmt.Errorf("reference %q does not point at a schema", ref)
+}
+
+type validator struct {
+	schema *Schema
+	limit  int
+	// failFast stops at the first violation, for probes that only need to
+	// know whether a value matches.
+	failFast bool
+	// steps counts subschemas visited, shared with every probe.
+	steps      *int
+	violations []string
+	dropped    int
+}
+
+func (v *validator) halted() bool {
+	return *v.steps > maxSteps || (v.failFast && len(v.violations) > 0)
+}
+
+func (v *validator) add(pointer, format string, args ...any) {
+	if len(v.violations) >= v.limit {
+		v.dropped++
+		return
+	}
+	if pointer == "" {
+		pointer = "/"
+	}
+	v.violations = append(v.violations, pointer+": "+fmt.Sprintf(format, args...))
+}
+
+// matches reports whether instance satisfies schema without recording
+// anything, for anyOf, oneOf, not and if.
+func (v *validator) matches(schema, instance any, pointer string, depth int) bool {
+	probe := &validator{schema: v.schema, limit: 1, failFast: true, steps: v.steps}
+	probe.validate(schema, instance, pointer, depth)
+	return len(probe.violations) == 0
+}
+
+func (v *validator) validate(schema, instance any, pointer string, depth int) {
+	if v.halted() {
+		return
+	}
+	*v.steps++
+	if depth > maxDepth {
+		v.add(pointer, "schema nests too deeply")
+		return
+	}
+	object, ok := schema.(map[string]any)
+	if !ok {
+		if allowed, _ := schema.(bool); !allowed {
+			v.add(pointer, "no value is allowed here")
+		}
+		return
+	}
+	if ref, ok := object["$ref"].(string); ok {
+		if target, err := v.schema.resolve(ref); err == nil {
+			v.validate(target, instance, pointer, depth+1)
+		}
+	}
+	if value, ok := object["type"]; ok {
+		names, _ := stringList(value)
+		if !hasType(names, instance) {
+			v.add(pointer, "must be %s, got %s", strings.Join(names, " or "), typeName(instance))
+			return
+		}
+	}
+	if value, ok := object["enum"].([]any); ok {
+		found := false
+		for _, option := range value {
+			if equal(option, instance) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.add(pointer, "must be one of %s", compact(value))
+		}
+	}
+	if value, ok := object["const"]; ok && !equal(value, instance) {
+		v.add(pointer, "must be %s", compact(value))
+	}
+
+	switch value := instance.(type) {
+	case string:
+		v.validateString(object, value, pointer)
+	case json.Number:
+		v.validateNumber(object, value, pointer)
+	case []any:
+		v.validateArray(object, value, pointer, depth)
+	case map[string]any:
+		v.validateObject(object, value, pointer, depth)
+	}
+	if v.halted() {
+		return
+	}
+
+	if value, ok := object["allOf"].([]any); ok {
+		for _, child := range value {
+			v.validate(child, instance, pointer, depth+1)
+		}
+	}
+	if value, ok := object["anyOf"].([]any); ok {
+		matched := false
+		for _, child := range value {
+			if v.halted() {
+				return
+			}
+			if v.matches(child, instance, pointer, depth+1) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.add(pointer, "must match at least one schema in anyOf")
+		}
+	}
+	if value, ok := object["oneOf"].([]any); ok {
+		matched := 0
+		for _, child := range value {
+			if v.halted() {
+				return
+			}
+			if v.matches(child, instance, pointer, depth+1) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			v.add(pointer, "must match exactly one schema in oneOf, matched %d", matched)
+		}
+	}
+	if value, ok := object["not"]; ok && v.matches(value, instance, pointer, depth+1) {
+		v.add(pointer, "must not match the schema in not")
+	}
+	if condition, ok := object["if"]; ok {
+		branch := "else"
+		if v.matches(condition, instance, pointer, depth+1) {
+			branch = "then"
+		}
+		if value, ok := object[branch]; ok {
+			v.validate(value, instance, pointer, depth+1)
+		}
+	}
+}
+
+func (v *validator) validateString(object map[string]any, value, pointer string) {
+	length := utf8.RuneCountInString(value)
+	if limit, ok := number(object["minLength"]); ok && float64(length) < limit {
+		v.add(pointer, "must be at least %s characters long, got %d", format(limit), length)
+	}
+	if limit, ok := number(object["maxLength"]); ok && float64(length) > limit {
+		v.add(pointer, "must be at most %s characters long, got %d", format(limit), length)
+	}
+	if pattern, ok := object["pattern"].(string); ok {
+		if compiled := v.schema.patterns[pattern]; compiled != nil && !compiled.MatchString(value) {
+			v.add(pointer, "must match the pattern %q", pattern)
+		}
+	}
+}
+
+func (v *validator) validateNumber(object map[string]any, raw json.Number, pointer string) {
+	value, _ := number(raw)
+	if limit, ok := number(object["minimum"]); ok && value < limit {
+		v.add(pointer, "must be at least %s, got %s", format(limit), raw)
+	}
+	if limit, ok := number(object["maximum"]); ok && value > limit {
+		v.add(pointer, "must be at most %s, got %s", format(limit), raw)
+	}
+	if limit, ok := number(object["exclusiveMinimum"]); ok && value <= limit {
+		v.add(pointer, "must be greater than %s, got %s", format(limit), raw)
+	}
+	if limit, ok := number(object["exclusiveMaximum"]); ok && value >= limit {
+		v.add(pointer, "must be less than %s, got %s", format(limit), raw)
+	}
+	if divisor, ok := number(object["multipleOf"]); ok && divisor > 0 {
+		quotient := value / divisor
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			v.add(pointer, "must be a multiple of %s, got %s", format(divisor), raw)
+		}
+	}
+}
+
+func (v *validator) validateArray(object map[string]any, value []any, pointer string, depth int) {
+	if limit, ok := number(object["minItems"]); ok && float64(len(value)) < limit {
+		v.add(pointer, "must have at least %s items, got %d", format(limit), len(value))
+	}
+	if limit, ok := number(object["maxItems"]); ok && float64(len(value)) > limit {
+		v.add(pointer, "must have at most %s items, got %d", format(limit), len(value))
+	}
+	if unique, _ := object["uniqueItems"].(bool); unique {
+	duplicates:
+		for i := range value {
+			for j := i + 1; j < len(value); j++ {
+				if equal(value[i], value[j]) {
+					v.add(pointer, "items %d and %d must not be equal", i, j)
+					break duplicates
+				}
+			}
+		}
+	}
+	// Draft-07 tuples list the item schemas under items; 2020-12 moved them
+	// to prefixItems.
+	prefix, _ := object["prefixItems"].([]any)
+	rest, hasRest := object["items"]
+	if tuple, ok := rest.([]any); ok {
+		prefix = tuple
+		rest, hasRest = object["additionalItems"]
+	}
+	for i, item := range value {
+		switch {
+		case i < len(prefix):
+			v.validate(prefix[i], item, pointer+"/"+strconv.Itoa(i), depth+1)
+		case hasRest:
+			v.validate(rest, item, pointer+"/"+strconv.Itoa(i), depth+1)
+		}
+	}
+}
+
+func (v *validator) validateObject(object map[string]any, value map[string]any, pointer string, depth int) {
+	if limit, ok := number(object["minProperties"]); ok && float64(len(value)) < limit {
+		v.add(pointer, "must have at least %s properties, got %d", format(limit), len(value))
+	}
+	if limit, ok := number(object["maxProperties"]); ok && float64(len(value)) > limit {
+		v.add(pointer, "must have at most %s properties, got %d", format(limit), len(value))
+	}
+	if required, ok := object["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, present := value[name]; !present {
+					v.add(pointer, "missing required property %q", name)
+				}
+			}
+		}
+	}
+	properties, _ := object["properties"].(map[string]any)
+	patterns, _ := object["patternProperties"].(map[string]any)
+	additional, hasAdditional := object["additionalProperties"]
+	for _, name := range sortedKeys(value) {
+		child := pointer + "/" + escape(name)
+		known := false
+		if schema, ok := properties[name]; ok {
+			known = true
+			v.validate(schema, value[name], child, depth+1)
+		}
+		for _, pattern := range sortedKeys(patterns) {
+			if compiled := v.schema.patterns[pattern]; compiled != nil && compiled.MatchString(name) {
+				known = true
+				v.validate(patterns[pattern], value[name], child, depth+1)
+			}
+		}
+		if !known && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				v.add(pointer, "property %q is not allowed", name)
+				continue
+			}
+			v.validate(additional, value[name], child, depth+1)
+		}
+	}
+}
+
+func stringList(value any) ([]string, bool) {
+	switch value := value.(type) {
+	case string:
+		return []string{value}, true
+	case []any:
+		names := make([]string, 0, len(value))
+		for _, item := range value {
+			name, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			names = append(names, name)
+		}
+		return names, true
+	}
+	return nil, false
+}
+
+func hasType(names []string, instance any) bool {
+	actual := typeName(instance)
+	for _, name := range names {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func typeName(instance any) string {
+	switch value := instance.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if f, ok := number(value); ok && f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", instance)
+}
+
+func number(value any) (float64, bool) {
+	raw, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := raw.Float64()
+	return f, err == nil
+}
+
+func format(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// equal compares JSON values, numbers by value.
+func equal(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		x, ok := number(a)
+		y, ok2 := number(b)
+		return ok && ok2 && x == y
+	case []any:
+		other, ok := b.([]any)
+		if !ok || len(a) != len(other) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], other[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		other, ok := b.(map[string]any)
+		if !ok || len(a) != len(other) {
+			return false
+		}
+		for key, value := range a {
+			if otherValue, ok := other[key]; !ok || !equal(value, otherValue) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// compact renders a schema value for a message, shortened when long.
+func compact(value any) string {
+	encoded, _ := json.Marshal(value)
+	if len(encoded) > 80 {
+		return string(encoded[:77]) + "..."
+	}
+	return string(encoded)
+}
+
+// escape makes name a JSON pointer token.
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+func sortedKeys(values map[string]any) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
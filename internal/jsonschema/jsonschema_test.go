@@ -0,0 +1,147 @@
+package jsonschema_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/jsonschema"
+)
+
+const orderSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"type": "object",
+	"required": ["id", "type", "items"],
+	"additionalProperties": false,
+	"properties": {
+		"id": {"type": "integer", "minimum": 1},
+		"type": {"enum": ["order.created", "order.paid"]},
+		"email": {"type": "string", "pattern": "^[^@]+@[^@]+$", "format": "email"},
+		"items": {"type": "array", "minItems": 1, "items": {"$ref": "#/$defs/item"}},
+		"note": {"type": ["string", "null"], "maxLength": 5}
+	},
+	"$defs": {
+		"item": {
+			"type": "object",
+			"required": ["sku"],
+			"properties": {"sku": {"type": "string"}, "quantity": {"type": "number", "exclusiveMinimum": 0, "multipleOf": 0.5}}
+		}
+	}
+}`
+
+func TestValidateReportsViolations(t *testing.T) {
+	schema, err := jsonschema.Compile([]byte(orderSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	violations, err := schema.Validate([]byte(`{"id": 7, "type": "order.paid", "items": [{"sku": "a", "quantity": 1.5}], "note": null}`))
+	if err != nil || len(violations) != 0 {
+		t.Fatalf("expected a valid document, got %v %v", violations, err)
+	}
+
+	violations, err = schema.Validate([]byte(`{"id": 1.5, "type": "order.lost", "email": "nobody", "items": [{"quantity": 0.3}], "note": "too long", "extra": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`/email: must match the pattern "^[^@]+@[^@]+$"`,
+		`/: property "extra" is not allowed`,
+		`/id: must be integer, got number`,
+		`/items/0: missing required property "sku"`,
+		`/items/0/quantity: must be a multiple of 0.5, got 0.3`,
+		`/note: must be at most 5 characters long, got 8`,
+		`/type: must be one of ["order.created","order.paid"]`,
+	}
+	if strings.Join(violations, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected violations:\n%s", strings.Join(violations, "\n"))
+	}
+
+	if _, err := schema.Validate([]byte(`{"id": 1`)); err == nil {
+		t.Fatal("expected a document that is not JSON to be an error")
+	}
+}
+
+func TestCombinatorsAndConditions(t *testing.T) {
+	schema, err := jsonschema.Compile([]byte(`{
+		"oneOf": [{"type": "string"}, {"type": "integer"}, {"type": "number"}],
+		"not": {"const": "forbidden"},
+		"if": {"type": "string"}, "then": {"minLength": 2}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for document, expected := range map[string]string{
+		`"ok"`:        "",
+		`1.5`:         "",
+		`3`:           "/: must match exactly one schema in oneOf, matched 2",
+		`"forbidden"`: "/: must not match the schema in not",
+		`"x"`:         "/: must be at least 2 characters long, got 1",
+		`true`:        "/: must match exactly one schema in oneOf, matched 0",
+	} {
+		violations, err := schema.Validate([]byte(document))
+		if err != nil || strings.Join(violations, "\n") != expected {
+			t.Errorf("%s: expected %q, got %v %v", document, expected, violations, err)
+		}
+	}
+}
+
+func TestCompileRejectsUnusableSchemas(t *testing.T) {
+	for schema, message := range map[string]string{
+		`[]`:                                     "must be an object or a boolean",
+		`{"type": "text"}`:                       `unknown type "text"`,
+		`{"pattern": "("}`:                       "invalid pattern",
+		`{"$ref": "https://example.com/s.json"}`: "only references within the schema",
+		`{"items": {"$ref": "#/$defs/missing"}}`: "does not resolve",
+		`{"properties": {"a": 1}}`:               "#/properties/a: a schema must be an object or a boolean",
+	} {
+		if _, err := jsonschema.Compile([]byte(schema)); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%s: expected an error containing %q, got %v", schema, message, err)
+		}
+	}
+	// A schema that only refers to itself ends instead of looping.
+	schema, err := jsonschema.Compile([]byte(`{"$ref": "#"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if violations, _ := schema.Validate([]byte(`{}`)); len(violations) != 1 || !strings.Contains(violations[0], "nests too deeply") {
+		t.Fatalf("unexpected violations for a self reference: %v", violations)
+	}
+}
+
+func TestExpensiveSchemasAreBounded(t *testing.T) {
+	for _, schema := range []string{
+		`{"anyOf": [{"$ref": "#"}, {"$ref": "#"}, {"$ref": "#"}]}`,
+		`{"$defs": {"a": {"not": {"$ref": "#/$defs/b"}}, "b": {"$ref": "#/$defs/a"}}, "$ref": "#/$defs/a"}`,
+	} {
+		if _, err := jsonschema.Compile([]byte(schema)); err == nil || !strings.Contains(err.Error(), "through a combinator") {
+			t.Errorf("%s: expected the cycle to be rejected, got %v", schema, err)
+		}
+	}
+	// Recursing into nested values is how recursive schemas are meant to work.
+	if _, err := jsonschema.Compile([]byte(`{"anyOf": [{"type": "integer"}, {"type": "array", "items": {"$ref": "#"}}]}`)); err != nil {
+		t.Fatalf("expected a schema recursing into items to compile, got %v", err)
+	}
+
+	// Without a cycle, definitions that each try the previous one twice
+	// still double the work per level, which the step budget cuts short.
+	defs := []string{`"d0": {"type": "string"}`}
+	for i := 1; i <= 30; i++ {
+		defs = append(defs, fmt.Sprintf(`"d%d": {"anyOf": [{"$ref": "#/$defs/d%d"}, {"$ref": "#/$defs/d%d"}]}`, i, i-1, i-1))
+	}
+	schema, err := jsonschema.Compile([]byte(`{"$defs": {` + strings.Join(defs, ",") + `}, "$ref": "#/$defs/d30"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	violations, err := schema.Validate([]byte(`1`))
+	if err != nil || len(violations) == 0 || !strings.Contains(violations[len(violations)-1], "too expensive") {
+		t.Fatalf("expected the budget to stop validation, got %v %v", violations, err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Fatalf("validation took %s", elapsed)
+	}
+	if violations, _ := schema.Validate([]byte(`"ok"`)); len(violations) != 0 {
+		t.Fatalf("expected a matching document to pass quickly, got %v", violations)
+	}
+}
@@ -0,0 +1,129 @@
+// Package logging builds the server's log/slog logger and ties log lines to
+// the HTTP request that produced them through a request ID.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Formats accepted by New.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// maxRequestIDLength bounds the X-Request-ID values taken from clients.
+const maxRequestIDLength = 128
+
+// New returns a logger writing records in format at or above level. Records
+// logged with the context of a request carry its ID as request_id.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch format {
+	case FormatText, "":
+		handler = slog.NewTextHandler(w, options)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// ParseLevel reads debug, info, warn or error, in any case.
+func ParseLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(value)))
+	return level, err
+}
+
+// contextHandler adds the request ID found in a record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := middleware.GetReqID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// RequestID gives every request an ID, reusing a well-formed X-Request-ID
+// sent by the client, and returns it in the X-Request-ID response header.
+// Handlers read it with RequestIDFromContext.
+func RequestID(next http.Handler) http.Handler {
+	requestID := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !validRequestID(r.Header.Get(middleware.RequestIDHeader)) {
+			r.Header.Del(middleware.RequestIDHeader)
+		}
+		requestID.ServeHTTP(w, r)
+	})
+}
+
+// validRequestID accepts short IDs of printable ASCII without spaces, so a
+// client cannot break up log lines or flood them.
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDFromContext returns the ID RequestID gave the request, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	return middleware.GetReqID(ctx)
+}
+
+// Requests logs one line per request with its method, path, status, size,
+// duration and client address. Requests for which skip returns true are
+// served without a line, for routes that log themselves.
+func Requests(logger *slog.Logger, skip func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip != nil && skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			started := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			defer func() {
+				status := ww.Status()
+				if status == 0 {
+					status = http.StatusOK
+				}
+				logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+					slog.String("method", r.Method), slog.String("path", r.URL.Path), slog.Int("status", status),
+					slog.Int("bytes", ww.BytesWritten()), slog.Duration("duration", time.Since(started)),
+					slog.String("remote_addr", r.RemoteAddr))
+			}()
+			next.ServeHTTP(ww, r)
+		})
+	}
+}
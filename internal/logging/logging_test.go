@@ -0,0 +1,79 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/PipeOpsHQ/pipehook/internal/logging"
+)
+
+func TestRequestsLogWithRequestID(t *testing.T) {
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	handler := logging.RequestID(logging.Requests(logger, func(r *http.Request) bool { return r.URL.Path == "/skip" })(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = logging.RequestIDFromContext(r.Context())
+			logger.DebugContext(r.Context(), "hidden below the level")
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte("done"))
+		})))
+
+	request := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	request.Header.Set("X-Request-ID", "abc-123")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if seen != "abc-123" || response.Header().Get("X-Request-ID") != "abc-123" {
+		t.Fatalf("expected the client's request ID to be kept, got %q and %q", seen, response.Header().Get("X-Request-ID"))
+	}
+	var line map[string]any
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("expected one JSON line, got %q: %v", out.String(), err)
+	}
+	if line["msg"] != "request" || line["request_id"] != "abc-123" || line["status"] != float64(http.StatusAccepted) ||
+		line["bytes"] != float64(4) || line["path"] != "/orders" {
+		t.Fatalf("unexpected request line: %v", line)
+	}
+
+	out.Reset()
+	request = httptest.NewRequest(http.MethodGet, "/skip", nil)
+	request.Header.Set("X-Request-ID", "has spaces\nand a newline")
+	response = httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if seen == "" || strings.ContainsAny(seen, " \n") || response.Header().Get("X-Request-ID") != seen {
+		t.Fatalf("expected a malformed request ID to be replaced, got %q", seen)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected skipped requests not to be logged, got %q", out.String())
+	}
+}
+
+func TestNewAndParseLevel(t *testing.T) {
+	if _, err := logging.New(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Fatal("expected an unknown format to be refused")
+	}
+	var out bytes.Buffer
+	logger, err := logging.New(&out, logging.FormatText, slog.LevelWarn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("quiet")
+	logger.With("component", "store").Warn("loud", "error", "disk full")
+	if text := out.String(); strings.Contains(text, "quiet") || !strings.Contains(text, `msg=loud component=store error="disk full"`) {
+		t.Fatalf("unexpected text output: %q", text)
+	}
+	if level, err := logging.ParseLevel(" WARN "); err != nil || level != slog.LevelWarn {
+		t.Fatalf("expected warn, got %v %v", level, err)
+	}
+	if _, err := logging.ParseLevel("loud"); err == nil {
+		t.Fatal("expected an unknown level to be refused")
+	}
+}
@@ -0,0 +1,261 @@
+// Package metrics keeps counters, gauges and histograms and writes them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultDurationBuckets suit request and query latencies in seconds.
+var DefaultDurationBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// SizeBuckets suit body sizes in bytes.
+var SizeBuckets = []float64{0, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+
+// Sample is one value of a metric read by a collect function.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// Registry holds metrics in the order they were registered.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes every metric in the Prometheus text format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+	buffered := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+type desc struct {
+	name, help, kind string
+	labels           []string
+}
+
+func (d desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, strings.ReplaceAll(d.help, "\n", " "), d.name, d.kind)
+}
+
+// format writes a metric name with its labels, for example
+// name{method="GET"}. extra adds name/value pairs such as le.
+func (d desc) format(name string, values []string, extra ...string) string {
+	pairs := make([]string, 0, len(d.labels)+len(extra)/2)
+	for i, label := range d.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, label, escape(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escape(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+func splitKey(k string, labels int) []string {
+	if labels == 0 {
+		return nil
+	}
+	return strings.Split(k, "\xff")
+}
+
+// CounterVec is a counter with one series per combination of label values.
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]float64
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name: name, help: help, kind: "counter", labels: labels}, series: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the series with the given label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", c.name, len(c.labels), len(values)))
+	}
+	c.mu.Lock()
+	c.series[key(values)] += delta
+	c.mu.Unlock()
+}
+
+// Value returns the current value of a series, mainly for tests.
+func (c *CounterVec) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.series[key(values)]
+}
+
+// DeleteLabel removes every series whose label name has value, such as the
+// series of something that no longer exists.
+func (c *CounterVec) DeleteLabel(name, value string) {
+	index := slices.Index(c.labels, name)
+	if index < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.series {
+		if splitKey(k, len(c.labels))[index] == value {
+			delete(c.series, k)
+		}
+	}
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, k := range slices.Sorted(maps.Keys(c.series)) {
+		fmt.Fprintf(w, "%s %s\n", c.format(c.name, splitKey(k, len(c.labels))), formatFloat(c.series[k]))
+	}
+}
+
+// HistogramVec counts observations into cumulative buckets per combination
+// of label values.
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc: desc{name: name, help: help, kind: "histogram", labels: labels}, buckets: slices.Sorted(slices.Values(buckets)),
+		series: make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	if len(values) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", h.name, len(h.labels), len(values)))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key(values)]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key(values)] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+// Count returns how many values a series observed, mainly for tests.
+func (h *HistogramVec) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[key(values)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, k := range slices.Sorted(maps.Keys(h.series)) {
+		s, values := h.series[k], splitKey(k, len(h.labels))
+		for i, bound := range h.buckets {
+			fmt.Fprintf(w, "%s %d\n", h.format(h.name+"_bucket", values, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", h.format(h.name+"_bucket", values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s %s\n", h.format(h.name+"_sum", values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s %d\n", h.format(h.name+"_count", values), s.count)
+	}
+}
+
+// funcMetric reads its samples when the registry is written, for values
+// that are kept elsewhere such as connection counts.
+type funcMetric struct {
+	desc
+	collect func() []Sample
+}
+
+// NewGaugeFunc registers a gauge whose samples come from collect.
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect func() []Sample) {
+	r.register(&funcMetric{desc: desc{name: name, help: help, kind: "gauge", labels: labels}, collect: collect})
+}
+
+// NewCounterFunc registers a counter whose samples come from collect.
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect func() []Sample) {
+	r.register(&funcMetric{desc: desc{name: name, help: help, kind: "counter", labels: labels}, collect: collect})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.header(w)
+	for _, sample := range f.collect() {
+		fmt.Fprintf(w, "%s %s\n", f.format(f.name, sample.Labels), formatFloat(sample.Value))
+	}
+}
@@ -0,0 +1,48 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PipeOpsHQ/pipehook/internal/metrics"
+)
+
+func TestWriteTextFormatsEveryKind(t *testing.T) {
+	registry := metrics.NewRegistry()
+	requests := registry.NewCounter("requests_total", "Requests served.", "path")
+	requests.Inc(`/a"b`)
+	requests.Add(2, "/c")
+	latency := registry.NewHistogram("latency_seconds", "Request latency.", []float64{1, 0.1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(3)
+	registry.NewGaugeFunc("connections", "Open connections.", nil, func() []metrics.Sample {
+		return []metrics.Sample{{Value: 4}}
+	})
+
+	var out strings.Builder
+	if err := registry.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{path="/a\"b"} 1
+requests_total{path="/c"} 2
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.55
+latency_seconds_count 3
+# HELP connections Open connections.
+# TYPE connections gauge
+connections 4
+`
+	if out.String() != want {
+		t.Fatalf("unexpected exposition:\n%s", out.String())
+	}
+	if requests.Value("/c") != 2 || latency.Count() != 3 {
+		t.Fatalf("unexpected values %v %d", requests.Value("/c"), latency.Count())
+	}
+}
@@ -0,0 +1,470 @@
+// Package notify tells people about captured requests through outgoing
+// webhooks, Slack-compatible incoming webhooks and email. Messages are sent
+// from a background queue and retried with backoff, and a channel with a
+// digest interval folds bursts of captures into one message with a count.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+const (
+	defaultAttempts  = 4
+	defaultBackoff   = 2 * time.Second
+	defaultQueueSize = 1024
+	workers          = 4
+	sendTimeout      = 10 * time.Second
+)
+
+// Results passed to Config.Result.
+const (
+	ResultSent    = "sent"
+	ResultFailed  = "failed"
+	ResultDropped = "dropped"
+)
+
+// SMTP is the mail server email channels send through.
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (s SMTP) Enabled() bool {
+	return s.Host != ""
+}
+
+// Config describes how messages are delivered. The functions are called for
+// every delivery, so reloaded settings apply to the next message.
+type Config struct {
+	// Client returns the HTTP client for webhook and Slack channels.
+	Client func() *http.Client
+	// SMTP returns the mail server for email channels.
+	SMTP func() SMTP
+	// Attempts is how often a message is tried, four by default. Backoff is
+	// the wait before the second attempt, two seconds by default, doubling
+	// for each attempt after it.
+	Attempts int
+	Backoff  time.Duration
+	// QueueSize bounds the messages waiting to be sent. Messages arriving
+	// while it is full are dropped.
+	QueueSize int
+	// Result, when set, is called once per message with its channel kind
+	// and ResultSent, ResultFailed or ResultDropped.
+	Result func(kind, result string)
+}
+
+// Capture summarises one captured request.
+type Capture struct {
+	EndpointID     string    `json:"endpoint_id"`
+	RequestID      int64     `json:"request_id"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	QueryString    string    `json:"query_string,omitempty"`
+	RemoteAddr     string    `json:"remote_addr"`
+	StatusCode     int       `json:"status_code"`
+	Size           int       `json:"size"`
+	RejectedReason string    `json:"rejected_reason,omitempty"`
+	CapturedAt     time.Time `json:"captured_at"`
+	// URL opens the endpoint's dashboard.
+	URL string `json:"url,omitempty"`
+}
+
+// Message is one delivery: the latest matching capture and how many
+// captures, starting at Since, it stands for.
+type Message struct {
+	ChannelID string    `json:"channel_id"`
+	Count     int       `json:"count"`
+	Since     time.Time `json:"since"`
+	Latest    Capture   `json:"latest"`
+}
+
+// Subject is a one-line summary of the message.
+func (m Message) Subject() string {
+	if m.Count > 1 {
+		return fmt.Sprintf("%d captures on endpoint %s", m.Count, m.Latest.EndpointID)
+	}
+	return "New capture on endpoint " + m.Latest.EndpointID
+}
+
+// Text describes the message in plain text.
+func (m Message) Text() string {
+	latest := m.Latest
+	var b strings.Builder
+	if m.Count > 1 {
+		fmt.Fprintf(&b, "%d captures on endpoint %s since %s. Latest: ", m.Count, latest.EndpointID, m.Since.UTC().Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(&b, "New capture on endpoint %s: ", latest.EndpointID)
+	}
+	target := latest.Path
+	if latest.QueryString != "" {
+		target += "?" + latest.QueryString
+	}
+	fmt.Fprintf(&b, "%s %s answered %d", latest.Method, target, latest.StatusCode)
+	if latest.RejectedReason != "" {
+		fmt.Fprintf(&b, " (rejected: %s)", latest.RejectedReason)
+	}
+	fmt.Fprintf(&b, " from %s, %d bytes, request #%d.", latest.RemoteAddr, latest.Size, latest.RequestID)
+	if latest.URL != "" {
+		b.WriteString("\n" + latest.URL)
+	}
+	return b.String()
+}
+
+type delivery struct {
+	channel store.NotificationChannel
+	message Message
+}
+
+// digest holds a channel's captures between messages.
+type digest struct {
+	channel store.NotificationChannel
+	// next is when the channel may send again.
+	next    time.Time
+	pending int
+	since   time.Time
+	latest  Capture
+	timer   *time.Timer
+}
+
+// Notifier queues and sends messages. It is safe for concurrent use.
+type Notifier struct {
+	config Config
+	queue  chan delivery
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	closed  bool
+	digests map[string]*digest
+}
+
+func New(config Config) *Notifier {
+	if config.Client == nil {
+		config.Client = func() *http.Client { return &http.Client{Timeout: sendTimeout} }
+	}
+	if config.SMTP == nil {
+		config.SMTP = func() SMTP { return SMTP{} }
+	}
+	if config.Attempts <= 0 {
+		config.Attempts = defaultAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultBackoff
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		config: config, queue: make(chan delivery, config.QueueSize), ctx: ctx, cancel: cancel,
+		digests: make(map[string]*digest),
+	}
+	for range workers {
+		n.wg.Add(1)
+		go n.work()
+	}
+	return n
+}
+
+// Notify reports capture on channel, right away or in the channel's next
+// digest. It never blocks.
+func (n *Notifier) Notify(channel *store.NotificationChannel, capture Capture) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	message := Message{ChannelID: channel.ID, Count: 1, Since: capture.CapturedAt, Latest: capture}
+	if channel.DigestSeconds <= 0 {
+		n.enqueue(*channel, message)
+		return
+	}
+	interval := time.Duration(channel.DigestSeconds) * time.Second
+	state := n.digests[channel.ID]
+	if state == nil {
+		state = &digest{}
+		n.digests[channel.ID] = state
+	}
+	state.channel = *channel
+	now := time.Now()
+	if state.pending == 0 && !now.Before(state.next) {
+		// The first capture after a quiet interval is sent straight away.
+		state.next = now.Add(interval)
+		n.enqueue(*channel, message)
+		return
+	}
+	if state.pending == 0 {
+		state.since = capture.CapturedAt
+	}
+	state.pending++
+	state.latest = capture
+	if state.timer == nil {
+		id := channel.ID
+		state.timer = time.AfterFunc(time.Until(state.next), func() { n.flush(id) })
+	}
+}
+
+// Forget drops a deleted channel's pending digest.
+func (n *Notifier) Forget(channelID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if state := n.digests[channelID]; state != nil && state.timer != nil {
+		state.timer.Stop()
+	}
+	delete(n.digests, channelID)
+}
+
+func (n *Notifier) flush(channelID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	state := n.digests[channelID]
+	if n.closed || state == nil {
+		return
+	}
+	state.timer = nil
+	if state.pending == 0 {
+		return
+	}
+	n.enqueue(state.channel, Message{ChannelID: channelID, Count: state.pending, Since: state.since, Latest: state.latest})
+	state.pending = 0
+	state.next = time.Now().Add(time.Duration(state.channel.DigestSeconds) * time.Second)
+}
+
+// enqueue must be called with n.mu held.
+func (n *Notifier) enqueue(channel store.NotificationChannel, message Message) {
+	select {
+	case n.queue <- delivery{channel: channel, message: message}:
+	default:
+		slog.Warn("notification queue is full, dropping message", "channel", channel.ID, "endpoint_id", channel.EndpointID)
+		n.result(channel.Kind, ResultDropped)
+	}
+}
+
+func (n *Notifier) result(kind, result string) {
+	if n.config.Result != nil {
+		n.config.Result(kind, result)
+	}
+}
+
+func (n *Notifier) work() {
+	defer n.wg.Done()
+	for next := range n.queue {
+		n.deliver(next)
+	}
+}
+
+// deliver tries a message until it is sent, its attempts run out or the
+// notifier is closed for good.
+func (n *Notifier) deliver(next delivery) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(n.ctx, sendTimeout)
+		err = n.Send(ctx, &next.channel, next.message)
+		cancel()
+		if err == nil {
+			n.result(next.channel.Kind, ResultSent)
+			return
+		}
+		if attempt == n.config.Attempts {
+			break
+		}
+		select {
+		case <-time.After(n.config.Backoff << (attempt - 1)):
+		case <-n.ctx.Done():
+		}
+		if n.ctx.Err() != nil {
+			break
+		}
+	}
+	slog.Warn("notification failed", "channel", next.channel.ID, "endpoint_id", next.channel.EndpointID,
+		"kind", next.channel.Kind, "error", err)
+	n.result(next.channel.Kind, ResultFailed)
+}
+
+// Close sends pending digests, waits for queued messages and stops the
+// workers. When ctx ends first, messages still being retried are abandoned.
+func (n *Notifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return nil
+	}
+	for id, state := range n.digests {
+		if state.timer != nil {
+			state.timer.Stop()
+			state.timer = nil
+		}
+		if state.pending > 0 {
+			n.enqueue(state.channel, Message{ChannelID: id, Count: state.pending, Since: state.since, Latest: state.latest})
+			state.pending = 0
+		}
+	}
+	n.closed = true
+	close(n.queue)
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		n.cancel()
+		return nil
+	case <-ctx.Done():
+		n.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Send makes one attempt to deliver message on channel.
+func (n *Notifier) Send(ctx context.Context, channel *store.NotificationChannel, message Message) error {
+	switch channel.Kind {
+	case store.NotifyWebhook:
+		payload := struct {
+			Event string `json:"event"`
+			Message
+		}{Event: "capture", Message: message}
+		return n.post(ctx, channel.Target, payload)
+	case store.NotifySlack:
+		return n.post(ctx, channel.Target, map[string]string{"text": slackText(message)})
+	case store.NotifyEmail:
+		recipients, err := Recipients(channel.Target)
+		if err != nil {
+			return err
+		}
+		return sendMail(ctx, n.config.SMTP(), recipients, message)
+	}
+	return fmt.Errorf("unknown notification channel kind %q", channel.Kind)
+}
+
+func (n *Notifier) post(ctx context.Context, target string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "pipehook-notifier")
+	response, err := n.config.Client().Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 32*1024))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("target answered %s", response.Status)
+	}
+	return nil
+}
+
+// slackText formats message with Slack's mrkdwn escaping and a link to the
+// dashboard.
+func slackText(message Message) string {
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	latest := message.Latest
+	latest.URL = ""
+	text := escape.Replace(Message{Count: message.Count, Since: message.Since, Latest: latest}.Text())
+	if message.Latest.URL != "" {
+		text += " <" + message.Latest.URL + "|Open in pipehook>"
+	}
+	return text
+}
+
+// Recipients parses a comma-separated list of email addresses.
+func Recipients(target string) ([]string, error) {
+	addresses, err := mail.ParseAddressList(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email recipients: %w", err)
+	}
+	recipients := make([]string, len(addresses))
+	for i, address := range addresses {
+		recipients[i] = address.Address
+	}
+	return recipients, nil
+}
+
+// sendMail delivers message as a plain text email. Port 465 uses implicit
+// TLS; other ports upgrade with STARTTLS when the server offers it.
+func sendMail(ctx context.Context, server SMTP, recipients []string, message Message) error {
+	if !server.Enabled() {
+		return errors.New("SMTP is not configured")
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", net.JoinHostPort(server.Host, strconv.Itoa(server.Port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if server.Port == 465 {
+		conn = tls.Client(conn, &tls.Config{ServerName: server.Host, MinVersion: tls.VersionTLS12})
+	}
+	client, err := smtp.NewClient(conn, server.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && server.Port != 465 {
+		if err := client.StartTLS(&tls.Config{ServerName: server.Host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if server.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", server.Username, server.Password, server.Host)); err != nil {
+			return err
+		}
+	}
+	from, err := mail.ParseAddress(server.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	headerSafe := strings.NewReplacer("\r", " ", "\n", " ")
+	fmt.Fprintf(writer, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\n"+
+		"Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n%s\r\n",
+		server.From, strings.Join(recipients, ", "), headerSafe.Replace("[pipehook] "+message.Subject()),
+		time.Now().Format(time.RFC1123Z), message.Text())
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,149 @@
+package notify_test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/notify"
+	"github.com/PipeOpsHQ/pipehook/internal/notify/notifytest"
+	"github.com/PipeOpsHQ/pipehook/internal/store"
+)
+
+type results struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (r *results) record(kind, result string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.seen = append(r.seen, kind+":"+result)
+}
+
+func (r *results) list() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.seen...)
+}
+
+func capture(id int64) notify.Capture {
+	return notify.Capture{
+		EndpointID: "orders", RequestID: id, Method: "POST", Path: "/h/orders", RemoteAddr: "10.0.0.1",
+		StatusCode: 200, Size: 12, CapturedAt: time.Now(), URL: "http://pipehook.test/orders",
+	}
+}
+
+func TestWebhookRetriesUntilDelivered(t *testing.T) {
+	receiver := notifytest.NewReceiver(2)
+	defer receiver.Close()
+	var outcomes results
+	notifier := notify.New(notify.Config{Backoff: time.Millisecond, Result: outcomes.record})
+
+	notifier.Notify(&store.NotificationChannel{ID: "c1", EndpointID: "orders", Kind: store.NotifyWebhook, Target: receiver.URL}, capture(7))
+	if !receiver.Wait(1, 5*time.Second) {
+		t.Fatal("expected the webhook to be delivered after two failures")
+	}
+	var payload struct {
+		Event  string         `json:"event"`
+		Count  int            `json:"count"`
+		Latest notify.Capture `json:"latest"`
+	}
+	if err := json.Unmarshal(receiver.Bodies()[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != "capture" || payload.Count != 1 || payload.Latest.RequestID != 7 || payload.Latest.Path != "/h/orders" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+	if err := notifier.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := outcomes.list(); len(got) != 1 || got[0] != "webhook:sent" {
+		t.Fatalf("expected one sent result, got %v", got)
+	}
+
+	failing := notifytest.NewReceiver(10)
+	defer failing.Close()
+	notifier = notify.New(notify.Config{Attempts: 2, Backoff: time.Millisecond, Result: outcomes.record})
+	notifier.Notify(&store.NotificationChannel{ID: "c2", Kind: store.NotifySlack, Target: failing.URL}, capture(8))
+	if err := notifier.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := outcomes.list(); len(got) != 2 || got[1] != "slack:failed" {
+		t.Fatalf("expected the Slack message to fail after two attempts, got %v", got)
+	}
+}
+
+func TestDigestFoldsBursts(t *testing.T) {
+	receiver := notifytest.NewReceiver(0)
+	defer receiver.Close()
+	notifier := notify.New(notify.Config{})
+	channel := &store.NotificationChannel{ID: "c1", Kind: store.NotifySlack, Target: receiver.URL, DigestSeconds: 1}
+
+	for id := int64(1); id <= 4; id++ {
+		notifier.Notify(channel, capture(id))
+	}
+	if !receiver.Wait(2, 5*time.Second) {
+		t.Fatalf("expected an immediate message and a digest, got %d", len(receiver.Bodies()))
+	}
+	var first, digest struct {
+		Text string `json:"text"`
+	}
+	_ = json.Unmarshal(receiver.Bodies()[0], &first)
+	_ = json.Unmarshal(receiver.Bodies()[1], &digest)
+	if !strings.HasPrefix(first.Text, "New capture on endpoint orders") || !strings.Contains(first.Text, "<http://pipehook.test/orders|Open in pipehook>") {
+		t.Fatalf("unexpected first message: %q", first.Text)
+	}
+	if !strings.HasPrefix(digest.Text, "3 captures on endpoint orders") || !strings.Contains(digest.Text, "request #4") {
+		t.Fatalf("unexpected digest: %q", digest.Text)
+	}
+
+	// Captures still waiting for their digest are sent on Close.
+	notifier.Notify(channel, capture(5))
+	if err := notifier.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if bodies := receiver.Bodies(); len(bodies) != 3 || !strings.Contains(string(bodies[2]), "request #5") {
+		t.Fatalf("expected the pending digest to be flushed on close, got %d messages", len(bodies))
+	}
+}
+
+func TestEmailThroughSMTP(t *testing.T) {
+	server, err := notifytest.NewSMTPServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	smtpConfig := notify.SMTP{Host: server.Host(), Port: server.Port(), From: "pipehook@example.com"}
+	notifier := notify.New(notify.Config{SMTP: func() notify.SMTP { return smtpConfig }})
+	defer notifier.Close(context.Background())
+
+	channel := &store.NotificationChannel{ID: "c1", Kind: store.NotifyEmail, Target: "Ops <ops@example.com>, dev@example.com"}
+	if err := notifier.Send(context.Background(), channel, notify.Message{Count: 1, Latest: capture(3)}); err != nil {
+		t.Fatal(err)
+	}
+	messages := server.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("expected one email, got %d", len(messages))
+	}
+	mail := messages[0]
+	if mail.From != "pipehook@example.com" || strings.Join(mail.To, ",") != "ops@example.com,dev@example.com" {
+		t.Fatalf("unexpected envelope: %+v", mail)
+	}
+	if !strings.Contains(mail.Data, "Subject: [pipehook] New capture on endpoint orders\r\n") ||
+		!strings.Contains(mail.Data, "POST /h/orders answered 200") {
+		t.Fatalf("unexpected email: %q", mail.Data)
+	}
+
+	if err := notifier.Send(context.Background(), &store.NotificationChannel{Kind: store.NotifyEmail, Target: "not an address"}, notify.Message{}); err == nil {
+		t.Fatal("expected invalid recipients to be refused")
+	}
+	disabled := notify.New(notify.Config{})
+	defer disabled.Close(context.Background())
+	if err := disabled.Send(context.Background(), channel, notify.Message{}); err == nil {
+		t.Fatal("expected email to fail without SMTP settings")
+	}
+}
@@ -0,0 +1,204 @@
+// Package notifytest provides local stand-ins for the services notifications
+// are sent to: an HTTP receiver for webhook and Slack channels and a minimal
+// SMTP server for email channels.
+package notifytest
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Receiver records the bodies POSTed to it. Its first few requests can be
+// answered 503, to exercise retries.
+type Receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	fail     int
+	bodies   [][]byte
+	received chan struct{}
+}
+
+// NewReceiver starts a receiver that fails its first fail requests.
+func NewReceiver(fail int) *Receiver {
+	receiver := &Receiver{fail: fail, received: make(chan struct{}, 64)}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		receiver.mu.Lock()
+		if receiver.fail > 0 {
+			receiver.fail--
+			receiver.mu.Unlock()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		receiver.bodies = append(receiver.bodies, body)
+		receiver.mu.Unlock()
+		signal(receiver.received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return receiver
+}
+
+// Bodies returns the bodies received so far.
+func (r *Receiver) Bodies() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]byte(nil), r.bodies...)
+}
+
+// Wait blocks until n bodies were received or timeout passes, and reports
+// whether they were.
+func (r *Receiver) Wait(n int, timeout time.Duration) bool {
+	return wait(r.received, func() int { return len(r.Bodies()) }, n, timeout)
+}
+
+// Mail is a message accepted by the SMTP stand-in.
+type Mail struct {
+	From string
+	To   []string
+	Data string
+}
+
+// SMTPServer accepts mail without authentication or TLS and records it.
+type SMTPServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages []Mail
+	received chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewSMTPServer starts an SMTP stand-in on a local port.
+func NewSMTPServer() (*SMTPServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	server := &SMTPServer{listener: listener, received: make(chan struct{}, 64)}
+	server.wg.Add(1)
+	go server.serve()
+	return server, nil
+}
+
+// Host and Port are where the server listens.
+func (s *SMTPServer) Host() string {
+	return s.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+func (s *SMTPServer) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Messages returns the mail accepted so far.
+func (s *SMTPServer) Messages() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Mail(nil), s.messages...)
+}
+
+// Wait blocks until n messages were accepted or timeout passes, and reports
+// whether they were.
+func (s *SMTPServer) Wait(n int, timeout time.Duration) bool {
+	return wait(s.received, func() int { return len(s.Messages()) }, n, timeout)
+}
+
+func (s *SMTPServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *SMTPServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.session(conn)
+		}()
+	}
+}
+
+func (s *SMTPServer) session(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+	reply("220 notifytest ESMTP")
+	var current Mail
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(verb, "EHLO"), strings.HasPrefix(verb, "HELO"):
+			reply("250 notifytest")
+		case strings.HasPrefix(verb, "MAIL FROM:"):
+			current = Mail{From: strings.Trim(line[len("MAIL FROM:"):], "<> ")}
+			reply("250 OK")
+		case strings.HasPrefix(verb, "RCPT TO:"):
+			current.To = append(current.To, strings.Trim(line[len("RCPT TO:"):], "<> "))
+			reply("250 OK")
+		case verb == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			current.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, current)
+			s.mu.Unlock()
+			signal(s.received)
+			reply("250 OK: queued as " + strconv.Itoa(len(s.Messages())))
+		case verb == "RSET", verb == "NOOP":
+			reply("250 OK")
+		case verb == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// signal wakes a waiter without blocking when nobody is waiting.
+func signal(received chan<- struct{}) {
+	select {
+	case received <- struct{}{}:
+	default:
+	}
+}
+
+func wait(received <-chan struct{}, count func() int, n int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for count() < n {
+		select {
+		case <-received:
+		case <-deadline:
+			return count() >= n
+		}
+	}
+	return true
+}
@@ -0,0 +1,412 @@
+// Package oidc implements the parts of OpenID Connect that pipehook needs to
+// sign users in: discovery, the authorization code flow with PKCE and
+// verification of RS256-signed ID tokens.
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is the leeway allowed when checking token timestamps.
+const clockSkew = time.Minute
+
+// Config describes the identity provider and who may sign in through it.
+type Config struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+	// AllowedDomains restricts sign-in to verified email addresses in these
+	// domains. Empty allows every domain.
+	AllowedDomains []string
+	// AllowedGroups restricts sign-in to members of at least one group listed
+	// in GroupsClaim. Empty allows every user.
+	AllowedGroups []string
+	GroupsClaim   string
+	// AdminClaim names the ID token claim that grants administrator access.
+	// A boolean claim grants it when true; a string or list claim grants it
+	// when it contains one of AdminValues.
+	AdminClaim  string
+	AdminValues []string
+}
+
+// Claims are the verified contents of an ID token.
+type Claims struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     *bool
+	PreferredUsername string
+	Name              string
+	Raw               map[string]any
+}
+
+// ErrNotAllowed is returned by Authorize when the user does not satisfy the
+// configured domain or group restrictions.
+var ErrNotAllowed = errors.New("oidc: user is not allowed to sign in")
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one identity provider. Discovery happens on first use
+// and is retried until it succeeds, so an unreachable provider does not stop
+// the server from starting.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	metadata  *discovery
+	keys      map[string]*rsa.PublicKey
+	keysFetch time.Time
+}
+
+func NewProvider(config Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	config.IssuerURL = strings.TrimSuffix(config.IssuerURL, "/")
+	return &Provider{config: config, client: client}
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	var metadata discovery
+	if err := p.getJSON(ctx, p.config.IssuerURL+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.config.IssuerURL {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", metadata.Issuer, p.config.IssuerURL)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: provider metadata is incomplete")
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, target string, destination any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", target, response.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(destination)
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() (string, error) {
+	return randomString(32)
+}
+
+// NewState returns a random value suitable for the state and nonce parameters.
+func NewState() (string, error) {
+	return randomString(24)
+}
+
+func randomString(size int) (string, error) {
+	buffer := make([]byte, size)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// challenge derives the S256 PKCE code challenge from a verifier.
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL that starts a sign-in.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified ID token
+// claims. nonce must match the value sent with AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {verifier},
+		"client_id":     {p.config.ClientID},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token exchange: %w", err)
+	}
+	defer response.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token exchange: unexpected status %d: %s", response.StatusCode, bytes.TrimSpace(body))
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return nil, errors.New("oidc token exchange: response has no id_token")
+	}
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+// Verify checks the signature and standard claims of an ID token.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (*Claims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed ID token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("oidc: decode token header: %w", err)
+	}
+	if header.Algorithm != "RS256" {
+		return nil, fmt.Errorf("oidc: unsupported signing algorithm %q", header.Algorithm)
+	}
+	key, err := p.signingKey(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("oidc: malformed token signature")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("oidc: invalid token signature")
+	}
+
+	raw := map[string]any{}
+	if err := decodeSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("oidc: decode token claims: %w", err)
+	}
+	claims := &Claims{Raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Email, _ = raw["email"].(string)
+	claims.PreferredUsername, _ = raw["preferred_username"].(string)
+	claims.Name, _ = raw["name"].(string)
+	if verified, ok := raw["email_verified"].(bool); ok {
+		claims.EmailVerified = &verified
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != p.config.IssuerURL {
+		return nil, fmt.Errorf("oidc: unexpected issuer %q", claims.Issuer)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("oidc: token has no subject")
+	}
+	audiences := stringList(raw["aud"])
+	if !slices.Contains(audiences, p.config.ClientID) {
+		return nil, errors.New("oidc: token was not issued for this client")
+	}
+	if azp, ok := raw["azp"].(string); ok && len(audiences) > 1 && azp != p.config.ClientID {
+		return nil, errors.New("oidc: token was authorized for another client")
+	}
+	now := time.Now()
+	expiry, ok := raw["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(expiry), 0).Add(clockSkew)) {
+		return nil, errors.New("oidc: token has expired")
+	}
+	if issuedAt, ok := raw["iat"].(float64); ok && time.Unix(int64(issuedAt), 0).After(now.Add(clockSkew)) {
+		return nil, errors.New("oidc: token was issued in the future")
+	}
+	if tokenNonce, _ := raw["nonce"].(string); tokenNonce != nonce {
+		return nil, errors.New("oidc: token nonce does not match")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, destination any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, destination)
+}
+
+// signingKey returns the provider key with the given ID. The key set is
+// refetched when an unknown key ID appears, at most once a minute, so key
+// rotation works without a restart.
+func (p *Provider) signingKey(ctx context.Context, keyID string) (*rsa.PublicKey, error) {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key := p.lookupKey(keyID); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetch) < time.Minute && p.keys != nil {
+		return nil, fmt.Errorf("oidc: unknown signing key %q", keyID)
+	}
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc: fetch signing keys: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		modulus, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		exponent, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(exponent) > 4 {
+			continue
+		}
+		keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: int(new(big.Int).SetBytes(exponent).Int64())}
+	}
+	p.keys = keys
+	p.keysFetch = time.Now()
+	if key := p.lookupKey(keyID); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown signing key %q", keyID)
+}
+
+// lookupKey matches by key ID. Tokens without a key ID are accepted when the
+// provider publishes exactly one key.
+func (p *Provider) lookupKey(keyID string) *rsa.PublicKey {
+	if key, ok := p.keys[keyID]; ok {
+		return key
+	}
+	if keyID == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return nil
+}
+
+// Authorize applies the domain and group restrictions and reports whether
+// the claims grant administrator access. A domain restriction only accepts
+// addresses the provider states are verified.
+func (p *Provider) Authorize(claims *Claims) (admin bool, err error) {
+	if len(p.config.AllowedDomains) > 0 {
+		at := strings.LastIndex(claims.Email, "@")
+		if at < 0 || claims.EmailVerified == nil || !*claims.EmailVerified {
+			return false, ErrNotAllowed
+		}
+		domain := strings.ToLower(claims.Email[at+1:])
+		if !slices.ContainsFunc(p.config.AllowedDomains, func(allowed string) bool { return strings.EqualFold(allowed, domain) }) {
+			return false, ErrNotAllowed
+		}
+	}
+	if len(p.config.AllowedGroups) > 0 {
+		groups := stringList(claims.Raw[p.config.GroupsClaim])
+		if !slices.ContainsFunc(groups, func(group string) bool { return slices.Contains(p.config.AllowedGroups, group) }) {
+			return false, ErrNotAllowed
+		}
+	}
+	if p.config.AdminClaim == "" {
+		return false, nil
+	}
+	value := claims.Raw[p.config.AdminClaim]
+	if granted, ok := value.(bool); ok {
+		return granted && len(p.config.AdminValues) == 0, nil
+	}
+	values := stringList(value)
+	return slices.ContainsFunc(values, func(value string) bool { return slices.Contains(p.config.AdminValues, value) }), nil
+}
+
+// stringList reads a claim that may be a single string or a list of strings.
+func stringList(value any) []string {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}
+	case []any:
+		values := make([]string, 0, len(typed))
+		for _, item := range typed {
+			if text, ok := item.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,138 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PipeOpsHQ/pipehook/internal/oidc"
+	"github.com/PipeOpsHQ/pipehook/internal/oidc/oidctest"
+)
+
+const redirectURL = "https://hooks.example.com/auth/oidc/callback"
+
+// authorize runs the browser half of the flow against the stand-in provider
+// and returns the code it issued.
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	target, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), redirectURL) || location.Query().Get("state") != state {
+		t.Fatalf("unexpected redirect %q: %v", response.Header.Get("Location"), err)
+	}
+	return location.Query().Get("code")
+}
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	server := oidctest.NewServer("pipehook", "secret")
+	defer server.Close()
+	server.SetClaims(map[string]any{"sub": "42", "email": "ada@example.com", "email_verified": true, "groups": []string{"ops"}})
+	provider := oidc.NewProvider(oidc.Config{
+		IssuerURL: server.URL, ClientID: "pipehook", ClientSecret: "secret", RedirectURL: redirectURL,
+		AllowedDomains: []string{"Example.com"}, AllowedGroups: []string{"ops", "dev"},
+		AdminClaim: "groups", AdminValues: []string{"ops"},
+	}, server.Client())
+
+	verifier, _ := oidc.NewVerifier()
+	code := authorize(t, provider, "state", "nonce", verifier)
+	if _, err := provider.Exchange(context.Background(), code, "wrong-verifier", "nonce"); err == nil {
+		t.Fatal("expected a mismatched PKCE verifier to be rejected")
+	}
+
+	code = authorize(t, provider, "state", "nonce", verifier)
+	if _, err := provider.Exchange(context.Background(), code, verifier, "other-nonce"); err == nil {
+		t.Fatal("expected a mismatched nonce to be rejected")
+	}
+
+	code = authorize(t, provider, "state", "nonce", verifier)
+	claims, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "42" || claims.Email != "ada@example.com" || claims.Issuer != server.URL {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+	if admin, err := provider.Authorize(claims); err != nil || !admin {
+		t.Fatalf("expected an allowed admin, got %t %v", admin, err)
+	}
+}
+
+func TestVerifyRejectsInvalidTokens(t *testing.T) {
+	server := oidctest.NewServer("pipehook", "secret")
+	defer server.Close()
+	provider := oidc.NewProvider(oidc.Config{IssuerURL: server.URL, ClientID: "pipehook", RedirectURL: redirectURL}, server.Client())
+	valid := func() map[string]any {
+		return map[string]any{
+			"iss": server.URL, "aud": "pipehook", "sub": "42", "nonce": "n",
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+	if _, err := provider.Verify(context.Background(), server.SignToken(valid()), "n"); err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+
+	cases := map[string]func(map[string]any){
+		"issuer":   func(claims map[string]any) { claims["iss"] = "https://evil.example.com" },
+		"audience": func(claims map[string]any) { claims["aud"] = []string{"someone-else"} },
+		"expired":  func(claims map[string]any) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"subject":  func(claims map[string]any) { delete(claims, "sub") },
+	}
+	for name, mutate := range cases {
+		claims := valid()
+		mutate(claims)
+		if _, err := provider.Verify(context.Background(), server.SignToken(claims), "n"); err == nil {
+			t.Errorf("%s: expected token to be rejected", name)
+		}
+	}
+
+	forged := valid()
+	forged["sub"] = "admin"
+	original, altered := strings.Split(server.SignToken(valid()), "."), strings.Split(server.SignToken(forged), ".")
+	tampered := original[0] + "." + altered[1] + "." + original[2]
+	if _, err := provider.Verify(context.Background(), tampered, "n"); err == nil {
+		t.Fatal("expected a tampered token to be rejected")
+	}
+}
+
+func TestAuthorizeAppliesDomainAndGroupRestrictions(t *testing.T) {
+	provider := oidc.NewProvider(oidc.Config{
+		IssuerURL: "https://id.example.com", ClientID: "pipehook",
+		AllowedDomains: []string{"example.com"}, AllowedGroups: []string{"ops"}, AdminClaim: "pipehook_admin",
+	}, nil)
+	verified, unverified := true, false
+	claims := func(email string, emailVerified *bool, raw map[string]any) *oidc.Claims {
+		return &oidc.Claims{Email: email, EmailVerified: emailVerified, Raw: raw}
+	}
+
+	if _, err := provider.Authorize(claims("ada@other.com", &verified, map[string]any{"groups": []any{"ops"}})); !errors.Is(err, oidc.ErrNotAllowed) {
+		t.Fatalf("expected other domain to be refused, got %v", err)
+	}
+	if _, err := provider.Authorize(claims("ada@example.com", &unverified, map[string]any{"groups": []any{"ops"}})); !errors.Is(err, oidc.ErrNotAllowed) {
+		t.Fatalf("expected unverified email to be refused, got %v", err)
+	}
+	if _, err := provider.Authorize(claims("ada@example.com", nil, map[string]any{"groups": []any{"ops"}})); !errors.Is(err, oidc.ErrNotAllowed) {
+		t.Fatalf("expected an email without email_verified to be refused, got %v", err)
+	}
+	if _, err := provider.Authorize(claims("ada@example.com", &verified, map[string]any{"groups": "dev"})); !errors.Is(err, oidc.ErrNotAllowed) {
+		t.Fatalf("expected missing group to be refused, got %v", err)
+	}
+	if admin, err := provider.Authorize(claims("ada@example.com", &verified, map[string]any{"groups": "ops"})); err != nil || admin {
+		t.Fatalf("expected allowed non-admin, got %t %v", admin, err)
+	}
+	if admin, err := provider.Authorize(claims("ada@example.com", &verified, map[string]any{"groups": "ops", "pipehook_admin": true})); err != nil || !admin {
+		t.Fatalf("expected boolean admin claim to grant admin, got %t %v", admin, err)
+	}
+}
@@ -0,0 +1,172 @@
+// Package oidctest provides a minimal OpenID Connect identity provider for
+// tests. It approves every authorization request immediately and issues
+// RS256-signed ID tokens carrying the configured claims.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const keyID = "test-key"
+
+type authorization struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+}
+
+// Server is a running stand-in identity provider.
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	claims map[string]any
+	codes  map[string]authorization
+}
+
+// NewServer starts a provider for the given client credentials. Close it when
+// the test is done.
+func NewServer(clientID, clientSecret string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	server := &Server{
+		ClientID: clientID, ClientSecret: clientSecret, key: key,
+		claims: map[string]any{"sub": "user-1"}, codes: map[string]authorization{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", server.discovery)
+	mux.HandleFunc("GET /authorize", server.authorize)
+	mux.HandleFunc("POST /token", server.token)
+	mux.HandleFunc("GET /jwks", server.jwks)
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+// SetClaims replaces the claims placed in the next ID tokens, in addition to
+// iss, aud, exp, iat and nonce.
+func (s *Server) SetClaims(claims map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims = claims
+}
+
+// SignToken signs arbitrary claims with the provider key, for tests that need
+// tokens the normal flow would not produce.
+func (s *Server) SignToken(claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != s.ClientID || query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		clientID: query.Get("client_id"), redirectURI: query.Get("redirect_uri"),
+		nonce: query.Get("nonce"), challenge: query.Get("code_challenge"),
+	}
+	s.mu.Unlock()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok || clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	s.mu.Lock()
+	grant, found := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	claims := make(map[string]any, len(s.claims)+5)
+	for name, value := range s.claims {
+		claims[name] = value
+	}
+	s.mu.Unlock()
+	verifierSum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !found || grant.redirectURI != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(verifierSum[:]) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	claims["iss"] = s.URL
+	claims["aud"] = grant.clientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	claims["nonce"] = grant.nonce
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(), "token_type": "Bearer", "expires_in": 3600, "id_token": s.SignToken(claims),
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA", "use": "sig", "alg": "RS256", "kid": keyID,
+		"n": base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+		"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+	}}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+func randomString() string {
+	buffer := make([]byte, 16)
+	_, _ = rand.Read(buffer)
+	return base64.RawURLEncoding.EncodeToString(buffer)
+}
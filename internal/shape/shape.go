@@ -0,0 +1,458 @@
+// Package shape infers the structure of JSON payloads from samples: which
+// fields occur, with which types, how often and with what example values.
+// The result can be written out as a JSON Schema or a Go type.
+package shape
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxNodes caps the fields tracked per shape, so payloads keyed by IDs
+	// do not grow without bound.
+	maxNodes = 2000
+	// maxDepth is how deeply nested values are followed.
+	maxDepth = 32
+	// maxExamples distinct example values are kept per field, each at most
+	// maxExampleSize bytes of compact JSON.
+	maxExamples    = 3
+	maxExampleSize = 80
+)
+
+// JSON types as reported in Field.Types.
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeNumber  = "number"
+	TypeBoolean = "boolean"
+	TypeNull    = "null"
+	TypeObject  = "object"
+	TypeArray   = "array"
+)
+
+// Decode parses a JSON document, keeping numbers exact.
+func Decode(data []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the JSON value")
+	}
+	return value, nil
+}
+
+// Inferrer merges samples into one shape per event.
+type Inferrer struct {
+	shapes map[string]*Shape
+}
+
+func New() *Inferrer {
+	return &Inferrer{shapes: make(map[string]*Shape)}
+}
+
+// Add merges a decoded document into the shape of event. "" groups
+// samples without an event.
+func (in *Inferrer) Add(event string, value any) {
+	shape := in.shapes[event]
+	if shape == nil {
+		shape = &Shape{Event: event, root: &node{}}
+		in.shapes[event] = shape
+	}
+	shape.Samples++
+	shape.root.observe(shape, value, 0)
+}
+
+// Shapes returns the inferred shapes, the most sampled first.
+func (in *Inferrer) Shapes() []*Shape {
+	shapes := make([]*Shape, 0, len(in.shapes))
+	for _, shape := range in.shapes {
+		shape.Fields = shape.root.fields(nil, "", 0, false)
+		shapes = append(shapes, shape)
+	}
+	sort.Slice(shapes, func(i, j int) bool {
+		if shapes[i].Samples != shapes[j].Samples {
+			return shapes[i].Samples > shapes[j].Samples
+		}
+		return shapes[i].Event < shapes[j].Event
+	})
+	return shapes
+}
+
+// Shape is the merged structure of one event's samples.
+type Shape struct {
+	Event   string  `json:"event"`
+	Samples int     `json:"samples"`
+	Fields  []Field `json:"fields"`
+	// Truncated is set when fields beyond the limit were left out.
+	Truncated bool `json:"truncated,omitempty"`
+	root      *node
+	nodes     int
+}
+
+// Field describes one path. Object keys are joined with dots and array
+// elements are written as [], as in "order.items[].sku". Frequency is the
+// share of enclosing objects that had the field; Optional fields were
+// missing from some of them. Examples are compact JSON.
+type Field struct {
+	Path      string   `json:"path"`
+	Types     []string `json:"types"`
+	Count     int      `json:"count"`
+	Frequency float64  `json:"frequency"`
+	Optional  bool     `json:"optional"`
+	Examples  []string `json:"examples,omitempty"`
+}
+
+type node struct {
+	count      int
+	types      map[string]int
+	objects    int
+	properties map[string]*node
+	items      *node
+	examples   []string
+}
+
+func typeOf(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return TypeNull
+	case bool:
+		return TypeBoolean
+	case string:
+		return TypeString
+	case json.Number:
+		if strings.ContainsAny(value.String(), ".eE") {
+			return TypeNumber
+		}
+		return TypeInteger
+	case map[string]any:
+		return TypeObject
+	case []any:
+		return TypeArray
+	}
+	return TypeNull
+}
+
+func (n *node) observe(shape *Shape, value any, depth int) {
+	n.count++
+	kind := typeOf(value)
+	if n.types == nil {
+		n.types = make(map[string]int)
+	}
+	n.types[kind]++
+	if depth >= maxDepth {
+		shape.Truncated = true
+		return
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		n.objects++
+		if n.properties == nil {
+			n.properties = make(map[string]*node)
+		}
+		for key, child := range value {
+			property := n.properties[key]
+			if property == nil {
+				if shape.nodes >= maxNodes {
+					shape.Truncated = true
+					continue
+				}
+				shape.nodes++
+				property = &node{}
+				n.properties[key] = property
+			}
+			property.observe(shape, child, depth+1)
+		}
+	case []any:
+		if n.items == nil && len(value) > 0 {
+			if shape.nodes >= maxNodes {
+				shape.Truncated = true
+				return
+			}
+			shape.nodes++
+			n.items = &node{}
+		}
+		for _, item := range value {
+			n.items.observe(shape, item, depth+1)
+		}
+	default:
+		n.addExample(value)
+	}
+}
+
+func (n *node) addExample(value any) {
+	if len(n.examples) >= maxExamples {
+		return
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil || len(encoded) > maxExampleSize {
+		return
+	}
+	for _, example := range n.examples {
+		if example == string(encoded) {
+			return
+		}
+	}
+	n.examples = append(n.examples, string(encoded))
+}
+
+// typeNames lists the observed types in a fixed order.
+func (n *node) typeNames() []string {
+	names := make([]string, 0, len(n.types))
+	for _, kind := range []string{TypeObject, TypeArray, TypeString, TypeInteger, TypeNumber, TypeBoolean, TypeNull} {
+		if n.types[kind] > 0 {
+			names = append(names, kind)
+		}
+	}
+	return names
+}
+
+func (n *node) sortedProperties() []string {
+	keys := make([]string, 0, len(n.properties))
+	for key := range n.properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (n *node) fields(out []Field, path string, parents int, isItem bool) []Field {
+	if path != "" {
+		field := Field{Path: path, Types: n.typeNames(), Count: n.count, Frequency: 1, Examples: n.examples}
+		if !isItem && parents > 0 {
+			field.Frequency = float64(n.count) / float64(parents)
+			field.Optional = n.count < parents
+		}
+		out = append(out, field)
+	}
+	for _, key := range n.sortedProperties() {
+		child := key
+		if path != "" {
+			child = path + "." + key
+		}
+		out = n.properties[key].fields(out, child, n.objects, false)
+	}
+	if n.items != nil {
+		out = n.items.fields(out, path+"[]", n.count, true)
+	}
+	return out
+}
+
+// JSONSchema writes the shape as a draft 2020-12 JSON Schema. Fields found
+// in every enclosing object are required.
+func (s *Shape) JSONSchema() ([]byte, error) {
+	schema := s.root.schema()
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	if s.Event != "" {
+		schema["title"] = s.Event
+	}
+	schema["description"] = fmt.Sprintf("Inferred from %d captured payloads.", s.Samples)
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+func (n *node) schema() map[string]any {
+	schema := make(map[string]any)
+	types := n.typeNames()
+	if n.types[TypeInteger] > 0 && n.types[TypeNumber] > 0 {
+		// Every integer is a number, so the narrower type adds nothing.
+		types = removeType(types, TypeInteger)
+	}
+	switch len(types) {
+	case 0:
+	case 1:
+		schema["type"] = types[0]
+	default:
+		schema["type"] = types
+	}
+	if n.objects > 0 {
+		properties := make(map[string]any, len(n.properties))
+		required := make([]string, 0)
+		for _, key := range n.sortedProperties() {
+			property := n.properties[key]
+			properties[key] = property.schema()
+			if property.count == n.objects {
+				required = append(required, key)
+			}
+		}
+		schema["properties"] = properties
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+	}
+	if n.items != nil {
+		schema["items"] = n.items.schema()
+	}
+	if len(n.examples) > 0 {
+		examples := make([]json.RawMessage, len(n.examples))
+		for i, example := range n.examples {
+			examples[i] = json.RawMessage(example)
+		}
+		schema["examples"] = examples
+	}
+	return schema
+}
+
+func removeType(types []string, remove string) []string {
+	kept := types[:0:0]
+	for _, kind := range types {
+		if kind != remove {
+			kept = append(kept, kind)
+		}
+	}
+	return kept
+}
+
+// GoType writes the shape as a formatted Go type declaration called name.
+// Nested objects become inline structs. Optional and nullable fields are
+// pointers with omitempty, and fields seen with several types are any.
+func (s *Shape) GoType(name string) ([]byte, error) {
+	var source strings.Builder
+	fmt.Fprintf(&source, "// %s was inferred from %d captured payloads", name, s.Samples)
+	if s.Event != "" {
+		fmt.Fprintf(&source, " of %q", s.Event)
+	}
+	source.WriteString(".\n")
+	fmt.Fprintf(&source, "type %s ", name)
+	s.root.goType(&source, false)
+	source.WriteString("\n")
+	return format.Source([]byte(source.String()))
+}
+
+func (n *node) goType(source *strings.Builder, pointer bool) {
+	types := removeType(n.typeNames(), TypeNull)
+	if n.types[TypeInteger] > 0 && n.types[TypeNumber] > 0 {
+		types = removeType(types, TypeInteger)
+	}
+	if len(types) != 1 {
+		source.WriteString("any")
+		return
+	}
+	nullable := n.types[TypeNull] > 0
+	switch types[0] {
+	case TypeObject:
+		if pointer || nullable {
+			source.WriteString("*")
+		}
+		source.WriteString("struct {\n")
+		used := make(map[string]bool)
+		for _, key := range n.sortedProperties() {
+			if !validTagName(key) {
+				// encoding/json cannot match the key through a struct tag,
+				// so the field is left out.
+				fmt.Fprintf(source, "// %q cannot be a json tag name and is left out.\n", key)
+				continue
+			}
+			property := n.properties[key]
+			optional := property.count < n.objects
+			fieldName := uniqueName(GoName(key, "Field"), used)
+			fmt.Fprintf(source, "%s ", fieldName)
+			property.goType(source, optional)
+			tag := key
+			switch {
+			case optional:
+				tag += ",omitempty"
+			case key == "-":
+				// A bare "-" tag would skip the field.
+				tag += ","
+			}
+			fmt.Fprintf(source, " `json:%q`\n", tag)
+		}
+		source.WriteString("}")
+	case TypeArray:
+		source.WriteString("[]")
+		if n.items == nil {
+			source.WriteString("any")
+			return
+		}
+		n.items.goType(source, false)
+	default:
+		if pointer || nullable {
+			source.WriteString("*")
+		}
+		source.WriteString(map[string]string{TypeString: "string", TypeInteger: "int64", TypeNumber: "float64", TypeBoolean: "bool"}[types[0]])
+	}
+}
+
+// validTagName reports whether encoding/json accepts key as the name in a
+// struct tag. It ignores tags with other characters and uses the field name.
+func validTagName(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("!#$%&()*+-./:;<=>?@[]^_{|}~ ", r) {
+			return false
+		}
+	}
+	return true
+}
+
+func uniqueName(name string, used map[string]bool) string {
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	used[candidate] = true
+	return candidate
+}
+
+// commonInitialisms are written in capitals in Go names, as golint does.
+var commonInitialisms = map[string]bool{
+	"API": true, "ASCII": true, "CPU": true, "CSS": true, "DNS": true, "EOF": true, "GUID": true, "HTML": true,
+	"HTTP": true, "HTTPS": true, "ID": true, "IP": true, "JSON": true, "SHA": true, "SQL": true, "SSH": true,
+	"TLS": true, "TTL": true, "UI": true, "UID": true, "URI": true, "URL": true, "UTF8": true, "UUID": true, "XML": true,
+}
+
+// GoName turns a JSON key or event name such as "order.paid" or
+// "repository_id" into an exported Go identifier like OrderPaid or
+// RepositoryID. fallback is used when nothing usable is left.
+func GoName(key, fallback string) string {
+	words := strings.FieldsFunc(key, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	var name strings.Builder
+	for _, word := range words {
+		for _, part := range splitCamel(word) {
+			if upper := strings.ToUpper(part); commonInitialisms[upper] {
+				name.WriteString(upper)
+				continue
+			}
+			runes := []rune(strings.ToLower(part))
+			runes[0] = unicode.ToUpper(runes[0])
+			name.WriteString(string(runes))
+		}
+	}
+	result := name.String()
+	if result == "" {
+		return fallback
+	}
+	// Letters without an upper case, as in many scripts, would leave the
+	// name unexported.
+	if first := []rune(result)[0]; !unicode.IsUpper(first) {
+		result = fallback + result
+	}
+	return result
+}
+
+// splitCamel splits "repositoryId" into "repository" and "Id", keeping runs
+// of capitals such as "HTML" in "HTMLUrl" together.
+func splitCamel(word string) []string {
+	runes := []rune(word)
+	var parts []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		lowerToUpper := unicode.IsLower(runes[i-1]) && unicode.IsUpper(runes[i])
+		acronymEnd := i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i+1])
+		if lowerToUpper || acronymEnd {
+			parts = append(parts, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(parts, string(runes[start:]))
+}
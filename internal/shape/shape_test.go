@@ -0,0 +1,134 @@
+package shape_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PipeOpsHQ/pipehook/internal/shape"
+)
+
+func infer(t *testing.T, documents map[string][]string) []*shape.Shape {
+	t.Helper()
+	inferrer := shape.New()
+	for event, list := range documents {
+		for _, document := range list {
+			value, err := shape.Decode([]byte(document))
+			if err != nil {
+				t.Fatal(err)
+			}
+			inferrer.Add(event, value)
+		}
+	}
+	return inferrer.Shapes()
+}
+
+func TestInferMergesSamplesPerEvent(t *testing.T) {
+	shapes := infer(t, map[string][]string{
+		"order.paid": {
+			`{"id": 1, "total": 9.5, "items": [{"sku": "a"}, {"sku": "b", "qty": 2}], "note": null}`,
+			`{"id": 2, "total": 10, "items": [], "note": "gift"}`,
+		},
+		"ping": {`{"zen": "keep it simple"}`, `{"zen": "keep it simple"}`, `{"zen": "speak like a human"}`},
+	})
+	if len(shapes) != 2 || shapes[0].Event != "ping" || shapes[0].Samples != 3 || shapes[1].Samples != 2 {
+		t.Fatalf("unexpected shapes: %+v", shapes)
+	}
+	if zen := shapes[0].Fields[0]; zen.Path != "zen" || zen.Optional || len(zen.Examples) != 2 {
+		t.Fatalf("expected two distinct examples: %+v", zen)
+	}
+	var lines []string
+	for _, field := range shapes[1].Fields {
+		encoded, _ := json.Marshal(field.Types)
+		lines = append(lines, fmt.Sprint(field.Path, " ", string(encoded), " ", field.Frequency))
+	}
+	expected := []string{
+		`id ["integer"] 1`,
+		`items ["array"] 1`,
+		`items[] ["object"] 1`,
+		`items[].qty ["integer"] 0.5`,
+		`items[].sku ["string"] 1`,
+		`note ["string","null"] 1`,
+		`total ["integer","number"] 1`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected fields:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestJSONSchemaAndGoType(t *testing.T) {
+	shapes := infer(t, map[string][]string{"push": {
+		`{"ref": "main", "repository": {"id": 1, "html_url": "https://example.com"}, "commits": [{"id": "abc"}], "forced": false}`,
+		`{"ref": "dev", "repository": {"id": 2, "html_url": "https://example.com"}, "commits": [], "deleted": true}`,
+	}})
+	schema, err := shapes[0].JSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Title      string                     `json:"title"`
+		Required   []string                   `json:"required"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(schema, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Title != "push" || strings.Join(decoded.Required, ",") != "commits,ref,repository" || len(decoded.Properties) != 5 {
+		t.Fatalf("unexpected schema: %s", schema)
+	}
+
+	source, err := shapes[0].GoType(shape.GoName(shapes[0].Event, "Payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"type Push struct {",
+		"Commits []struct {",
+		"ID string `json:\"id\"`",
+		"Deleted    *bool  `json:\"deleted,omitempty\"`",
+		"HTMLURL string `json:\"html_url\"`",
+		"ID      int64  `json:\"id\"`",
+	} {
+		if !strings.Contains(string(source), want) {
+			t.Fatalf("expected %q in:\n%s", want, source)
+		}
+	}
+}
+
+func TestGoTypeHandlesKeysThatCannotBeTags(t *testing.T) {
+	shapes := infer(t, map[string][]string{"odd": {
+		`{"a,b": 1, "-": 2, "名前": "x", "back` + "`" + `tick": 3, "quote\"d": 4, "": 5, "ok": 6}`,
+	}})
+	source, err := shapes[0].GoType("Odd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"// \"a,b\" cannot be a json tag name and is left out.",
+		"Field int64 `json:\"-,\"`",
+		"Field名前 string `json:\"名前\"`",
+		"// \"quote\\\"d\" cannot be a json tag name and is left out.",
+		"// \"\" cannot be a json tag name and is left out.",
+		"// \"back`tick\" cannot be a json tag name and is left out.",
+		"Ok int64 `json:\"ok\"`",
+	} {
+		if !strings.Contains(string(source), want) {
+			t.Errorf("expected %q in:\n%s", want, source)
+		}
+	}
+	if strings.Contains(string(source), "json:\"a,b") || strings.Contains(string(source), "json:\"back") {
+		t.Fatalf("unexpected fields for invalid keys:\n%s", source)
+	}
+}
+
+func TestGoName(t *testing.T) {
+	for key, expected := range map[string]string{
+		"order.paid": "OrderPaid", "repository_id": "RepositoryID", "htmlUrl": "HTMLURL", "HTMLBody": "HTMLBody",
+		"2fa": "Field2fa", "": "Field", "pull-request": "PullRequest", "名前": "Field名前", "état": "État",
+	} {
+		if name := shape.GoName(key, "Field"); name != expected {
+			t.Errorf("GoName(%q) = %q, want %q", key, name, expected)
+		}
+	}
+}
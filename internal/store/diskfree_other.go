@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd)
+
+package store
+
+import "errors"
+
+// diskFree is not implemented on this platform, so readiness skips the disk
+// space check.
+func diskFree(string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,522 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Instrument wraps s so that observe hears about every call. observe is
+// called with the call's context and method name when a call starts and
+// returns a function that receives the call's error when it ends.
+func Instrument(s Store, observe func(ctx context.Context, method string) func(err error)) Store {
+	return &instrumentedStore{store: s, observe: observe}
+}
+
+type instrumentedStore struct {
+	store   Store
+	observe func(ctx context.Context, method string) func(err error)
+}
+
+func (s *instrumentedStore) CreateEndpoint(ctx context.Context, id string, alias string, creatorID string, ttl time.Duration) (*Endpoint, error) {
+	done := s.observe(ctx, "CreateEndpoint")
+	result, err := s.store.CreateEndpoint(ctx, id, alias, creatorID, ttl)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) ImportEndpoint(ctx context.Context, endpoint *Endpoint) error {
+	done := s.observe(ctx, "ImportEndpoint")
+	err := s.store.ImportEndpoint(ctx, endpoint)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) GetEndpoint(ctx context.Context, id string) (*Endpoint, error) {
+	done := s.observe(ctx, "GetEndpoint")
+	result, err := s.store.GetEndpoint(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) UpdateEndpoint(ctx context.Context, id string, alias string, ttl time.Duration) error {
+	done := s.observe(ctx, "UpdateEndpoint")
+	err := s.store.UpdateEndpoint(ctx, id, alias, ttl)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) UpdateEndpointSettings(ctx context.Context, id string, settings EndpointSettings) error {
+	done := s.observe(ctx, "UpdateEndpointSettings")
+	err := s.store.UpdateEndpointSettings(ctx, id, settings)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) DeleteEndpoint(ctx context.Context, id string) error {
+	done := s.observe(ctx, "DeleteEndpoint")
+	err := s.store.DeleteEndpoint(ctx, id)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) ListEndpoints(ctx context.Context, creatorID string, limit int) ([]*Endpoint, error) {
+	done := s.observe(ctx, "ListEndpoints")
+	result, err := s.store.ListEndpoints(ctx, creatorID, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) ListAllEndpoints(ctx context.Context, limit int, offset int) ([]*Endpoint, error) {
+	done := s.observe(ctx, "ListAllEndpoints")
+	result, err := s.store.ListAllEndpoints(ctx, limit, offset)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) SaveRequest(ctx context.Context, req *Request) error {
+	done := s.observe(ctx, "SaveRequest")
+	err := s.store.SaveRequest(ctx, req)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) GetRequests(ctx context.Context, endpointID string, limit int) ([]*Request, error) {
+	done := s.observe(ctx, "GetRequests")
+	result, err := s.store.GetRequests(ctx, endpointID, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) GetRequestsWithOffset(ctx context.Context, endpointID string, limit int, offset int) ([]*Request, error) {
+	done := s.observe(ctx, "GetRequestsWithOffset")
+	result, err := s.store.GetRequestsWithOffset(ctx, endpointID, limit, offset)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) ListRequestsAfter(ctx context.Context, endpointID string, afterID int64, limit int) ([]*Request, error) {
+	done := s.observe(ctx, "ListRequestsAfter")
+	result, err := s.store.ListRequestsAfter(ctx, endpointID, afterID, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) GetRequestSummaries(ctx context.Context, endpointID string, limit int) ([]*Request, error) {
+	done := s.observe(ctx, "GetRequestSummaries")
+	result, err := s.store.GetRequestSummaries(ctx, endpointID, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) GetRequestSummariesWithOffset(ctx context.Context, endpointID string, limit int, offset int) ([]*Request, error) {
+	done := s.observe(ctx, "GetRequestSummariesWithOffset")
+	result, err := s.store.GetRequestSummariesWithOffset(ctx, endpointID, limit, offset)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) SearchRequestSummaries(ctx context.Context, endpointID string, query string, limit int, offset int) ([]*Request, error) {
+	done := s.observe(ctx, "SearchRequestSummaries")
+	result, err := s.store.SearchRequestSummaries(ctx, endpointID, query, limit, offset)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) SearchRequests(ctx context.Context, endpointID string, query string, limit int, offset int) ([]*Request, error) {
+	done := s.observe(ctx, "SearchRequests")
+	result, err := s.store.SearchRequests(ctx, endpointID, query, limit, offset)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) CountRequests(ctx context.Context, endpointID string) (int, error) {
+	done := s.observe(ctx, "CountRequests")
+	result, err := s.store.CountRequests(ctx, endpointID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) CountRequestsFiltered(ctx context.Context, endpointID string, query string) (int, error) {
+	done := s.observe(ctx, "CountRequestsFiltered")
+	result, err := s.store.CountRequestsFiltered(ctx, endpointID, query)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) GetRequest(ctx context.Context, id int64) (*Request, error) {
+	done := s.observe(ctx, "GetRequest")
+	result, err := s.store.GetRequest(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) RecordResponse(ctx context.Context, request *Request) error {
+	done := s.observe(ctx, "RecordResponse")
+	err := s.store.RecordResponse(ctx, request)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) IncrementRejectedCount(ctx context.Context, endpointID string) error {
+	done := s.observe(ctx, "IncrementRejectedCount")
+	err := s.store.IncrementRejectedCount(ctx, endpointID)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) AddThrottledCounts(ctx context.Context, counts map[string]int64) error {
+	done := s.observe(ctx, "AddThrottledCounts")
+	err := s.store.AddThrottledCounts(ctx, counts)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) RecordChaosAttempt(ctx context.Context, endpointID, key string) (int, error) {
+	done := s.observe(ctx, "RecordChaosAttempt")
+	result, err := s.store.RecordChaosAttempt(ctx, endpointID, key)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) SetResponseSequence(ctx context.Context, sequence *ResponseSequence) error {
+	done := s.observe(ctx, "SetResponseSequence")
+	err := s.store.SetResponseSequence(ctx, sequence)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) ListResponseSequences(ctx context.Context, endpointID string) ([]*ResponseSequence, error) {
+	done := s.observe(ctx, "ListResponseSequences")
+	result, err := s.store.ListResponseSequences(ctx, endpointID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) ResetResponseSequence(ctx context.Context, endpointID, path string) error {
+	done := s.observe(ctx, "ResetResponseSequence")
+	err := s.store.ResetResponseSequence(ctx, endpointID, path)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) DeleteResponseSequence(ctx context.Context, endpointID, path string) error {
+	done := s.observe(ctx, "DeleteResponseSequence")
+	err := s.store.DeleteResponseSequence(ctx, endpointID, path)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) NextSequenceStep(ctx context.Context, endpointID, path string) (*SequenceStep, int, error) {
+	done := s.observe(ctx, "NextSequenceStep")
+	r0, r1, err := s.store.NextSequenceStep(ctx, endpointID, path)
+	done(err)
+	return r0, r1, err
+}
+
+func (s *instrumentedStore) SetAssertion(ctx context.Context, assertion *Assertion) error {
+	done := s.observe(ctx, "SetAssertion")
+	err := s.store.SetAssertion(ctx, assertion)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) ListAssertions(ctx context.Context, endpointID string) ([]*Assertion, error) {
+	done := s.observe(ctx, "ListAssertions")
+	result, err := s.store.ListAssertions(ctx, endpointID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) DeleteAssertion(ctx context.Context, endpointID, path string) error {
+	done := s.observe(ctx, "DeleteAssertion")
+	err := s.store.DeleteAssertion(ctx, endpointID, path)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) MatchAssertion(ctx context.Context, endpointID, path string) (*Assertion, error) {
+	done := s.observe(ctx, "MatchAssertion")
+	result, err := s.store.MatchAssertion(ctx, endpointID, path)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) CreateNotificationChannel(ctx context.Context, channel *NotificationChannel) error {
+	done := s.observe(ctx, "CreateNotificationChannel")
+	err := s.store.CreateNotificationChannel(ctx, channel)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) ListNotificationChannels(ctx context.Context, endpointID string) ([]*NotificationChannel, error) {
+	done := s.observe(ctx, "ListNotificationChannels")
+	result, err := s.store.ListNotificationChannels(ctx, endpointID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) DeleteNotificationChannel(ctx context.Context, endpointID, id string) error {
+	done := s.observe(ctx, "DeleteNotificationChannel")
+	err := s.store.DeleteNotificationChannel(ctx, endpointID, id)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) DeleteRequest(ctx context.Context, id int64) error {
+	done := s.observe(ctx, "DeleteRequest")
+	err := s.store.DeleteRequest(ctx, id)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) TrimRequests(ctx context.Context, endpointID string, keep int) error {
+	done := s.observe(ctx, "TrimRequests")
+	err := s.store.TrimRequests(ctx, endpointID, keep)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) CreateAPIKey(ctx context.Context, key *APIKey, keyHash string) error {
+	done := s.observe(ctx, "CreateAPIKey")
+	err := s.store.CreateAPIKey(ctx, key, keyHash)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	done := s.observe(ctx, "ListAPIKeys")
+	result, err := s.store.ListAPIKeys(ctx)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	done := s.observe(ctx, "GetAPIKeyByHash")
+	result, err := s.store.GetAPIKeyByHash(ctx, keyHash)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) RevokeAPIKey(ctx context.Context, id string) error {
+	done := s.observe(ctx, "RevokeAPIKey")
+	err := s.store.RevokeAPIKey(ctx, id)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	done := s.observe(ctx, "TouchAPIKey")
+	err := s.store.TouchAPIKey(ctx, id, usedAt)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) CreateUser(ctx context.Context, user *User, passwordHash string) error {
+	done := s.observe(ctx, "CreateUser")
+	err := s.store.CreateUser(ctx, user, passwordHash)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) GetUserByUsername(ctx context.Context, username string) (*User, string, error) {
+	done := s.observe(ctx, "GetUserByUsername")
+	r0, r1, err := s.store.GetUserByUsername(ctx, username)
+	done(err)
+	return r0, r1, err
+}
+
+func (s *instrumentedStore) GetUserByOIDCSubject(ctx context.Context, subject string) (*User, error) {
+	done := s.observe(ctx, "GetUserByOIDCSubject")
+	result, err := s.store.GetUserByOIDCSubject(ctx, subject)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) CreateOIDCUser(ctx context.Context, user *User, subject string) error {
+	done := s.observe(ctx, "CreateOIDCUser")
+	err := s.store.CreateOIDCUser(ctx, user, subject)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) CreateSession(ctx context.Context, session *Session) error {
+	done := s.observe(ctx, "CreateSession")
+	err := s.store.CreateSession(ctx, session)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) GetSession(ctx context.Context, tokenHash string) (*Session, error) {
+	done := s.observe(ctx, "GetSession")
+	result, err := s.store.GetSession(ctx, tokenHash)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	done := s.observe(ctx, "DeleteSession")
+	err := s.store.DeleteSession(ctx, tokenHash)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) SetEndpointOwner(ctx context.Context, endpointID string, userID string) error {
+	done := s.observe(ctx, "SetEndpointOwner")
+	err := s.store.SetEndpointOwner(ctx, endpointID, userID)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) ListUserEndpoints(ctx context.Context, userID string, limit int) ([]*Endpoint, error) {
+	done := s.observe(ctx, "ListUserEndpoints")
+	result, err := s.store.ListUserEndpoints(ctx, userID, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) ClaimEndpoints(ctx context.Context, creatorID string, userID string) (int, error) {
+	done := s.observe(ctx, "ClaimEndpoints")
+	result, err := s.store.ClaimEndpoints(ctx, creatorID, userID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) CreateShareLink(ctx context.Context, link *ShareLink) error {
+	done := s.observe(ctx, "CreateShareLink")
+	err := s.store.CreateShareLink(ctx, link)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) GetShareLink(ctx context.Context, id string) (*ShareLink, error) {
+	done := s.observe(ctx, "GetShareLink")
+	result, err := s.store.GetShareLink(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) ListShareLinks(ctx context.Context, endpointID string) ([]*ShareLink, error) {
+	done := s.observe(ctx, "ListShareLinks")
+	result, err := s.store.ListShareLinks(ctx, endpointID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) RevokeShareLink(ctx context.Context, endpointID string, id string) error {
+	done := s.observe(ctx, "RevokeShareLink")
+	err := s.store.RevokeShareLink(ctx, endpointID, id)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) SigningKey(ctx context.Context, name string) ([]byte, error) {
+	done := s.observe(ctx, "SigningKey")
+	result, err := s.store.SigningKey(ctx, name)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	done := s.observe(ctx, "RecordAuditEvent")
+	err := s.store.RecordAuditEvent(ctx, event)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	done := s.observe(ctx, "ListAuditEvents")
+	result, err := s.store.ListAuditEvents(ctx, filter)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) CreateWorkspace(ctx context.Context, workspace *Workspace, ownerUserID string) error {
+	done := s.observe(ctx, "CreateWorkspace")
+	err := s.store.CreateWorkspace(ctx, workspace, ownerUserID)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) GetWorkspace(ctx context.Context, id string) (*Workspace, error) {
+	done := s.observe(ctx, "GetWorkspace")
+	result, err := s.store.GetWorkspace(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) ListUserWorkspaces(ctx context.Context, userID string) ([]*Workspace, error) {
+	done := s.observe(ctx, "ListUserWorkspaces")
+	result, err := s.store.ListUserWorkspaces(ctx, userID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) GetWorkspaceRole(ctx context.Context, workspaceID string, userID string) (string, error) {
+	done := s.observe(ctx, "GetWorkspaceRole")
+	result, err := s.store.GetWorkspaceRole(ctx, workspaceID, userID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) ListWorkspaceMembers(ctx context.Context, workspaceID string) ([]*WorkspaceMember, error) {
+	done := s.observe(ctx, "ListWorkspaceMembers")
+	result, err := s.store.ListWorkspaceMembers(ctx, workspaceID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) SetWorkspaceMember(ctx context.Context, workspaceID string, userID string, role string) error {
+	done := s.observe(ctx, "SetWorkspaceMember")
+	err := s.store.SetWorkspaceMember(ctx, workspaceID, userID, role)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) RemoveWorkspaceMember(ctx context.Context, workspaceID string, userID string) error {
+	done := s.observe(ctx, "RemoveWorkspaceMember")
+	err := s.store.RemoveWorkspaceMember(ctx, workspaceID, userID)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) SetEndpointWorkspace(ctx context.Context, endpointID string, workspaceID string) error {
+	done := s.observe(ctx, "SetEndpointWorkspace")
+	err := s.store.SetEndpointWorkspace(ctx, endpointID, workspaceID)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) ListWorkspaceEndpoints(ctx context.Context, workspaceID string, limit int) ([]*Endpoint, error) {
+	done := s.observe(ctx, "ListWorkspaceEndpoints")
+	result, err := s.store.ListWorkspaceEndpoints(ctx, workspaceID, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) Cleanup(ctx context.Context) error {
+	done := s.observe(ctx, "Cleanup")
+	err := s.store.Cleanup(ctx)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) GetAdminStats(ctx context.Context) (*AdminStats, error) {
+	done := s.observe(ctx, "GetAdminStats")
+	result, err := s.store.GetAdminStats(ctx)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStore) Ready(ctx context.Context, minFreeDiskBytes int64) error {
+	done := s.observe(ctx, "Ready")
+	err := s.store.Ready(ctx, minFreeDiskBytes)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStore) Diagnostics(ctx context.Context) (*Diagnostics, error) {
+	done := s.observe(ctx, "Diagnostics")
+	result, err := s.store.Diagnostics(ctx)
+	done(err)
+	return result, err
+}
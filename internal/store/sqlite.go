@@ -3,8 +3,13 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,14 +17,20 @@ import (
 )
 
 const (
-	endpointColumns = `id, COALESCE(alias, ''), COALESCE(creator_id, ''), created_at, expires_at,
+	endpointColumns = `id, COALESCE(alias, ''), COALESCE(creator_id, ''), COALESCE(owner_user_id, ''), COALESCE(workspace_id, ''), created_at, expires_at,
 		COALESCE(default_status, 200), COALESCE(default_body, 'ok'),
 		COALESCE(default_content_type, 'text/plain; charset=utf-8'),
 		COALESCE(response_delay_ms, 0), COALESCE(enable_cors, 0),
-		COALESCE(forward_url, ''), COALESCE(request_limit, 1000)`
+		COALESCE(forward_url, ''), COALESCE(request_limit, 1000),
+		inbound_basic_username, inbound_basic_password, inbound_bearer_token, inbound_header_name,
+		inbound_header_value, inbound_allowed_cidrs, inbound_client_cert_sha256, inbound_store_rejected, rejected_count,
+		throttle_requests_per_second, throttle_burst, throttle_body, throttled_count,
+		chaos_error_percent, chaos_error_statuses, chaos_latency_min_ms, chaos_latency_max_ms, chaos_reset_percent,
+		chaos_drip_percent, chaos_drip_interval_ms, chaos_fail_first, chaos_fail_key_header`
 	requestColumns = `id, endpoint_id, method, path, COALESCE(query_string, ''),
 		COALESCE(host, ''), COALESCE(scheme, ''), remote_addr, headers, body,
-		COALESCE(content_length, 0), COALESCE(body_truncated, 0), status_code, created_at`
+		COALESCE(content_length, 0), COALESCE(body_truncated, 0), status_code, rejected_reason, chaos, sequence_step,
+		response_headers, response_body, response_time_ms, client_aborted, trace_id, correlation_id, validation, created_at`
 )
 
 type SQLiteStore struct {
@@ -51,14 +62,71 @@ func NewSQLiteStore(dsn string) (*SQLiteStore, error) {
 	return s, nil
 }
 
+// columnMigrations adds the columns introduced after a table was first
+// created. Ready checks that all of them exist.
+var columnMigrations = []struct {
+	table, column, definition string
+}{
+	{"endpoints", "creator_id", "TEXT"},
+	{"endpoints", "owner_user_id", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "workspace_id", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "default_status", "INTEGER NOT NULL DEFAULT 200"},
+	{"endpoints", "default_body", "TEXT NOT NULL DEFAULT 'ok'"},
+	{"endpoints", "default_content_type", "TEXT NOT NULL DEFAULT 'text/plain; charset=utf-8'"},
+	{"endpoints", "response_delay_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "enable_cors", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "forward_url", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "request_limit", "INTEGER NOT NULL DEFAULT 1000"},
+	{"requests", "query_string", "TEXT NOT NULL DEFAULT ''"},
+	{"requests", "host", "TEXT NOT NULL DEFAULT ''"},
+	{"requests", "scheme", "TEXT NOT NULL DEFAULT ''"},
+	{"requests", "content_length", "INTEGER NOT NULL DEFAULT 0"},
+	{"requests", "body_truncated", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "inbound_basic_username", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "inbound_basic_password", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "inbound_bearer_token", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "inbound_header_name", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "inbound_header_value", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "inbound_allowed_cidrs", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "inbound_client_cert_sha256", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "inbound_store_rejected", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "rejected_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"requests", "rejected_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "throttle_requests_per_second", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "throttle_burst", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "throttle_body", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "throttled_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "chaos_error_percent", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "chaos_error_statuses", "TEXT NOT NULL DEFAULT ''"},
+	{"endpoints", "chaos_latency_min_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "chaos_latency_max_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "chaos_reset_percent", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "chaos_drip_percent", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "chaos_drip_interval_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "chaos_fail_first", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "chaos_fail_key_header", "TEXT NOT NULL DEFAULT ''"},
+	{"requests", "chaos", "TEXT NOT NULL DEFAULT ''"},
+	{"requests", "sequence_step", "INTEGER NOT NULL DEFAULT 0"},
+	{"requests", "response_headers", "TEXT NOT NULL DEFAULT ''"},
+	{"requests", "response_body", "BLOB"},
+	{"requests", "response_time_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"requests", "client_aborted", "INTEGER NOT NULL DEFAULT 0"},
+	{"requests", "trace_id", "TEXT NOT NULL DEFAULT ''"},
+	{"requests", "correlation_id", "TEXT NOT NULL DEFAULT ''"},
+	{"requests", "validation", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "oidc_subject", "TEXT NOT NULL DEFAULT ''"},
+	{"sessions", "is_admin", "INTEGER NOT NULL DEFAULT 0"},
+	{"endpoints", "inbound_secrets_hashed", "INTEGER NOT NULL DEFAULT 0"},
+}
+
 func (s *SQLiteStore) init() error {
 	var readOnly int
 	if err := s.db.QueryRow("PRAGMA query_only;").Scan(&readOnly); err == nil && readOnly == 1 {
-		log.Printf("CRITICAL WARNING: Database is opened in READ-ONLY mode!")
+		slog.Error("database is opened in read-only mode")
 	}
 
 	if _, err := s.db.Exec("PRAGMA journal_mode=WAL;"); err != nil {
-		log.Printf("Warning: Failed to enable WAL mode: %v", err)
+		slog.Warn("failed to enable WAL mode", "error", err)
 	}
 	_, _ = s.db.Exec("PRAGMA synchronous=NORMAL;")
 	_, _ = s.db.Exec("PRAGMA foreign_keys=ON;")
@@ -69,6 +137,8 @@ func (s *SQLiteStore) init() error {
 			id TEXT PRIMARY KEY,
 			alias TEXT,
 			creator_id TEXT,
+			owner_user_id TEXT NOT NULL DEFAULT '',
+			workspace_id TEXT NOT NULL DEFAULT '',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			expires_at DATETIME,
 			default_status INTEGER NOT NULL DEFAULT 200,
@@ -96,63 +166,202 @@ func (s *SQLiteStore) init() error {
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY(endpoint_id) REFERENCES endpoints(id) ON DELETE CASCADE
 		);
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL DEFAULT '',
+			endpoint_ids TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME,
+			last_used_at DATETIME,
+			revoked_at DATETIME
+		);
+		CREATE TABLE IF NOT EXISTS users (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			password_hash TEXT NOT NULL,
+			oidc_subject TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			is_admin INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS share_links (
+			id TEXT PRIMARY KEY,
+			endpoint_id TEXT NOT NULL,
+			request_id INTEGER,
+			redact_headers TEXT NOT NULL DEFAULT '',
+			created_by TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL,
+			revoked_at DATETIME,
+			FOREIGN KEY(endpoint_id) REFERENCES endpoints(id) ON DELETE CASCADE,
+			FOREIGN KEY(request_id) REFERENCES requests(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS signing_keys (
+			name TEXT PRIMARY KEY,
+			key BLOB NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS workspaces (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS workspace_members (
+			workspace_id TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			role TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (workspace_id, user_id),
+			FOREIGN KEY(workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+			FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS audit_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at DATETIME NOT NULL,
+			actor_type TEXT NOT NULL,
+			actor_id TEXT NOT NULL DEFAULT '',
+			actor TEXT NOT NULL DEFAULT '',
+			action TEXT NOT NULL,
+			target_type TEXT NOT NULL DEFAULT '',
+			target_id TEXT NOT NULL DEFAULT '',
+			remote_addr TEXT NOT NULL DEFAULT '',
+			details TEXT NOT NULL DEFAULT ''
+		);
+		CREATE TABLE IF NOT EXISTS chaos_attempts (
+			endpoint_id TEXT NOT NULL,
+			attempt_key TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (endpoint_id, attempt_key),
+			FOREIGN KEY(endpoint_id) REFERENCES endpoints(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS response_sequences (
+			endpoint_id TEXT NOT NULL,
+			path TEXT NOT NULL DEFAULT '',
+			mode TEXT NOT NULL,
+			steps TEXT NOT NULL,
+			position INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (endpoint_id, path),
+			FOREIGN KEY(endpoint_id) REFERENCES endpoints(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS assertions (
+			endpoint_id TEXT NOT NULL,
+			path TEXT NOT NULL DEFAULT '',
+			methods TEXT NOT NULL DEFAULT '[]',
+			headers TEXT NOT NULL DEFAULT '{}',
+			schema TEXT NOT NULL DEFAULT '',
+			reject_status INTEGER NOT NULL DEFAULT 0,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (endpoint_id, path),
+			FOREIGN KEY(endpoint_id) REFERENCES endpoints(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS notification_channels (
+			id TEXT PRIMARY KEY,
+			endpoint_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL,
+			match TEXT NOT NULL DEFAULT '',
+			digest_seconds INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY(endpoint_id) REFERENCES endpoints(id) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS readiness_probe (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			checked_at DATETIME NOT NULL
+		);
+		CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+		BEGIN SELECT RAISE(ABORT, 'audit events are append-only'); END;
+		CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+		BEGIN SELECT RAISE(ABORT, 'audit events are append-only'); END;
 	`); err != nil {
 		return fmt.Errorf("initialize database schema: %w", err)
 	}
 
-	migrations := []struct {
-		table, column, definition string
-	}{
-		{"endpoints", "creator_id", "TEXT"},
-		{"endpoints", "default_status", "INTEGER NOT NULL DEFAULT 200"},
-		{"endpoints", "default_body", "TEXT NOT NULL DEFAULT 'ok'"},
-		{"endpoints", "default_content_type", "TEXT NOT NULL DEFAULT 'text/plain; charset=utf-8'"},
-		{"endpoints", "response_delay_ms", "INTEGER NOT NULL DEFAULT 0"},
-		{"endpoints", "enable_cors", "INTEGER NOT NULL DEFAULT 0"},
-		{"endpoints", "forward_url", "TEXT NOT NULL DEFAULT ''"},
-		{"endpoints", "request_limit", "INTEGER NOT NULL DEFAULT 1000"},
-		{"requests", "query_string", "TEXT NOT NULL DEFAULT ''"},
-		{"requests", "host", "TEXT NOT NULL DEFAULT ''"},
-		{"requests", "scheme", "TEXT NOT NULL DEFAULT ''"},
-		{"requests", "content_length", "INTEGER NOT NULL DEFAULT 0"},
-		{"requests", "body_truncated", "INTEGER NOT NULL DEFAULT 0"},
-	}
-	for _, migration := range migrations {
+	for _, migration := range columnMigrations {
 		if err := s.ensureColumn(migration.table, migration.column, migration.definition); err != nil {
 			return err
 		}
 	}
+	if err := s.hashInboundSecrets(); err != nil {
+		return err
+	}
 
 	_, err := s.db.Exec(`
 		DROP INDEX IF EXISTS idx_requests_endpoint_id;
 		CREATE INDEX IF NOT EXISTS idx_endpoints_creator_id ON endpoints(creator_id);
+		CREATE INDEX IF NOT EXISTS idx_endpoints_owner_user_id ON endpoints(owner_user_id);
+		CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_users_oidc_subject ON users(oidc_subject) WHERE oidc_subject != '';
+		CREATE INDEX IF NOT EXISTS idx_endpoints_workspace_id ON endpoints(workspace_id);
+		CREATE INDEX IF NOT EXISTS idx_share_links_endpoint_id ON share_links(endpoint_id);
+		CREATE INDEX IF NOT EXISTS idx_workspace_members_user_id ON workspace_members(user_id);
 		CREATE INDEX IF NOT EXISTS idx_requests_endpoint_created ON requests(endpoint_id, created_at DESC);
+		CREATE INDEX IF NOT EXISTS idx_audit_events_target_id ON audit_events(target_id);
+		CREATE INDEX IF NOT EXISTS idx_audit_events_actor ON audit_events(actor);
+		CREATE INDEX IF NOT EXISTS idx_notification_channels_endpoint_id ON notification_channels(endpoint_id);
 	`)
 	return err
 }
 
-func (s *SQLiteStore) ensureColumn(table, column, definition string) error {
-	rows, err := s.db.Query("PRAGMA table_info(" + table + ")")
+// hashInboundSecrets replaces the sender authentication secrets that older
+// versions stored in plaintext with their digests. Rows written since are
+// marked, so no digest is hashed twice.
+func (s *SQLiteStore) hashInboundSecrets() error {
+	rows, err := s.db.Query(`
+		SELECT id, inbound_basic_password, inbound_bearer_token, inbound_header_value FROM endpoints
+		WHERE inbound_secrets_hashed = 0 AND (inbound_basic_password != '' OR inbound_bearer_token != '' OR inbound_header_value != '')
+	`)
 	if err != nil {
-		return err
+		return fmt.Errorf("find plaintext sender secrets: %w", err)
 	}
-	found := false
+	type plaintext struct{ id, basicPassword, bearerToken, headerValue string }
+	var endpoints []plaintext
 	for rows.Next() {
-		var cid, notNull, primaryKey int
-		var name, columnType string
-		var defaultValue any
-		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+		var endpoint plaintext
+		if err := rows.Scan(&endpoint.id, &endpoint.basicPassword, &endpoint.bearerToken, &endpoint.headerValue); err != nil {
 			rows.Close()
 			return err
 		}
-		if name == column {
-			found = true
+		endpoints = append(endpoints, endpoint)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	digest := func(secret string) string {
+		if secret == "" {
+			return ""
 		}
+		return InboundSecretDigest(secret)
 	}
-	if err := rows.Close(); err != nil {
+	for _, endpoint := range endpoints {
+		if _, err := s.db.Exec(`
+			UPDATE endpoints SET inbound_basic_password = ?, inbound_bearer_token = ?, inbound_header_value = ?,
+				inbound_secrets_hashed = 1
+			WHERE id = ?
+		`, digest(endpoint.basicPassword), digest(endpoint.bearerToken), digest(endpoint.headerValue), endpoint.id); err != nil {
+			return fmt.Errorf("hash sender secrets of endpoint %s: %w", endpoint.id, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ensureColumn(table, column, definition string) error {
+	columns, err := s.tableColumns(context.Background(), table)
+	if err != nil {
 		return err
 	}
-	if found {
+	if columns[column] {
 		return nil
 	}
 	if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
@@ -161,30 +370,103 @@ func (s *SQLiteStore) ensureColumn(table, column, definition string) error {
 	return nil
 }
 
+func (s *SQLiteStore) tableColumns(ctx context.Context, table string) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx, "PRAGMA table_info("+table+")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, primaryKey int
+		var name, columnType string
+		var defaultValue any
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &primaryKey); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
+
 func scanEndpoint(row scanner) (*Endpoint, error) {
 	var endpoint Endpoint
+	var allowedCIDRs, clientCerts, errorStatuses string
+	auth, chaos := &endpoint.InboundAuth, &endpoint.Chaos
 	if err := row.Scan(
-		&endpoint.ID, &endpoint.Alias, &endpoint.CreatorID, &endpoint.CreatedAt, &endpoint.ExpiresAt,
+		&endpoint.ID, &endpoint.Alias, &endpoint.CreatorID, &endpoint.OwnerUserID, &endpoint.WorkspaceID, &endpoint.CreatedAt, &endpoint.ExpiresAt,
 		&endpoint.DefaultStatus, &endpoint.DefaultBody, &endpoint.DefaultContentType,
 		&endpoint.ResponseDelayMS, &endpoint.EnableCORS, &endpoint.ForwardURL, &endpoint.RequestLimit,
+		&auth.BasicUsername, &auth.BasicPassword, &auth.BearerToken, &auth.HeaderName,
+		&auth.HeaderValue, &allowedCIDRs, &clientCerts, &auth.StoreRejected, &endpoint.RejectedCount,
+		&endpoint.Throttle.RequestsPerSecond, &endpoint.Throttle.Burst, &endpoint.Throttle.Body, &endpoint.ThrottledCount,
+		&chaos.ErrorPercent, &errorStatuses, &chaos.LatencyMinMS, &chaos.LatencyMaxMS, &chaos.ResetPercent,
+		&chaos.DripPercent, &chaos.DripIntervalMS, &chaos.FailFirst, &chaos.FailKeyHeader,
 	); err != nil {
 		return nil, err
 	}
+	auth.AllowedCIDRs = splitList(allowedCIDRs)
+	auth.ClientCertSHA256 = splitList(clientCerts)
+	for _, status := range splitList(errorStatuses) {
+		if code, err := strconv.Atoi(status); err == nil {
+			chaos.ErrorStatuses = append(chaos.ErrorStatuses, code)
+		}
+	}
 	return &endpoint, nil
 }
 
 func scanRequest(row scanner) (*Request, error) {
 	var request Request
+	var chaos, validation string
 	if err := row.Scan(
 		&request.ID, &request.EndpointID, &request.Method, &request.Path, &request.QueryString,
 		&request.Host, &request.Scheme, &request.RemoteAddr, &request.Headers, &request.Body,
-		&request.ContentLength, &request.BodyTruncated, &request.StatusCode, &request.CreatedAt,
+		&request.ContentLength, &request.BodyTruncated, &request.StatusCode, &request.RejectedReason, &chaos, &request.SequenceStep,
+		&request.ResponseHeaders, &request.ResponseBody, &request.ResponseTimeMS, &request.ClientAborted, &request.TraceID, &request.CorrelationID,
+		&validation, &request.CreatedAt,
 	); err != nil {
 		return nil, err
 	}
+	if chaos != "" {
+		request.Chaos = new(ChaosOutcome)
+		if err := json.Unmarshal([]byte(chaos), request.Chaos); err != nil {
+			return nil, fmt.Errorf("decode chaos outcome of request %d: %w", request.ID, err)
+		}
+	}
+	if err := decodeValidation(&request, validation); err != nil {
+		return nil, err
+	}
 	return &request, nil
 }
 
+func encodeValidation(request *Request) (string, error) {
+	if request.Validation == nil {
+		return "", nil
+	}
+	encoded, err := json.Marshal(request.Validation)
+	return string(encoded), err
+}
+
+func decodeValidation(request *Request, validation string) error {
+	if validation == "" {
+		return nil
+	}
+	request.Validation = new(Validation)
+	if err := json.Unmarshal([]byte(validation), request.Validation); err != nil {
+		return fmt.Errorf("decode validation of request %d: %w", request.ID, err)
+	}
+	return nil
+}
+
+// joinInts stores a list of integers the way splitList reads them back.
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = strconv.Itoa(value)
+	}
+	return strings.Join(parts, ",")
+}
+
 func (s *SQLiteStore) CreateEndpoint(ctx context.Context, id, alias, creatorID string, ttl time.Duration) (*Endpoint, error) {
 	now := time.Now()
 	settings := DefaultEndpointSettings()
@@ -207,6 +489,21 @@ func (s *SQLiteStore) CreateEndpoint(ctx context.Context, id, alias, creatorID s
 	return endpoint, nil
 }
 
+// ImportEndpoint creates an endpoint with the settings and timestamps of
+// one exported from another instance, owned by endpoint.OwnerUserID.
+// Workspaces are not carried over.
+func (s *SQLiteStore) ImportEndpoint(ctx context.Context, endpoint *Endpoint) error {
+	if _, err := s.CreateEndpoint(ctx, endpoint.ID, endpoint.Alias, endpoint.CreatorID, 0); err != nil {
+		return err
+	}
+	if err := s.UpdateEndpointSettings(ctx, endpoint.ID, endpoint.Settings()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "UPDATE endpoints SET created_at = ?, expires_at = ?, owner_user_id = ? WHERE id = ?",
+		endpoint.CreatedAt, endpoint.ExpiresAt, endpoint.OwnerUserID, endpoint.ID)
+	return err
+}
+
 func (s *SQLiteStore) GetEndpoint(ctx context.Context, id string) (*Endpoint, error) {
 	return scanEndpoint(s.db.QueryRowContext(ctx, "SELECT "+endpointColumns+" FROM endpoints WHERE id = ?", id))
 }
@@ -216,24 +513,71 @@ func (s *SQLiteStore) UpdateEndpoint(ctx context.Context, id, alias string, ttl
 	if err != nil {
 		return err
 	}
-	return s.UpdateEndpointSettings(ctx, id, EndpointSettings{
-		Alias: alias, TTL: ttl, DefaultStatus: endpoint.DefaultStatus, DefaultBody: endpoint.DefaultBody,
-		DefaultContentType: endpoint.DefaultContentType, ResponseDelayMS: endpoint.ResponseDelayMS,
-		EnableCORS: endpoint.EnableCORS, ForwardURL: endpoint.ForwardURL, RequestLimit: endpoint.RequestLimit,
-	})
+	settings := endpoint.Settings()
+	settings.Alias, settings.TTL = alias, ttl
+	return s.UpdateEndpointSettings(ctx, id, settings)
 }
 
 func (s *SQLiteStore) UpdateEndpointSettings(ctx context.Context, id string, settings EndpointSettings) error {
+	auth, chaos := settings.InboundAuth, settings.Chaos
 	_, err := s.db.ExecContext(ctx, `
 		UPDATE endpoints SET alias = ?, expires_at = ?, default_status = ?, default_body = ?,
-			default_content_type = ?, response_delay_ms = ?, enable_cors = ?, forward_url = ?, request_limit = ?
+			default_content_type = ?, response_delay_ms = ?, enable_cors = ?, forward_url = ?, request_limit = ?,
+			inbound_basic_username = ?, inbound_basic_password = ?, inbound_bearer_token = ?, inbound_header_name = ?,
+			inbound_header_value = ?, inbound_allowed_cidrs = ?, inbound_client_cert_sha256 = ?, inbound_store_rejected = ?,
+			inbound_secrets_hashed = 1, throttle_requests_per_second = ?, throttle_burst = ?, throttle_body = ?,
+			chaos_error_percent = ?, chaos_error_statuses = ?, chaos_latency_min_ms = ?, chaos_latency_max_ms = ?,
+			chaos_reset_percent = ?, chaos_drip_percent = ?, chaos_drip_interval_ms = ?, chaos_fail_first = ?,
+			chaos_fail_key_header = ?
 		WHERE id = ?
 	`, settings.Alias, time.Now().Add(settings.TTL), settings.DefaultStatus, settings.DefaultBody,
 		settings.DefaultContentType, settings.ResponseDelayMS, settings.EnableCORS, settings.ForwardURL,
-		settings.RequestLimit, id)
+		settings.RequestLimit, auth.BasicUsername, auth.BasicPassword, auth.BearerToken, auth.HeaderName,
+		auth.HeaderValue, strings.Join(auth.AllowedCIDRs, ","), strings.Join(auth.ClientCertSHA256, ","),
+		auth.StoreRejected, settings.Throttle.RequestsPerSecond, settings.Throttle.Burst, settings.Throttle.Body,
+		chaos.ErrorPercent, joinInts(chaos.ErrorStatuses), chaos.LatencyMinMS, chaos.LatencyMaxMS,
+		chaos.ResetPercent, chaos.DripPercent, chaos.DripIntervalMS, chaos.FailFirst, chaos.FailKeyHeader, id)
+	return err
+}
+
+// IncrementRejectedCount counts a capture attempt refused by inbound auth.
+func (s *SQLiteStore) IncrementRejectedCount(ctx context.Context, endpointID string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE endpoints SET rejected_count = rejected_count + 1 WHERE id = ?", endpointID)
 	return err
 }
 
+// AddThrottledCounts adds capture attempts dropped by a rate limit, by
+// endpoint ID, in one transaction. IDs of no endpoint are ignored.
+func (s *SQLiteStore) AddThrottledCounts(ctx context.Context, counts map[string]int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for endpointID, count := range counts {
+		if _, err := tx.ExecContext(ctx, "UPDATE endpoints SET throttled_count = throttled_count + ? WHERE id = ?", count, endpointID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// chaosAttemptRetention is how long an idempotency key's attempt count is
+// kept after its last attempt.
+const chaosAttemptRetention = 24 * time.Hour
+
+// RecordChaosAttempt counts one more attempt carrying key and returns the
+// attempt number, starting at 1.
+func (s *SQLiteStore) RecordChaosAttempt(ctx context.Context, endpointID, key string) (int, error) {
+	var attempts int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO chaos_attempts (endpoint_id, attempt_key, attempts, updated_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT (endpoint_id, attempt_key) DO UPDATE SET attempts = attempts + 1, updated_at = excluded.updated_at
+		RETURNING attempts
+	`, endpointID, key, time.Now()).Scan(&attempts)
+	return attempts, err
+}
+
 func (s *SQLiteStore) DeleteEndpoint(ctx context.Context, id string) error {
 	_, err := s.db.ExecContext(ctx, "DELETE FROM endpoints WHERE id = ?", id)
 	return err
@@ -241,7 +585,7 @@ func (s *SQLiteStore) DeleteEndpoint(ctx context.Context, id string) error {
 
 func (s *SQLiteStore) ListEndpoints(ctx context.Context, creatorID string, limit int) ([]*Endpoint, error) {
 	rows, err := s.db.QueryContext(ctx, `SELECT `+endpointColumns+`
-		FROM endpoints WHERE creator_id = ? AND expires_at > ? ORDER BY created_at DESC LIMIT ?`,
+		FROM endpoints WHERE creator_id = ? AND owner_user_id = '' AND expires_at > ? ORDER BY created_at DESC LIMIT ?`,
 		creatorID, time.Now(), limit)
 	if err != nil {
 		return nil, err
@@ -272,16 +616,36 @@ func collectEndpoints(rows *sql.Rows) ([]*Endpoint, error) {
 	return endpoints, rows.Err()
 }
 
+// SaveRequest stores a new request. A zero CreatedAt is set to now; imports
+// pass the original capture time.
 func (s *SQLiteStore) SaveRequest(ctx context.Context, request *Request) error {
-	now := time.Now()
+	now := request.CreatedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+	var chaos string
+	if request.Chaos != nil {
+		encoded, err := json.Marshal(request.Chaos)
+		if err != nil {
+			return err
+		}
+		chaos = string(encoded)
+	}
+	validation, err := encodeValidation(request)
+	if err != nil {
+		return err
+	}
 	result, err := s.db.ExecContext(ctx, `
 		INSERT INTO requests (
 			endpoint_id, method, path, query_string, host, scheme, remote_addr, headers, body,
-			content_length, body_truncated, status_code, created_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			content_length, body_truncated, status_code, rejected_reason, chaos, sequence_step,
+			response_headers, response_body, response_time_ms, client_aborted, trace_id, correlation_id, validation, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`, request.EndpointID, request.Method, request.Path, request.QueryString, request.Host, request.Scheme,
 		request.RemoteAddr, request.Headers, request.Body, request.ContentLength, request.BodyTruncated,
-		request.StatusCode, now)
+		request.StatusCode, request.RejectedReason, chaos, request.SequenceStep,
+		request.ResponseHeaders, request.ResponseBody, request.ResponseTimeMS, request.ClientAborted, request.TraceID,
+		request.CorrelationID, validation, now)
 	if err != nil {
 		return err
 	}
@@ -290,6 +654,25 @@ func (s *SQLiteStore) SaveRequest(ctx context.Context, request *Request) error {
 	return nil
 }
 
+// RecordResponse stores the response served for a saved request.
+func (s *SQLiteStore) RecordResponse(ctx context.Context, request *Request) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE requests SET status_code = ?, response_headers = ?, response_body = ?, response_time_ms = ?, client_aborted = ?
+		WHERE id = ?
+	`, request.StatusCode, request.ResponseHeaders, request.ResponseBody, request.ResponseTimeMS, request.ClientAborted, request.ID)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 func (s *SQLiteStore) GetRequests(ctx context.Context, endpointID string, limit int) ([]*Request, error) {
 	return s.GetRequestsWithOffset(ctx, endpointID, limit, 0)
 }
@@ -304,6 +687,18 @@ func (s *SQLiteStore) GetRequestsWithOffset(ctx context.Context, endpointID stri
 	return collectRequests(rows)
 }
 
+// ListRequestsAfter returns requests with an ID above afterID in the order
+// they were stored, for exports that page through every request.
+func (s *SQLiteStore) ListRequestsAfter(ctx context.Context, endpointID string, afterID int64, limit int) ([]*Request, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+requestColumns+`
+		FROM requests WHERE endpoint_id = ? AND id > ? ORDER BY id LIMIT ?`,
+		endpointID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectRequests(rows)
+}
+
 func (s *SQLiteStore) GetRequestSummaries(ctx context.Context, endpointID string, limit int) ([]*Request, error) {
 	return s.GetRequestSummariesWithOffset(ctx, endpointID, limit, 0)
 }
@@ -318,7 +713,7 @@ func (s *SQLiteStore) SearchRequestSummaries(ctx context.Context, endpointID, qu
 	rows, err := s.db.QueryContext(ctx, `
 		SELECT id, endpoint_id, method, path, COALESCE(query_string, ''), COALESCE(host, ''),
 			COALESCE(scheme, ''), remote_addr, COALESCE(content_length, 0),
-			COALESCE(body_truncated, 0), status_code, created_at
+			COALESCE(body_truncated, 0), status_code, rejected_reason, validation, created_at
 		FROM requests WHERE `+where+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`, args...)
 	if err != nil {
 		return nil, err
@@ -327,9 +722,14 @@ func (s *SQLiteStore) SearchRequestSummaries(ctx context.Context, endpointID, qu
 	requests := make([]*Request, 0)
 	for rows.Next() {
 		var request Request
+		var validation string
 		if err := rows.Scan(&request.ID, &request.EndpointID, &request.Method, &request.Path,
 			&request.QueryString, &request.Host, &request.Scheme, &request.RemoteAddr,
-			&request.ContentLength, &request.BodyTruncated, &request.StatusCode, &request.CreatedAt); err != nil {
+			&request.ContentLength, &request.BodyTruncated, &request.StatusCode, &request.RejectedReason, &validation,
+			&request.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := decodeValidation(&request, validation); err != nil {
 			return nil, err
 		}
 		requests = append(requests, &request)
@@ -348,16 +748,52 @@ func (s *SQLiteStore) SearchRequests(ctx context.Context, endpointID, query stri
 	return collectRequests(rows)
 }
 
+// Search queries that select captures by their assertion result instead of
+// matching text.
+const (
+	SearchValidationPassed = "validation:passed"
+	SearchValidationFailed = "validation:failed"
+)
+
+// requestSearchWhere and MatchesSearch must agree: notification channels
+// use the same rules as the dashboard search.
 func requestSearchWhere(endpointID, query string) (string, []any) {
 	query = strings.ToLower(strings.TrimSpace(query))
-	if query == "" {
+	switch query {
+	case "":
 		return "endpoint_id = ?", []any{endpointID}
+	case SearchValidationPassed, SearchValidationFailed:
+		// validation is empty for captures no assertion applied to, which
+		// json_extract would refuse.
+		return `endpoint_id = ? AND (CASE WHEN validation = '' THEN NULL ELSE json_extract(validation, '$.passed') END) = ?`,
+			[]any{endpointID, query == SearchValidationPassed}
 	}
 	pattern := "%" + query + "%"
 	return `endpoint_id = ? AND (
 		LOWER(method) LIKE ? OR LOWER(path) LIKE ? OR LOWER(COALESCE(query_string, '')) LIKE ? OR
-		LOWER(remote_addr) LIKE ? OR LOWER(headers) LIKE ? OR LOWER(CAST(body AS TEXT)) LIKE ?
-	)`, []any{endpointID, pattern, pattern, pattern, pattern, pattern, pattern}
+		LOWER(remote_addr) LIKE ? OR LOWER(headers) LIKE ? OR LOWER(CAST(body AS TEXT)) LIKE ? OR LOWER(validation) LIKE ?
+	)`, []any{endpointID, pattern, pattern, pattern, pattern, pattern, pattern, pattern}
+}
+
+// MatchesSearch reports whether request would be found by the search query:
+// a case-insensitive substring of its method, path, query string, sender
+// address, headers, body or assertion violations, or one of the
+// SearchValidation queries. An empty query matches every request.
+func (request *Request) MatchesSearch(query string) bool {
+	query = strings.ToLower(strings.TrimSpace(query))
+	switch query {
+	case "":
+		return true
+	case SearchValidationPassed, SearchValidationFailed:
+		return request.Validation != nil && request.Validation.Passed == (query == SearchValidationPassed)
+	}
+	validation, _ := encodeValidation(request)
+	for _, field := range []string{request.Method, request.Path, request.QueryString, request.RemoteAddr, request.Headers, string(request.Body), validation} {
+		if strings.Contains(strings.ToLower(field), query) {
+			return true
+		}
+	}
+	return false
 }
 
 func collectRequests(rows *sql.Rows) ([]*Request, error) {
@@ -406,7 +842,17 @@ func (s *SQLiteStore) TrimRequests(ctx context.Context, endpointID string, keep
 }
 
 func (s *SQLiteStore) Cleanup(ctx context.Context) error {
-	_, err := s.db.ExecContext(ctx, "DELETE FROM endpoints WHERE expires_at < ?", time.Now())
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM endpoints WHERE expires_at < ?", now); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < ?", now); err != nil {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM chaos_attempts WHERE updated_at < ?", now.Add(-chaosAttemptRetention)); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, "DELETE FROM share_links WHERE expires_at < ?", now)
 	return err
 }
 
@@ -440,7 +886,13 @@ func (s *SQLiteStore) GetAdminStats(ctx context.Context) (*AdminStats, error) {
 		}
 		stats.EndpointUsageStats = append(stats.EndpointUsageStats, stat)
 	}
-	return stats, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if stats.WorkspaceUsageStats, err = s.workspaceUsageStats(ctx); err != nil {
+		return nil, err
+	}
+	return stats, nil
 }
 
 func parseSQLiteTime(value any) *time.Time {
@@ -479,6 +931,142 @@ func parseSQLiteTime(value any) *time.Time {
 	return nil
 }
 
+// DatabaseSize returns the size of the database in bytes, not counting the
+// write-ahead log.
+func (s *SQLiteStore) DatabaseSize(ctx context.Context) (int64, error) {
+	pages, err := s.pragmaInt(ctx, "page_count")
+	if err != nil {
+		return 0, err
+	}
+	pageSize, err := s.pragmaInt(ctx, "page_size")
+	return pages * pageSize, err
+}
+
+func (s *SQLiteStore) pragmaInt(ctx context.Context, name string) (int64, error) {
+	var value int64
+	err := s.db.QueryRowContext(ctx, "PRAGMA "+name).Scan(&value)
+	return value, err
+}
+
+// databaseFile returns the path of the main database file, or "" for an
+// in-memory database.
+func (s *SQLiteStore) databaseFile(ctx context.Context) (string, error) {
+	rows, err := s.db.QueryContext(ctx, "PRAGMA database_list")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var seq int
+		var name, file string
+		if err := rows.Scan(&seq, &name, &file); err != nil {
+			return "", err
+		}
+		if name == "main" {
+			return file, nil
+		}
+	}
+	return "", rows.Err()
+}
+
+// Ready checks that the database answers, accepts writes, has every column
+// migration applied and, when minFreeDiskBytes is positive, that the disk
+// holding it has that much space left. All failures are reported together.
+func (s *SQLiteStore) Ready(ctx context.Context, minFreeDiskBytes int64) error {
+	queryOnly, err := s.pragmaInt(ctx, "query_only")
+	if err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+	var errs []error
+	if queryOnly == 1 {
+		errs = append(errs, errors.New("database is in query_only mode"))
+	} else if _, err := s.db.ExecContext(ctx, "INSERT OR REPLACE INTO readiness_probe (id, checked_at) VALUES (1, ?)", time.Now()); err != nil {
+		errs = append(errs, fmt.Errorf("database is not writable: %w", err))
+	}
+	tables := make(map[string]map[string]bool)
+	for _, migration := range columnMigrations {
+		if tables[migration.table] == nil {
+			if tables[migration.table], err = s.tableColumns(ctx, migration.table); err != nil {
+				return errors.Join(append(errs, fmt.Errorf("read %s columns: %w", migration.table, err))...)
+			}
+		}
+		if !tables[migration.table][migration.column] {
+			errs = append(errs, fmt.Errorf("migration not applied: %s.%s is missing", migration.table, migration.column))
+		}
+	}
+	if minFreeDiskBytes > 0 {
+		file, err := s.databaseFile(ctx)
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if file != "" {
+			if free, err := diskFree(filepath.Dir(file)); err == nil && free < minFreeDiskBytes {
+				errs = append(errs, fmt.Errorf("only %d bytes of disk space left, below the %d byte minimum", free, minFreeDiskBytes))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Diagnostics reads sizes, settings and per-table row counts. Counting rows
+// scans each table, so it is meant for occasional use by administrators.
+func (s *SQLiteStore) Diagnostics(ctx context.Context) (*Diagnostics, error) {
+	diagnostics := &Diagnostics{FreeDiskBytes: -1}
+	var err error
+	if diagnostics.Path, err = s.databaseFile(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&diagnostics.JournalMode); err != nil {
+		return nil, err
+	}
+	for name, target := range map[string]*int64{
+		"page_size": &diagnostics.PageSize, "page_count": &diagnostics.PageCount, "freelist_count": &diagnostics.FreelistCount,
+	} {
+		if *target, err = s.pragmaInt(ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	queryOnly, err := s.pragmaInt(ctx, "query_only")
+	if err != nil {
+		return nil, err
+	}
+	diagnostics.QueryOnly = queryOnly == 1
+	diagnostics.SizeBytes = diagnostics.PageSize * diagnostics.PageCount
+	if diagnostics.Path != "" {
+		if info, err := os.Stat(diagnostics.Path + "-wal"); err == nil {
+			diagnostics.WALBytes = info.Size()
+		}
+		if free, err := diskFree(filepath.Dir(diagnostics.Path)); err == nil {
+			diagnostics.FreeDiskBytes = free
+		}
+	}
+
+	rows, err := s.db.QueryContext(ctx, "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		table := TableCount{Name: name}
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM "`+strings.ReplaceAll(name, `"`, `""`)+`"`).Scan(&table.Rows); err != nil {
+			return nil, err
+		}
+		diagnostics.Tables = append(diagnostics.Tables, table)
+	}
+	return diagnostics, nil
+}
+
 func (s *SQLiteStore) Close() error {
 	return s.db.Close()
 }
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const apiKeyColumns = `id, name, prefix, scopes, endpoint_ids, created_at, expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row scanner) (*APIKey, error) {
+	var key APIKey
+	var scopes, endpointIDs string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &endpointIDs, &key.CreatedAt,
+		&expiresAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	key.Scopes = splitList(scopes)
+	key.EndpointIDs = splitList(endpointIDs)
+	key.ExpiresAt = nullTimePointer(expiresAt)
+	key.LastUsedAt = nullTimePointer(lastUsedAt)
+	key.RevokedAt = nullTimePointer(revokedAt)
+	return &key, nil
+}
+
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func nullTimePointer(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+func timePointerValue(value *time.Time) any {
+	if value == nil {
+		return nil
+	}
+	return *value
+}
+
+func (s *SQLiteStore) CreateAPIKey(ctx context.Context, key *APIKey, keyHash string) error {
+	if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO api_keys (id, name, prefix, key_hash, scopes, endpoint_ids, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, key.ID, key.Name, key.Prefix, keyHash, strings.Join(key.Scopes, ","), strings.Join(key.EndpointIDs, ","),
+		key.CreatedAt, timePointerValue(key.ExpiresAt))
+	return err
+}
+
+func (s *SQLiteStore) ListAPIKeys(ctx context.Context) ([]*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := make([]*APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLiteStore) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	return scanAPIKey(s.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", keyHash))
+}
+
+func (s *SQLiteStore) RevokeAPIKey(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (s *SQLiteStore) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", usedAt, id)
+	return err
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const assertionColumns = `endpoint_id, path, methods, headers, schema, reject_status, updated_at`
+
+func scanAssertion(row scanner) (*Assertion, error) {
+	var assertion Assertion
+	var methods, headers, schema string
+	if err := row.Scan(&assertion.EndpointID, &assertion.Path, &methods, &headers, &schema, &assertion.RejectStatus, &assertion.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(methods), &assertion.Methods); err != nil {
+		return nil, fmt.Errorf("decode methods of assertion %s%s: %w", assertion.EndpointID, assertion.Path, err)
+	}
+	if err := json.Unmarshal([]byte(headers), &assertion.Headers); err != nil {
+		return nil, fmt.Errorf("decode headers of assertion %s%s: %w", assertion.EndpointID, assertion.Path, err)
+	}
+	if schema != "" {
+		assertion.Schema = json.RawMessage(schema)
+	}
+	return &assertion, nil
+}
+
+// SetAssertion creates or replaces the assertion for the endpoint and path.
+func (s *SQLiteStore) SetAssertion(ctx context.Context, assertion *Assertion) error {
+	methods, err := json.Marshal(assertion.Methods)
+	if err != nil {
+		return err
+	}
+	headers, err := json.Marshal(assertion.Headers)
+	if err != nil {
+		return err
+	}
+	assertion.UpdatedAt = time.Now()
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO assertions (`+assertionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (endpoint_id, path) DO UPDATE SET methods = excluded.methods, headers = excluded.headers,
+			schema = excluded.schema, reject_status = excluded.reject_status, updated_at = excluded.updated_at
+	`, assertion.EndpointID, assertion.Path, string(methods), string(headers), string(assertion.Schema), assertion.RejectStatus,
+		assertion.UpdatedAt)
+	return err
+}
+
+// ListAssertions returns an endpoint's assertions ordered by path, with the
+// endpoint-wide assertion first.
+func (s *SQLiteStore) ListAssertions(ctx context.Context, endpointID string) ([]*Assertion, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+assertionColumns+" FROM assertions WHERE endpoint_id = ? ORDER BY path", endpointID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	assertions := make([]*Assertion, 0)
+	for rows.Next() {
+		assertion, err := scanAssertion(rows)
+		if err != nil {
+			return nil, err
+		}
+		assertions = append(assertions, assertion)
+	}
+	return assertions, rows.Err()
+}
+
+// DeleteAssertion returns sql.ErrNoRows when there is nothing to delete.
+func (s *SQLiteStore) DeleteAssertion(ctx context.Context, endpointID, path string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM assertions WHERE endpoint_id = ? AND path = ?", endpointID, path)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// MatchAssertion returns the assertion that applies to path: the one for the
+// exact path, else the endpoint-wide one, else nil.
+func (s *SQLiteStore) MatchAssertion(ctx context.Context, endpointID, path string) (*Assertion, error) {
+	assertion, err := scanAssertion(s.db.QueryRowContext(ctx, `
+		SELECT `+assertionColumns+` FROM assertions WHERE endpoint_id = ? AND path IN (?, '') ORDER BY path DESC LIMIT 1
+	`, endpointID, path))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return assertion, err
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+const auditEventColumns = `id, created_at, actor_type, actor_id, actor, action, target_type, target_id, remote_addr, details`
+
+func scanAuditEvent(row scanner) (*AuditEvent, error) {
+	var event AuditEvent
+	var details string
+	if err := row.Scan(&event.ID, &event.CreatedAt, &event.ActorType, &event.ActorID, &event.Actor, &event.Action,
+		&event.TargetType, &event.TargetID, &event.RemoteAddr, &details); err != nil {
+		return nil, err
+	}
+	if details != "" {
+		event.Details = []byte(details)
+	}
+	return &event, nil
+}
+
+func (s *SQLiteStore) RecordAuditEvent(ctx context.Context, event *AuditEvent) error {
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO audit_events (created_at, actor_type, actor_id, actor, action, target_type, target_id, remote_addr, details)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, event.CreatedAt, event.ActorType, event.ActorID, event.Actor, event.Action, event.TargetType, event.TargetID,
+		event.RemoteAddr, string(event.Details))
+	if err != nil {
+		return err
+	}
+	event.ID, err = result.LastInsertId()
+	return err
+}
+
+// ListAuditEvents returns matching events, newest first. A non-positive
+// limit defaults to 100.
+func (s *SQLiteStore) ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	conditions := make([]string, 0, 7)
+	args := make([]any, 0, 8)
+	if filter.ActorType != "" {
+		conditions = append(conditions, "actor_type = ?")
+		args = append(args, filter.ActorType)
+	}
+	if filter.Actor != "" {
+		conditions = append(conditions, "(actor = ? OR actor_id = ?)")
+		args = append(args, filter.Actor, filter.Actor)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.TargetID != "" {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, filter.TargetID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, filter.Until)
+	}
+	if filter.BeforeID > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+	query := "SELECT " + auditEventColumns + " FROM audit_events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+	rows, err := s.db.QueryContext(ctx, query+" ORDER BY id DESC LIMIT ?", append(args, filter.Limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := make([]*AuditEvent, 0)
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+const notificationColumns = `id, endpoint_id, kind, target, match, digest_seconds, created_at`
+
+func (s *SQLiteStore) CreateNotificationChannel(ctx context.Context, channel *NotificationChannel) error {
+	channel.CreatedAt = time.Now()
+	_, err := s.db.ExecContext(ctx, "INSERT INTO notification_channels ("+notificationColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		channel.ID, channel.EndpointID, channel.Kind, channel.Target, channel.Match, channel.DigestSeconds, channel.CreatedAt)
+	return err
+}
+
+// ListNotificationChannels returns an endpoint's channels, oldest first.
+func (s *SQLiteStore) ListNotificationChannels(ctx context.Context, endpointID string) ([]*NotificationChannel, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+notificationColumns+" FROM notification_channels WHERE endpoint_id = ? ORDER BY created_at, id", endpointID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	channels := make([]*NotificationChannel, 0)
+	for rows.Next() {
+		var channel NotificationChannel
+		if err := rows.Scan(&channel.ID, &channel.EndpointID, &channel.Kind, &channel.Target, &channel.Match,
+			&channel.DigestSeconds, &channel.CreatedAt); err != nil {
+			return nil, err
+		}
+		channels = append(channels, &channel)
+	}
+	return channels, rows.Err()
+}
+
+// DeleteNotificationChannel returns sql.ErrNoRows when the endpoint has no
+// channel with that ID.
+func (s *SQLiteStore) DeleteNotificationChannel(ctx context.Context, endpointID, id string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM notification_channels WHERE endpoint_id = ? AND id = ?", endpointID, id)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
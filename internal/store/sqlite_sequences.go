@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const sequenceColumns = `endpoint_id, path, mode, steps, position, updated_at`
+
+func scanSequence(row scanner) (*ResponseSequence, error) {
+	var sequence ResponseSequence
+	var steps string
+	if err := row.Scan(&sequence.EndpointID, &sequence.Path, &sequence.Mode, &steps, &sequence.Position, &sequence.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(steps), &sequence.Steps); err != nil {
+		return nil, fmt.Errorf("decode steps of sequence %s%s: %w", sequence.EndpointID, sequence.Path, err)
+	}
+	return &sequence, nil
+}
+
+// SetResponseSequence creates or replaces the sequence for the endpoint and
+// path, starting it from its first step.
+func (s *SQLiteStore) SetResponseSequence(ctx context.Context, sequence *ResponseSequence) error {
+	steps, err := json.Marshal(sequence.Steps)
+	if err != nil {
+		return err
+	}
+	sequence.Position, sequence.UpdatedAt = 0, time.Now()
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO response_sequences (endpoint_id, path, mode, steps, position, updated_at) VALUES (?, ?, ?, ?, 0, ?)
+		ON CONFLICT (endpoint_id, path) DO UPDATE SET mode = excluded.mode, steps = excluded.steps, position = 0,
+			updated_at = excluded.updated_at
+	`, sequence.EndpointID, sequence.Path, sequence.Mode, string(steps), sequence.UpdatedAt)
+	return err
+}
+
+// ListResponseSequences returns an endpoint's sequences ordered by path, with
+// the endpoint-wide sequence first.
+func (s *SQLiteStore) ListResponseSequences(ctx context.Context, endpointID string) ([]*ResponseSequence, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+sequenceColumns+" FROM response_sequences WHERE endpoint_id = ? ORDER BY path", endpointID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sequences := make([]*ResponseSequence, 0)
+	for rows.Next() {
+		sequence, err := scanSequence(rows)
+		if err != nil {
+			return nil, err
+		}
+		sequences = append(sequences, sequence)
+	}
+	return sequences, rows.Err()
+}
+
+// ResetResponseSequence starts a sequence over. It returns sql.ErrNoRows
+// when the endpoint has no sequence for path.
+func (s *SQLiteStore) ResetResponseSequence(ctx context.Context, endpointID, path string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE response_sequences SET position = 0, updated_at = ? WHERE endpoint_id = ? AND path = ?
+	`, time.Now(), endpointID, path)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteResponseSequence returns sql.ErrNoRows when there is nothing to delete.
+func (s *SQLiteStore) DeleteResponseSequence(ctx context.Context, endpointID, path string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM response_sequences WHERE endpoint_id = ? AND path = ?", endpointID, path)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// NextSequenceStep advances the sequence that applies to path and returns
+// the step to serve with its 1-based number. A sequence for the exact path
+// wins over the endpoint-wide one. It returns a nil step when neither exists.
+// The sequence is looked up with a read first, so that captures to endpoints
+// without one never write. The position is then advanced in a single
+// statement so concurrent captures each get their own step.
+func (s *SQLiteStore) NextSequenceStep(ctx context.Context, endpointID, path string) (*SequenceStep, int, error) {
+	var matched string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT path FROM response_sequences
+		WHERE endpoint_id = ? AND path IN (?, '') AND json_array_length(steps) > 0
+		ORDER BY path DESC LIMIT 1
+	`, endpointID, path).Scan(&matched)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var mode, encoded string
+	var position int
+	err = s.db.QueryRowContext(ctx, `
+		UPDATE response_sequences SET updated_at = ?, position = CASE
+			WHEN mode = ? THEN (position + 1) % json_array_length(steps)
+			ELSE MIN(position + 1, json_array_length(steps))
+		END
+		WHERE endpoint_id = ? AND path = ? AND json_array_length(steps) > 0
+		RETURNING mode, steps, position
+	`, time.Now(), SequenceLoop, endpointID, matched).Scan(&mode, &encoded, &position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	var steps []SequenceStep
+	if err := json.Unmarshal([]byte(encoded), &steps); err != nil {
+		return nil, 0, err
+	}
+	// position is the next step to serve; hold sequences stop at the end.
+	index := position - 1
+	if mode == SequenceLoop {
+		index = (position - 1 + len(steps)) % len(steps)
+	}
+	return &steps[index], index + 1, nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const shareLinkColumns = `id, endpoint_id, COALESCE(request_id, 0), redact_headers, created_by, created_at, expires_at, revoked_at`
+
+func scanShareLink(row scanner) (*ShareLink, error) {
+	var link ShareLink
+	var redactHeaders string
+	var revokedAt sql.NullTime
+	if err := row.Scan(&link.ID, &link.EndpointID, &link.RequestID, &redactHeaders, &link.CreatedBy,
+		&link.CreatedAt, &link.ExpiresAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	link.RedactHeaders = splitList(redactHeaders)
+	link.RevokedAt = nullTimePointer(revokedAt)
+	return &link, nil
+}
+
+func (s *SQLiteStore) CreateShareLink(ctx context.Context, link *ShareLink) error {
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	var requestID any
+	if link.RequestID != 0 {
+		requestID = link.RequestID
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO share_links (id, endpoint_id, request_id, redact_headers, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, link.ID, link.EndpointID, requestID, strings.Join(link.RedactHeaders, ","), link.CreatedBy, link.CreatedAt, link.ExpiresAt)
+	return err
+}
+
+func (s *SQLiteStore) GetShareLink(ctx context.Context, id string) (*ShareLink, error) {
+	return scanShareLink(s.db.QueryRowContext(ctx, "SELECT "+shareLinkColumns+" FROM share_links WHERE id = ?", id))
+}
+
+// ListShareLinks returns the unexpired links of an endpoint, newest first,
+// including revoked ones so the settings page can show what was shared.
+func (s *SQLiteStore) ListShareLinks(ctx context.Context, endpointID string) ([]*ShareLink, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+shareLinkColumns+`
+		FROM share_links WHERE endpoint_id = ? AND expires_at > ? ORDER BY created_at DESC`, endpointID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	links := make([]*ShareLink, 0)
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+// RevokeShareLink returns sql.ErrNoRows when the endpoint has no such active link.
+func (s *SQLiteStore) RevokeShareLink(ctx context.Context, endpointID, id string) error {
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE share_links SET revoked_at = ? WHERE id = ? AND endpoint_id = ? AND revoked_at IS NULL
+	`, time.Now(), id, endpointID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// SigningKey returns the random 32-byte key stored under name, creating it
+// on first use so signatures stay valid across restarts.
+func (s *SQLiteStore) SigningKey(ctx context.Context, name string) ([]byte, error) {
+	var key []byte
+	err := s.db.QueryRowContext(ctx, "SELECT key FROM signing_keys WHERE name = ?", name).Scan(&key)
+	if err != sql.ErrNoRows {
+		return key, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// Another caller may have created the key first; keep whichever won.
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO signing_keys (name, key, created_at) VALUES (?, ?, ?)
+	`, name, key, time.Now()); err != nil {
+		return nil, err
+	}
+	err = s.db.QueryRowContext(ctx, "SELECT key FROM signing_keys WHERE name = ?", name).Scan(&key)
+	return key, err
+}
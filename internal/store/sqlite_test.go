@@ -1,9 +1,13 @@
 package store
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"errors"
+	"math"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -74,6 +78,50 @@ func TestSQLiteStoreMigratesAndRetainsRequests(t *testing.T) {
 	}
 }
 
+func TestPlaintextSenderSecretsAreHashedOnce(t *testing.T) {
+	databasePath := filepath.Join(t.TempDir(), "secrets.db")
+	store, err := NewSQLiteStore(databasePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, id := range []string{"legacy", "current"} {
+		if _, err := store.CreateEndpoint(ctx, id, "", "", DefaultTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.db.Exec(`
+		UPDATE endpoints SET inbound_basic_username = 'user', inbound_basic_password = 'pass',
+			inbound_header_name = 'X-Token', inbound_header_value = 'value', inbound_secrets_hashed = 0
+		WHERE id = 'legacy'
+	`); err != nil {
+		t.Fatal(err)
+	}
+	settings := DefaultEndpointSettings()
+	settings.InboundAuth = InboundAuth{BearerToken: InboundSecretDigest("token")}
+	if err := store.UpdateEndpointSettings(ctx, "current", settings); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Close()
+
+	for range 2 {
+		store, err = NewSQLiteStore(databasePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		legacy, err := store.GetEndpoint(ctx, "legacy")
+		if err != nil || legacy.InboundAuth.BasicPassword != InboundSecretDigest("pass") ||
+			legacy.InboundAuth.HeaderValue != InboundSecretDigest("value") || legacy.InboundAuth.BearerToken != "" {
+			t.Fatalf("expected plaintext secrets to be replaced by digests: %+v %v", legacy, err)
+		}
+		current, err := store.GetEndpoint(ctx, "current")
+		if err != nil || current.InboundAuth.BearerToken != InboundSecretDigest("token") {
+			t.Fatalf("expected stored digests to be left alone: %+v %v", current, err)
+		}
+		_ = store.Close()
+	}
+}
+
 func TestUpdateEndpointSettings(t *testing.T) {
 	store, err := NewSQLiteStore(":memory:")
 	if err != nil {
@@ -88,10 +136,21 @@ func TestUpdateEndpointSettings(t *testing.T) {
 		Alias: "payments", TTL: TTL1Week, DefaultStatus: 202, DefaultBody: `{"accepted":true}`,
 		DefaultContentType: "application/json", ResponseDelayMS: 25, EnableCORS: true,
 		ForwardURL: "https://example.com/hooks", RequestLimit: 50,
+		InboundAuth: InboundAuth{
+			BearerToken: "token", AllowedCIDRs: []string{"10.0.0.0/8", "192.0.2.1/32"}, StoreRejected: true,
+		},
+		Throttle: Throttle{RequestsPerSecond: 5, Burst: 20, Body: "slow down"},
+		Chaos:    Chaos{ErrorPercent: 10, ErrorStatuses: []int{500, 503}, FailFirst: 2, FailKeyHeader: "Idempotency-Key"},
 	}
 	if err := store.UpdateEndpointSettings(ctx, "endpoint", settings); err != nil {
 		t.Fatal(err)
 	}
+	if err := store.IncrementRejectedCount(ctx, "endpoint"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AddThrottledCounts(ctx, map[string]int64{"endpoint": 1, "missing": 3}); err != nil {
+		t.Fatal(err)
+	}
 	endpoint, err := store.GetEndpoint(ctx, "endpoint")
 	if err != nil {
 		t.Fatal(err)
@@ -99,4 +158,544 @@ func TestUpdateEndpointSettings(t *testing.T) {
 	if endpoint.Alias != settings.Alias || endpoint.DefaultStatus != 202 || !endpoint.EnableCORS || endpoint.RequestLimit != 50 {
 		t.Fatalf("settings were not persisted: %+v", endpoint)
 	}
+	if auth := endpoint.InboundAuth; !auth.Enabled() || auth.BearerToken != "token" || len(auth.AllowedCIDRs) != 2 ||
+		!auth.StoreRejected || endpoint.RejectedCount != 1 {
+		t.Fatalf("inbound auth was not persisted: %+v", endpoint)
+	}
+	if endpoint.Throttle != settings.Throttle || endpoint.ThrottledCount != 1 {
+		t.Fatalf("throttle was not persisted: %+v", endpoint)
+	}
+	if chaos := endpoint.Chaos; !chaos.Enabled() || len(chaos.ErrorStatuses) != 2 || chaos.ErrorStatuses[1] != 503 || chaos.FailFirst != 2 {
+		t.Fatalf("chaos was not persisted: %+v", chaos)
+	}
+	for expected := 1; expected <= 2; expected++ {
+		if attempt, err := store.RecordChaosAttempt(ctx, "endpoint", "key"); err != nil || attempt != expected {
+			t.Fatalf("expected attempt %d, got %d err=%v", expected, attempt, err)
+		}
+	}
+
+	rejected := &Request{
+		EndpointID: "endpoint", Method: "POST", Path: "/h/endpoint", Headers: "{}", StatusCode: 401, RejectedReason: "invalid bearer token",
+		Chaos: &ChaosOutcome{LatencyMS: 40}, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", CorrelationID: "req-42",
+	}
+	if err := store.SaveRequest(ctx, rejected); err != nil {
+		t.Fatal(err)
+	}
+	if summaries, err := store.GetRequestSummaries(ctx, "endpoint", 10); err != nil || len(summaries) != 1 || summaries[0].RejectedReason != rejected.RejectedReason {
+		t.Fatalf("expected the rejected attempt to be flagged, got %+v %v", summaries, err)
+	}
+	if requests, err := store.GetRequests(ctx, "endpoint", 10); err != nil || len(requests) != 1 || requests[0].Chaos == nil || requests[0].Chaos.LatencyMS != 40 {
+		t.Fatalf("expected the chaos outcome to round-trip, got %+v %v", requests, err)
+	}
+	rejected.StatusCode, rejected.ResponseHeaders, rejected.ResponseBody = 503, `{"Content-Type":["text/plain"]}`, []byte("Service Unavailable")
+	rejected.ResponseTimeMS, rejected.ClientAborted = 42, true
+	if err := store.RecordResponse(ctx, rejected); err != nil {
+		t.Fatal(err)
+	}
+	if served, err := store.GetRequest(ctx, rejected.ID); err != nil || served.StatusCode != 503 || string(served.ResponseBody) != "Service Unavailable" ||
+		served.ResponseHeaders != rejected.ResponseHeaders || served.ResponseTimeMS != 42 || !served.ClientAborted || served.TraceID != rejected.TraceID ||
+		served.CorrelationID != "req-42" {
+		t.Fatalf("expected the served response to be recorded, got %+v %v", served, err)
+	}
+	if err := store.RecordResponse(ctx, &Request{ID: rejected.ID + 100}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected ErrNoRows for a missing request, got %v", err)
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+	key := &APIKey{ID: "key", Name: "ci", Prefix: "phk_abc", Scopes: []string{APIScopeRead, APIScopeWrite}, ExpiresAt: &expiresAt}
+	if err := store.CreateAPIKey(ctx, key, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	found, err := store.GetAPIKeyByHash(ctx, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Name != "ci" || len(found.Scopes) != 2 || len(found.EndpointIDs) != 0 || found.ExpiresAt == nil || found.LastUsedAt != nil {
+		t.Fatalf("unexpected key: %+v", found)
+	}
+	if !found.HasScope(APIScopeWrite) || found.HasScope(APIScopeDelete) || !found.Active(time.Now()) {
+		t.Fatalf("unexpected key permissions: %+v", found)
+	}
+	if err := store.TouchAPIKey(ctx, "key", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeAPIKey(ctx, "key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RevokeAPIKey(ctx, "key"); err == nil {
+		t.Fatal("expected second revoke to report a missing key")
+	}
+	found, _ = store.GetAPIKeyByHash(ctx, "hash")
+	if found.LastUsedAt == nil || found.RevokedAt == nil || found.Active(time.Now()) {
+		t.Fatalf("expected used and revoked key: %+v", found)
+	}
+}
+
+func TestUsersSessionsAndEndpointClaims(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, &User{ID: "user", Username: "Alice"}, "hash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateUser(ctx, &User{ID: "other", Username: "alice"}, "hash"); err != ErrUsernameTaken {
+		t.Fatalf("expected case-insensitive duplicate to be rejected, got %v", err)
+	}
+	user, passwordHash, err := store.GetUserByUsername(ctx, "ALICE")
+	if err != nil || user.ID != "user" || passwordHash != "hash" {
+		t.Fatalf("unexpected lookup result: %+v %q %v", user, passwordHash, err)
+	}
+
+	if err := store.CreateSession(ctx, &Session{TokenHash: "live", UserID: "user", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateSession(ctx, &Session{TokenHash: "stale", UserID: "user", ExpiresAt: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := store.GetSession(ctx, "live"); err != nil || found.User.Username != "Alice" || found.Admin {
+		t.Fatalf("expected live session, got %+v %v", found, err)
+	}
+	if _, err := store.GetSession(ctx, "stale"); err != sql.ErrNoRows {
+		t.Fatalf("expected expired session to be ignored, got %v", err)
+	}
+
+	if err := store.CreateOIDCUser(ctx, &User{ID: "sso", Username: "ALICE"}, "https://id.example.com|1"); err != ErrUsernameTaken {
+		t.Fatalf("expected username clash for single sign-on user, got %v", err)
+	}
+	if err := store.CreateOIDCUser(ctx, &User{ID: "sso", Username: "alice-2"}, "https://id.example.com|1"); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := store.GetUserByOIDCSubject(ctx, "https://id.example.com|1"); err != nil || found.ID != "sso" {
+		t.Fatalf("expected linked user, got %+v %v", found, err)
+	}
+	if _, err := store.GetUserByOIDCSubject(ctx, ""); err != sql.ErrNoRows {
+		t.Fatalf("password accounts must not match an empty subject, got %v", err)
+	}
+	if _, passwordHash, _ := store.GetUserByUsername(ctx, "alice-2"); passwordHash != "" {
+		t.Fatalf("single sign-on users must not have a password, got %q", passwordHash)
+	}
+	if err := store.CreateSession(ctx, &Session{TokenHash: "admin", UserID: "sso", Admin: true, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := store.GetSession(ctx, "admin"); err != nil || !found.Admin || found.User.Username != "alice-2" {
+		t.Fatalf("expected admin session, got %+v %v", found, err)
+	}
+
+	const browser = "0b7f6a4e-95a1-4f5c-9c32-3f0e4d2b8a11"
+	for _, id := range []string{"first", "second"} {
+		if _, err := store.CreateEndpoint(ctx, id, "", browser, DefaultTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.CreateEndpoint(ctx, "from-api", "", "api", DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := store.ClaimEndpoints(ctx, "api", "user"); err != nil || claimed != 0 {
+		t.Fatalf("creator IDs that are not browser IDs must claim nothing, got %d %v", claimed, err)
+	}
+	claimed, err := store.ClaimEndpoints(ctx, browser, "user")
+	if err != nil || claimed != 2 {
+		t.Fatalf("expected two claimed endpoints, got %d %v", claimed, err)
+	}
+	if remaining, _ := store.ListEndpoints(ctx, browser, 10); len(remaining) != 0 {
+		t.Fatalf("claimed endpoints must not be listed for the browser: %d", len(remaining))
+	}
+	if owned, _ := store.ListUserEndpoints(ctx, "user", 10); len(owned) != 2 || owned[0].OwnerUserID != "user" {
+		t.Fatalf("unexpected owned endpoints: %+v", owned)
+	}
+
+	if err := store.DeleteSession(ctx, "live"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSession(ctx, "live"); err != sql.ErrNoRows {
+		t.Fatalf("expected deleted session to be gone, got %v", err)
+	}
+}
+
+func TestWorkspaceMembershipAndUsage(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	for _, username := range []string{"owner", "viewer"} {
+		if err := store.CreateUser(ctx, &User{ID: username, Username: username}, "hash"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CreateWorkspace(ctx, &Workspace{ID: "team", Name: "Team"}, "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetWorkspaceMember(ctx, "team", "viewer", WorkspaceRoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetWorkspaceMember(ctx, "team", "owner", WorkspaceRoleEditor); err != ErrLastWorkspaceOwner {
+		t.Fatalf("expected last owner demotion to be rejected, got %v", err)
+	}
+	if err := store.RemoveWorkspaceMember(ctx, "team", "owner"); err != ErrLastWorkspaceOwner {
+		t.Fatalf("expected last owner removal to be rejected, got %v", err)
+	}
+	if role, err := store.GetWorkspaceRole(ctx, "team", "viewer"); err != nil || role != WorkspaceRoleViewer {
+		t.Fatalf("unexpected role %q %v", role, err)
+	}
+
+	if _, err := store.CreateEndpoint(ctx, "shared", "", "browser", DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetEndpointWorkspace(ctx, "shared", "team"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveRequest(ctx, &Request{EndpointID: "shared", Method: "POST", Headers: "{}"}); err != nil {
+		t.Fatal(err)
+	}
+	if visible, _ := store.ListUserEndpoints(ctx, "viewer", 10); len(visible) != 1 || visible[0].WorkspaceID != "team" {
+		t.Fatalf("expected the shared endpoint to be visible to members: %+v", visible)
+	}
+	stats, err := store.GetAdminStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.WorkspaceUsageStats) != 1 {
+		t.Fatalf("expected one workspace, got %+v", stats.WorkspaceUsageStats)
+	}
+	usage := stats.WorkspaceUsageStats[0]
+	if usage.MemberCount != 2 || usage.EndpointCount != 1 || usage.RequestCount != 1 {
+		t.Fatalf("unexpected workspace usage: %+v", usage)
+	}
+
+	if err := store.RemoveWorkspaceMember(ctx, "team", "viewer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetWorkspaceRole(ctx, "team", "viewer"); err != sql.ErrNoRows {
+		t.Fatalf("expected removed member to have no role, got %v", err)
+	}
+}
+
+func TestShareLinksAndSigningKey(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if _, err := store.CreateEndpoint(ctx, "endpoint", "", "browser", DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	link := &ShareLink{ID: "link", EndpointID: "endpoint", RedactHeaders: []string{"Authorization", "Cookie"}, CreatedBy: "browser", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.CreateShareLink(ctx, link); err != nil {
+		t.Fatal(err)
+	}
+	found, err := store.GetShareLink(ctx, "link")
+	if err != nil || found.RequestID != 0 || len(found.RedactHeaders) != 2 || !found.Active(time.Now()) {
+		t.Fatalf("unexpected share link: %+v %v", found, err)
+	}
+	if err := store.RevokeShareLink(ctx, "other-endpoint", "link"); err != sql.ErrNoRows {
+		t.Fatalf("links must only be revoked through their endpoint, got %v", err)
+	}
+	if err := store.RevokeShareLink(ctx, "endpoint", "link"); err != nil {
+		t.Fatal(err)
+	}
+	if links, err := store.ListShareLinks(ctx, "endpoint"); err != nil || len(links) != 1 || links[0].Active(time.Now()) {
+		t.Fatalf("expected one revoked link, got %+v %v", links, err)
+	}
+
+	first, err := store.SigningKey(ctx, "share-links")
+	if err != nil || len(first) != 32 {
+		t.Fatalf("unexpected signing key: %d %v", len(first), err)
+	}
+	if second, _ := store.SigningKey(ctx, "share-links"); !bytes.Equal(first, second) {
+		t.Fatal("signing key must be stable")
+	}
+
+	if err := store.DeleteEndpoint(ctx, "endpoint"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetShareLink(ctx, "link"); err != sql.ErrNoRows {
+		t.Fatalf("share links must be deleted with their endpoint, got %v", err)
+	}
+}
+
+func TestAuditEventsAreAppendOnlyAndFilterable(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	events := []*AuditEvent{
+		{ActorType: AuditActorBrowser, ActorID: "browser-1", Actor: "browser-1", Action: "endpoint.delete", TargetType: "endpoint", TargetID: "one"},
+		{ActorType: AuditActorAPIKey, ActorID: "key-1", Actor: "ci", Action: "endpoint.update", TargetType: "endpoint", TargetID: "two", Details: []byte(`{"alias":{"from":"","to":"x"}}`)},
+		{ActorType: AuditActorAdmin, Actor: "admin", Action: "endpoint.delete", TargetType: "endpoint", TargetID: "two"},
+	}
+	for _, event := range events {
+		if err := store.RecordAuditEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := store.ListAuditEvents(ctx, AuditFilter{})
+	if err != nil || len(all) != 3 || all[0].ID != events[2].ID {
+		t.Fatalf("expected all events newest first, got %+v %v", all, err)
+	}
+	if found, _ := store.ListAuditEvents(ctx, AuditFilter{Actor: "key-1"}); len(found) != 1 || string(found[0].Details) != string(events[1].Details) {
+		t.Fatalf("expected the API key event by ID, got %+v", found)
+	}
+	if found, _ := store.ListAuditEvents(ctx, AuditFilter{Action: "endpoint.delete", TargetID: "two"}); len(found) != 1 || found[0].ActorType != AuditActorAdmin {
+		t.Fatalf("expected the admin delete, got %+v", found)
+	}
+	if found, _ := store.ListAuditEvents(ctx, AuditFilter{BeforeID: events[2].ID, Limit: 1}); len(found) != 1 || found[0].ID != events[1].ID {
+		t.Fatalf("expected to page past the newest event, got %+v", found)
+	}
+	if found, _ := store.ListAuditEvents(ctx, AuditFilter{Since: time.Now().Add(time.Hour)}); len(found) != 0 {
+		t.Fatalf("expected no future events, got %+v", found)
+	}
+
+	if _, err := store.db.ExecContext(ctx, "UPDATE audit_events SET actor = 'someone'"); err == nil {
+		t.Fatal("audit events must not be editable")
+	}
+	if _, err := store.db.ExecContext(ctx, "DELETE FROM audit_events"); err == nil {
+		t.Fatal("audit events must not be deletable")
+	}
+	if err := store.Cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResponseSequencesAdvanceAndPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sequences.db")
+	store, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err := store.CreateEndpoint(ctx, "endpoint", "", "browser", DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	if step, _, err := store.NextSequenceStep(ctx, "endpoint", "/any"); err != nil || step != nil {
+		t.Fatalf("expected no sequence, got %+v %v", step, err)
+	}
+	steps := []SequenceStep{{Status: 500}, {Status: 429, Body: "slow"}, {Status: 200, Body: "ok"}}
+	if err := store.SetResponseSequence(ctx, &ResponseSequence{EndpointID: "endpoint", Mode: SequenceHold, Steps: steps}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetResponseSequence(ctx, &ResponseSequence{EndpointID: "endpoint", Path: "/orders", Mode: SequenceLoop, Steps: steps[:2]}); err != nil {
+		t.Fatal(err)
+	}
+	next := func(path string) int {
+		t.Helper()
+		step, number, err := store.NextSequenceStep(ctx, "endpoint", path)
+		if err != nil || step == nil || step.Status != steps[number-1].Status {
+			t.Fatalf("unexpected step for %q: %+v %d %v", path, step, number, err)
+		}
+		return step.Status
+	}
+	for _, expected := range []int{500, 429, 200, 200} {
+		if status := next("/other"); status != expected {
+			t.Fatalf("hold sequence served %d, expected %d", status, expected)
+		}
+	}
+	for _, expected := range []int{500, 429, 500} {
+		if status := next("/orders"); status != expected {
+			t.Fatalf("loop sequence served %d, expected %d", status, expected)
+		}
+	}
+
+	// Positions survive a restart.
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = NewSQLiteStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if status := next("/orders"); status != 429 {
+		t.Fatalf("expected the loop to resume after a restart, got %d", status)
+	}
+	if err := store.ResetResponseSequence(ctx, "endpoint", ""); err != nil {
+		t.Fatal(err)
+	}
+	if status := next(""); status != 500 {
+		t.Fatalf("expected a reset sequence to start over, got %d", status)
+	}
+	if err := store.DeleteResponseSequence(ctx, "endpoint", "/orders"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.ResetResponseSequence(ctx, "endpoint", "/orders"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected a deleted sequence to be gone, got %v", err)
+	}
+	if sequences, err := store.ListResponseSequences(ctx, "endpoint"); err != nil || len(sequences) != 1 || sequences[0].Position != 1 {
+		t.Fatalf("unexpected sequences: %+v %v", sequences, err)
+	}
+}
+
+func TestNotificationChannelsAndSearchMatching(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if _, err := store.CreateEndpoint(ctx, "endpoint", "", "browser", DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	for _, channel := range []*NotificationChannel{
+		{ID: "slack", EndpointID: "endpoint", Kind: NotifySlack, Target: "https://hooks.slack.test/a", Match: "failed", DigestSeconds: 60},
+		{ID: "mail", EndpointID: "endpoint", Kind: NotifyEmail, Target: "ops@example.com"},
+	} {
+		if err := store.CreateNotificationChannel(ctx, channel); err != nil {
+			t.Fatal(err)
+		}
+	}
+	channels, err := store.ListNotificationChannels(ctx, "endpoint")
+	if err != nil || len(channels) != 2 || channels[0].ID != "slack" || channels[0].Match != "failed" || channels[0].DigestSeconds != 60 {
+		t.Fatalf("unexpected channels: %+v %v", channels, err)
+	}
+	if err := store.DeleteNotificationChannel(ctx, "other", "mail"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected another endpoint's channel to be out of reach, got %v", err)
+	}
+	if err := store.DeleteNotificationChannel(ctx, "endpoint", "mail"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DeleteEndpoint(ctx, "endpoint"); err != nil {
+		t.Fatal(err)
+	}
+	if channels, err := store.ListNotificationChannels(ctx, "endpoint"); err != nil || len(channels) != 0 {
+		t.Fatalf("expected channels to go with their endpoint: %+v %v", channels, err)
+	}
+
+	request := &Request{Method: "POST", Path: "/h/endpoint/orders", Headers: `{"X-Event":["Payment_Failed"]}`, Body: []byte(`{"id":1}`)}
+	for query, expected := range map[string]bool{"": true, "payment_failed": true, "ORDERS": true, `"id":1`: true, "refund": false} {
+		if request.MatchesSearch(query) != expected {
+			t.Errorf("MatchesSearch(%q) = %v", query, !expected)
+		}
+	}
+}
+
+func TestAssertionsMatchPathsAndValidationIsSearchable(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if _, err := store.CreateEndpoint(ctx, "endpoint", "", "browser", DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+	if assertion, err := store.MatchAssertion(ctx, "endpoint", "/orders"); err != nil || assertion != nil {
+		t.Fatalf("expected no assertion yet, got %+v %v", assertion, err)
+	}
+	for _, assertion := range []*Assertion{
+		{EndpointID: "endpoint", Methods: []string{"POST"}},
+		{EndpointID: "endpoint", Path: "/orders", Headers: map[string]string{"X-Signature": ""}, Schema: []byte(`{"type":"object"}`), RejectStatus: 422},
+	} {
+		if err := store.SetAssertion(ctx, assertion); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if assertion, err := store.MatchAssertion(ctx, "endpoint", "/orders"); err != nil || assertion.Path != "/orders" ||
+		assertion.RejectStatus != 422 || string(assertion.Schema) != `{"type":"object"}` || assertion.Headers["X-Signature"] != "" {
+		t.Fatalf("expected the path's own assertion, got %+v %v", assertion, err)
+	}
+	if assertion, err := store.MatchAssertion(ctx, "endpoint", "/refunds"); err != nil || assertion.Path != "" || assertion.Methods[0] != "POST" {
+		t.Fatalf("expected the endpoint-wide assertion, got %+v %v", assertion, err)
+	}
+	if err := store.SetAssertion(ctx, &Assertion{EndpointID: "endpoint", Methods: []string{"PUT"}}); err != nil {
+		t.Fatal(err)
+	}
+	assertions, err := store.ListAssertions(ctx, "endpoint")
+	if err != nil || len(assertions) != 2 || assertions[0].Path != "" || assertions[0].Methods[0] != "PUT" || assertions[1].Path != "/orders" {
+		t.Fatalf("unexpected assertions: %+v %v", assertions, err)
+	}
+	if err := store.DeleteAssertion(ctx, "endpoint", "/missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected a missing assertion to be reported, got %v", err)
+	}
+	if err := store.DeleteAssertion(ctx, "endpoint", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, request := range []*Request{
+		{EndpointID: "endpoint", Method: "POST", Path: "/h/endpoint/orders", Validation: &Validation{Passed: true, Path: "/orders"}},
+		{EndpointID: "endpoint", Method: "POST", Path: "/h/endpoint/orders", Validation: &Validation{Path: "/orders", Violations: []string{`/: missing required property "id"`}}},
+		{EndpointID: "endpoint", Method: "GET", Path: "/h/endpoint"},
+	} {
+		if err := store.SaveRequest(ctx, request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for query, expected := range map[string]int{SearchValidationPassed: 1, "Validation:Failed": 1, "required property": 1, "": 3} {
+		requests, err := store.SearchRequests(ctx, "endpoint", query, 10, 0)
+		if err != nil || len(requests) != expected {
+			t.Errorf("search %q: expected %d requests, got %d %v", query, expected, len(requests), err)
+			continue
+		}
+		for _, request := range requests {
+			if query != "" && !request.MatchesSearch(query) {
+				t.Errorf("search %q found request %d that MatchesSearch rejects", query, request.ID)
+			}
+		}
+	}
+	requests, err := store.SearchRequests(ctx, "endpoint", SearchValidationFailed, 10, 0)
+	if err != nil || len(requests) != 1 || requests[0].Validation == nil || requests[0].Validation.Passed || len(requests[0].Validation.Violations) != 1 {
+		t.Fatalf("expected the failed validation to be read back, got %+v %v", requests, err)
+	}
+	if err := store.DeleteEndpoint(ctx, "endpoint"); err != nil {
+		t.Fatal(err)
+	}
+	if assertions, err := store.ListAssertions(ctx, "endpoint"); err != nil || len(assertions) != 0 {
+		t.Fatalf("expected assertions to go with their endpoint: %+v %v", assertions, err)
+	}
+}
+
+func TestReadinessAndDiagnostics(t *testing.T) {
+	store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "ready.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	ctx := context.Background()
+	if err := store.Ready(ctx, 1); err != nil {
+		t.Fatalf("expected a fresh database to be ready, got %v", err)
+	}
+	if err := store.Ready(ctx, math.MaxInt64); err == nil || !strings.Contains(err.Error(), "disk space") {
+		t.Fatalf("expected the disk threshold to fail readiness, got %v", err)
+	}
+	if _, err := store.CreateEndpoint(ctx, "endpoint", "", "browser", DefaultTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics, err := store.Diagnostics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diagnostics.Path == "" || diagnostics.SizeBytes <= 0 || diagnostics.PageCount <= 0 || diagnostics.PageSize <= 0 || diagnostics.QueryOnly {
+		t.Fatalf("unexpected diagnostics: %+v", diagnostics)
+	}
+	rows := map[string]int64{}
+	for _, table := range diagnostics.Tables {
+		rows[table.Name] = table.Rows
+	}
+	if count, ok := rows["endpoints"]; !ok || count != 1 {
+		t.Fatalf("expected one endpoint row, got %+v", diagnostics.Tables)
+	}
+
+	if _, err := store.db.ExecContext(ctx, "PRAGMA query_only = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Ready(ctx, 0); err == nil || !strings.Contains(err.Error(), "query_only") {
+		t.Fatalf("expected query_only to fail readiness, got %v", err)
+	}
+	if diagnostics, err := store.Diagnostics(ctx); err != nil || !diagnostics.QueryOnly {
+		t.Fatalf("expected diagnostics to report query_only, got %+v %v", diagnostics, err)
+	}
 }
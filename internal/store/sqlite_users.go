@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+func (s *SQLiteStore) CreateUser(ctx context.Context, user *User, passwordHash string) error {
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users (id, username, password_hash, created_at) VALUES (?, ?, ?, ?)
+	`, user.ID, user.Username, passwordHash, user.CreatedAt)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+// GetUserByUsername returns the user and their password hash. The lookup is
+// case-insensitive.
+func (s *SQLiteStore) GetUserByUsername(ctx context.Context, username string) (*User, string, error) {
+	var user User
+	var passwordHash string
+	err := s.db.QueryRowContext(ctx, "SELECT id, username, created_at, password_hash FROM users WHERE username = ?", username).
+		Scan(&user.ID, &user.Username, &user.CreatedAt, &passwordHash)
+	if err != nil {
+		return nil, "", err
+	}
+	return &user, passwordHash, nil
+}
+
+// GetUserByOIDCSubject returns the user linked to an identity provider
+// subject, written as "<issuer>|<sub>".
+func (s *SQLiteStore) GetUserByOIDCSubject(ctx context.Context, subject string) (*User, error) {
+	var user User
+	err := s.db.QueryRowContext(ctx, "SELECT id, username, created_at FROM users WHERE oidc_subject = ? AND oidc_subject != ''", subject).
+		Scan(&user.ID, &user.Username, &user.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// CreateOIDCUser creates an account that can only sign in through the
+// identity provider. It has no password, so password logins always fail.
+func (s *SQLiteStore) CreateOIDCUser(ctx context.Context, user *User, subject string) error {
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = time.Now()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO users (id, username, password_hash, oidc_subject, created_at) VALUES (?, ?, '', ?, ?)
+	`, user.ID, user.Username, subject, user.CreatedAt)
+	if err != nil && strings.Contains(err.Error(), "users.username") {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+func (s *SQLiteStore) CreateSession(ctx context.Context, session *Session) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO sessions (token_hash, user_id, is_admin, created_at, expires_at) VALUES (?, ?, ?, ?, ?)
+	`, session.TokenHash, session.UserID, session.Admin, time.Now(), session.ExpiresAt)
+	return err
+}
+
+// GetSession returns an unexpired session together with its user.
+func (s *SQLiteStore) GetSession(ctx context.Context, tokenHash string) (*Session, error) {
+	session := Session{TokenHash: tokenHash, User: &User{}}
+	err := s.db.QueryRowContext(ctx, `
+		SELECT s.user_id, s.is_admin, s.expires_at, u.id, u.username, u.created_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?
+	`, tokenHash, time.Now()).Scan(&session.UserID, &session.Admin, &session.ExpiresAt, &session.User.ID, &session.User.Username, &session.User.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (s *SQLiteStore) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sessions WHERE token_hash = ?", tokenHash)
+	return err
+}
+
+func (s *SQLiteStore) SetEndpointOwner(ctx context.Context, endpointID, userID string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE endpoints SET owner_user_id = ? WHERE id = ?", userID, endpointID)
+	return err
+}
+
+// ListUserEndpoints returns endpoints owned by the user or shared with them
+// through a workspace.
+func (s *SQLiteStore) ListUserEndpoints(ctx context.Context, userID string, limit int) ([]*Endpoint, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+endpointColumns+`
+		FROM endpoints WHERE (owner_user_id = ?
+			OR workspace_id IN (SELECT workspace_id FROM workspace_members WHERE user_id = ?))
+		AND expires_at > ? ORDER BY created_at DESC LIMIT ?`,
+		userID, userID, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectEndpoints(rows)
+}
+
+// ClaimEndpoints transfers every unowned endpoint created by the browser
+// creatorID to userID and reports how many were claimed. Creator IDs that are
+// not browser IDs claim nothing.
+func (s *SQLiteStore) ClaimEndpoints(ctx context.Context, creatorID, userID string) (int, error) {
+	if !ValidBrowserID(creatorID) {
+		return 0, nil
+	}
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE endpoints SET owner_user_id = ? WHERE creator_id = ? AND owner_user_id = ''
+	`, userID, creatorID)
+	if err != nil {
+		return 0, err
+	}
+	claimed, err := result.RowsAffected()
+	return int(claimed), err
+}
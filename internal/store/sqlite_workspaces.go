@@ -0,0 +1,192 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CreateWorkspace stores the workspace and makes ownerUserID its first owner.
+func (s *SQLiteStore) CreateWorkspace(ctx context.Context, workspace *Workspace, ownerUserID string) error {
+	if workspace.CreatedAt.IsZero() {
+		workspace.CreatedAt = time.Now()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO workspaces (id, name, created_at) VALUES (?, ?, ?)",
+		workspace.ID, workspace.Name, workspace.CreatedAt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)",
+		workspace.ID, ownerUserID, WorkspaceRoleOwner, workspace.CreatedAt); err != nil {
+		return err
+	}
+	workspace.Role = WorkspaceRoleOwner
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) GetWorkspace(ctx context.Context, id string) (*Workspace, error) {
+	var workspace Workspace
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, created_at FROM workspaces WHERE id = ?", id).
+		Scan(&workspace.ID, &workspace.Name, &workspace.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &workspace, nil
+}
+
+func (s *SQLiteStore) ListUserWorkspaces(ctx context.Context, userID string) ([]*Workspace, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT w.id, w.name, w.created_at, m.role
+		FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = ? ORDER BY w.name COLLATE NOCASE
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	workspaces := make([]*Workspace, 0)
+	for rows.Next() {
+		var workspace Workspace
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.CreatedAt, &workspace.Role); err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, &workspace)
+	}
+	return workspaces, rows.Err()
+}
+
+// GetWorkspaceRole returns sql.ErrNoRows when the user is not a member.
+func (s *SQLiteStore) GetWorkspaceRole(ctx context.Context, workspaceID, userID string) (string, error) {
+	var role string
+	err := s.db.QueryRowContext(ctx, "SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?",
+		workspaceID, userID).Scan(&role)
+	return role, err
+}
+
+func (s *SQLiteStore) ListWorkspaceMembers(ctx context.Context, workspaceID string) ([]*WorkspaceMember, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT m.workspace_id, m.user_id, u.username, m.role, m.created_at
+		FROM workspace_members m JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = ? ORDER BY u.username COLLATE NOCASE
+	`, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make([]*WorkspaceMember, 0)
+	for rows.Next() {
+		var member WorkspaceMember
+		if err := rows.Scan(&member.WorkspaceID, &member.UserID, &member.Username, &member.Role, &member.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	return members, rows.Err()
+}
+
+// SetWorkspaceMember adds the user or changes their role. Demoting the last
+// owner returns ErrLastWorkspaceOwner.
+func (s *SQLiteStore) SetWorkspaceMember(ctx context.Context, workspaceID, userID, role string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if role != WorkspaceRoleOwner {
+		if err := ensureOtherOwner(ctx, tx, workspaceID, userID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO workspace_members (workspace_id, user_id, role, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(workspace_id, user_id) DO UPDATE SET role = excluded.role
+	`, workspaceID, userID, role, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveWorkspaceMember returns sql.ErrNoRows when the user is not a member
+// and ErrLastWorkspaceOwner when they are its only owner.
+func (s *SQLiteStore) RemoveWorkspaceMember(ctx context.Context, workspaceID, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := ensureOtherOwner(ctx, tx, workspaceID, userID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?", workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// ensureOtherOwner fails when userID is currently the workspace's only owner.
+func ensureOtherOwner(ctx context.Context, tx *sql.Tx, workspaceID, userID string) error {
+	var role string
+	err := tx.QueryRowContext(ctx, "SELECT role FROM workspace_members WHERE workspace_id = ? AND user_id = ?",
+		workspaceID, userID).Scan(&role)
+	if err == sql.ErrNoRows || (err == nil && role != WorkspaceRoleOwner) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var owners int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM workspace_members WHERE workspace_id = ? AND role = ?",
+		workspaceID, WorkspaceRoleOwner).Scan(&owners); err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastWorkspaceOwner
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetEndpointWorkspace(ctx context.Context, endpointID, workspaceID string) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE endpoints SET workspace_id = ? WHERE id = ?", workspaceID, endpointID)
+	return err
+}
+
+func (s *SQLiteStore) ListWorkspaceEndpoints(ctx context.Context, workspaceID string, limit int) ([]*Endpoint, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+endpointColumns+`
+		FROM endpoints WHERE workspace_id = ? AND expires_at > ? ORDER BY created_at DESC LIMIT ?`,
+		workspaceID, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	return collectEndpoints(rows)
+}
+
+func (s *SQLiteStore) workspaceUsageStats(ctx context.Context) ([]WorkspaceUsageStat, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT w.id, w.name, w.created_at,
+			(SELECT COUNT(*) FROM workspace_members m WHERE m.workspace_id = w.id),
+			(SELECT COUNT(*) FROM endpoints e WHERE e.workspace_id = w.id),
+			(SELECT COUNT(*) FROM requests r JOIN endpoints e ON e.id = r.endpoint_id WHERE e.workspace_id = w.id)
+		FROM workspaces w ORDER BY w.name COLLATE NOCASE
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	stats := make([]WorkspaceUsageStat, 0)
+	for rows.Next() {
+		var stat WorkspaceUsageStat
+		if err := rows.Scan(&stat.WorkspaceID, &stat.Name, &stat.CreatedAt, &stat.MemberCount, &stat.EndpointCount, &stat.RequestCount); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
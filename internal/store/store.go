@@ -2,7 +2,13 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 const (
@@ -15,18 +21,120 @@ const (
 )
 
 type Endpoint struct {
-	ID                 string    `json:"id"`
-	Alias              string    `json:"alias"`
-	CreatorID          string    `json:"creator_id"`
-	CreatedAt          time.Time `json:"created_at"`
-	ExpiresAt          time.Time `json:"expires_at"`
-	DefaultStatus      int       `json:"default_status"`
-	DefaultBody        string    `json:"default_body"`
-	DefaultContentType string    `json:"default_content_type"`
-	ResponseDelayMS    int       `json:"response_delay_ms"`
-	EnableCORS         bool      `json:"enable_cors"`
-	ForwardURL         string    `json:"forward_url"`
-	RequestLimit       int       `json:"request_limit"`
+	ID                 string      `json:"id"`
+	Alias              string      `json:"alias"`
+	CreatorID          string      `json:"creator_id"`
+	OwnerUserID        string      `json:"owner_user_id"`
+	WorkspaceID        string      `json:"workspace_id"`
+	CreatedAt          time.Time   `json:"created_at"`
+	ExpiresAt          time.Time   `json:"expires_at"`
+	DefaultStatus      int         `json:"default_status"`
+	DefaultBody        string      `json:"default_body"`
+	DefaultContentType string      `json:"default_content_type"`
+	ResponseDelayMS    int         `json:"response_delay_ms"`
+	EnableCORS         bool        `json:"enable_cors"`
+	ForwardURL         string      `json:"forward_url"`
+	RequestLimit       int         `json:"request_limit"`
+	InboundAuth        InboundAuth `json:"inbound_auth"`
+	RejectedCount      int64       `json:"rejected_count"`
+	Throttle           Throttle    `json:"throttle"`
+	ThrottledCount     int64       `json:"throttled_count"`
+	Chaos              Chaos       `json:"chaos"`
+}
+
+// ValidBrowserID reports whether id has the form of the browser IDs the
+// server issues: a UUID in its canonical text form. Endpoints created by the
+// API or an import have creator IDs that never pass, so no cookie can claim
+// or open them.
+func ValidBrowserID(id string) bool {
+	parsed, err := uuid.Parse(id)
+	return err == nil && parsed.String() == id
+}
+
+// InboundAuth lists the requirements a sender must meet before a capture is
+// accepted. Every configured requirement must pass; an empty InboundAuth
+// accepts everyone. ClientCertSHA256 holds hex SHA-256 fingerprints of the
+// allowed client certificates.
+//
+// The secrets hold SHA-256 digests, see InboundSecretDigest, and are never
+// encoded as JSON. The *Set fields say whether each one is configured
+// instead; they are filled in when the settings are encoded, so API
+// responses and exports carry them.
+type InboundAuth struct {
+	BasicUsername    string   `json:"basic_username"`
+	BasicPassword    string   `json:"-"`
+	BasicPasswordSet bool     `json:"basic_password_set"`
+	BearerToken      string   `json:"-"`
+	BearerTokenSet   bool     `json:"bearer_token_set"`
+	HeaderName       string   `json:"header_name"`
+	HeaderValue      string   `json:"-"`
+	HeaderValueSet   bool     `json:"header_value_set"`
+	AllowedCIDRs     []string `json:"allowed_cidrs"`
+	ClientCertSHA256 []string `json:"client_cert_sha256"`
+	StoreRejected    bool     `json:"store_rejected"`
+}
+
+func (a InboundAuth) MarshalJSON() ([]byte, error) {
+	type inboundAuth InboundAuth
+	a.BasicPasswordSet, a.BearerTokenSet, a.HeaderValueSet = a.BasicPassword != "", a.BearerToken != "", a.HeaderValue != ""
+	return json.Marshal(inboundAuth(a))
+}
+
+// InboundSecretDigest returns the hex SHA-256 digest that stands for a sender
+// authentication secret, so the database never holds the secret itself.
+func InboundSecretDigest(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Enabled reports whether any requirement is configured.
+func (a InboundAuth) Enabled() bool {
+	return a.BasicUsername != "" || a.BasicPassword != "" || a.BearerToken != "" || a.HeaderName != "" ||
+		len(a.AllowedCIDRs) > 0 || len(a.ClientCertSHA256) > 0
+}
+
+// Throttle limits how fast an endpoint accepts captures with a token bucket
+// that refills RequestsPerSecond and holds Burst requests. A zero
+// RequestsPerSecond disables it. Body replaces the default 429 message.
+type Throttle struct {
+	RequestsPerSecond int    `json:"requests_per_second"`
+	Burst             int    `json:"burst"`
+	Body              string `json:"body"`
+}
+
+func (t Throttle) Enabled() bool {
+	return t.RequestsPerSecond > 0
+}
+
+// Chaos injects faults into an endpoint's responses to exercise a sender's
+// retry logic. Percentages are chances per request from 0 to 100. Injected
+// errors use a random entry of ErrorStatuses, or a random 5xx when it is
+// empty. FailFirst answers the first N attempts carrying the same
+// FailKeyHeader value with an error before letting one through.
+type Chaos struct {
+	ErrorPercent   int    `json:"error_percent"`
+	ErrorStatuses  []int  `json:"error_statuses"`
+	LatencyMinMS   int    `json:"latency_min_ms"`
+	LatencyMaxMS   int    `json:"latency_max_ms"`
+	ResetPercent   int    `json:"reset_percent"`
+	DripPercent    int    `json:"drip_percent"`
+	DripIntervalMS int    `json:"drip_interval_ms"`
+	FailFirst      int    `json:"fail_first"`
+	FailKeyHeader  string `json:"fail_key_header"`
+}
+
+// Enabled reports whether any fault is configured.
+func (c Chaos) Enabled() bool {
+	return c.ErrorPercent > 0 || c.LatencyMaxMS > 0 || c.ResetPercent > 0 || c.DripPercent > 0 || c.FailFirst > 0
+}
+
+// ChaosOutcome records the faults injected into the response to one request.
+type ChaosOutcome struct {
+	Status         int  `json:"status,omitempty"`
+	Attempt        int  `json:"attempt,omitempty"`
+	LatencyMS      int  `json:"latency_ms,omitempty"`
+	Reset          bool `json:"reset,omitempty"`
+	DripIntervalMS int  `json:"drip_interval_ms,omitempty"`
 }
 
 type EndpointSettings struct {
@@ -38,9 +146,23 @@ type EndpointSettings struct {
 	ResponseDelayMS    int           `json:"response_delay_ms"`
 	EnableCORS         bool          `json:"enable_cors"`
 	ForwardURL         string        `json:"forward_url"`
+	InboundAuth        InboundAuth   `json:"inbound_auth"`
+	Throttle           Throttle      `json:"throttle"`
+	Chaos              Chaos         `json:"chaos"`
 	RequestLimit       int           `json:"request_limit"`
 }
 
+// Settings returns the endpoint's configurable settings, with TTL set to the
+// time left until it expires.
+func (e *Endpoint) Settings() EndpointSettings {
+	return EndpointSettings{
+		Alias: e.Alias, TTL: time.Until(e.ExpiresAt), DefaultStatus: e.DefaultStatus, DefaultBody: e.DefaultBody,
+		DefaultContentType: e.DefaultContentType, ResponseDelayMS: e.ResponseDelayMS, EnableCORS: e.EnableCORS,
+		ForwardURL: e.ForwardURL, InboundAuth: e.InboundAuth, Throttle: e.Throttle, Chaos: e.Chaos,
+		RequestLimit: e.RequestLimit,
+	}
+}
+
 func DefaultEndpointSettings() EndpointSettings {
 	return EndpointSettings{
 		TTL:                DefaultTTL,
@@ -52,19 +174,117 @@ func DefaultEndpointSettings() EndpointSettings {
 }
 
 type Request struct {
-	ID            int64     `json:"id"`
+	ID            int64  `json:"id"`
+	EndpointID    string `json:"endpoint_id"`
+	Method        string `json:"method"`
+	Path          string `json:"path"`
+	QueryString   string `json:"query_string"`
+	Host          string `json:"host"`
+	Scheme        string `json:"scheme"`
+	RemoteAddr    string `json:"remote_addr"`
+	Headers       string `json:"headers"`
+	Body          []byte `json:"body"`
+	ContentLength int64  `json:"content_length"`
+	BodyTruncated bool   `json:"body_truncated"`
+	StatusCode    int    `json:"status_code"`
+	// RejectedReason is set when the sender failed the endpoint's inbound
+	// auth and the attempt was only kept for inspection.
+	RejectedReason string `json:"rejected_reason,omitempty"`
+	// Chaos lists the faults injected into the response, if any.
+	Chaos *ChaosOutcome `json:"chaos,omitempty"`
+	// SequenceStep is the 1-based response sequence step that was served,
+	// or 0 when no sequence applied.
+	SequenceStep int `json:"sequence_step,omitempty"`
+	// ResponseHeaders (JSON), ResponseBody and ResponseTimeMS describe the
+	// response as it was served, once it finished. ResponseHeaders is empty
+	// for captures stored before responses were recorded. ClientAborted is
+	// set when the sender went away before the response was complete.
+	ResponseHeaders string `json:"response_headers,omitempty"`
+	ResponseBody    []byte `json:"response_body,omitempty"`
+	ResponseTimeMS  int64  `json:"response_time_ms"`
+	ClientAborted   bool   `json:"client_aborted"`
+	// TraceID is the hex OpenTelemetry trace ID the capture was recorded
+	// under, from the sender's traceparent header or a new trace.
+	TraceID string `json:"trace_id,omitempty"`
+	// CorrelationID is the request ID the capture was logged under, from the
+	// sender's X-Request-ID header or generated on arrival.
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Validation is the result of the endpoint's assertion, or nil when
+	// none applied.
+	Validation *Validation `json:"validation,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+const (
+	SequenceLoop = "loop"
+	SequenceHold = "hold"
+)
+
+// SequenceStep is one scripted response. An empty ContentType uses the
+// endpoint's default.
+type SequenceStep struct {
+	Status      int    `json:"status"`
+	Body        string `json:"body"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// ResponseSequence answers successive captures with its steps in order. Path
+// limits it to one path under the endpoint; empty applies to every path
+// without its own sequence. Loop sequences start over after the last step,
+// hold sequences keep serving it. Position is the next step to serve.
+type ResponseSequence struct {
+	EndpointID string         `json:"endpoint_id"`
+	Path       string         `json:"path"`
+	Mode       string         `json:"mode"`
+	Steps      []SequenceStep `json:"steps"`
+	Position   int            `json:"position"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// Assertion is what captures to an endpoint, or to one path under it, are
+// expected to look like. As with response sequences, a path's own assertion
+// wins over the endpoint-wide one. Methods lists the allowed methods, empty
+// for any. Headers maps header names to the value they must have; an empty
+// value only requires the header. Schema is a JSON Schema the body must
+// satisfy. When RejectStatus is set, failing captures are answered with it
+// instead of the endpoint's response.
+type Assertion struct {
+	EndpointID   string            `json:"endpoint_id"`
+	Path         string            `json:"path"`
+	Methods      []string          `json:"methods,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Schema       json.RawMessage   `json:"schema,omitempty"`
+	RejectStatus int               `json:"reject_status,omitempty"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+}
+
+// Validation is the outcome of checking a capture against the assertion for
+// Path.
+type Validation struct {
+	Passed     bool     `json:"passed"`
+	Path       string   `json:"path"`
+	Violations []string `json:"violations,omitempty"`
+}
+
+// Notification channel kinds.
+const (
+	NotifyWebhook = "webhook"
+	NotifySlack   = "slack"
+	NotifyEmail   = "email"
+)
+
+// NotificationChannel reports an endpoint's captures. Target is the URL of
+// an outgoing or Slack incoming webhook, or comma-separated email addresses.
+// Match selects captures with the rules of the dashboard search; empty
+// matches every capture. With DigestSeconds set, the channel sends at most
+// one message per interval, counting the captures it stands for.
+type NotificationChannel struct {
+	ID            string    `json:"id"`
 	EndpointID    string    `json:"endpoint_id"`
-	Method        string    `json:"method"`
-	Path          string    `json:"path"`
-	QueryString   string    `json:"query_string"`
-	Host          string    `json:"host"`
-	Scheme        string    `json:"scheme"`
-	RemoteAddr    string    `json:"remote_addr"`
-	Headers       string    `json:"headers"`
-	Body          []byte    `json:"body"`
-	ContentLength int64     `json:"content_length"`
-	BodyTruncated bool      `json:"body_truncated"`
-	StatusCode    int       `json:"status_code"`
+	Kind          string    `json:"kind"`
+	Target        string    `json:"target"`
+	Match         string    `json:"match"`
+	DigestSeconds int       `json:"digest_seconds"`
 	CreatedAt     time.Time `json:"created_at"`
 }
 
@@ -78,6 +298,7 @@ const (
 
 type Store interface {
 	CreateEndpoint(ctx context.Context, id string, alias string, creatorID string, ttl time.Duration) (*Endpoint, error)
+	ImportEndpoint(ctx context.Context, endpoint *Endpoint) error
 	GetEndpoint(ctx context.Context, id string) (*Endpoint, error)
 	UpdateEndpoint(ctx context.Context, id string, alias string, ttl time.Duration) error
 	UpdateEndpointSettings(ctx context.Context, id string, settings EndpointSettings) error
@@ -88,6 +309,7 @@ type Store interface {
 	SaveRequest(ctx context.Context, req *Request) error
 	GetRequests(ctx context.Context, endpointID string, limit int) ([]*Request, error)
 	GetRequestsWithOffset(ctx context.Context, endpointID string, limit int, offset int) ([]*Request, error)
+	ListRequestsAfter(ctx context.Context, endpointID string, afterID int64, limit int) ([]*Request, error)
 	GetRequestSummaries(ctx context.Context, endpointID string, limit int) ([]*Request, error)
 	GetRequestSummariesWithOffset(ctx context.Context, endpointID string, limit int, offset int) ([]*Request, error)
 	SearchRequestSummaries(ctx context.Context, endpointID string, query string, limit int, offset int) ([]*Request, error)
@@ -95,17 +317,264 @@ type Store interface {
 	CountRequests(ctx context.Context, endpointID string) (int, error)
 	CountRequestsFiltered(ctx context.Context, endpointID string, query string) (int, error)
 	GetRequest(ctx context.Context, id int64) (*Request, error)
+	RecordResponse(ctx context.Context, request *Request) error
+	IncrementRejectedCount(ctx context.Context, endpointID string) error
+	AddThrottledCounts(ctx context.Context, counts map[string]int64) error
+	RecordChaosAttempt(ctx context.Context, endpointID, key string) (int, error)
+
+	SetResponseSequence(ctx context.Context, sequence *ResponseSequence) error
+	ListResponseSequences(ctx context.Context, endpointID string) ([]*ResponseSequence, error)
+	ResetResponseSequence(ctx context.Context, endpointID, path string) error
+	DeleteResponseSequence(ctx context.Context, endpointID, path string) error
+	NextSequenceStep(ctx context.Context, endpointID, path string) (*SequenceStep, int, error)
+	SetAssertion(ctx context.Context, assertion *Assertion) error
+	ListAssertions(ctx context.Context, endpointID string) ([]*Assertion, error)
+	DeleteAssertion(ctx context.Context, endpointID, path string) error
+	MatchAssertion(ctx context.Context, endpointID, path string) (*Assertion, error)
+	CreateNotificationChannel(ctx context.Context, channel *NotificationChannel) error
+	ListNotificationChannels(ctx context.Context, endpointID string) ([]*NotificationChannel, error)
+	DeleteNotificationChannel(ctx context.Context, endpointID, id string) error
 	DeleteRequest(ctx context.Context, id int64) error
 	TrimRequests(ctx context.Context, endpointID string, keep int) error
 
+	CreateAPIKey(ctx context.Context, key *APIKey, keyHash string) error
+	ListAPIKeys(ctx context.Context) ([]*APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+
+	CreateUser(ctx context.Context, user *User, passwordHash string) error
+	GetUserByUsername(ctx context.Context, username string) (*User, string, error)
+	GetUserByOIDCSubject(ctx context.Context, subject string) (*User, error)
+	CreateOIDCUser(ctx context.Context, user *User, subject string) error
+	CreateSession(ctx context.Context, session *Session) error
+	GetSession(ctx context.Context, tokenHash string) (*Session, error)
+	DeleteSession(ctx context.Context, tokenHash string) error
+	SetEndpointOwner(ctx context.Context, endpointID string, userID string) error
+	ListUserEndpoints(ctx context.Context, userID string, limit int) ([]*Endpoint, error)
+	ClaimEndpoints(ctx context.Context, creatorID string, userID string) (int, error)
+
+	CreateShareLink(ctx context.Context, link *ShareLink) error
+	GetShareLink(ctx context.Context, id string) (*ShareLink, error)
+	ListShareLinks(ctx context.Context, endpointID string) ([]*ShareLink, error)
+	RevokeShareLink(ctx context.Context, endpointID string, id string) error
+	SigningKey(ctx context.Context, name string) ([]byte, error)
+
+	RecordAuditEvent(ctx context.Context, event *AuditEvent) error
+	ListAuditEvents(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+
+	CreateWorkspace(ctx context.Context, workspace *Workspace, ownerUserID string) error
+	GetWorkspace(ctx context.Context, id string) (*Workspace, error)
+	ListUserWorkspaces(ctx context.Context, userID string) ([]*Workspace, error)
+	GetWorkspaceRole(ctx context.Context, workspaceID string, userID string) (string, error)
+	ListWorkspaceMembers(ctx context.Context, workspaceID string) ([]*WorkspaceMember, error)
+	SetWorkspaceMember(ctx context.Context, workspaceID string, userID string, role string) error
+	RemoveWorkspaceMember(ctx context.Context, workspaceID string, userID string) error
+	SetEndpointWorkspace(ctx context.Context, endpointID string, workspaceID string) error
+	ListWorkspaceEndpoints(ctx context.Context, workspaceID string, limit int) ([]*Endpoint, error)
+
 	Cleanup(ctx context.Context) error
 	GetAdminStats(ctx context.Context) (*AdminStats, error)
+	// Ready returns why the database cannot take traffic, or nil. Disk space
+	// is only checked when minFreeDiskBytes is positive.
+	Ready(ctx context.Context, minFreeDiskBytes int64) error
+	Diagnostics(ctx context.Context) (*Diagnostics, error)
+}
+
+const (
+	APIScopeRead   = "read"
+	APIScopeWrite  = "write"
+	APIScopeDelete = "delete"
+	APIScopeAdmin  = "admin"
+)
+
+// APIScopes lists every scope an API key can hold, in display order.
+var APIScopes = []string{APIScopeRead, APIScopeWrite, APIScopeDelete, APIScopeAdmin}
+
+// APIKey describes a stored API key. The secret itself is never stored; only
+// its SHA-256 hash is kept alongside a short prefix for identification.
+type APIKey struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Scopes      []string   `json:"scopes"`
+	EndpointIDs []string   `json:"endpoint_ids"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt  *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt   *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key grants scope. The admin scope implies all others.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, granted := range k.Scopes {
+		if granted == scope || granted == APIScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsEndpoint reports whether the key may act on endpointID. Keys without
+// an endpoint restriction may act on every endpoint.
+func (k *APIKey) AllowsEndpoint(endpointID string) bool {
+	if len(k.EndpointIDs) == 0 {
+		return true
+	}
+	for _, allowed := range k.EndpointIDs {
+		if allowed == endpointID {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key is neither revoked nor expired at now.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// ErrUsernameTaken is returned by CreateUser when the username already exists.
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// User is a local account. Usernames are unique regardless of case.
+type User struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Session is a signed-in browser. Admin is granted by the identity provider
+// at sign-in and lasts as long as the session; GetSession fills in User.
+type Session struct {
+	TokenHash string
+	UserID    string
+	Admin     bool
+	ExpiresAt time.Time
+	User      *User
+}
+
+// ShareLink grants read-only access to one request, or to a whole endpoint
+// when RequestID is zero, until it expires or is revoked. Headers named in
+// RedactHeaders are masked in the shared view.
+type ShareLink struct {
+	ID            string     `json:"id"`
+	EndpointID    string     `json:"endpoint_id"`
+	RequestID     int64      `json:"request_id,omitempty"`
+	RedactHeaders []string   `json:"redact_headers"`
+	CreatedBy     string     `json:"created_by"`
+	CreatedAt     time.Time  `json:"created_at"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the link is neither revoked nor expired at now.
+func (l *ShareLink) Active(now time.Time) bool {
+	return l.RevokedAt == nil && now.Before(l.ExpiresAt)
+}
+
+const (
+	AuditActorBrowser = "browser"
+	AuditActorUser    = "user"
+	AuditActorAdmin   = "admin"
+	AuditActorAPIKey  = "api_key"
+)
+
+// AuditEvent records who did what to which target. Events are append-only:
+// the database rejects updates and deletes. Details holds action-specific
+// JSON, such as the changed endpoint settings.
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ActorType  string          `json:"actor_type"`
+	ActorID    string          `json:"actor_id"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	RemoteAddr string          `json:"remote_addr"`
+	Details    json.RawMessage `json:"details,omitempty"`
+}
+
+// AuditFilter narrows ListAuditEvents. Empty fields match everything; Actor
+// matches either the actor name or its ID. BeforeID pages backwards from the
+// newest event.
+type AuditFilter struct {
+	ActorType string
+	Actor     string
+	Action    string
+	TargetID  string
+	Since     time.Time
+	Until     time.Time
+	BeforeID  int64
+	Limit     int
+}
+
+const (
+	WorkspaceRoleViewer = "viewer"
+	WorkspaceRoleEditor = "editor"
+	WorkspaceRoleOwner  = "owner"
+)
+
+// WorkspaceRoles lists every workspace role from least to most privileged.
+var WorkspaceRoles = []string{WorkspaceRoleViewer, WorkspaceRoleEditor, WorkspaceRoleOwner}
+
+// ErrLastWorkspaceOwner is returned when a change would leave a workspace
+// without an owner.
+var ErrLastWorkspaceOwner = errors.New("a workspace must keep at least one owner")
+
+// Workspace groups endpoints shared by its members. Role is only set when the
+// workspace was listed for a specific user.
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Role      string    `json:"role,omitempty"`
+}
+
+type WorkspaceMember struct {
+	WorkspaceID string    `json:"workspace_id"`
+	UserID      string    `json:"user_id"`
+	Username    string    `json:"username"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Diagnostics describes the database for the admin diagnostics page. Sizes
+// are in bytes. FreeDiskBytes is -1 when it cannot be read on this platform
+// or the database is in memory.
+type Diagnostics struct {
+	Path          string       `json:"path"`
+	JournalMode   string       `json:"journal_mode"`
+	QueryOnly     bool         `json:"query_only"`
+	PageSize      int64        `json:"page_size"`
+	PageCount     int64        `json:"page_count"`
+	FreelistCount int64        `json:"freelist_count"`
+	SizeBytes     int64        `json:"size_bytes"`
+	WALBytes      int64        `json:"wal_bytes"`
+	FreeDiskBytes int64        `json:"free_disk_bytes"`
+	Tables        []TableCount `json:"tables"`
+}
+
+type TableCount struct {
+	Name string `json:"name"`
+	Rows int64  `json:"rows"`
 }
 
 type AdminStats struct {
-	TotalEndpoints     int                 `json:"total_endpoints"`
-	TotalRequests      int                 `json:"total_requests"`
-	EndpointUsageStats []EndpointUsageStat `json:"endpoint_usage_stats"`
+	TotalEndpoints      int                  `json:"total_endpoints"`
+	TotalRequests       int                  `json:"total_requests"`
+	EndpointUsageStats  []EndpointUsageStat  `json:"endpoint_usage_stats"`
+	WorkspaceUsageStats []WorkspaceUsageStat `json:"workspace_usage_stats"`
+}
+
+type WorkspaceUsageStat struct {
+	WorkspaceID   string    `json:"workspace_id"`
+	Name          string    `json:"name"`
+	MemberCount   int       `json:"member_count"`
+	EndpointCount int       `json:"endpoint_count"`
+	RequestCount  int       `json:"request_count"`
+	CreatedAt     time.Time `json:"created_at"`
 }
 
 type EndpointUsageStat struct {
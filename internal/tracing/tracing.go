@@ -0,0 +1,464 @@
+// Package tracing records spans and exports them to an OpenTelemetry
+// collector over OTLP/HTTP with JSON encoding. Trace context travels in W3C
+// traceparent headers.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxQueuedSpans bounds the spans waiting for export. Spans ended while
+	// the queue is full are dropped rather than slowing requests down.
+	maxQueuedSpans = 2048
+	maxBatchSpans  = 512
+	flushInterval  = 5 * time.Second
+	scopeName      = "github.com/PipeOpsHQ/pipehook"
+)
+
+// Config describes where spans are sent.
+type Config struct {
+	// Endpoint is the collector's base URL, such as http://localhost:4318.
+	// Spans are posted to its /v1/traces path unless the URL already ends in
+	// it.
+	Endpoint    string
+	ServiceName string
+	// Headers are added to every export, for example for authentication.
+	Headers map[string]string
+	// Client defaults to a client with a ten second timeout.
+	Client *http.Client
+}
+
+// Kind says which side of a call a span describes.
+type Kind int
+
+// Values match the OTLP SpanKind enumeration.
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// SpanContext identifies a span within its trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are set, as W3C trace context requires.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent reads a traceparent header value. Versions after 00 are
+// accepted as long as they start with the version 00 fields.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	version, err := hex.DecodeString(parts[0])
+	if err != nil || len(version) != 1 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil || strings.ToLower(parts[1]) != parts[1] || strings.ToLower(parts[2]) != parts[2] {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Extract returns ctx carrying the caller's span context from the
+// traceparent header, if it has a valid one. Spans started from the returned
+// context become its children.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if sc, ok := ParseTraceparent(header.Get("Traceparent")); ok {
+		return context.WithValue(ctx, remoteKey{}, sc)
+	}
+	return ctx
+}
+
+// Inject sets the traceparent header to the current span, or to the
+// extracted caller when no span was started.
+func Inject(ctx context.Context, header http.Header) {
+	if sc, ok := spanContext(ctx); ok {
+		header.Set("Traceparent", sc.Traceparent())
+	}
+}
+
+// TraceID returns the hex trace ID of the current trace, or "" outside one.
+func TraceID(ctx context.Context) string {
+	if sc, ok := spanContext(ctx); ok {
+		return hex.EncodeToString(sc.TraceID[:])
+	}
+	return ""
+}
+
+func spanContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// SpanFromContext returns the span started in ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Tracer starts spans and exports them in batches. A nil *Tracer is valid
+// and records nothing, so callers need not check whether tracing is on.
+type Tracer struct {
+	config   Config
+	url      string
+	queue    chan *Span
+	flush    chan chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// New starts a tracer that exports to config.Endpoint until Shutdown.
+func New(config Config) *Tracer {
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = "pipehook"
+	}
+	endpoint := strings.TrimSuffix(config.Endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	t := &Tracer{
+		config: config, url: endpoint, queue: make(chan *Span, maxQueuedSpans),
+		flush: make(chan chan struct{}), done: make(chan struct{}),
+	}
+	go t.run()
+	return t
+}
+
+// Start begins a span that is a child of the span or caller context in ctx.
+// Without a parent it starts a new, sampled trace; with one it follows the
+// parent's sampling decision. The span must be ended with End.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	span := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent, ok := spanContext(ctx); ok {
+		span.context.TraceID, span.context.Sampled, span.parent = parent.TraceID, parent.Sampled, parent.SpanID
+	} else {
+		_, _ = rand.Read(span.context.TraceID[:])
+		span.context.Sampled = true
+	}
+	_, _ = rand.Read(span.context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Flush exports the spans ended so far.
+func (t *Tracer) Flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	flushed := make(chan struct{})
+	select {
+	case t.flush <- flushed:
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown exports the remaining spans and stops the exporter. Spans ended
+// afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	err := t.Flush(ctx)
+	t.stopOnce.Do(func() { close(t.done) })
+	return err
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, maxBatchSpans)
+	export := func() {
+		if len(batch) > 0 {
+			if err := t.export(batch); err != nil {
+				slog.Warn("failed to export spans", "spans", len(batch), "error", err)
+			}
+			batch = batch[:0]
+		}
+	}
+	for {
+		select {
+		case span := <-t.queue:
+			if batch = append(batch, span); len(batch) == maxBatchSpans {
+				export()
+			}
+		case <-ticker.C:
+			export()
+		case flushed := <-t.flush:
+			for drained := false; !drained; {
+				select {
+				case span := <-t.queue:
+					if batch = append(batch, span); len(batch) == maxBatchSpans {
+						export()
+					}
+				default:
+					drained = true
+				}
+			}
+			export()
+			close(flushed)
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *Tracer) enqueue(span *Span) {
+	select {
+	case <-t.done:
+	case t.queue <- span:
+	default:
+	}
+}
+
+// Span is one timed operation. Its methods do nothing on a nil *Span.
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+	parent  [8]byte
+	name    string
+	kind    Kind
+	start   time.Time
+
+	mu         sync.Mutex
+	end        time.Time
+	attributes []attribute
+	err        string
+	ended      bool
+}
+
+type attribute struct {
+	key   string
+	value any
+}
+
+// Context returns the span's identity for propagation.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttribute records a string, bool, integer or float attribute. Other
+// values are recorded with fmt.Sprint.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, attribute{key: key, value: value})
+}
+
+// SetError marks the span as failed with err's message. A nil err is ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End finishes the span and queues it for export when it is sampled. Calls
+// after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, time.Now()
+	s.mu.Unlock()
+	if s.context.Sampled {
+		s.tracer.enqueue(s)
+	}
+}
+
+// The types below are the OTLP JSON encoding of an ExportTraceServiceRequest.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func newOTLPAttribute(key string, value any) otlpAttribute {
+	var v otlpValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case bool:
+		v.BoolValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceID: hex.EncodeToString(s.context.TraceID[:]), SpanID: hex.EncodeToString(s.context.SpanID[:]),
+		Name: s.name, Kind: s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10), EndTimeUnixNano: strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for _, attribute := range s.attributes {
+		span.Attributes = append(span.Attributes, newOTLPAttribute(attribute.key, attribute.value))
+	}
+	if s.err != "" {
+		span.Status = &otlpStatus{Code: 2, Message: s.err}
+	}
+	return span
+}
+
+func (t *Tracer) export(batch []*Span) error {
+	spans := make([]otlpSpan, len(batch))
+	for i, span := range batch {
+		spans[i] = span.otlp()
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{newOTLPAttribute("service.name", t.config.ServiceName)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range t.config.Headers {
+		request.Header.Set(key, value)
+	}
+	response, err := t.config.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("collector answered %s", response.Status)
+	}
+	return nil
+}
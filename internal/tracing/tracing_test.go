@@ -0,0 +1,83 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/PipeOpsHQ/pipehook/internal/tracing"
+	"github.com/PipeOpsHQ/pipehook/internal/tracing/tracingtest"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	sc, ok := tracing.ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || !sc.Sampled || sc.Traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("unexpected span context %+v %t", sc, ok)
+	}
+	for _, invalid := range []string{
+		"", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, ok := tracing.ParseTraceparent(invalid); ok {
+			t.Errorf("expected %q to be rejected", invalid)
+		}
+	}
+	if _, ok := tracing.ParseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra"); !ok {
+		t.Error("expected a later version with extra fields to be accepted")
+	}
+}
+
+func TestSpansFollowTheCallerAndExport(t *testing.T) {
+	collector := tracingtest.NewCollector()
+	defer collector.Close()
+	tracer := tracing.New(tracing.Config{Endpoint: collector.URL, ServiceName: "hooks", Headers: map[string]string{"Authorization": "Bearer abc"}})
+
+	header := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	ctx, server := tracer.Start(tracing.Extract(context.Background(), header), "server", tracing.KindServer)
+	server.SetAttribute("http.response.status_code", 200)
+	_, child := tracer.Start(ctx, "child", tracing.KindInternal)
+	child.SetError(errors.New("boom"))
+	child.End()
+	outgoing := http.Header{}
+	tracing.Inject(ctx, outgoing)
+	server.End()
+	_, unsampled := tracer.Start(tracing.Extract(context.Background(), http.Header{
+		"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"},
+	}), "unsampled", tracing.KindServer)
+	unsampled.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if tracing.TraceID(ctx) != "4bf92f3577b34da6a3ce929d0e0e4736" || outgoing.Get("Traceparent") != server.Context().Traceparent() {
+		t.Fatalf("unexpected propagation: trace %s traceparent %s", tracing.TraceID(ctx), outgoing.Get("Traceparent"))
+	}
+	spans := collector.Spans()
+	if len(spans) != 2 || collector.Headers()[0].Get("Authorization") != "Bearer abc" {
+		t.Fatalf("expected the two sampled spans with the configured headers, got %+v", spans)
+	}
+	exportedChild, exportedServer := spans[0], spans[1]
+	if exportedServer.Name != "server" || exportedServer.ParentSpanID != "00f067aa0ba902b7" || exportedServer.Service != "hooks" ||
+		exportedServer.Attributes["http.response.status_code"] != "200" || exportedServer.Kind != int(tracing.KindServer) {
+		t.Fatalf("unexpected server span %+v", exportedServer)
+	}
+	if exportedChild.TraceID != exportedServer.TraceID || exportedChild.ParentSpanID != exportedServer.SpanID ||
+		exportedChild.Status.Code != 2 || exportedChild.Status.Message != "boom" {
+		t.Fatalf("unexpected child span %+v", exportedChild)
+	}
+}
+
+func TestNilTracerPropagatesTheCaller(t *testing.T) {
+	var tracer *tracing.Tracer
+	header := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+	ctx, span := tracer.Start(tracing.Extract(context.Background(), header), "server", tracing.KindServer)
+	span.SetAttribute("ignored", true)
+	span.End()
+	outgoing := http.Header{}
+	tracing.Inject(ctx, outgoing)
+	if span != nil || outgoing.Get("Traceparent") != header.Get("Traceparent") || tracing.TraceID(ctx) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("expected the caller's context to pass through, got %q", outgoing.Get("Traceparent"))
+	}
+}
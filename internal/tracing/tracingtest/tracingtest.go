@@ -0,0 +1,118 @@
+// Package tracingtest provides a minimal OTLP/HTTP collector for tests. It
+// accepts JSON-encoded trace exports and keeps the spans it receives.
+package tracingtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Span is an exported span as the collector received it.
+type Span struct {
+	TraceID      string            `json:"traceId"`
+	SpanID       string            `json:"spanId"`
+	ParentSpanID string            `json:"parentSpanId"`
+	Name         string            `json:"name"`
+	Kind         int               `json:"kind"`
+	Attributes   map[string]string `json:"-"`
+	Status       struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+	// Service is the service.name resource attribute of the export.
+	Service string `json:"-"`
+}
+
+// Collector is a running stand-in collector.
+type Collector struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	spans   []Span
+	headers []http.Header
+}
+
+// NewCollector starts a collector. Close it when the test is done.
+func NewCollector() *Collector {
+	collector := &Collector{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/traces", collector.traces)
+	collector.Server = httptest.NewServer(mux)
+	return collector
+}
+
+// Spans returns the spans received so far.
+func (c *Collector) Spans() []Span {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Span(nil), c.spans...)
+}
+
+// Headers returns the request headers of each export received so far.
+func (c *Collector) Headers() []http.Header {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]http.Header(nil), c.headers...)
+}
+
+type attribute struct {
+	Key   string                     `json:"key"`
+	Value map[string]json.RawMessage `json:"value"`
+}
+
+func (c *Collector) traces(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "only JSON exports are supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	var export struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []attribute `json:"attributes"`
+			} `json:"resource"`
+			ScopeSpans []struct {
+				Spans []struct {
+					Span
+					Attributes []attribute `json:"attributes"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.headers = append(c.headers, r.Header.Clone())
+	for _, resource := range export.ResourceSpans {
+		service := attributeMap(resource.Resource.Attributes)["service.name"]
+		for _, scope := range resource.ScopeSpans {
+			for _, received := range scope.Spans {
+				span := received.Span
+				span.Attributes, span.Service = attributeMap(received.Attributes), service
+				c.spans = append(c.spans, span)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// attributeMap flattens attribute values to their JSON text, with strings
+// unquoted, so tests can compare them directly.
+func attributeMap(attributes []attribute) map[string]string {
+	values := make(map[string]string, len(attributes))
+	for _, attribute := range attributes {
+		for _, raw := range attribute.Value {
+			var text string
+			if json.Unmarshal(raw, &text) != nil {
+				text = string(raw)
+			}
+			values[attribute.Key] = text
+		}
+	}
+	return values
+}